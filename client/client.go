@@ -0,0 +1,207 @@
+// Package client is a typed Go client for the SMS gateway API described by
+// api/openapi.yaml.
+//
+//	c := client.New("https://sms.example.com", token)
+//	res, err := c.SendMessage(ctx, client.SendRequest{To: "+49123456789", Body: "hi"})
+//
+// Sends and top-ups carry an Idempotency-Key, generated when the caller
+// doesn't pass one, so they are retried like reads: on connection errors and
+// 5xx answers, with exponential backoff. Other writes are never retried. For
+// signed requests, set HTTPClient to one using signing.Transport.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+	HeaderActAsUser          = "X-User-ID"
+)
+
+// Client calls one gateway with one credential.
+type Client struct {
+	BaseURL    string       // e.g. https://sms.example.com
+	Token      string       // API key or JWT; empty for signed or mTLS clients
+	HTTPClient *http.Client // nil means http.DefaultClient
+
+	// Retries is how many times a retryable request is repeated after a
+	// connection error or 5xx answer. RetryWait is the first pause, doubled
+	// (with jitter) before each further attempt.
+	Retries   int
+	RetryWait time.Duration
+}
+
+// New returns a client with 3 retries starting at 200ms.
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: baseURL, Token: token, Retries: 3, RetryWait: 200 * time.Millisecond}
+}
+
+// Error is an error answer. From the gateway it is a problem details body
+// whose Code is stable and is what to branch on; the codes are listed in
+// docs/errors.md. Answers from elsewhere (a proxy, say) have no Code.
+type Error struct {
+	StatusCode int          `json:"status"`
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	Detail     string       `json:"detail"`
+	Instance   string       `json:"instance"` // the request ID, as logged by the gateway
+	Code       string       `json:"code"`
+	Errors     []FieldError `json:"errors"`
+
+	// RetryAfter is set from Retry-After on 429 and 503 answers.
+	RetryAfter time.Duration `json:"-"`
+}
+
+// FieldError points at one invalid member of a request body or parameter.
+type FieldError struct {
+	Field string `json:"field"`
+	Code  string `json:"code"`
+}
+
+func (e *Error) Error() string {
+	code := e.Code
+	if code == "" {
+		code = e.Title
+	}
+	msg := fmt.Sprintf("sms gateway: %d %s", e.StatusCode, code)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	for _, f := range e.Errors {
+		msg += fmt.Sprintf(" (%s: %s)", f.Field, f.Code)
+	}
+	return msg
+}
+
+// HasCode reports whether err is an *Error with the given code.
+func HasCode(err error, code string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// NewIdempotencyKey returns a random key for one logical request.
+func NewIdempotencyKey() string {
+	return rand.Text()
+}
+
+// call is one API request. Body, when set, is sent as JSON.
+type call struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   any
+}
+
+// do sends req, retrying when that is safe, and decodes a 2xx answer into
+// out (unless nil). It returns the answer's headers.
+func (c *Client) do(ctx context.Context, req call, out any) (http.Header, error) {
+	var body []byte
+	if req.body != nil {
+		b, err := json.Marshal(req.body)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+	u := strings.TrimRight(c.BaseURL, "/") + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	retryable := req.method == http.MethodGet || req.method == http.MethodPut ||
+		req.header.Get(HeaderIdempotencyKey) != ""
+
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		hdr, retry, err := c.send(ctx, req, u, body, out)
+		if !retry || !retryable || attempt >= c.Retries || ctx.Err() != nil {
+			return hdr, err
+		}
+		// Jitter keeps a fleet of clients from retrying in step.
+		pause := wait/2 + mrand.N(wait/2+1)
+		select {
+		case <-ctx.Done():
+			return hdr, err
+		case <-time.After(pause):
+		}
+		wait *= 2
+	}
+}
+
+// send makes one attempt and reports whether it may be worth repeating.
+func (c *Client) send(ctx context.Context, req call, u string, body []byte, out any) (http.Header, bool, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	r, err := http.NewRequestWithContext(ctx, req.method, u, rd)
+	if err != nil {
+		return nil, false, err
+	}
+	for k, vs := range req.header {
+		r.Header[k] = vs
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json, application/problem+json")
+	if c.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(r)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.Header, true, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.Header, resp.StatusCode >= 500, decodeError(resp, data)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp.Header, false, fmt.Errorf("sms gateway: decode %s %s: %w", req.method, req.path, err)
+		}
+	}
+	return resp.Header, false, nil
+}
+
+func decodeError(resp *http.Response, data []byte) error {
+	e := &Error{}
+	if json.Unmarshal(data, e) != nil || e.Code == "" {
+		// Not from the gateway itself, e.g. a proxy's 502.
+		e = &Error{Title: http.StatusText(resp.StatusCode)}
+	}
+	e.StatusCode = resp.StatusCode
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(s) * time.Second
+	}
+	return e
+}
+
+func idempotencyHeader(key string) http.Header {
+	if key == "" {
+		key = NewIdempotencyKey()
+	}
+	return http.Header{HeaderIdempotencyKey: {key}}
+}
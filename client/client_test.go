@@ -0,0 +1,131 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/client"
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	dbpkg "github.com/Cypherspark/sms-gateway/internal/db"
+	"github.com/Cypherspark/sms-gateway/internal/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_AgainstServer(t *testing.T) {
+	ctx := context.Background()
+	srv := httpapi.NewServer(&core.Store{DB: dbpkg.StartTestPostgres(t)})
+	// The server rejects requests the spec doesn't allow; check its answers too.
+	srv.ResponseViolations = func(r *http.Request, err error) {
+		t.Errorf("%s %s: response doesn't match the spec: %v", r.Method, r.URL, err)
+	}
+	ts := httptest.NewServer(srv.Router())
+	defer ts.Close()
+	_, token, err := srv.Store.CreateAdminKey(ctx, "test")
+	require.NoError(t, err)
+	admin := client.New(ts.URL, token)
+
+	user, err := admin.CreateUser(ctx, client.CreateUserRequest{Name: "acme"})
+	require.NoError(t, err)
+	ref := "psp-1"
+	topup := client.TopUpRequest{Amount: 3, ExternalRef: &ref, IdempotencyKey: "payment-1"}
+	paid, err := admin.TopUp(ctx, user.ID, topup)
+	require.NoError(t, err)
+	require.Equal(t, 3, paid.Balance)
+	paid, err = admin.TopUp(ctx, user.ID, topup) // same key: credited once
+	require.NoError(t, err)
+	require.Equal(t, 3, paid.Balance)
+
+	tenant := client.New(ts.URL, user.APIKey)
+	bal, err := tenant.Balance(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, 3, bal.Balance)
+	require.Nil(t, bal.NextExpiry)
+
+	first, err := tenant.SendMessage(ctx, client.SendRequest{To: "+15550000000", Body: "one", IdempotencyKey: "k1"})
+	require.NoError(t, err)
+	require.Equal(t, client.StatusQueued, first.Status)
+	require.False(t, first.Replayed)
+	again, err := tenant.SendMessage(ctx, client.SendRequest{To: "+15550000000", Body: "one", IdempotencyKey: "k1"})
+	require.NoError(t, err)
+	require.True(t, again.Replayed)
+	require.Equal(t, first.ID, again.ID)
+	for _, body := range []string{"two", "three"} {
+		_, err := tenant.SendMessage(ctx, client.SendRequest{To: "+15550000000", Body: body})
+		require.NoError(t, err)
+	}
+
+	_, err = tenant.SendMessage(ctx, client.SendRequest{To: "+15550000000", Body: "four"})
+	require.True(t, client.HasCode(err, "insufficient_balance"), err)
+	_, err = tenant.SendMessage(ctx, client.SendRequest{To: "+15550000000", Body: "one", IdempotencyKey: "k1"})
+	require.NoError(t, err) // the original key replays even with no balance left
+
+	msg, err := tenant.GetMessage(ctx, first.ID)
+	require.NoError(t, err)
+	require.Equal(t, "one", msg.Body)
+	require.Equal(t, user.ID, msg.UserID)
+
+	page, err := tenant.ListMessages(ctx, client.ListMessagesParams{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 2)
+	require.NotEmpty(t, page.NextCursor)
+	var bodies []string
+	for m, err := range tenant.Messages(ctx, client.ListMessagesParams{Limit: 2}) {
+		require.NoError(t, err)
+		bodies = append(bodies, m.Body)
+	}
+	require.Equal(t, []string{"three", "two", "one"}, bodies)
+
+	_, err = tenant.CreateUser(ctx, client.CreateUserRequest{Name: "nope"})
+	require.True(t, client.HasCode(err, "forbidden"), err)
+}
+
+func TestClient_RetriesIdempotentRequests(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	fail := 2
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get(client.HeaderIdempotencyKey))
+		if fail > 0 {
+			fail--
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"status":503,"code":"unavailable"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"id":"m1","status":"queued"}`))
+	}))
+	defer ts.Close()
+	c := client.New(ts.URL, "token")
+	c.RetryWait = time.Millisecond
+
+	res, err := c.SendMessage(context.Background(), client.SendRequest{To: "+15550000000", Body: "hi"})
+	require.NoError(t, err)
+	require.Equal(t, "m1", res.ID)
+	require.Len(t, keys, 3)
+	require.NotEmpty(t, keys[0])
+	require.Equal(t, keys[0], keys[1]) // one key across attempts
+	require.Equal(t, keys[0], keys[2])
+
+	// Without a key a failed write isn't repeated, and the error says why.
+	keys, fail = nil, 1
+	_, err = c.CreateUser(context.Background(), client.CreateUserRequest{Name: "acme"})
+	require.True(t, client.HasCode(err, "unavailable"), err)
+	require.Len(t, keys, 1)
+
+	// Retries give up after Retries attempts.
+	keys, fail = nil, 10
+	c.Retries = 1
+	_, err = c.Balance(context.Background(), "u1")
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	require.Len(t, keys, 2)
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Message statuses.
+const (
+	StatusQueued  = "queued"
+	StatusSending = "sending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+)
+
+// SendRequest is one SMS to enqueue.
+type SendRequest struct {
+	To   string `json:"to"`
+	Body string `json:"body"`
+
+	// UserID sends as another user: required for admin keys, or one of the
+	// caller's sub-accounts. Empty means the key's own user.
+	UserID string `json:"-"`
+
+	// IdempotencyKey makes a retried send queue (and charge) one message. One
+	// is generated when empty; pass your own to make retries across
+	// processes safe too.
+	IdempotencyKey string `json:"-"`
+}
+
+// SendResult is an accepted send.
+type SendResult struct {
+	ID      string `json:"id"`
+	UserID  string `json:"user_id"`
+	Status  string `json:"status"`
+	Already bool   `json:"already"`
+
+	// Replayed is true when the key had been used before and this is the
+	// first send's answer.
+	Replayed bool `json:"-"`
+}
+
+// SendMessage enqueues an SMS, debiting the balance.
+func (c *Client) SendMessage(ctx context.Context, req SendRequest) (*SendResult, error) {
+	header := idempotencyHeader(req.IdempotencyKey)
+	if req.UserID != "" {
+		header.Set(HeaderActAsUser, req.UserID)
+	}
+	var out SendResult
+	hdr, err := c.do(ctx, call{method: http.MethodPost, path: "/messages", header: header, body: req}, &out)
+	if err != nil {
+		return nil, err
+	}
+	out.Replayed = hdr.Get(HeaderIdempotentReplayed) == "true"
+	return &out, nil
+}
+
+// Message is a message and its delivery state.
+type Message struct {
+	ID                string     `json:"id"`
+	UserID            string     `json:"user_id"`
+	To                string     `json:"to_msisdn"`
+	Body              string     `json:"body"`
+	Status            string     `json:"status"`
+	ProviderMessageID *string    `json:"provider_message_id"`
+	ErrorCode         *string    `json:"error_code"`
+	RequestedAt       time.Time  `json:"requested_at"`
+	SentAt            *time.Time `json:"sent_at"`
+	DeliveredAt       *time.Time `json:"delivered_at"`
+	Attempts          int        `json:"attempts"`
+}
+
+// GetMessage returns a message by ID.
+func (c *Client) GetMessage(ctx context.Context, id string) (*Message, error) {
+	return c.getMessage(ctx, id, nil)
+}
+
+// WaitForMessage returns the message once it is sent or has failed, or as it
+// is when wait runs out. The server caps each wait just below its write
+// timeout, so check Status and call again if it is still pending.
+func (c *Client) WaitForMessage(ctx context.Context, id string, wait time.Duration) (*Message, error) {
+	return c.getMessage(ctx, id, url.Values{"wait": {strconv.Itoa(int(wait / time.Second))}})
+}
+
+func (c *Client) getMessage(ctx context.Context, id string, query url.Values) (*Message, error) {
+	var out Message
+	if _, err := c.do(ctx, call{method: http.MethodGet, path: "/messages/" + url.PathEscape(id), query: query}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListMessagesParams filters and pages GET /messages. Zero values are
+// omitted.
+type ListMessagesParams struct {
+	UserID string // defaults to the caller; required for admin keys
+	Status string
+	From   time.Time // requested at or after
+	To     time.Time // requested before
+	Limit  int       // 1-500, default 50
+	Cursor string    // a page's NextCursor or PrevCursor
+}
+
+func (p ListMessagesParams) query() url.Values {
+	q := url.Values{}
+	set := func(k, v string) {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	set("user_id", p.UserID)
+	set("status", p.Status)
+	if !p.From.IsZero() {
+		q.Set("from", p.From.Format(time.RFC3339))
+	}
+	if !p.To.IsZero() {
+		q.Set("to", p.To.Format(time.RFC3339))
+	}
+	if p.Limit > 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	set("cursor", p.Cursor)
+	return q
+}
+
+// MessagePage is one page of messages, newest first.
+type MessagePage struct {
+	Items      []Message `json:"items"`
+	Limit      int       `json:"limit"`
+	NextCursor string    `json:"next_cursor"` // older messages; empty on the last page
+	PrevCursor string    `json:"prev_cursor"` // newer messages; empty on the first page
+}
+
+// ListMessages returns one page of messages.
+func (c *Client) ListMessages(ctx context.Context, p ListMessagesParams) (*MessagePage, error) {
+	var out MessagePage
+	if _, err := c.do(ctx, call{method: http.MethodGet, path: "/messages", query: p.query()}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Messages iterates over every message matching p, newest first, fetching
+// pages as it goes. Iteration stops at the first error, which is yielded.
+//
+//	for m, err := range c.Messages(ctx, client.ListMessagesParams{Status: client.StatusFailed}) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func (c *Client) Messages(ctx context.Context, p ListMessagesParams) iter.Seq2[Message, error] {
+	return func(yield func(Message, error) bool) {
+		for {
+			page, err := c.ListMessages(ctx, p)
+			if err != nil {
+				yield(Message{}, err)
+				return
+			}
+			for _, m := range page.Items {
+				if !yield(m, nil) {
+					return
+				}
+			}
+			if page.NextCursor == "" {
+				return
+			}
+			p.Cursor = page.NextCursor
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// CreateUserRequest is the body of POST /users.
+type CreateUserRequest struct {
+	Name       string  `json:"name"`
+	OwnerName  string  `json:"owner_name,omitempty"`  // defaults to Name
+	OwnerEmail *string `json:"owner_email,omitempty"` // an existing member with this email becomes the owner
+}
+
+// NewUser is a created user and its first API key, shown only once.
+type NewUser struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	APIKey string `json:"api_key"`
+}
+
+// CreateUser creates a user (admin only). It is not retried.
+func (c *Client) CreateUser(ctx context.Context, req CreateUserRequest) (*NewUser, error) {
+	var out NewUser
+	if _, err := c.do(ctx, call{method: http.MethodPost, path: "/users", body: req}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// TopUpRequest is the body of POST /users/{id}/topup.
+type TopUpRequest struct {
+	Amount      int     `json:"amount"`
+	ExternalRef *string `json:"external_ref,omitempty"` // reference in the paying system
+	Note        *string `json:"note,omitempty"`
+
+	// IdempotencyKey credits the balance once however often the top-up is
+	// sent. One is generated when empty; pass your own (a payment ID, say) to
+	// make retries across processes safe too.
+	IdempotencyKey string `json:"-"`
+}
+
+// PaidBalance is the paid balance after a top-up or transfer.
+type PaidBalance struct {
+	UserID  string `json:"user_id"`
+	Balance int    `json:"balance"`
+}
+
+// TopUp adds to a user's paid balance (admin only).
+func (c *Client) TopUp(ctx context.Context, userID string, req TopUpRequest) (*PaidBalance, error) {
+	var out PaidBalance
+	_, err := c.do(ctx, call{
+		method: http.MethodPost,
+		path:   "/users/" + url.PathEscape(userID) + "/topup",
+		header: idempotencyHeader(req.IdempotencyKey),
+		body:   req,
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Balance is a user's spendable balance, split into paid and promotional
+// credit.
+type Balance struct {
+	UserID      string     `json:"user_id"`
+	Balance     int        `json:"balance"` // Paid + Promotional
+	Paid        int        `json:"paid"`
+	Promotional int        `json:"promotional"`
+	NextExpiry  *time.Time `json:"next_expiry"` // earliest promotional expiry
+}
+
+// Balance returns a user's balance.
+func (c *Client) Balance(ctx context.Context, userID string) (*Balance, error) {
+	var out Balance
+	if _, err := c.do(ctx, call{method: http.MethodGet, path: "/users/" + url.PathEscape(userID) + "/balance"}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
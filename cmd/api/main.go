@@ -2,17 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/Cypherspark/sms-gateway/internal/auth"
 	"github.com/Cypherspark/sms-gateway/internal/core"
 	dbpkg "github.com/Cypherspark/sms-gateway/internal/db"
+	"github.com/Cypherspark/sms-gateway/internal/events"
 	"github.com/Cypherspark/sms-gateway/internal/http"
 	"github.com/Cypherspark/sms-gateway/internal/metrics"
+	"github.com/Cypherspark/sms-gateway/internal/ratelimit"
+	"github.com/Cypherspark/sms-gateway/internal/tlsreload"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -46,22 +53,139 @@ func main() {
 	defer close(stopPoolMetrics)
 
 	database := dbpkg.NewDB(pool)
-	coreStore := &core.Store{DB: database}
+	coreStore := &core.Store{
+		DB: database,
+		QueueLimits: core.QueueLimits{
+			PerUser: atoiEnv("MAX_QUEUED_PER_USER", 0),
+			Global:  atoiEnv("MAX_QUEUED_TOTAL", 0),
+		},
+		IdempotencyTTL:       durEnv("IDEMPOTENCY_TTL_MS", core.DefaultIdempotencyTTL),
+		AllowPrivateWebhooks: os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true",
+	}
+	go purgeIdempotencyKeys(rootCtx, coreStore)
 
 	srv := httpapi.NewServer(coreStore)
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		if srv.TrustedProxies, err = core.ParseCIDRs(strings.Split(v, ",")); err != nil {
+			log.Printf("TRUSTED_PROXIES: %v", err)
+			exitCode = 1
+			return
+		}
+	}
+	srv.SigningMaxSkew = durEnv("SIGNING_MAX_SKEW_MS", srv.SigningMaxSkew)
+	switch store := env("SIGNING_NONCE_STORE", "memory"); store {
+	case "memory":
+	case "postgres":
+		// Shared across replicas; expired nonces are swept periodically.
+		srv.SigningNonces = coreStore
+		go purgeNonces(rootCtx, coreStore, srv.SigningMaxSkew)
+	default:
+		log.Printf("SIGNING_NONCE_STORE: unknown store %q", store)
+		exitCode = 1
+		return
+	}
+	if url, file := os.Getenv("JWT_JWKS_URL"), os.Getenv("JWT_JWKS_FILE"); url != "" || file != "" {
+		srv.JWT = &auth.JWTValidator{
+			Keys:       &auth.JWKS{URL: url, File: file, Refresh: durEnv("JWT_JWKS_REFRESH_MS", time.Hour)},
+			Issuer:     os.Getenv("JWT_ISSUER"),
+			Audience:   os.Getenv("JWT_AUDIENCE"),
+			UserClaim:  env("JWT_USER_CLAIM", "sub"),
+			ScopeClaim: env("JWT_SCOPE_CLAIM", "scope"),
+			AllowAdmin: os.Getenv("JWT_ALLOW_ADMIN") == "true",
+			Leeway:     durEnv("JWT_LEEWAY_MS", 30*time.Second),
+		}
+		// Fail fast on a bad JWKS rather than on the first request.
+		if _, err := srv.JWT.Keys.Key(rootCtx, ""); err != nil && !errors.Is(err, auth.ErrUnknownKey) {
+			log.Printf("jwks: %v", err)
+			exitCode = 1
+			return
+		}
+	}
+	// Rate limiting is off unless plans or a per-key limit are configured.
+	plans, err := ratelimit.ParsePlans(os.Getenv("RATE_LIMIT_PLANS"))
+	if err != nil {
+		log.Printf("RATE_LIMIT_PLANS: %v", err)
+		exitCode = 1
+		return
+	}
+	var perKey ratelimit.Limit
+	if v := os.Getenv("RATE_LIMIT_PER_KEY"); v != "" {
+		if perKey, err = ratelimit.ParseLimit(v); err != nil {
+			log.Printf("RATE_LIMIT_PER_KEY: %v", err)
+			exitCode = 1
+			return
+		}
+	}
+	if len(plans) > 0 || !perKey.Unlimited() {
+		srv.RateLimits = &httpapi.RateLimits{Plans: plans, DefaultPlan: env("RATE_LIMIT_DEFAULT_PLAN", "standard"), PerKey: perKey}
+		if _, ok := plans[srv.RateLimits.DefaultPlan]; !ok && len(plans) > 0 {
+			log.Printf("RATE_LIMIT_DEFAULT_PLAN: %q is not in RATE_LIMIT_PLANS", srv.RateLimits.DefaultPlan)
+			exitCode = 1
+			return
+		}
+		switch store := env("RATE_LIMIT_STORE", "memory"); store {
+		case "memory":
+			srv.RateLimits.Store = ratelimit.NewMemory()
+		case "postgres":
+			// Shared across replicas; idle buckets are swept periodically.
+			srv.RateLimits.Store = coreStore
+			go purgeRateLimits(rootCtx, coreStore)
+		default:
+			log.Printf("RATE_LIMIT_STORE: unknown store %q", store)
+			exitCode = 1
+			return
+		}
+	}
+
+	// One LISTEN connection per process feeds every status stream; the event
+	// log behind Last-Event-ID resume is trimmed to the retention window.
+	listener := &events.Listener{Pool: pool}
+	go listener.Run(rootCtx)
+	srv.Events = listener
+	srv.StreamHeartbeat = durEnv("STREAM_HEARTBEAT_MS", srv.StreamHeartbeat)
+	go purgeStatusEvents(rootCtx, coreStore, durEnv("STATUS_EVENTS_RETENTION_MS", time.Hour))
+
 	host := env("HOST", "0.0.0.0")
 	port := env("PORT", "8080")
 	server := &http.Server{
 		Addr:         host + ":" + port,
 		Handler:      srv.Router(),
 		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: durEnv("WRITE_TIMEOUT_MS", 10*time.Second),
+	}
+	// ?wait= on GET /messages/{id} must answer before the write deadline.
+	srv.MaxWait = max(server.WriteTimeout-time.Second, 0)
+
+	// TLS, optionally with client certificates (mutual TLS), when a
+	// certificate is configured. SIGHUP reloads the files in place.
+	var certs *tlsreload.Reloader
+	if certFile := os.Getenv("TLS_CERT_FILE"); certFile != "" {
+		certs = &tlsreload.Reloader{
+			CertFile:          certFile,
+			KeyFile:           os.Getenv("TLS_KEY_FILE"),
+			ClientCAFile:      os.Getenv("TLS_CLIENT_CA_FILE"),
+			RequireClientCert: os.Getenv("TLS_CLIENT_CERT_REQUIRED") == "true",
+		}
+		if err := certs.Load(); err != nil {
+			log.Printf("%v", err)
+			exitCode = 1
+			return
+		}
+		server.TLSConfig = certs.Config()
+		go reloadCerts(rootCtx, certs)
 	}
 
 	errCh := make(chan error, 1)
 	go func() {
-		log.Printf("HTTP listening on %s", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if certs != nil {
+			log.Printf("HTTPS listening on %s", server.Addr)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("HTTP listening on %s", server.Addr)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			errCh <- err
 		}
 	}()
@@ -87,6 +211,104 @@ func main() {
 	}
 }
 
+// reloadCerts reloads the TLS files on SIGHUP. Open connections are kept;
+// a failed reload leaves the previous certificates in place.
+func reloadCerts(ctx context.Context, certs *tlsreload.Reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := certs.Load(); err != nil {
+				log.Printf("reload TLS certificates: %v", err)
+				continue
+			}
+			log.Printf("TLS certificates reloaded")
+		}
+	}
+}
+
+func purgeNonces(ctx context.Context, store *core.Store, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := store.PurgeNonces(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("purge request nonces: %v", err)
+			}
+		}
+	}
+}
+
+func purgeRateLimits(ctx context.Context, store *core.Store) {
+	t := time.NewTicker(10 * time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := store.PurgeRateLimits(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("purge rate limit buckets: %v", err)
+			}
+		}
+	}
+}
+
+func purgeIdempotencyKeys(ctx context.Context, store *core.Store) {
+	t := time.NewTicker(10 * time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := store.PurgeIdempotencyKeys(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("purge idempotency keys: %v", err)
+			}
+		}
+	}
+}
+
+func purgeStatusEvents(ctx context.Context, store *core.Store, retention time.Duration) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := store.PruneStatusEvents(ctx, retention); err != nil && ctx.Err() == nil {
+				log.Printf("prune status events: %v", err)
+			}
+		}
+	}
+}
+
+func atoiEnv(k string, def int) int {
+	if v := os.Getenv(k); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func durEnv(k string, def time.Duration) time.Duration {
+	if v := os.Getenv(k); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return time.Duration(n) * time.Millisecond
+		}
+	}
+	return def
+}
+
 func env(k, def string) string {
 	if v := os.Getenv(k); v != "" {
 		return v
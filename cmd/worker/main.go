@@ -51,6 +51,28 @@ func main() {
 		UserSlots:     atoiEnv("WORKER_USER_SLOTS", 100),
 	}
 
+	notifyOpts := wpkg.NotifierOptions{
+		BatchSize:    atoiEnv("NOTIFY_BATCH", 50),
+		PollInterval: durEnv("NOTIFY_POLL_MS", 2*time.Second),
+		MaxAttempts:  atoiEnv("NOTIFY_MAX_ATTEMPTS", 8),
+		Timeout:      durEnv("NOTIFY_TIMEOUT_MS", 5*time.Second),
+		AllowPrivate: os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true",
+	}
+
+	webhookOpts := wpkg.WebhookOptions{
+		BatchSize:    atoiEnv("WEBHOOK_BATCH", 50),
+		PollInterval: durEnv("WEBHOOK_POLL_MS", time.Second),
+		MaxAttempts:  atoiEnv("WEBHOOK_MAX_ATTEMPTS", 10),
+		DisableAfter: atoiEnv("WEBHOOK_DISABLE_AFTER", 50),
+		Timeout:      durEnv("WEBHOOK_TIMEOUT_MS", 10*time.Second),
+		AllowPrivate: os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true",
+	}
+
+	expiryOpts := wpkg.CreditExpiryOptions{
+		BatchSize: atoiEnv("CREDIT_EXPIRY_BATCH", 200),
+		Interval:  durEnv("CREDIT_EXPIRY_INTERVAL_MS", time.Minute),
+	}
+
 	rootCtx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
@@ -81,6 +103,24 @@ func main() {
 
 	go serveHealthzAndMetrics()
 
+	go func() {
+		if err := wpkg.RunBalanceNotifier(rootCtx, store, prov, notifyOpts); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("balance notifier exited: %v", err)
+		}
+	}()
+
+	go func() {
+		if err := wpkg.RunWebhookDispatcher(rootCtx, store, webhookOpts); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("webhook dispatcher exited: %v", err)
+		}
+	}()
+
+	go func() {
+		if err := wpkg.RunCreditExpiry(rootCtx, store, expiryOpts); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("credit expiry exited: %v", err)
+		}
+	}()
+
 	if err := wpkg.RunWorker(rootCtx, store, prov, opts); err != nil && !errors.Is(err, context.Canceled) {
 		log.Printf("worker exited: %v", err)
 		exitCode = 1
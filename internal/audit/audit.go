@@ -0,0 +1,43 @@
+// Package audit carries who is acting, and from where, through a request
+// context so the store can attribute the audit events it records.
+package audit
+
+import "context"
+
+// Actor types.
+const (
+	ActorAPIKey     = "api_key"
+	ActorJWT        = "jwt"
+	ActorClientCert = "client_cert"
+	ActorCLI        = "cli"
+	ActorSystem     = "system"
+
+	// ActorInvitation is an unauthenticated caller redeeming an invitation
+	// token.
+	ActorInvitation = "invitation"
+)
+
+// Actor is the caller behind an audited action. Fields other than Type may be
+// empty.
+type Actor struct {
+	Type      string
+	KeyID     string
+	UserID    string
+	RequestID string
+	SourceIP  string
+}
+
+type ctxKey struct{}
+
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, ctxKey{}, a)
+}
+
+// FromContext returns the context's actor, or a system actor when there is
+// none (e.g. background jobs).
+func FromContext(ctx context.Context) Actor {
+	if a, ok := ctx.Value(ctxKey{}).(Actor); ok {
+		return a
+	}
+	return Actor{Type: ActorSystem}
+}
@@ -0,0 +1,194 @@
+// Package auth generates and checks API key tokens and carries the
+// authenticated caller through a request context.
+//
+// A token looks like "sgw_<prefix>_<secret>". The prefix is stored in clear
+// to find the key; only a SHA-256 of the whole token is stored.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const tokenScheme = "sgw"
+
+// Scopes a key can carry. ScopeAdmin is only granted to admin keys and
+// satisfies every other scope.
+const (
+	ScopeMessagesSend  = "messages:send"
+	ScopeMessagesRead  = "messages:read"
+	ScopeBalanceRead   = "balance:read"
+	ScopeAccountManage = "account:manage" // thresholds, sub-accounts, transfers, keys, webhooks
+	ScopeMembersManage = "members:manage" // members and invitations
+	ScopeAdmin         = "admin:*"
+)
+
+// TenantScopes are the scopes a tenant key may hold; new keys get all of them
+// unless narrowed.
+var TenantScopes = []string{ScopeMessagesSend, ScopeMessagesRead, ScopeBalanceRead, ScopeAccountManage, ScopeMembersManage}
+
+// Member roles within an organization.
+const (
+	RoleOwner     = "owner"
+	RoleDeveloper = "developer"
+	RoleBilling   = "billing"
+	RoleViewer    = "viewer"
+)
+
+// RoleScopes caps what a member's keys can do; a key's effective scopes are
+// its own scopes narrowed to its member's role.
+var RoleScopes = map[string][]string{
+	RoleOwner:     TenantScopes,
+	RoleDeveloper: {ScopeMessagesSend, ScopeMessagesRead, ScopeBalanceRead},
+	RoleBilling:   {ScopeBalanceRead, ScopeAccountManage},
+	RoleViewer:    {ScopeMessagesRead, ScopeBalanceRead},
+}
+
+// NarrowToRole returns the scopes in scopes that role allows.
+func NarrowToRole(scopes []string, role string) []string {
+	allowed := RoleScopes[role]
+	out := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		if slices.Contains(allowed, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// ValidTenantScopes reports whether every scope is one a tenant key may hold.
+func ValidTenantScopes(scopes []string) bool {
+	for _, s := range scopes {
+		if !slices.Contains(TenantScopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+)
+
+// Principal is the caller a request was authenticated as. UserID (the
+// organization's account) is empty for admin keys; MemberID and Role are set
+// for keys issued to a member. CertID is set instead of KeyID for callers
+// authenticated by a client certificate.
+type Principal struct {
+	UserID   string
+	KeyID    string
+	CertID   string
+	MemberID string
+	Role     string
+	Admin    bool
+	Scopes   []string
+}
+
+// Allows reports whether the principal holds scope, directly or via admin:*.
+func (p Principal) Allows(scope string) bool {
+	return slices.Contains(p.Scopes, ScopeAdmin) || slices.Contains(p.Scopes, scope)
+}
+
+// Grantable returns the tenant scopes the principal may put on a key it
+// creates: every one for admins, otherwise only those it holds itself.
+func (p Principal) Grantable() []string {
+	if p.Allows(ScopeAdmin) {
+		return TenantScopes
+	}
+	out := make([]string, 0, len(p.Scopes))
+	for _, s := range p.Scopes {
+		if slices.Contains(TenantScopes, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+type ctxKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(Principal)
+	return p, ok
+}
+
+// NewToken returns a fresh token together with its lookup prefix and hash.
+func NewToken() (token, prefix string, hash []byte, err error) {
+	buf := make([]byte, 6+32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", nil, err
+	}
+	prefix = hex.EncodeToString(buf[:6])
+	token = tokenScheme + "_" + prefix + "_" + hex.EncodeToString(buf[6:])
+	return token, prefix, Hash(token), nil
+}
+
+// Prefix extracts the lookup prefix from a well-formed token.
+func Prefix(token string) (string, bool) {
+	parts := strings.Split(token, "_")
+	if len(parts) != 3 || parts[0] != tokenScheme || len(parts[1]) != 12 || len(parts[2]) != 64 {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func Hash(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// Verify compares a presented token against a stored hash in constant time.
+func Verify(token string, hash []byte) bool {
+	return subtle.ConstantTimeCompare(Hash(token), hash) == 1
+}
+
+// BearerToken returns the token from "Authorization: Bearer <token>", or "".
+func BearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(h, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// NewSigningSecret returns a fresh HMAC secret for request signing. Clients use
+// the string's bytes as the key.
+func NewSigningSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "sgs_" + hex.EncodeToString(buf), nil
+}
+
+// NewWebhookSecret returns a fresh secret a webhook endpoint verifies
+// deliveries with; like a signing secret, its bytes are the HMAC key.
+func NewWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// NewInviteToken returns an invitation token and the hash to store for it.
+func NewInviteToken() (token string, hash []byte, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	token = "sgi_" + hex.EncodeToString(buf)
+	return token, Hash(token), nil
+}
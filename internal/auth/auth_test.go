@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestTokenRoundTrip(t *testing.T) {
+	token, prefix, hash, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := Prefix(token)
+	if !ok || got != prefix {
+		t.Fatalf("Prefix(%q) = %q, %v; want %q", token, got, ok, prefix)
+	}
+	if !Verify(token, hash) {
+		t.Fatal("Verify rejected its own token")
+	}
+	if Verify(token+"x", hash) {
+		t.Fatal("Verify accepted a different token")
+	}
+	for _, bad := range []string{"", "sgw_abc_def", "xyz_" + prefix + "_" + token[len(token)-64:]} {
+		if _, ok := Prefix(bad); ok {
+			t.Errorf("Prefix(%q) accepted a malformed token", bad)
+		}
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "bearer sgw_x_y")
+	if got := BearerToken(r); got != "sgw_x_y" {
+		t.Fatalf("BearerToken = %q", got)
+	}
+	r.Header.Set("Authorization", "Basic abc")
+	if got := BearerToken(r); got != "" {
+		t.Fatalf("BearerToken = %q for Basic auth", got)
+	}
+}
+
+func TestPrincipalAllows(t *testing.T) {
+	tenant := Principal{Scopes: []string{ScopeMessagesSend}}
+	if !tenant.Allows(ScopeMessagesSend) || tenant.Allows(ScopeBalanceRead) || tenant.Allows(ScopeAdmin) {
+		t.Fatalf("tenant scopes not enforced: %+v", tenant)
+	}
+	admin := Principal{Admin: true, Scopes: []string{ScopeAdmin}}
+	for _, s := range append(TenantScopes, ScopeAdmin) {
+		if !admin.Allows(s) {
+			t.Errorf("admin:* should allow %s", s)
+		}
+	}
+	if ValidTenantScopes([]string{ScopeMessagesRead, ScopeAdmin}) {
+		t.Error("admin:* accepted as a tenant scope")
+	}
+}
+
+func TestPrincipalGrantable(t *testing.T) {
+	narrow := Principal{Scopes: []string{ScopeAccountManage}}
+	if got := narrow.Grantable(); !slices.Equal(got, []string{ScopeAccountManage}) {
+		t.Fatalf("Grantable = %v; want only the caller's own scopes", got)
+	}
+	admin := Principal{Admin: true, Scopes: []string{ScopeAdmin}}
+	if got := admin.Grantable(); !slices.Equal(got, TenantScopes) {
+		t.Fatalf("admin Grantable = %v; want every tenant scope", got)
+	}
+}
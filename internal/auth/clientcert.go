@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"crypto/x509"
+	"strings"
+)
+
+// Client certificate identity kinds, as stored in client_certificates.identity.
+var certIdentityKinds = []string{"subject:", "dns:", "uri:", "email:"}
+
+// CertIdentities lists every identity a verified client certificate
+// presents: its subject DN and each DNS, URI and email SAN.
+func CertIdentities(cert *x509.Certificate) []string {
+	ids := []string{"subject:" + cert.Subject.String()}
+	for _, n := range cert.DNSNames {
+		ids = append(ids, "dns:"+strings.ToLower(n))
+	}
+	for _, u := range cert.URIs {
+		ids = append(ids, "uri:"+u.String())
+	}
+	for _, e := range cert.EmailAddresses {
+		ids = append(ids, "email:"+strings.ToLower(e))
+	}
+	return ids
+}
+
+// NormalizeCertIdentity checks id's form and lowercases the parts compared
+// case-insensitively. ok is false for unknown kinds or an empty value.
+func NormalizeCertIdentity(id string) (norm string, ok bool) {
+	for _, kind := range certIdentityKinds {
+		v, found := strings.CutPrefix(id, kind)
+		if !found || v == "" {
+			continue
+		}
+		if kind == "dns:" || kind == "email:" {
+			v = strings.ToLower(v)
+		}
+		return kind + v, true
+	}
+	return "", false
+}
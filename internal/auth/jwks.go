@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// JWKS is a cached JSON Web Key Set read from a URL or a file. Keys are
+// reloaded every Refresh, and early (at most once per MinRefresh) when a token
+// names a kid the cache doesn't know, so the identity provider can rotate
+// keys without a restart. One reload runs at a time, outside the lock: known
+// keys keep being served meanwhile, and only lookups that need the new set
+// wait for it.
+type JWKS struct {
+	URL  string // either URL or File
+	File string
+
+	Refresh    time.Duration // default 1h
+	MinRefresh time.Duration // default 1m
+	Client     *http.Client  // default http.DefaultClient
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	loadErr error         // from the last reload
+	loading chan struct{} // closed when the reload in flight finishes
+}
+
+var ErrUnknownKey = errors.New("jwks: unknown key")
+
+// Key returns the public key with id kid.
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	refresh, minRefresh := j.Refresh, j.MinRefresh
+	if refresh <= 0 {
+		refresh = time.Hour
+	}
+	if minRefresh <= 0 {
+		minRefresh = time.Minute
+	}
+
+	j.mu.Lock()
+	age := time.Since(j.fetched)
+	k, known := j.keys[kid]
+	if j.keys != nil && age <= refresh && (known || age <= minRefresh) {
+		j.mu.Unlock()
+		if !known {
+			return nil, ErrUnknownKey
+		}
+		return k, nil
+	}
+	switch done := j.loading; {
+	case done == nil:
+		done = make(chan struct{})
+		j.loading = done
+		j.mu.Unlock()
+		keys, err := j.load(ctx)
+		j.mu.Lock()
+		// On error keep serving the previous set; retry after minRefresh.
+		if err == nil {
+			j.keys = keys
+		}
+		j.loadErr = err
+		j.fetched = time.Now()
+		j.loading = nil
+		close(done)
+	case known:
+		j.mu.Unlock()
+		return k, nil
+	default:
+		j.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		j.mu.Lock()
+	}
+	defer j.mu.Unlock()
+
+	if j.keys == nil {
+		return nil, j.loadErr
+	}
+	k, ok := j.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return k, nil
+}
+
+func (j *JWKS) load(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var raw []byte
+	switch {
+	case j.File != "":
+		b, err := os.ReadFile(j.File)
+		if err != nil {
+			return nil, fmt.Errorf("jwks: %w", err)
+		}
+		raw = b
+	case j.URL != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("jwks: %w", err)
+		}
+		client := j.Client
+		if client == nil {
+			client = http.DefaultClient
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("jwks: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("jwks: %s: %s", j.URL, resp.Status)
+		}
+		b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return nil, fmt.Errorf("jwks: %w", err)
+		}
+		raw = b
+	default:
+		return nil, errors.New("jwks: no URL or file configured")
+	}
+	return ParseJWKS(raw)
+}
+
+// ParseJWKS decodes the RSA and P-256 signing keys of a JWK set. Other keys
+// are skipped.
+func ParseJWKS(raw []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	out := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("jwks: malformed RSA key %q", k.Kid)
+			}
+			out[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil || len(x) != 32 || len(y) != 32 {
+				return nil, fmt.Errorf("jwks: malformed EC key %q", k.Kid)
+			}
+			pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+			if err != nil {
+				return nil, fmt.Errorf("jwks: EC key %q: %w", k.Kid, err)
+			}
+			out[k.Kid] = pub
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JWTValidator checks RS256/ES256 bearer JWTs from an identity provider and
+// maps their claims to a Principal.
+type JWTValidator struct {
+	Keys     *JWKS
+	Issuer   string // required "iss" when set
+	Audience string // required in "aud" when set
+
+	// UserClaim holds the gateway user ID (default "sub"); ScopeClaim holds
+	// the scopes, as a space-separated string or an array (default "scope").
+	UserClaim  string
+	ScopeClaim string
+
+	// AllowAdmin honours admin:* in tokens, making their holders gateway
+	// admins. Off by default: the scope is then ignored like any unknown one.
+	AllowAdmin bool
+
+	Leeway time.Duration // allowed clock drift for exp/nbf
+	Now    func() time.Time
+}
+
+// LooksLikeJWT tells a JWT apart from an API key token.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Validate verifies token and returns its principal. Every failure is
+// ErrUnauthenticated.
+func (v *JWTValidator) Validate(ctx context.Context, token string) (Principal, error) {
+	claims, err := v.verify(ctx, token)
+	if err != nil {
+		return Principal{}, ErrUnauthenticated
+	}
+	userClaim, scopeClaim := v.UserClaim, v.ScopeClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	if scopeClaim == "" {
+		scopeClaim = "scope"
+	}
+
+	var scopes []string
+	switch s := claims[scopeClaim].(type) {
+	case string:
+		scopes = strings.Fields(s)
+	case []any:
+		for _, x := range s {
+			if str, ok := x.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+	}
+	// Scopes the gateway doesn't know are ignored rather than rejected, since
+	// the provider's tokens usually carry scopes for other services too.
+	scopes = slices.DeleteFunc(scopes, func(s string) bool {
+		if s == ScopeAdmin {
+			return !v.AllowAdmin
+		}
+		return !slices.Contains(TenantScopes, s)
+	})
+
+	p := Principal{Scopes: scopes, Admin: slices.Contains(scopes, ScopeAdmin)}
+	if !p.Admin {
+		uid, _ := claims[userClaim].(string)
+		if uid == "" {
+			return Principal{}, ErrUnauthenticated
+		}
+		p.UserID = uid
+	}
+	return p, nil
+}
+
+func (v *JWTValidator) verify(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("jwt: malformed")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	key, err := v.Keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	// The algorithm must match the key type, so an RSA key can't be used to
+	// accept a token that claims some other algorithm.
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return nil, errors.New("jwt: bad signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 ||
+			!ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return nil, errors.New("jwt: bad signature")
+		}
+	default:
+		return nil, errors.New("jwt: unsupported key")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	t := now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || t.After(time.Unix(int64(exp), 0).Add(v.Leeway)) {
+		return nil, errors.New("jwt: expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && t.Add(v.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("jwt: not yet valid")
+	}
+	if v.Issuer != "" && claims["iss"] != v.Issuer {
+		return nil, errors.New("jwt: wrong issuer")
+	}
+	if v.Audience != "" && !hasAudience(claims["aud"], v.Audience) {
+		return nil, errors.New("jwt: wrong audience")
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func hasAudience(aud any, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []any:
+		return slices.Contains(a, any(want))
+	}
+	return false
+}
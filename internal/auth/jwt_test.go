@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	input := b64(h) + "." + b64(c)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		s, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = s
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64(sig)
+}
+
+func TestJWTValidator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPub, _ := ecKey.PublicKey.Bytes()
+	set, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kid": "rsa1", "kty": "RSA", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kid": "ec1", "kty": "EC", "crv": "P-256", "x": b64(ecPub[1:33]), "y": b64(ecPub[33:])},
+	}})
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, set, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &JWTValidator{
+		Keys:       &JWKS{File: file},
+		Issuer:     "https://idp.example",
+		Audience:   "sms-gateway",
+		UserClaim:  "gateway_user",
+		ScopeClaim: "scope",
+	}
+	ctx := context.Background()
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := map[string]any{
+		"iss": "https://idp.example", "aud": []string{"sms-gateway"}, "exp": exp,
+		"gateway_user": "u-1", "scope": "messages:send openid",
+	}
+
+	for _, tc := range []struct {
+		alg, kid string
+		key      crypto.Signer
+	}{{"RS256", "rsa1", rsaKey}, {"ES256", "ec1", ecKey}} {
+		p, err := v.Validate(ctx, signJWT(t, tc.alg, tc.kid, tc.key, claims))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.alg, err)
+		}
+		if p.UserID != "u-1" || !slices.Equal(p.Scopes, []string{ScopeMessagesSend}) || p.Admin {
+			t.Fatalf("%s: principal = %+v", tc.alg, p)
+		}
+	}
+
+	bad := map[string]map[string]any{
+		"expired":        {"exp": time.Now().Add(-time.Hour).Unix()},
+		"wrong issuer":   {"iss": "https://evil.example"},
+		"wrong audience": {"aud": "other"},
+		"no user":        {"gateway_user": ""},
+	}
+	for name, override := range bad {
+		c := map[string]any{}
+		for k, v := range claims {
+			c[k] = v
+		}
+		for k, v := range override {
+			c[k] = v
+		}
+		if _, err := v.Validate(ctx, signJWT(t, "RS256", "rsa1", rsaKey, c)); err != ErrUnauthenticated {
+			t.Errorf("%s: err = %v, want ErrUnauthenticated", name, err)
+		}
+	}
+
+	// admin:* only counts when the operator allows it
+	admin := map[string]any{"iss": "https://idp.example", "aud": "sms-gateway", "exp": exp, "scope": "admin:*"}
+	if _, err := v.Validate(ctx, signJWT(t, "RS256", "rsa1", rsaKey, admin)); err != ErrUnauthenticated {
+		t.Errorf("admin:* without AllowAdmin: err = %v, want ErrUnauthenticated", err)
+	}
+	v.AllowAdmin = true
+	if p, err := v.Validate(ctx, signJWT(t, "RS256", "rsa1", rsaKey, admin)); err != nil || !p.Admin {
+		t.Errorf("admin:* with AllowAdmin: principal = %+v, err = %v", p, err)
+	}
+	v.AllowAdmin = false
+
+	// Algorithm must match the key type
+	if _, err := v.Validate(ctx, signJWT(t, "ES256", "rsa1", rsaKey, claims)); err != ErrUnauthenticated {
+		t.Errorf("alg mismatch: err = %v", err)
+	}
+	// Unknown kid
+	if _, err := v.Validate(ctx, signJWT(t, "RS256", "nope", rsaKey, claims)); err != ErrUnauthenticated {
+		t.Errorf("unknown kid: err = %v", err)
+	}
+	// Tampered payload under the original signature
+	parts := strings.Split(signJWT(t, "RS256", "rsa1", rsaKey, claims), ".")
+	forged, _ := json.Marshal(map[string]any{"iss": "https://idp.example", "aud": "sms-gateway", "exp": exp, "gateway_user": "u-2"})
+	if _, err := v.Validate(ctx, parts[0]+"."+b64(forged)+"."+parts[2]); err != ErrUnauthenticated {
+		t.Errorf("tampered: err = %v", err)
+	}
+}
+
+func TestJWKSRefreshOnUnknownKid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	write := func(kid string) {
+		set := `{"keys":[{"kid":"` + kid + `","kty":"RSA","n":"AQAB","e":"AQAB"}]}`
+		if err := os.WriteFile(file, []byte(set), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("k1")
+	j := &JWKS{File: file, MinRefresh: time.Nanosecond}
+	ctx := context.Background()
+	if _, err := j.Key(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	write("k2") // provider rotated its keys
+	time.Sleep(time.Millisecond)
+	if _, err := j.Key(ctx, "k2"); err != nil {
+		t.Fatalf("rotated key not picked up: %v", err)
+	}
+	if _, err := j.Key(ctx, "k1"); err != ErrUnknownKey {
+		t.Fatalf("retired key: err = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestJWKSReloadsOnceWithoutBlockingKnownKeys(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) == 1 {
+			_, _ = w.Write([]byte(`{"keys":[{"kid":"k1","kty":"RSA","n":"AQAB","e":"AQAB"}]}`))
+			return
+		}
+		<-release // a slow provider, after rotating in k2
+		_, _ = w.Write([]byte(`{"keys":[{"kid":"k1","kty":"RSA","n":"AQAB","e":"AQAB"},{"kid":"k2","kty":"RSA","n":"AQAB","e":"AQAB"}]}`))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	j := &JWKS{URL: srv.URL, MinRefresh: time.Nanosecond}
+	ctx := context.Background()
+	if _, err := j.Key(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	// Lookups of a new kid share one reload and wait for it...
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := j.Key(ctx, "k2"); err != nil {
+				t.Errorf("rotated key: %v", err)
+			}
+		}()
+	}
+	for fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	// ...while a known one is still served from the cache.
+	if _, err := j.Key(ctx, "k1"); err != nil {
+		t.Fatalf("known kid blocked behind the reload: %v", err)
+	}
+	release <- struct{}{}
+	wg.Wait()
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", n)
+	}
+}
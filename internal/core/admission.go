@@ -0,0 +1,63 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+)
+
+// QueueLimits bound how many messages may be outstanding (queued or sending)
+// per user and across all users.
+type QueueLimits struct {
+	PerUser int
+	Global  int
+}
+
+var ErrQueueFull = errors.New("queue_full")
+
+// QueueFullError reports which limit an enqueue hit; it matches ErrQueueFull.
+type QueueFullError struct {
+	Scope string // "user" | "global"
+	Depth int
+	Limit int
+}
+
+func (e *QueueFullError) Error() string {
+	return fmt.Sprintf("queue_full: %s depth %d reached limit %d", e.Scope, e.Depth, e.Limit)
+}
+
+func (e *QueueFullError) Is(target error) bool { return target == ErrQueueFull }
+
+// admit rejects an enqueue for userID when it would exceed QueueLimits. The
+// error carries the actual depth, which may be above the limit.
+func (s *Store) admit(ctx context.Context, q *dbgen.Queries, userID string) error {
+	if l := s.QueueLimits.PerUser; l > 0 {
+		depth, err := q.CountOutstandingForUser(ctx, dbgen.CountOutstandingForUserParams{UserID: userID, Cap: int32(l)})
+		if err != nil {
+			return err
+		}
+		if int(depth) >= l {
+			// Only a rejection pays for counting past the limit.
+			if depth, err = q.CountOutstandingForUser(ctx, dbgen.CountOutstandingForUserParams{UserID: userID, Cap: math.MaxInt32}); err != nil {
+				return err
+			}
+			return &QueueFullError{Scope: "user", Depth: int(depth), Limit: l}
+		}
+	}
+	if l := s.QueueLimits.Global; l > 0 {
+		depth, err := q.CountOutstanding(ctx, int32(l))
+		if err != nil {
+			return err
+		}
+		if int(depth) >= l {
+			if depth, err = q.CountOutstanding(ctx, math.MaxInt32); err != nil {
+				return err
+			}
+			return &QueueFullError{Scope: "global", Depth: int(depth), Limit: l}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrInvalidThreshold = errors.New("invalid_threshold")
+	ErrThresholdExists  = errors.New("threshold_exists")
+)
+
+const (
+	NotifyChannelWebhook = "webhook"
+	NotifyChannelSMS     = "sms"
+)
+
+// ---- Balance thresholds ----
+
+type ThresholdRequest struct {
+	UserID       string
+	Threshold    int
+	WebhookURL   *string
+	NotifyMSISDN *string
+}
+
+func (s *Store) CreateBalanceThreshold(ctx context.Context, req ThresholdRequest) (dbgen.BalanceThreshold, error) {
+	if req.Threshold <= 0 {
+		return dbgen.BalanceThreshold{}, ErrInvalidThreshold
+	}
+	if req.WebhookURL == nil && req.NotifyMSISDN == nil {
+		return dbgen.BalanceThreshold{}, ErrInvalidThreshold
+	}
+	if req.WebhookURL != nil && !s.checkWebhookURL(ctx, *req.WebhookURL) {
+		return dbgen.BalanceThreshold{}, ErrInvalidThreshold
+	}
+	var t dbgen.BalanceThreshold
+	err := s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		var e error
+		t, e = q.CreateBalanceThreshold(ctx, dbgen.CreateBalanceThresholdParams{
+			Threshold:    int32(req.Threshold),
+			WebhookUrl:   toPgText(req.WebhookURL),
+			NotifyMsisdn: toPgText(req.NotifyMSISDN),
+			UserID:       req.UserID,
+		})
+		if e != nil {
+			return e
+		}
+		return record(ctx, q, AuditThresholdCreate, TargetThreshold, t.ID, nil, t)
+	})
+	if isUniqueViolation(err) {
+		return t, ErrThresholdExists
+	}
+	return t, mapNoRows(err, ErrUserNotFound)
+}
+
+func (s *Store) ListBalanceThresholds(ctx context.Context, userID string) ([]dbgen.BalanceThreshold, error) {
+	items, err := s.DB.Queries.ListBalanceThresholds(ctx, userID)
+	return items, mapNoRows(err, ErrUserNotFound)
+}
+
+func (s *Store) DeleteBalanceThreshold(ctx context.Context, userID, id string) error {
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		t, e := q.DeleteBalanceThreshold(ctx, dbgen.DeleteBalanceThresholdParams{ID: id, UserID: userID})
+		if e != nil {
+			return mapNoRows(e, ErrNotFound)
+		}
+		return record(ctx, q, AuditThresholdDelete, TargetThreshold, t.ID, t, nil)
+	})
+}
+
+// tripThresholds disarms every armed threshold the user's balance is now below
+// and queues one notification per configured channel, plus a balance.low
+// webhook event. Must run in the debit tx.
+func tripThresholds(ctx context.Context, q *dbgen.Queries, userID string) error {
+	tripped, err := q.TripBalanceThresholds(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, t := range tripped {
+		targets := []struct {
+			channel string
+			target  pgtype.Text
+		}{
+			{NotifyChannelWebhook, t.WebhookUrl},
+			{NotifyChannelSMS, t.NotifyMsisdn},
+		}
+		for _, c := range targets {
+			if !c.target.Valid {
+				continue
+			}
+			if err := q.InsertBalanceNotification(ctx, dbgen.InsertBalanceNotificationParams{
+				UserID:      userID,
+				ThresholdID: toPgUUID(t.ID),
+				Threshold:   t.Threshold,
+				Balance:     t.Balance,
+				Channel:     c.channel,
+				Target:      c.target.String,
+			}); err != nil {
+				return err
+			}
+		}
+		if err := enqueueWebhookEvent(ctx, q, userID, EventBalanceLow, balanceEvent{
+			UserID: userID, Threshold: t.Threshold, Balance: t.Balance,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ---- Notification outbox (worker side) ----
+
+func (s *Store) ClaimBalanceNotifications(ctx context.Context, limit int, lease time.Duration) ([]dbgen.ClaimBalanceNotificationsRow, error) {
+	return s.DB.Queries.ClaimBalanceNotifications(ctx, dbgen.ClaimBalanceNotificationsParams{
+		LeaseSeconds: int32(lease / time.Second),
+		LimitN:       int32(limit),
+	})
+}
+
+func (s *Store) MarkBalanceNotificationDelivered(ctx context.Context, id int64) error {
+	return s.DB.Queries.MarkBalanceNotificationDelivered(ctx, id)
+}
+
+func (s *Store) RetryBalanceNotification(ctx context.Context, id int64, retryIn time.Duration, cause error) error {
+	msg := cause.Error()
+	return s.DB.Queries.RetryBalanceNotification(ctx, dbgen.RetryBalanceNotificationParams{
+		DelaySeconds: int32(retryIn / time.Second),
+		LastError:    toPgText(&msg),
+		ID:           id,
+	})
+}
+
+func (s *Store) FailBalanceNotification(ctx context.Context, id int64, cause error) error {
+	msg := cause.Error()
+	return s.DB.Queries.FailBalanceNotification(ctx, dbgen.FailBalanceNotificationParams{
+		LastError: toPgText(&msg),
+		ID:        id,
+	})
+}
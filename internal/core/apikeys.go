@@ -0,0 +1,406 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/auth"
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+)
+
+const (
+	KeyKindTenant = "tenant"
+	KeyKindAdmin  = "admin"
+)
+
+var (
+	ErrKeyInactive  = errors.New("key_inactive")
+	ErrInvalidScope = errors.New("invalid_scope")
+)
+
+// APIKey is a key's public view; the hash never leaves the store.
+type APIKey struct {
+	ID          string         `json:"id"`
+	UserID      *string        `json:"user_id"`   // nil for admin keys
+	MemberID    *string        `json:"member_id"` // nil for admin and account keys
+	Kind        string         `json:"kind"`
+	Scopes      []string       `json:"scopes"`
+	Name        string         `json:"name"`
+	Prefix      string         `json:"prefix"`
+	CreatedAt   time.Time      `json:"created_at"`
+	LastUsedAt  *time.Time     `json:"last_used_at"`
+	ExpiresAt   *time.Time     `json:"expires_at"`
+	RevokedAt   *time.Time     `json:"revoked_at"`
+	ReplacedBy  *string        `json:"replaced_by"`
+	Signing     bool           `json:"signing"`      // accepts HMAC-signed requests
+	IPAllowlist []netip.Prefix `json:"ip_allowlist"` // empty = any address
+}
+
+func toAPIKey(k dbgen.ApiKey) APIKey {
+	out := APIKey{ID: k.ID, Kind: k.Kind, Scopes: k.Scopes, Name: k.Name, Prefix: k.Prefix, CreatedAt: k.CreatedAt.Time, Signing: k.SigningSecret != nil, IPAllowlist: k.IpAllowlist}
+	out.UserID = uuidPtr(k.UserID)
+	out.MemberID = uuidPtr(k.MemberID)
+	if k.LastUsedAt.Valid {
+		out.LastUsedAt = &k.LastUsedAt.Time
+	}
+	if k.ExpiresAt.Valid {
+		out.ExpiresAt = &k.ExpiresAt.Time
+	}
+	if k.RevokedAt.Valid {
+		out.RevokedAt = &k.RevokedAt.Time
+	}
+	if k.ReplacedBy.Valid {
+		s := k.ReplacedBy.String()
+		out.ReplacedBy = &s
+	}
+	return out
+}
+
+func keyActive(k dbgen.ApiKey, now time.Time) bool {
+	return !k.RevokedAt.Valid && (!k.ExpiresAt.Valid || k.ExpiresAt.Time.After(now))
+}
+
+// insertAPIKey stores a new key; userID is empty for admin keys and memberID
+// for keys not issued to a member.
+func insertAPIKey(ctx context.Context, q *dbgen.Queries, userID, memberID, kind string, scopes []string, name string) (APIKey, string, error) {
+	token, prefix, hash, err := auth.NewToken()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	k, err := q.InsertAPIKey(ctx, dbgen.InsertAPIKeyParams{
+		UserID:     toPgUUID(userID),
+		MemberID:   toPgUUID(memberID),
+		Kind:       kind,
+		Scopes:     scopes,
+		Name:       name,
+		Prefix:     prefix,
+		SecretHash: hash,
+	})
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	return toAPIKey(k), token, nil
+}
+
+// CreateUserWithKey creates an organization with owner as its first member,
+// and an API key for that member. The token is only ever returned here.
+func (s *Store) CreateUserWithKey(ctx context.Context, name string, owner NewMember) (userID, token string, err error) {
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		u, e := q.CreateUser(ctx, name)
+		if e != nil {
+			return e
+		}
+		userID = u.ID
+		if e := record(ctx, q, AuditUserCreate, TargetUser, u.ID, nil, u); e != nil {
+			return e
+		}
+		if owner.Name == "" {
+			owner.Name = name
+		}
+		m, e := addMember(ctx, q, u.ID, owner, auth.RoleOwner)
+		if e != nil {
+			return e
+		}
+		var k APIKey
+		k, token, e = insertAPIKey(ctx, q, u.ID, m.ID, KeyKindTenant, auth.TenantScopes, "default")
+		if e != nil {
+			return e
+		}
+		return record(ctx, q, AuditKeyCreate, TargetAPIKey, k.ID, nil, k)
+	})
+	return userID, token, err
+}
+
+// CreateAPIKey issues a tenant key for userID, to memberID when set (an
+// account key otherwise). A key never gets more than grantable (the caller's
+// own scopes, see auth.Principal.Grantable) or the member's role allows. Nil
+// scopes means all of that; explicit scopes must all be within it.
+func (s *Store) CreateAPIKey(ctx context.Context, userID, memberID, name string, scopes, grantable []string) (APIKey, string, error) {
+	if scopes != nil && (len(scopes) == 0 || !auth.ValidTenantScopes(scopes)) {
+		return APIKey{}, "", ErrInvalidScope
+	}
+	if !toPgUUID(userID).Valid {
+		return APIKey{}, "", ErrUserNotFound
+	}
+	var k APIKey
+	var token string
+	err := s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		if _, e := q.LockLiveUser(ctx, userID); e != nil {
+			return mapNoRows(e, ErrUserNotFound)
+		}
+		allowed := grantable
+		if memberID != "" {
+			role, e := q.GetMembershipRole(ctx, dbgen.GetMembershipRoleParams{AccountID: userID, MemberID: memberID})
+			if e != nil {
+				return mapNoRows(e, ErrMemberNotFound)
+			}
+			allowed = auth.NarrowToRole(allowed, role)
+		}
+		if scopes == nil {
+			scopes = allowed
+		} else if !subset(scopes, allowed) {
+			return ErrInvalidScope
+		}
+		var e error
+		if k, token, e = insertAPIKey(ctx, q, userID, memberID, KeyKindTenant, scopes, name); e != nil {
+			return e
+		}
+		return record(ctx, q, AuditKeyCreate, TargetAPIKey, k.ID, nil, k)
+	})
+	if isForeignKeyViolation(err) {
+		return APIKey{}, "", ErrUserNotFound
+	}
+	return k, token, err
+}
+
+// CreateAdminKey issues a key that may act on any account. It is only exposed
+// through smsctl, so the first admin can be bootstrapped from the database.
+func (s *Store) CreateAdminKey(ctx context.Context, name string) (k APIKey, token string, err error) {
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		var e error
+		if k, token, e = insertAPIKey(ctx, q, "", "", KeyKindAdmin, []string{auth.ScopeAdmin}, name); e != nil {
+			return e
+		}
+		return record(ctx, q, AuditKeyCreate, TargetAPIKey, k.ID, nil, k)
+	})
+	return k, token, err
+}
+
+func (s *Store) ListAdminKeys(ctx context.Context) ([]APIKey, error) {
+	items, err := s.DB.Queries.ListAdminAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]APIKey, 0, len(items))
+	for _, k := range items {
+		out = append(out, toAPIKey(k))
+	}
+	return out, nil
+}
+
+func (s *Store) RevokeAdminKey(ctx context.Context, keyID string) error {
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		k, e := q.RevokeAdminAPIKey(ctx, keyID)
+		if e != nil {
+			return mapNoRows(e, ErrNotFound)
+		}
+		return recordRevoke(ctx, q, k)
+	})
+}
+
+// recordRevoke audits a revocation; the key was active (unrevoked) before,
+// or the revoke would have matched no row.
+func recordRevoke(ctx context.Context, q *dbgen.Queries, k dbgen.ApiKey) error {
+	after := toAPIKey(k)
+	before := after
+	before.RevokedAt = nil
+	return record(ctx, q, AuditKeyRevoke, TargetAPIKey, k.ID, before, after)
+}
+
+func (s *Store) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	items, err := s.DB.Queries.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, mapNoRows(err, ErrUserNotFound)
+	}
+	out := make([]APIKey, 0, len(items))
+	for _, k := range items {
+		out = append(out, toAPIKey(k))
+	}
+	return out, nil
+}
+
+// lockKeyFor locks userID's key keyID for caller to change. Besides their
+// own keys, callers may only change keys that can't do more than they could
+// grant, and another member's key takes members:manage; other keys are
+// reported as not found.
+func lockKeyFor(ctx context.Context, q *dbgen.Queries, caller auth.Principal, userID, keyID string) (dbgen.ApiKey, error) {
+	k, err := q.GetAPIKeyForUpdate(ctx, dbgen.GetAPIKeyForUpdateParams{ID: keyID, UserID: userID})
+	if err != nil {
+		return dbgen.ApiKey{}, mapNoRows(err, ErrNotFound)
+	}
+	if k.MemberID.Valid && k.MemberID.String() == caller.MemberID {
+		return k, nil // narrowed to the caller's own role anyway
+	}
+	if (k.MemberID.Valid && !caller.Allows(auth.ScopeMembersManage)) || !subset(k.Scopes, caller.Grantable()) {
+		return dbgen.ApiKey{}, ErrNotFound
+	}
+	return k, nil
+}
+
+// RotateAPIKey issues a replacement for keyID with the same scopes and IP
+// allowlist. The old key keeps working for grace (zero retires it
+// immediately) so clients can roll over.
+func (s *Store) RotateAPIKey(ctx context.Context, caller auth.Principal, userID, keyID string, grace time.Duration) (key APIKey, token string, err error) {
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		old, e := lockKeyFor(ctx, q, caller, userID, keyID)
+		if e != nil {
+			return e
+		}
+		if !keyActive(old, time.Now()) {
+			return ErrKeyInactive
+		}
+		key, token, e = insertAPIKey(ctx, q, userID, old.MemberID.String(), old.Kind, old.Scopes, old.Name)
+		if e != nil {
+			return e
+		}
+		if len(old.IpAllowlist) > 0 {
+			k, e := q.SetAPIKeyIPAllowlist(ctx, dbgen.SetAPIKeyIPAllowlistParams{IpAllowlist: old.IpAllowlist, ID: key.ID, UserID: userID})
+			if e != nil {
+				return e
+			}
+			key = toAPIKey(k)
+		}
+		if e := q.RetireAPIKey(ctx, dbgen.RetireAPIKeyParams{
+			GraceSeconds: int32(grace / time.Second),
+			ReplacedBy:   key.ID,
+			ID:           old.ID,
+		}); e != nil {
+			return e
+		}
+		return record(ctx, q, AuditKeyRotate, TargetAPIKey, old.ID, toAPIKey(old), key)
+	})
+	return key, token, err
+}
+
+func (s *Store) RevokeAPIKey(ctx context.Context, caller auth.Principal, userID, keyID string) error {
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		if _, e := lockKeyFor(ctx, q, caller, userID, keyID); e != nil {
+			return e
+		}
+		k, e := q.RevokeAPIKey(ctx, dbgen.RevokeAPIKeyParams{ID: keyID, UserID: userID})
+		if e != nil {
+			return mapNoRows(e, ErrNotFound)
+		}
+		return recordRevoke(ctx, q, k)
+	})
+}
+
+// principalOf resolves a key to the caller it acts as. A member's key is
+// narrowed to the member's current role, and stops working once the member
+// leaves the organization.
+func principalOf(ctx context.Context, q *dbgen.Queries, k dbgen.ApiKey) (auth.Principal, error) {
+	p := auth.Principal{KeyID: k.ID, Admin: k.Kind == KeyKindAdmin, Scopes: k.Scopes}
+	if k.UserID.Valid {
+		p.UserID = k.UserID.String()
+	}
+	if k.MemberID.Valid {
+		role, err := q.GetMembershipRole(ctx, dbgen.GetMembershipRoleParams{AccountID: p.UserID, MemberID: k.MemberID.String()})
+		if err != nil {
+			return auth.Principal{}, mapNoRows(err, auth.ErrUnauthenticated)
+		}
+		p.MemberID, p.Role = k.MemberID.String(), role
+		p.Scopes = auth.NarrowToRole(k.Scopes, role)
+	}
+	return p, nil
+}
+
+// AuthenticateAPIKey resolves a bearer token to its owner. Unknown, malformed,
+// revoked and expired tokens all yield auth.ErrUnauthenticated.
+func (s *Store) AuthenticateAPIKey(ctx context.Context, token string) (auth.Principal, error) {
+	prefix, ok := auth.Prefix(token)
+	if !ok {
+		return auth.Principal{}, auth.ErrUnauthenticated
+	}
+	k, err := s.DB.Queries.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return auth.Principal{}, mapNoRows(err, auth.ErrUnauthenticated)
+	}
+	if !auth.Verify(token, k.SecretHash) || !keyActive(k, time.Now()) {
+		return auth.Principal{}, auth.ErrUnauthenticated
+	}
+	if err := s.DB.Queries.TouchAPIKey(ctx, k.ID); err != nil {
+		return auth.Principal{}, err
+	}
+	return principalOf(ctx, s.DB.Queries, k)
+}
+
+// EnableSigning issues a new HMAC signing secret for an active key, replacing
+// any earlier one. The secret is only ever returned here.
+func (s *Store) EnableSigning(ctx context.Context, caller auth.Principal, userID, keyID string) (APIKey, string, error) {
+	secret, err := auth.NewSigningSecret()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	var key APIKey
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		if _, e := lockKeyFor(ctx, q, caller, userID, keyID); e != nil {
+			return e
+		}
+		k, e := q.SetAPIKeySigningSecret(ctx, dbgen.SetAPIKeySigningSecretParams{
+			SigningSecret: []byte(secret),
+			ID:            keyID,
+			UserID:        userID,
+		})
+		if e != nil {
+			return mapNoRows(e, ErrNotFound)
+		}
+		key = toAPIKey(k)
+		// The secret itself never reaches the audit log.
+		return record(ctx, q, AuditKeySigning, TargetAPIKey, k.ID, nil, key)
+	})
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	return key, secret, nil
+}
+
+// SigningKey resolves the key ID of a signed request (the key's prefix) to its
+// owner and signing secret. Unknown and inactive keys, and keys without
+// signing enabled, yield auth.ErrUnauthenticated.
+func (s *Store) SigningKey(ctx context.Context, prefix string) (auth.Principal, []byte, error) {
+	k, err := s.DB.Queries.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return auth.Principal{}, nil, mapNoRows(err, auth.ErrUnauthenticated)
+	}
+	if k.SigningSecret == nil || !keyActive(k, time.Now()) {
+		return auth.Principal{}, nil, auth.ErrUnauthenticated
+	}
+	p, err := principalOf(ctx, s.DB.Queries, k)
+	if err != nil {
+		return auth.Principal{}, nil, err
+	}
+	return p, k.SigningSecret, nil
+}
+
+// TouchAPIKey records that keyID was just used.
+func (s *Store) TouchAPIKey(ctx context.Context, keyID string) error {
+	return s.DB.Queries.TouchAPIKey(ctx, keyID)
+}
+
+// Claim implements signing.NonceCache on Postgres, so replays are caught
+// across API replicas.
+func (s *Store) Claim(ctx context.Context, keyID, nonce string, expires time.Time) (bool, error) {
+	n, err := s.DB.Queries.ClaimRequestNonce(ctx, dbgen.ClaimRequestNonceParams{
+		KeyID:     keyID,
+		Nonce:     nonce,
+		ExpiresAt: toPgTimestamptz(&expires),
+	})
+	return n == 1, err
+}
+
+// PurgeNonces drops nonces that can no longer be replayed.
+func (s *Store) PurgeNonces(ctx context.Context) (int64, error) {
+	return s.DB.Queries.PurgeRequestNonces(ctx)
+}
+
+// CanActFor reports whether the caller may act on userID's resources: its own
+// account, or one of its sub-accounts that hasn't been deleted. Admin keys
+// may act on any account.
+func (s *Store) CanActFor(ctx context.Context, p auth.Principal, userID string) (bool, error) {
+	if p.Admin || (p.UserID != "" && p.UserID == userID) {
+		return true, nil
+	}
+	u, err := s.DB.Queries.GetUser(ctx, userID)
+	if err != nil {
+		if errors.Is(mapNoRows(err, ErrUserNotFound), ErrUserNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if u.DeletedAt.Valid {
+		return false, nil
+	}
+	return u.ParentID.Valid && u.ParentID.String() == p.UserID, nil
+}
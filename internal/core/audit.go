@@ -0,0 +1,159 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/audit"
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Audited actions.
+const (
+	AuditUserCreate      = "user.create"
+	AuditUserUpdate      = "user.update"
+	AuditUserSuspend     = "user.suspend"
+	AuditUserReinstate   = "user.reinstate"
+	AuditUserDelete      = "user.delete"
+	AuditPlanSet         = "user.plan_set"
+	AuditTopUp           = "balance.topup"
+	AuditTransfer        = "balance.transfer"
+	AuditCreditGrant     = "credit.grant"
+	AuditThresholdCreate = "threshold.create"
+	AuditThresholdDelete = "threshold.delete"
+	AuditChildUpdate     = "subaccount.update"
+	AuditKeyCreate       = "key.create"
+	AuditKeyRotate       = "key.rotate"
+	AuditKeyRevoke       = "key.revoke"
+	AuditKeySigning      = "key.signing_enable"
+)
+
+// Audit target types.
+const (
+	TargetUser      = "user"
+	TargetAPIKey    = "api_key"
+	TargetThreshold = "threshold"
+	TargetCredit    = "credit"
+)
+
+// record appends an audit event attributed to the context's actor. Call it in
+// the same transaction as the change so one is never stored without the
+// other. before/after are JSON snapshots; nil is stored as NULL.
+func record(ctx context.Context, q *dbgen.Queries, action, targetType, targetID string, before, after any) error {
+	a := audit.FromContext(ctx)
+	b, err := snapshot(before)
+	if err != nil {
+		return err
+	}
+	af, err := snapshot(after)
+	if err != nil {
+		return err
+	}
+	return q.InsertAuditEvent(ctx, dbgen.InsertAuditEventParams{
+		ActorType:   a.Type,
+		ActorKeyID:  toPgUUID(a.KeyID),
+		ActorUserID: toPgUUID(a.UserID),
+		Action:      action,
+		TargetType:  targetType,
+		TargetID:    targetID,
+		RequestID:   textOrNull(a.RequestID),
+		SourceIp:    textOrNull(a.SourceIP),
+		Before:      b,
+		After:       af,
+	})
+}
+
+func snapshot(v any) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+func textOrNull(s string) pgtype.Text {
+	return pgtype.Text{String: s, Valid: s != ""}
+}
+
+// AuditEvent is the API view of an audit_events row.
+type AuditEvent struct {
+	ID          int64           `json:"id"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+	ActorType   string          `json:"actor_type"`
+	ActorKeyID  *string         `json:"actor_key_id"`
+	ActorUserID *string         `json:"actor_user_id"`
+	Action      string          `json:"action"`
+	TargetType  string          `json:"target_type"`
+	TargetID    string          `json:"target_id"`
+	RequestID   *string         `json:"request_id"`
+	SourceIP    *string         `json:"source_ip"`
+	Before      json.RawMessage `json:"before"`
+	After       json.RawMessage `json:"after"`
+}
+
+func uuidPtr(u pgtype.UUID) *string {
+	if !u.Valid {
+		return nil
+	}
+	s := u.String()
+	return &s
+}
+
+func textPtr(t pgtype.Text) *string {
+	if !t.Valid {
+		return nil
+	}
+	return &t.String
+}
+
+// AuditFilter narrows ListAuditEvents; zero fields don't filter.
+type AuditFilter struct {
+	ActorUserID string
+	ActorKeyID  string
+	Action      string
+	TargetType  string
+	TargetID    string
+	From, To    *time.Time
+	BeforeID    int64 // page backwards from this id
+	Limit       int
+}
+
+func (s *Store) ListAuditEvents(ctx context.Context, f AuditFilter) ([]AuditEvent, error) {
+	var beforeID pgtype.Int8
+	if f.BeforeID > 0 {
+		beforeID = pgtype.Int8{Int64: f.BeforeID, Valid: true}
+	}
+	rows, err := s.DB.Queries.ListAuditEvents(ctx, dbgen.ListAuditEventsParams{
+		ActorUserID: toPgUUID(f.ActorUserID),
+		ActorKeyID:  toPgUUID(f.ActorKeyID),
+		Action:      textOrNull(f.Action),
+		TargetType:  textOrNull(f.TargetType),
+		TargetID:    textOrNull(f.TargetID),
+		FromTs:      toPgTimestamptz(f.From),
+		ToTs:        toPgTimestamptz(f.To),
+		BeforeID:    beforeID,
+		LimitN:      int32(f.Limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]AuditEvent, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, AuditEvent{
+			ID:          r.ID,
+			OccurredAt:  r.OccurredAt.Time,
+			ActorType:   r.ActorType,
+			ActorKeyID:  uuidPtr(r.ActorKeyID),
+			ActorUserID: uuidPtr(r.ActorUserID),
+			Action:      r.Action,
+			TargetType:  r.TargetType,
+			TargetID:    r.TargetID,
+			RequestID:   textPtr(r.RequestID),
+			SourceIP:    textPtr(r.SourceIp),
+			Before:      r.Before,
+			After:       r.After,
+		})
+	}
+	return out, nil
+}
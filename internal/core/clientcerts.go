@@ -0,0 +1,134 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/auth"
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+)
+
+var (
+	ErrInvalidIdentity = errors.New("invalid_identity")
+	ErrIdentityTaken   = errors.New("identity_taken")
+)
+
+const (
+	AuditCertCreate = "client_cert.create"
+	AuditCertRevoke = "client_cert.revoke"
+
+	TargetClientCert = "client_cert"
+)
+
+// ClientCert binds a client certificate identity to an account.
+type ClientCert struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Identity   string     `json:"identity"`
+	Scopes     []string   `json:"scopes"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+func toClientCert(c dbgen.ClientCertificate) ClientCert {
+	out := ClientCert{ID: c.ID, UserID: c.UserID, Identity: c.Identity, Scopes: c.Scopes, Name: c.Name, CreatedAt: c.CreatedAt.Time}
+	if c.LastUsedAt.Valid {
+		out.LastUsedAt = &c.LastUsedAt.Time
+	}
+	if c.RevokedAt.Valid {
+		out.RevokedAt = &c.RevokedAt.Time
+	}
+	return out
+}
+
+// CreateClientCert lets certificates presenting identity act for userID. Nil
+// scopes means all tenant scopes.
+func (s *Store) CreateClientCert(ctx context.Context, userID, identity, name string, scopes []string) (ClientCert, error) {
+	identity, ok := auth.NormalizeCertIdentity(identity)
+	if !ok {
+		return ClientCert{}, ErrInvalidIdentity
+	}
+	if scopes == nil {
+		scopes = auth.TenantScopes
+	}
+	if len(scopes) == 0 || !auth.ValidTenantScopes(scopes) {
+		return ClientCert{}, ErrInvalidScope
+	}
+	if !toPgUUID(userID).Valid {
+		return ClientCert{}, ErrUserNotFound
+	}
+	var out ClientCert
+	err := s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		if _, e := q.LockLiveUser(ctx, userID); e != nil {
+			return mapNoRows(e, ErrUserNotFound)
+		}
+		c, e := q.InsertClientCertificate(ctx, dbgen.InsertClientCertificateParams{
+			UserID: userID, Identity: identity, Scopes: scopes, Name: name,
+		})
+		if e != nil {
+			return e
+		}
+		out = toClientCert(c)
+		return record(ctx, q, AuditCertCreate, TargetClientCert, c.ID, nil, out)
+	})
+	switch {
+	case isUniqueViolation(err):
+		return ClientCert{}, ErrIdentityTaken
+	case isForeignKeyViolation(err):
+		return ClientCert{}, ErrUserNotFound
+	}
+	return out, err
+}
+
+func (s *Store) ListClientCerts(ctx context.Context, userID string) ([]ClientCert, error) {
+	items, err := s.DB.Queries.ListClientCertificates(ctx, userID)
+	if err != nil {
+		return nil, mapNoRows(err, ErrUserNotFound)
+	}
+	out := make([]ClientCert, 0, len(items))
+	for _, c := range items {
+		out = append(out, toClientCert(c))
+	}
+	return out, nil
+}
+
+func (s *Store) RevokeClientCert(ctx context.Context, userID, id string) error {
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		c, e := q.RevokeClientCertificate(ctx, dbgen.RevokeClientCertificateParams{ID: id, UserID: userID})
+		if e != nil {
+			return mapNoRows(e, ErrNotFound)
+		}
+		after := toClientCert(c)
+		before := after
+		before.RevokedAt = nil
+		return record(ctx, q, AuditCertRevoke, TargetClientCert, c.ID, before, after)
+	})
+}
+
+// AuthenticateClientCert resolves the identities of a verified client
+// certificate to the account bound to them. No binding, or bindings to more
+// than one account, yield auth.ErrUnauthenticated. When several of the
+// certificate's identities are bound to the same account, any one of them is
+// used.
+func (s *Store) AuthenticateClientCert(ctx context.Context, identities []string) (auth.Principal, error) {
+	found, err := s.DB.Queries.FindClientCertificates(ctx, identities)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	if len(found) == 0 {
+		return auth.Principal{}, auth.ErrUnauthenticated
+	}
+	c := found[0]
+	for _, other := range found[1:] {
+		if other.UserID != c.UserID {
+			return auth.Principal{}, auth.ErrUnauthenticated
+		}
+	}
+	if err := s.DB.Queries.TouchClientCertificate(ctx, c.ID); err != nil {
+		return auth.Principal{}, err
+	}
+	return auth.Principal{UserID: c.UserID, CertID: c.ID, Scopes: c.Scopes}, nil
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	LedgerKindPromoGrant  = "promo_grant"
+	LedgerKindPromoExpire = "promo_expire"
+)
+
+var ErrInvalidGrant = errors.New("invalid_grant")
+
+// debit takes amount from the payer's earliest-expiring promotional bucket,
+// falling back to paid balance. The returned bucket id is invalid when the
+// paid balance was used.
+func debit(ctx context.Context, q *dbgen.Queries, payer string, amount int) (pgtype.UUID, error) {
+	bucketID, err := q.DebitCreditBucket(ctx, dbgen.DebitCreditBucketParams{Amount: int32(amount), UserID: payer})
+	if err == nil {
+		return toPgUUID(bucketID), nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return pgtype.UUID{}, err
+	}
+	rows, err := q.DebitIfEnough(ctx, dbgen.DebitIfEnoughParams{Balance: int32(amount), ID: payer})
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+	if rows == 0 {
+		return pgtype.UUID{}, ErrInsufficientBalance
+	}
+	return pgtype.UUID{}, nil
+}
+
+type GrantRequest struct {
+	UserID    string
+	Amount    int
+	ExpiresAt time.Time
+	Note      *string
+}
+
+// GrantCredit adds a promotional bucket that is spent before paid balance and
+// expires at req.ExpiresAt.
+func (s *Store) GrantCredit(ctx context.Context, req GrantRequest) (bucket dbgen.CreditBucket, err error) {
+	if req.Amount <= 0 || !req.ExpiresAt.After(time.Now()) {
+		return bucket, ErrInvalidGrant
+	}
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		if _, e := q.LockUserBalance(ctx, req.UserID); e != nil {
+			return mapNoRows(e, ErrUserNotFound)
+		}
+		b, e := q.InsertCreditBucket(ctx, dbgen.InsertCreditBucketParams{
+			UserID:    req.UserID,
+			Amount:    int32(req.Amount),
+			ExpiresAt: toPgTimestamptz(&req.ExpiresAt),
+			Note:      toPgText(req.Note),
+		})
+		if e != nil {
+			return e
+		}
+		bucket = b
+		if e := record(ctx, q, AuditCreditGrant, TargetCredit, b.ID, nil, b); e != nil {
+			return e
+		}
+		if _, e := q.InsertLedgerEntry(ctx, dbgen.InsertLedgerEntryParams{
+			Kind:           LedgerKindPromoGrant,
+			Amount:         int32(req.Amount),
+			Note:           toPgText(req.Note),
+			CreditBucketID: toPgUUID(b.ID),
+			UserID:         req.UserID,
+		}); e != nil {
+			return e
+		}
+		return q.RearmBalanceThresholds(ctx, req.UserID)
+	})
+	return bucket, err
+}
+
+// ListCredits returns the user's unexpired promotional buckets with credit left,
+// in the order they will be spent.
+func (s *Store) ListCredits(ctx context.Context, userID string) ([]dbgen.CreditBucket, error) {
+	items, err := s.DB.Queries.ListActiveCreditBuckets(ctx, userID)
+	return items, mapNoRows(err, ErrUserNotFound)
+}
+
+type BalanceBreakdown struct {
+	Balance     int        `json:"balance"` // paid + promotional
+	Paid        int        `json:"paid"`
+	Promotional int        `json:"promotional"`
+	NextExpiry  *time.Time `json:"next_expiry"` // earliest expiry among promotional buckets
+}
+
+func (s *Store) GetBalanceBreakdown(ctx context.Context, userID string) (BalanceBreakdown, error) {
+	row, err := s.DB.Queries.GetBalanceBreakdown(ctx, userID)
+	if err != nil {
+		return BalanceBreakdown{}, mapNoRows(err, ErrUserNotFound)
+	}
+	out := BalanceBreakdown{
+		Balance:     int(row.Paid + row.Promotional),
+		Paid:        int(row.Paid),
+		Promotional: int(row.Promotional),
+	}
+	if row.NextExpiry.Valid {
+		t := row.NextExpiry.Time
+		out.NextExpiry = &t
+	}
+	return out, nil
+}
+
+// ExpireCredits sweeps up to limit expired buckets, one transaction each, and
+// writes a promo_expire ledger entry for whatever was left in them. Returns the
+// number of buckets expired.
+func (s *Store) ExpireCredits(ctx context.Context, limit int) (int, error) {
+	due, err := s.DB.Queries.ListExpiredCreditBuckets(ctx, int32(limit))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, b := range due {
+		err := s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+			// Same lock order as spending: user row, then bucket.
+			if _, e := q.LockUserBalance(ctx, b.UserID); e != nil {
+				return e
+			}
+			row, e := q.ExpireCreditBucket(ctx, b.ID)
+			if e != nil {
+				return e
+			}
+			if _, e := q.InsertLedgerEntry(ctx, dbgen.InsertLedgerEntryParams{
+				Kind:           LedgerKindPromoExpire,
+				Amount:         -row.ExpiredAmount,
+				CreditBucketID: toPgUUID(b.ID),
+				UserID:         row.UserID,
+			}); e != nil {
+				return e
+			}
+			return tripThresholds(ctx, q, row.UserID)
+		})
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			// swept by another worker (or refunded into and re-swept) meanwhile
+		case err != nil:
+			return n, err
+		default:
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrIdempotencyKeyReused means a live idempotency key was sent with a
+// different request than the one it was first used for.
+var ErrIdempotencyKeyReused = errors.New("idempotency_key_reused")
+
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// StoredResponse is the response first sent for an idempotency key. Status is
+// zero when none was kept (keys from before responses were stored).
+type StoredResponse struct {
+	MessageID string
+	Status    int
+	Body      []byte
+}
+
+// sendFingerprint identifies what a send request asks for. The migration
+// computes the same hash for keys that predate it.
+func sendFingerprint(r SendRequest) []byte {
+	h := sha256.New()
+	h.Write([]byte(r.To))
+	h.Write([]byte{0})
+	h.Write([]byte(r.Body))
+	return h.Sum(nil)
+}
+
+// claimIdempotencyKey takes r's key for this request, or reports that the key
+// is already live: already is set when it was used for the same request, and
+// ErrIdempotencyKeyReused is returned when it was used for another.
+func (s *Store) claimIdempotencyKey(ctx context.Context, q *dbgen.Queries, r SendRequest) (msgID string, already bool, err error) {
+	ttl := s.IdempotencyTTL
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	expires := time.Now().Add(ttl)
+	fp := sendFingerprint(r)
+	_, err = q.ClaimIdempotencyKey(ctx, dbgen.ClaimIdempotencyKeyParams{
+		UserID:      r.UserID,
+		Key:         *r.IdempotencyKey,
+		Fingerprint: fp,
+		ExpiresAt:   toPgTimestamptz(&expires),
+	})
+	if err == nil {
+		return "", false, nil
+	}
+	if isForeignKeyViolation(err) {
+		return "", false, ErrUserNotFound
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return "", false, err
+	}
+	k, err := q.GetIdempotencyKey(ctx, dbgen.GetIdempotencyKeyParams{UserID: r.UserID, Key: *r.IdempotencyKey})
+	if err != nil {
+		return "", false, err
+	}
+	if string(k.Fingerprint) != string(fp) {
+		return "", false, ErrIdempotencyKeyReused
+	}
+	return k.MessageID.String(), true, nil
+}
+
+// IdempotentResponse returns what was first answered for a live key.
+func (s *Store) IdempotentResponse(ctx context.Context, userID, key string) (StoredResponse, error) {
+	k, err := s.DB.Queries.GetIdempotencyKey(ctx, dbgen.GetIdempotencyKeyParams{UserID: userID, Key: key})
+	if err != nil {
+		return StoredResponse{}, mapNoRows(err, ErrNotFound)
+	}
+	return StoredResponse{MessageID: k.MessageID.String(), Status: int(k.ResponseStatus.Int32), Body: k.ResponseBody}, nil
+}
+
+// PurgeIdempotencyKeys drops expired keys. Expired keys are also reclaimed
+// as they are reused, so this only bounds the table.
+func (s *Store) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	return s.DB.Queries.PurgeIdempotencyKeys(ctx)
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/Cypherspark/sms-gateway/internal/auth"
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+)
+
+var ErrInvalidCIDR = errors.New("invalid_cidr")
+
+const (
+	AuditUserIPAllowlist = "user.ip_allowlist"
+	AuditKeyIPAllowlist  = "key.ip_allowlist"
+	AuditIPDenied        = "access.ip_denied"
+
+	// Which allowlist rejected a request.
+	IPScopeUser = "user"
+	IPScopeKey  = "key"
+
+	maxAllowlistEntries = 100
+)
+
+// ParseCIDRs parses allowlist entries, CIDRs or bare addresses (a single
+// host), masking off host bits.
+func ParseCIDRs(entries []string) ([]netip.Prefix, error) {
+	if len(entries) > maxAllowlistEntries {
+		return nil, ErrInvalidCIDR
+	}
+	out := make([]netip.Prefix, 0, len(entries))
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if !strings.Contains(e, "/") {
+			ip, err := netip.ParseAddr(e)
+			if err != nil {
+				return nil, ErrInvalidCIDR
+			}
+			ip = ip.Unmap()
+			out = append(out, netip.PrefixFrom(ip, ip.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(e)
+		if err != nil {
+			return nil, ErrInvalidCIDR
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+func allowlisted(list []netip.Prefix, ip netip.Addr) bool {
+	return len(list) == 0 || slices.ContainsFunc(list, func(p netip.Prefix) bool { return p.Contains(ip) })
+}
+
+func (s *Store) UserIPAllowlist(ctx context.Context, userID string) ([]netip.Prefix, error) {
+	list, err := s.DB.Queries.GetUserIPAllowlist(ctx, userID)
+	return list, mapNoRows(err, ErrUserNotFound)
+}
+
+// SetUserIPAllowlist replaces the user's allowlist; empty lifts the
+// restriction.
+func (s *Store) SetUserIPAllowlist(ctx context.Context, userID string, entries []string) ([]netip.Prefix, error) {
+	list, err := ParseCIDRs(entries)
+	if err != nil {
+		return nil, err
+	}
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		prev, e := q.SetUserIPAllowlist(ctx, dbgen.SetUserIPAllowlistParams{IpAllowlist: list, ID: userID})
+		if e != nil {
+			return mapNoRows(e, ErrUserNotFound)
+		}
+		return record(ctx, q, AuditUserIPAllowlist, TargetUser, userID,
+			map[string]any{"ip_allowlist": prev}, map[string]any{"ip_allowlist": list})
+	})
+	return list, err
+}
+
+// SetAPIKeyIPAllowlist replaces a key's allowlist; empty lifts the
+// restriction.
+func (s *Store) SetAPIKeyIPAllowlist(ctx context.Context, caller auth.Principal, userID, keyID string, entries []string) (APIKey, error) {
+	list, err := ParseCIDRs(entries)
+	if err != nil {
+		return APIKey{}, err
+	}
+	var out APIKey
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		old, e := lockKeyFor(ctx, q, caller, userID, keyID)
+		if e != nil {
+			return e
+		}
+		k, e := q.SetAPIKeyIPAllowlist(ctx, dbgen.SetAPIKeyIPAllowlistParams{IpAllowlist: list, ID: keyID, UserID: userID})
+		if e != nil {
+			return e
+		}
+		out = toAPIKey(k)
+		return record(ctx, q, AuditKeyIPAllowlist, TargetAPIKey, keyID, toAPIKey(old), out)
+	})
+	return out, err
+}
+
+// CheckIPAllowlist tells whether ip may act as p: it must be on the
+// allowlist of p's user and of p's key, where those are set. It returns the
+// scope of the list that rejected ip, or "" when allowed.
+func (s *Store) CheckIPAllowlist(ctx context.Context, p auth.Principal, ip netip.Addr) (string, error) {
+	if p.UserID == "" && p.KeyID == "" {
+		return "", nil
+	}
+	lists, err := s.DB.Queries.GetIPAllowlists(ctx, dbgen.GetIPAllowlistsParams{
+		UserID: toPgUUID(p.UserID),
+		KeyID:  toPgUUID(p.KeyID),
+	})
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case !allowlisted(lists.UserAllowlist, ip):
+		return IPScopeUser, nil
+	case !allowlisted(lists.KeyAllowlist, ip):
+		return IPScopeKey, nil
+	}
+	return "", nil
+}
+
+// RecordIPDenied audits a request rejected by an allowlist. The event
+// targets the key or user whose list rejected it.
+func (s *Store) RecordIPDenied(ctx context.Context, p auth.Principal, ip netip.Addr, scope, request string) error {
+	targetType, targetID := TargetUser, p.UserID
+	if scope == IPScopeKey {
+		targetType, targetID = TargetAPIKey, p.KeyID
+	}
+	return record(ctx, s.DB.Queries, AuditIPDenied, targetType, targetID, nil, map[string]any{
+		"ip": ip.String(), "scope": scope, "request": request,
+	})
+}
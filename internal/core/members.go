@@ -0,0 +1,332 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/auth"
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrMemberNotFound    = errors.New("member_not_found")
+	ErrInvalidRole       = errors.New("invalid_role")
+	ErrInvalidEmail      = errors.New("invalid_email")
+	ErrLastOwner         = errors.New("last_owner")
+	ErrAlreadyMember     = errors.New("already_member")
+	ErrInvitationInvalid = errors.New("invitation_invalid")
+)
+
+const (
+	AuditMemberAdd        = "member.add"
+	AuditMemberRole       = "member.role_change"
+	AuditMemberRemove     = "member.remove"
+	AuditInvitationCreate = "invitation.create"
+	AuditInvitationRevoke = "invitation.revoke"
+	AuditInvitationAccept = "invitation.accept"
+
+	TargetMember     = "member"
+	TargetInvitation = "invitation"
+
+	DefaultInvitationTTL = 7 * 24 * time.Hour
+)
+
+// NewMember describes a person joining an organization.
+type NewMember struct {
+	Name  string
+	Email *string
+}
+
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if a, err := mail.ParseAddress(email); err != nil || a.Address != email {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
+
+func validRole(role string) bool {
+	_, ok := auth.RoleScopes[role]
+	return ok
+}
+
+func subset(scopes, allowed []string) bool {
+	for _, s := range scopes {
+		if !slices.Contains(allowed, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// addMember makes m part of accountID with role. A member is a person across
+// organizations, so one with the same email is reused rather than duplicated.
+func addMember(ctx context.Context, q *dbgen.Queries, accountID string, m NewMember, role string) (dbgen.Member, error) {
+	if m.Email != nil {
+		e, err := normalizeEmail(*m.Email)
+		if err != nil {
+			return dbgen.Member{}, err
+		}
+		m.Email = &e
+		existing, err := q.GetMemberByEmail(ctx, toPgText(m.Email))
+		if err == nil {
+			return existing, joinAccount(ctx, q, accountID, existing, role)
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return dbgen.Member{}, err
+		}
+	}
+	member, err := q.CreateMember(ctx, dbgen.CreateMemberParams{Name: m.Name, Email: toPgText(m.Email)})
+	if err != nil {
+		return member, err
+	}
+	return member, joinAccount(ctx, q, accountID, member, role)
+}
+
+func joinAccount(ctx context.Context, q *dbgen.Queries, accountID string, m dbgen.Member, role string) error {
+	ms, err := q.InsertMembership(ctx, dbgen.InsertMembershipParams{AccountID: accountID, MemberID: m.ID, Role: role})
+	if isUniqueViolation(err) {
+		return ErrAlreadyMember
+	}
+	if err != nil {
+		return err
+	}
+	return record(ctx, q, AuditMemberAdd, TargetMember, m.ID, nil, map[string]any{
+		"account_id": accountID, "name": m.Name, "email": m.Email, "role": ms.Role,
+	})
+}
+
+// Member is a person's view within one organization.
+type Member struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Email    *string   `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+func (s *Store) ListMembers(ctx context.Context, accountID string) ([]Member, error) {
+	rows, err := s.DB.Queries.ListMembers(ctx, accountID)
+	if err != nil {
+		return nil, mapNoRows(err, ErrUserNotFound)
+	}
+	out := make([]Member, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, Member{ID: r.ID, Name: r.Name, Email: textPtr(r.Email), Role: r.Role, JoinedAt: r.JoinedAt.Time})
+	}
+	return out, nil
+}
+
+// lockMembership locks the organization (so owner counts can't race) and the
+// membership being changed.
+func lockMembership(ctx context.Context, q *dbgen.Queries, accountID, memberID string) (dbgen.Membership, error) {
+	if _, err := q.LockUserBalance(ctx, accountID); err != nil {
+		return dbgen.Membership{}, mapNoRows(err, ErrMemberNotFound)
+	}
+	ms, err := q.GetMembershipForUpdate(ctx, dbgen.GetMembershipForUpdateParams{AccountID: accountID, MemberID: memberID})
+	return ms, mapNoRows(err, ErrMemberNotFound)
+}
+
+// leavesNoOwner reports whether taking ms out of the owner role would leave
+// the organization without one.
+func leavesNoOwner(ctx context.Context, q *dbgen.Queries, ms dbgen.Membership) (bool, error) {
+	if ms.Role != auth.RoleOwner {
+		return false, nil
+	}
+	n, err := q.CountOwners(ctx, ms.AccountID)
+	return n <= 1, err
+}
+
+// UpdateMemberRole changes a member's role. The member's keys follow the new
+// role immediately. An organization always keeps at least one owner.
+func (s *Store) UpdateMemberRole(ctx context.Context, accountID, memberID, role string) error {
+	if !validRole(role) {
+		return ErrInvalidRole
+	}
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		ms, e := lockMembership(ctx, q, accountID, memberID)
+		if e != nil {
+			return e
+		}
+		if role != auth.RoleOwner {
+			last, e := leavesNoOwner(ctx, q, ms)
+			if e != nil {
+				return e
+			}
+			if last {
+				return ErrLastOwner
+			}
+		}
+		updated, e := q.UpdateMembershipRole(ctx, dbgen.UpdateMembershipRoleParams{Role: role, AccountID: accountID, MemberID: memberID})
+		if e != nil {
+			return e
+		}
+		return record(ctx, q, AuditMemberRole, TargetMember, memberID, ms, updated)
+	})
+}
+
+// RemoveMember takes a member out of the organization and revokes the keys
+// they held for it.
+func (s *Store) RemoveMember(ctx context.Context, accountID, memberID string) error {
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		ms, e := lockMembership(ctx, q, accountID, memberID)
+		if e != nil {
+			return e
+		}
+		last, e := leavesNoOwner(ctx, q, ms)
+		if e != nil {
+			return e
+		}
+		if last {
+			return ErrLastOwner
+		}
+		if e := q.DeleteMembership(ctx, dbgen.DeleteMembershipParams{AccountID: accountID, MemberID: memberID}); e != nil {
+			return e
+		}
+		if _, e := q.RevokeMemberKeys(ctx, dbgen.RevokeMemberKeysParams{AccountID: accountID, MemberID: memberID}); e != nil {
+			return e
+		}
+		return record(ctx, q, AuditMemberRemove, TargetMember, memberID, ms, nil)
+	})
+}
+
+// ---- Invitations ----
+
+// Invitation is an invitation's public view; the token is only returned on
+// creation.
+type Invitation struct {
+	ID        string    `json:"id"`
+	AccountID string    `json:"account_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy *string   `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func toInvitation(i dbgen.Invitation) Invitation {
+	return Invitation{
+		ID:        i.ID,
+		AccountID: i.AccountID,
+		Email:     i.Email,
+		Role:      i.Role,
+		InvitedBy: uuidPtr(i.InvitedBy),
+		CreatedAt: i.CreatedAt.Time,
+		ExpiresAt: i.ExpiresAt.Time,
+	}
+}
+
+type InvitationRequest struct {
+	AccountID string
+	Email     string
+	Role      string
+	InvitedBy string // member id; empty when an admin invites
+	TTL       time.Duration
+}
+
+// CreateInvitation invites email to join the organization with role.
+func (s *Store) CreateInvitation(ctx context.Context, req InvitationRequest) (Invitation, string, error) {
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return Invitation{}, "", err
+	}
+	if !validRole(req.Role) {
+		return Invitation{}, "", ErrInvalidRole
+	}
+	if req.TTL <= 0 {
+		req.TTL = DefaultInvitationTTL
+	}
+	token, hash, err := auth.NewInviteToken()
+	if err != nil {
+		return Invitation{}, "", err
+	}
+	expires := time.Now().Add(req.TTL)
+	var inv Invitation
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		i, e := q.InsertInvitation(ctx, dbgen.InsertInvitationParams{
+			AccountID: req.AccountID,
+			Email:     email,
+			Role:      req.Role,
+			TokenHash: hash,
+			InvitedBy: toPgUUID(req.InvitedBy),
+			ExpiresAt: toPgTimestamptz(&expires),
+		})
+		if e != nil {
+			return e
+		}
+		inv = toInvitation(i)
+		return record(ctx, q, AuditInvitationCreate, TargetInvitation, i.ID, nil, inv)
+	})
+	if isForeignKeyViolation(err) {
+		return Invitation{}, "", ErrUserNotFound
+	}
+	return inv, token, err
+}
+
+func (s *Store) ListInvitations(ctx context.Context, accountID string) ([]Invitation, error) {
+	rows, err := s.DB.Queries.ListPendingInvitations(ctx, accountID)
+	if err != nil {
+		return nil, mapNoRows(err, ErrUserNotFound)
+	}
+	out := make([]Invitation, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, toInvitation(r))
+	}
+	return out, nil
+}
+
+func (s *Store) RevokeInvitation(ctx context.Context, accountID, id string) error {
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		i, e := q.RevokeInvitation(ctx, dbgen.RevokeInvitationParams{ID: id, AccountID: accountID})
+		if e != nil {
+			return mapNoRows(e, ErrNotFound)
+		}
+		return record(ctx, q, AuditInvitationRevoke, TargetInvitation, i.ID, toInvitation(i), nil)
+	})
+}
+
+// AcceptInvitation redeems an invitation token: the invited email joins the
+// organization (as an existing member if one has that email) and gets a
+// first API key limited to its role.
+func (s *Store) AcceptInvitation(ctx context.Context, token string, m NewMember) (joined Member, key APIKey, keyToken string, err error) {
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		inv, e := q.GetInvitationByTokenForUpdate(ctx, auth.Hash(token))
+		if e != nil {
+			return mapNoRows(e, ErrInvitationInvalid)
+		}
+		if inv.AcceptedAt.Valid || inv.RevokedAt.Valid || !inv.ExpiresAt.Time.After(time.Now()) {
+			return ErrInvitationInvalid
+		}
+
+		if m.Name == "" {
+			m.Name, _, _ = strings.Cut(inv.Email, "@")
+		}
+		m.Email = &inv.Email
+		member, e := addMember(ctx, q, inv.AccountID, m, inv.Role)
+		if e != nil {
+			return e
+		}
+		accepted, e := q.MarkInvitationAccepted(ctx, inv.ID)
+		if e != nil {
+			return e
+		}
+		if e := record(ctx, q, AuditInvitationAccept, TargetInvitation, inv.ID, toInvitation(inv), map[string]any{
+			"member_id": member.ID, "accepted_at": accepted.AcceptedAt.Time,
+		}); e != nil {
+			return e
+		}
+
+		key, keyToken, e = insertAPIKey(ctx, q, inv.AccountID, member.ID, KeyKindTenant, auth.RoleScopes[inv.Role], "default")
+		if e != nil {
+			return e
+		}
+		joined = Member{ID: member.ID, Name: member.Name, Email: textPtr(member.Email), Role: inv.Role, JoinedAt: accepted.AcceptedAt.Time}
+		return record(ctx, q, AuditKeyCreate, TargetAPIKey, key.ID, nil, key)
+	})
+	return joined, key, keyToken, err
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"context"
+	"sort"
+
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/Cypherspark/sms-gateway/internal/ratelimit"
+)
+
+// Take implements ratelimit.Store on Postgres, so every API replica draws on
+// the same buckets.
+func (s *Store) Take(ctx context.Context, buckets ...ratelimit.Bucket) ([]ratelimit.Result, error) {
+	// Lock in key order so concurrent requests can't deadlock.
+	order := make([]int, len(buckets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return buckets[order[a]].Key < buckets[order[b]].Key })
+
+	out := make([]ratelimit.Result, len(buckets))
+	err := s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		tokens := make([]float64, len(buckets))
+		allowed := true
+		for _, i := range order {
+			t, e := q.RefillRateLimitBucket(ctx, dbgen.RefillRateLimitBucketParams{
+				BucketKey: buckets[i].Key,
+				Burst:     float64(buckets[i].Limit.Burst),
+				Rate:      buckets[i].Limit.Rate,
+			})
+			if e != nil {
+				return e
+			}
+			tokens[i] = t
+			allowed = allowed && t >= 1
+		}
+		if allowed {
+			keys := make([]string, len(buckets))
+			for i, b := range buckets {
+				keys[i] = b.Key
+				tokens[i]--
+			}
+			if e := q.SpendRateLimitTokens(ctx, keys); e != nil {
+				return e
+			}
+		}
+		for i, b := range buckets {
+			out[i] = ratelimit.NewResult(b.Limit, tokens[i], allowed || tokens[i] >= 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PurgeRateLimits drops buckets that have been idle long enough to be full.
+func (s *Store) PurgeRateLimits(ctx context.Context) (int64, error) {
+	return s.DB.Queries.PurgeRateLimitBuckets(ctx)
+}
+
+func (s *Store) UserPlan(ctx context.Context, userID string) (string, error) {
+	plan, err := s.DB.Queries.GetUserPlan(ctx, userID)
+	return plan, mapNoRows(err, ErrUserNotFound)
+}
+
+func (s *Store) SetUserPlan(ctx context.Context, userID, plan string) error {
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		prev, e := q.SetUserPlan(ctx, dbgen.SetUserPlanParams{Plan: plan, ID: userID})
+		if e != nil {
+			return mapNoRows(e, ErrUserNotFound)
+		}
+		return record(ctx, q, AuditPlanSet, TargetUser, userID,
+			map[string]string{"plan": prev}, map[string]string{"plan": plan})
+	})
+}
@@ -10,16 +10,33 @@ import (
 	dbpkg "github.com/Cypherspark/sms-gateway/internal/db"
 	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Store struct {
 	DB *dbpkg.DB
+
+	// QueueLimits caps outstanding messages at enqueue time; zero values
+	// mean unlimited.
+	QueueLimits QueueLimits
+
+	// IdempotencyTTL is how long a send's Idempotency-Key is remembered;
+	// zero means DefaultIdempotencyTTL.
+	IdempotencyTTL time.Duration
+
+	// AllowPrivateWebhooks lets webhook and alert URLs point at loopback
+	// and private addresses; for development and tests only.
+	AllowPrivateWebhooks bool
 }
 
 const PricePerSMS = 1
 
-var ErrInsufficientBalance = errors.New("insufficient_balance")
+var (
+	ErrInsufficientBalance = errors.New("insufficient_balance")
+	ErrUserNotFound        = errors.New("user_not_found")
+	ErrNotFound            = errors.New("not_found")
+)
 
 func toPgText(p *string) pgtype.Text {
 	if p == nil {
@@ -28,6 +45,38 @@ func toPgText(p *string) pgtype.Text {
 	return pgtype.Text{String: *p, Valid: true}
 }
 
+func toPgUUID(s string) pgtype.UUID {
+	var u pgtype.UUID
+	_ = u.Scan(s) // leaves Valid=false on malformed input
+	return u
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
+
+// mapNoRows turns "no such row" into notFound. A malformed literal (e.g. a
+// non-UUID id from a URL path) can't match a row either, so it maps the same way.
+func mapNoRows(err, notFound error) error {
+	var pgErr *pgconn.PgError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return notFound
+	case errors.As(err, &pgErr) && pgErr.Code == "22P02":
+		return notFound
+	default:
+		return err
+	}
+}
+
 // ---- Users / Balance ----
 
 func (s *Store) CreateUser(ctx context.Context, name string) (string, error) {
@@ -38,24 +87,90 @@ func (s *Store) CreateUser(ctx context.Context, name string) (string, error) {
 	return u.ID, nil
 }
 
+// GetBalance returns the spendable balance: paid plus unexpired promotional credit.
 func (s *Store) GetBalance(ctx context.Context, userID string) (int, error) {
 	bal, err := s.DB.Queries.GetBalance(ctx, userID)
 	return int(bal), err
 }
 
 type TopUpRequest struct {
-	UserID string
-	Amount int
+	UserID         string
+	Amount         int
+	IdempotencyKey *string
+	ExternalRef    *string // e.g. payment provider transaction id
+	Note           *string
 }
 
-func (s *Store) TopUp(ctx context.Context, req TopUpRequest) error {
-	if req.Amount <= 0 {
-		return errors.New("invalid amount")
+const (
+	LedgerKindTopUp      = "topup"
+	LedgerKindCharge     = "charge"
+	LedgerKindRefund     = "refund"
+	LedgerKindAdjustment = "adjustment"
+)
+
+// TopUp credits the balance and records a ledger entry; idempotent when key is provided.
+// A replay returns the balance as of the original credit; reusing the key for a
+// different amount or external_ref is ErrIdempotencyKeyReused.
+func (s *Store) TopUp(ctx context.Context, req TopUpRequest) (balance int, already bool, err error) {
+	if req.Amount <= 0 || req.Amount > math.MaxInt32 {
+		return 0, false, errors.New("invalid amount")
 	}
-	return s.DB.Queries.TopUp(ctx, dbgen.TopUpParams{
-		Balance: int32(req.Amount),
-		ID:      req.UserID,
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		// 1) Lock the user so concurrent retries with the same key serialize here
+		if _, e := q.LockUserBalance(ctx, req.UserID); e != nil {
+			return mapNoRows(e, ErrUserNotFound)
+		}
+
+		// 2) Idempotency check (only if provided)
+		if req.IdempotencyKey != nil {
+			prev, e := q.GetLedgerEntryByIdemKey(ctx, dbgen.GetLedgerEntryByIdemKeyParams{
+				UserID:         req.UserID,
+				IdempotencyKey: toPgText(req.IdempotencyKey),
+			})
+			if e == nil {
+				if prev.Kind != LedgerKindTopUp || int(prev.Amount) != req.Amount ||
+					prev.ExternalRef != toPgText(req.ExternalRef) {
+					return ErrIdempotencyKeyReused
+				}
+				balance = int(prev.BalanceAfter)
+				already = true
+				return nil
+			}
+			if !errors.Is(e, pgx.ErrNoRows) {
+				return e
+			}
+		}
+
+		// 3) Credit + ledger entry
+		if _, e := q.TopUp(ctx, dbgen.TopUpParams{
+			Balance: int32(req.Amount),
+			ID:      req.UserID,
+		}); e != nil {
+			return e
+		}
+		entry, e := q.InsertLedgerEntry(ctx, dbgen.InsertLedgerEntryParams{
+			Kind:           LedgerKindTopUp,
+			Amount:         int32(req.Amount),
+			IdempotencyKey: toPgText(req.IdempotencyKey),
+			ExternalRef:    toPgText(req.ExternalRef),
+			Note:           toPgText(req.Note),
+			UserID:         req.UserID,
+		})
+		if e != nil {
+			return e
+		}
+		balance = int(entry.BalanceAfter)
+		if e := record(ctx, q, AuditTopUp, TargetUser, req.UserID,
+			map[string]int{"balance": balance - req.Amount},
+			map[string]any{"balance": balance, "amount": req.Amount, "external_ref": req.ExternalRef, "ledger_entry_id": entry.ID},
+		); e != nil {
+			return e
+		}
+
+		// 4) Thresholds the balance is back above may fire again.
+		return q.RearmBalanceThresholds(ctx, req.UserID)
 	})
+	return balance, already, err
 }
 
 // ---- Messages / Send flow ----
@@ -65,51 +180,89 @@ type SendRequest struct {
 	To             string
 	Body           string
 	IdempotencyKey *string
+
+	// Response, when set with a key, renders the answer for a new message so
+	// it can be stored with the key and replayed to retries.
+	Response func(msgID string) (status int, body []byte)
 }
 
-// Debit + enqueue atomically; idempotent when key is provided.
+// Debit + enqueue atomically; idempotent when key is provided. A key reused
+// for a different request yields ErrIdempotencyKeyReused.
 func (s *Store) EnqueueAndCharge(ctx context.Context, r SendRequest) (msgID string, already bool, err error) {
 	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
 		// 1) Idempotency check (only if provided)
 		if r.IdempotencyKey != nil {
-			id, e := q.GetMessageByIdemKey(ctx, dbgen.GetMessageByIdemKeyParams{
-				UserID:         r.UserID,
-				IdempotencyKey: toPgText(r.IdempotencyKey),
-			})
-			if e == nil {
-				msgID = id
-				already = true
-				return nil
-			}
-			if !errors.Is(e, pgx.ErrNoRows) {
+			id, dup, e := s.claimIdempotencyKey(ctx, q, r)
+			if e != nil || dup {
+				msgID, already = id, dup
 				return e
 			}
 		}
 
-		// 2) Conditional debit (locks row; returns 0 rows if insufficient)
-		rows, e := q.DebitIfEnough(ctx, dbgen.DebitIfEnoughParams{
-			Balance: int32(PricePerSMS),
-			ID:      r.UserID,
-		})
+		// 2) Resolve the payer (locks the sender's row first); parent-billed
+		//    children draw on the parent's balance within their quota
+		payer, e := resolvePayer(ctx, q, r.UserID, PricePerSMS)
+		if e != nil {
+			return e
+		}
+
+		// 2b) Admission control. The sender's row is locked, so the per-user
+		//     depth is exact; the global one may overshoot by the number of
+		//     concurrent enqueues.
+		if e := s.admit(ctx, q, r.UserID); e != nil {
+			return e
+		}
+
+		// 3) Debit promotional credit first, then paid balance
+		bucketID, e := debit(ctx, q, payer, PricePerSMS)
 		if e != nil {
 			return e
 		}
-		if rows == 0 {
-			return ErrInsufficientBalance
+
+		// 4) Queue notifications for low-balance thresholds crossed by this debit
+		if e := tripThresholds(ctx, q, payer); e != nil {
+			return e
 		}
 
-		// 3) Insert message (idempotency_key may be NULL)
+		// 5) Insert message (idempotency_key may be NULL)
 		id, e := q.InsertMessage(ctx, dbgen.InsertMessageParams{
 			UserID:         r.UserID,
 			ToMsisdn:       r.To,
 			Body:           r.Body,
 			IdempotencyKey: toPgText(r.IdempotencyKey),
+			BilledUserID:   payer,
+			CreditBucketID: bucketID,
 		})
 		if e != nil {
 			return e
 		}
 		msgID = id
-		return nil
+
+		// 6) Ledger entry on the payer's side
+		_, e = q.InsertLedgerEntry(ctx, dbgen.InsertLedgerEntryParams{
+			Kind:           LedgerKindCharge,
+			Amount:         -int32(PricePerSMS),
+			CreditBucketID: bucketID,
+			MessageID:      toPgUUID(id),
+			UserID:         payer,
+		})
+		if e != nil || r.IdempotencyKey == nil {
+			return e
+		}
+
+		// 7) Remember the answer for retries with the same key
+		var status int
+		var body []byte
+		if r.Response != nil {
+			status, body = r.Response(id)
+		}
+		return q.CompleteIdempotencyKey(ctx, dbgen.CompleteIdempotencyKeyParams{
+			MessageID:      toPgUUID(id),
+			ResponseStatus: toPgStatus(status),
+			ResponseBody:   body,
+			UserID:         r.UserID,
+			Key:            *r.IdempotencyKey,
+		})
 	})
 	return msgID, already, err
 }
@@ -134,10 +287,28 @@ func (s *Store) LoadMessageForSend(ctx context.Context, id string) (userID, to,
 	return row.UserID, row.ToMsisdn, row.Body, nil
 }
 
+// MarkSent records the provider's acceptance of a message and queues its
+// message.sent webhook event.
 func (s *Store) MarkSent(ctx context.Context, id, providerID string) error {
-	return s.DB.Queries.MarkSent(ctx, dbgen.MarkSentParams{
-		ID:                id,
-		ProviderMessageID: toPgText(&providerID),
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		m, e := q.MarkSent(ctx, dbgen.MarkSentParams{
+			ID:                id,
+			ProviderMessageID: toPgText(&providerID),
+		})
+		if errors.Is(e, pgx.ErrNoRows) {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		return enqueueWebhookEvent(ctx, q, m.UserID, EventMessageSent, messageEvent{
+			MessageID:         m.ID,
+			UserID:            m.UserID,
+			To:                m.ToMsisdn,
+			Status:            string(m.Status),
+			ProviderMessageID: textPtr(m.ProviderMessageID),
+			SentAt:            &m.SentAt.Time,
+		})
 	})
 }
 
@@ -150,11 +321,54 @@ func (s *Store) MarkFailedWithRetry(ctx context.Context, id string, retryIn time
 }
 
 func (s *Store) MarkFailedPermanent(ctx context.Context, id string) error {
-	return s.DB.Queries.MarkFailed(ctx, id)
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		m, e := q.MarkFailed(ctx, id)
+		if errors.Is(e, pgx.ErrNoRows) {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		return enqueueWebhookEvent(ctx, q, m.UserID, EventMessageFailed, messageEvent{
+			MessageID: m.ID, UserID: m.UserID, To: m.ToMsisdn, Status: string(m.Status),
+		})
+	})
 }
 
+// MarkFailedPermanentAndRefund fails the message and refunds the payer once;
+// a message that is already failed is left alone.
 func (s *Store) MarkFailedPermanentAndRefund(ctx context.Context, id string) error {
-	return s.DB.Queries.MarkFailedAndRefund(ctx, id)
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		return failAndRefund(ctx, q, id, nil)
+	})
+}
+
+// failAndRefund is MarkFailedPermanentAndRefund inside the caller's tx,
+// recording errorCode on the message when given.
+func failAndRefund(ctx context.Context, q *dbgen.Queries, id string, errorCode *string) error {
+	row, e := q.MarkFailedAndRefund(ctx, dbgen.MarkFailedAndRefundParams{
+		ID:        id,
+		ErrorCode: toPgText(errorCode),
+	})
+	if errors.Is(e, pgx.ErrNoRows) {
+		return nil
+	}
+	if e != nil {
+		return e
+	}
+	_, e = q.InsertLedgerEntry(ctx, dbgen.InsertLedgerEntryParams{
+		Kind:           LedgerKindRefund,
+		Amount:         int32(PricePerSMS),
+		CreditBucketID: row.CreditBucketID,
+		MessageID:      toPgUUID(id),
+		UserID:         row.PayerID,
+	})
+	if e != nil {
+		return e
+	}
+	return enqueueWebhookEvent(ctx, q, row.UserID, EventMessageFailed, messageEvent{
+		MessageID: id, UserID: row.UserID, To: row.ToMsisdn, Status: "failed",
+	})
 }
 
 // ClaimQueuedMessagesLRS claims up to limit messages, taking at most perUser from each of
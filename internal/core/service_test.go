@@ -1,6 +1,7 @@
 package core_test
 
 import (
+	"bytes"
 	"context"
 	"strconv"
 	"sync"
@@ -8,8 +9,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Cypherspark/sms-gateway/internal/auth"
 	"github.com/Cypherspark/sms-gateway/internal/core"
 	dbpkg "github.com/Cypherspark/sms-gateway/internal/db"
+	"github.com/Cypherspark/sms-gateway/internal/ratelimit"
 	"github.com/stretchr/testify/require"
 )
 
@@ -25,7 +28,8 @@ func createUser(t *testing.T, s *core.Store, name string) string {
 }
 
 func topUp(t *testing.T, s *core.Store, user string, amount int) {
-	require.NoError(t, s.TopUp(context.Background(), core.TopUpRequest{UserID: user, Amount: amount}))
+	_, _, err := s.TopUp(context.Background(), core.TopUpRequest{UserID: user, Amount: amount})
+	require.NoError(t, err)
 }
 
 func TestTopUpAndBalance(t *testing.T) {
@@ -37,6 +41,44 @@ func TestTopUpAndBalance(t *testing.T) {
 	require.Equal(t, 100, bal)
 }
 
+func TestTopUp_IdempotentWithReference(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	uid := createUser(t, s, "acme")
+
+	key := "pay-123"
+	ref := "psp_txn_42"
+	req := core.TopUpRequest{UserID: uid, Amount: 50, IdempotencyKey: &key, ExternalRef: &ref}
+
+	bal, already, err := s.TopUp(ctx, req)
+	require.NoError(t, err)
+	require.False(t, already)
+	require.Equal(t, 50, bal)
+
+	topUp(t, s, uid, 10)
+
+	// Retried callback: no second credit, original balance echoed back
+	bal, already, err = s.TopUp(ctx, req)
+	require.NoError(t, err)
+	require.True(t, already)
+	require.Equal(t, 50, bal)
+
+	cur, err := s.GetBalance(ctx, uid)
+	require.NoError(t, err)
+	require.Equal(t, 60, cur)
+
+	var gotRef string
+	require.NoError(t, s.DB.Pool.QueryRow(ctx,
+		`SELECT external_ref FROM ledger_entries WHERE user_id = $1 AND idempotency_key = $2`, uid, key).Scan(&gotRef))
+	require.Equal(t, ref, gotRef)
+}
+
+func TestTopUp_UnknownUser(t *testing.T) {
+	s := newStore(t)
+	_, _, err := s.TopUp(context.Background(), core.TopUpRequest{UserID: "00000000-0000-0000-0000-000000000000", Amount: 1})
+	require.ErrorIs(t, err, core.ErrUserNotFound)
+}
+
 func TestEnqueueAndCharge_IdempotentSingleDebit(t *testing.T) {
 	s := newStore(t)
 	uid := createUser(t, s, "acme1")
@@ -57,6 +99,46 @@ func TestEnqueueAndCharge_IdempotentSingleDebit(t *testing.T) {
 	require.Equal(t, 9, bal)
 }
 
+func TestEnqueueAndCharge_IdempotencyKeyFingerprintAndExpiry(t *testing.T) {
+	s := newStore(t)
+	s.IdempotencyTTL = time.Second
+	ctx := context.Background()
+	uid := createUser(t, s, "acme")
+	topUp(t, s, uid, 10)
+	key := "otp-1"
+	send := func(body string) (string, bool, error) {
+		return s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+49", Body: body, IdempotencyKey: &key,
+			Response: func(id string) (int, []byte) { return 202, []byte(id) }})
+	}
+
+	first, already, err := send("code 1234")
+	require.NoError(t, err)
+	require.False(t, already)
+	again, already, err := send("code 1234")
+	require.NoError(t, err)
+	require.True(t, already)
+	require.Equal(t, first, again)
+	stored, err := s.IdempotentResponse(ctx, uid, key)
+	require.NoError(t, err)
+	require.Equal(t, core.StoredResponse{MessageID: first, Status: 202, Body: []byte(first)}, stored)
+
+	// A different message under a live key is refused and not charged.
+	_, _, err = send("code 5678")
+	require.ErrorIs(t, err, core.ErrIdempotencyKeyReused)
+	bal, _ := s.GetBalance(ctx, uid)
+	require.Equal(t, 9, bal)
+
+	// Once expired the key names a new message.
+	time.Sleep(1100 * time.Millisecond)
+	second, already, err := send("code 5678")
+	require.NoError(t, err)
+	require.False(t, already)
+	require.NotEqual(t, first, second)
+	n, err := s.PurgeIdempotencyKeys(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n, "the reused key is live again")
+}
+
 func TestEnqueueInsufficientBalance(t *testing.T) {
 	s := newStore(t)
 	uid := createUser(t, s, "acme")
@@ -183,3 +265,472 @@ func TestConcurrentClaim_SkipLocked_NoDuplicates(t *testing.T) {
 		"did not claim all messages before timeout")
 	require.Len(t, seen, total)
 }
+
+func TestBalanceThreshold_FiresOnceUntilToppedUp(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	uid := createUser(t, s, "acme")
+	topUp(t, s, uid, 3)
+
+	hook := "https://example.com/hook"
+	admin := "+4900000"
+	_, err := s.CreateBalanceThreshold(ctx, core.ThresholdRequest{UserID: uid, Threshold: 2, WebhookURL: &hook, NotifyMSISDN: &admin})
+	require.NoError(t, err)
+
+	countNotifications := func() int {
+		var n int
+		require.NoError(t, s.DB.Pool.QueryRow(ctx,
+			`SELECT COUNT(*) FROM balance_notifications WHERE user_id = $1`, uid).Scan(&n))
+		return n
+	}
+
+	// 3 -> 2: not below the threshold yet
+	_, _, err = s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+49", Body: "a"})
+	require.NoError(t, err)
+	require.Equal(t, 0, countNotifications())
+
+	// 2 -> 1: crosses, one notification per channel
+	_, _, err = s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+49", Body: "b"})
+	require.NoError(t, err)
+	require.Equal(t, 2, countNotifications())
+
+	// 1 -> 0: still below, debounced
+	_, _, err = s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+49", Body: "c"})
+	require.NoError(t, err)
+	require.Equal(t, 2, countNotifications())
+
+	// Top up above and cross again: fires again
+	topUp(t, s, uid, 2)
+	_, _, err = s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+49", Body: "d"})
+	require.NoError(t, err)
+	require.Equal(t, 4, countNotifications())
+
+	items, err := s.ClaimBalanceNotifications(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, items, 4)
+	again, err := s.ClaimBalanceNotifications(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, again, "leased rows must not be claimed twice")
+}
+
+func TestSubaccount_ParentBilledWithinQuota(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	parent := createUser(t, s, "reseller")
+	topUp(t, s, parent, 5)
+
+	quota := 2
+	child, err := s.CreateChildAccount(ctx, core.ChildRequest{ParentID: parent, Name: "customer", BillingMode: core.BillingModeParent, Quota: &quota})
+	require.NoError(t, err)
+
+	first, _, err := s.EnqueueAndCharge(ctx, core.SendRequest{UserID: child.ID, To: "+49", Body: "a"})
+	require.NoError(t, err)
+	_, _, err = s.EnqueueAndCharge(ctx, core.SendRequest{UserID: child.ID, To: "+49", Body: "b"})
+	require.NoError(t, err)
+	_, _, err = s.EnqueueAndCharge(ctx, core.SendRequest{UserID: child.ID, To: "+49", Body: "c"})
+	require.ErrorIs(t, err, core.ErrQuotaExceeded)
+
+	bal, _ := s.GetBalance(ctx, parent)
+	require.Equal(t, 3, bal)
+
+	// Refund goes back to the parent and frees quota
+	require.NoError(t, s.MarkFailedPermanentAndRefund(ctx, first))
+	bal, _ = s.GetBalance(ctx, parent)
+	require.Equal(t, 4, bal)
+	_, _, err = s.EnqueueAndCharge(ctx, core.SendRequest{UserID: child.ID, To: "+49", Body: "d"})
+	require.NoError(t, err)
+
+	// Nested sub-accounts are rejected
+	_, err = s.CreateChildAccount(ctx, core.ChildRequest{ParentID: child.ID, Name: "grandchild"})
+	require.ErrorIs(t, err, core.ErrNestedSubaccount)
+}
+
+func TestSubaccount_TransferCredit(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	parent := createUser(t, s, "reseller")
+	topUp(t, s, parent, 10)
+	child, err := s.CreateChildAccount(ctx, core.ChildRequest{ParentID: parent, Name: "customer"})
+	require.NoError(t, err)
+	stranger := createUser(t, s, "other")
+
+	fromBal, toBal, err := s.TransferCredit(ctx, core.TransferRequest{ParentID: parent, FromUserID: parent, ToUserID: child.ID, Amount: 4})
+	require.NoError(t, err)
+	require.Equal(t, 6, fromBal)
+	require.Equal(t, 4, toBal)
+
+	_, _, err = s.TransferCredit(ctx, core.TransferRequest{ParentID: parent, FromUserID: child.ID, ToUserID: parent, Amount: 5})
+	require.ErrorIs(t, err, core.ErrInsufficientBalance)
+
+	_, _, err = s.TransferCredit(ctx, core.TransferRequest{ParentID: parent, FromUserID: parent, ToUserID: stranger, Amount: 1})
+	require.ErrorIs(t, err, core.ErrNotFound)
+
+	usage, err := s.SubaccountUsage(ctx, parent, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, usage, 2)
+	require.Equal(t, parent, usage[0].ID)
+}
+
+func TestPromoCredit_SpentFirstRefundedAndExpired(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	uid := createUser(t, s, "promo")
+	topUp(t, s, uid, 5)
+
+	soon, err := s.GrantCredit(ctx, core.GrantRequest{UserID: uid, Amount: 2, ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	_, err = s.GrantCredit(ctx, core.GrantRequest{UserID: uid, Amount: 3, ExpiresAt: time.Now().Add(48 * time.Hour)})
+	require.NoError(t, err)
+
+	bal, err := s.GetBalanceBreakdown(ctx, uid)
+	require.NoError(t, err)
+	require.Equal(t, core.BalanceBreakdown{Balance: 10, Paid: 5, Promotional: 5, NextExpiry: bal.NextExpiry}, bal)
+
+	// Earliest-expiring bucket pays first; paid balance is untouched
+	msgID, _, err := s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+49", Body: "a"})
+	require.NoError(t, err)
+	bal, err = s.GetBalanceBreakdown(ctx, uid)
+	require.NoError(t, err)
+	require.Equal(t, 5, bal.Paid)
+	require.Equal(t, 4, bal.Promotional)
+
+	// Refund goes back to the bucket it came from
+	require.NoError(t, s.MarkFailedPermanentAndRefund(ctx, msgID))
+	items, err := s.ListCredits(ctx, uid)
+	require.NoError(t, err)
+	require.Equal(t, soon.ID, items[0].ID)
+	require.EqualValues(t, 2, items[0].Remaining)
+
+	// Force the first bucket past its expiry and sweep it
+	_, err = s.DB.Pool.Exec(ctx, `UPDATE credit_buckets SET expires_at = now() - interval '1 second' WHERE id = $1`, soon.ID)
+	require.NoError(t, err)
+	n, err := s.ExpireCredits(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	n, err = s.ExpireCredits(ctx, 10)
+	require.NoError(t, err)
+	require.Zero(t, n, "already swept")
+
+	got, err := s.GetBalance(ctx, uid)
+	require.NoError(t, err)
+	require.Equal(t, 8, got)
+
+	var amount, after int
+	require.NoError(t, s.DB.Pool.QueryRow(ctx,
+		`SELECT amount, balance_after FROM ledger_entries WHERE user_id = $1 AND kind = 'promo_expire'`, uid).Scan(&amount, &after))
+	require.Equal(t, -2, amount)
+	require.Equal(t, 8, after)
+}
+
+func TestStatement_ReconcilesOpeningToClosing(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	uid := createUser(t, s, "finance")
+	from := time.Now().Add(-time.Minute)
+
+	topUp(t, s, uid, 10)
+	_, _, err := s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+4915100000001", Body: "a"})
+	require.NoError(t, err)
+	failed, _, err := s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+4915100000002", Body: "b"})
+	require.NoError(t, err)
+	_, _, err = s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+33600000000", Body: "c"})
+	require.NoError(t, err)
+	require.NoError(t, s.MarkFailedPermanentAndRefund(ctx, failed))
+	require.NoError(t, s.MarkFailedPermanentAndRefund(ctx, failed), "second refund is a no-op")
+
+	st, err := s.GetStatement(ctx, uid, from, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 0, st.OpeningBalance)
+	require.Equal(t, 10, st.TopUps)
+	require.Equal(t, 3, st.Charges)
+	require.Equal(t, 1, st.Refunds)
+	require.Equal(t, 8, st.ClosingBalance)
+	require.Equal(t, []core.ChargeLine{
+		{Country: "DE", CallingCode: "49", Status: "failed", Messages: 1, Charged: 1, Refunded: 1},
+		{Country: "DE", CallingCode: "49", Status: "queued", Messages: 1, Charged: 1},
+		{Country: "FR", CallingCode: "33", Status: "queued", Messages: 1, Charged: 1},
+	}, st.ChargeLines)
+
+	var buf bytes.Buffer
+	require.NoError(t, st.WriteCSV(&buf))
+	require.Contains(t, buf.String(), "summary,closing_balance,,,,,,,8\n")
+}
+
+func TestEnqueue_QueueDepthLimits(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	s.QueueLimits = core.QueueLimits{PerUser: 2, Global: 3}
+	a, b := createUser(t, s, "a"), createUser(t, s, "b")
+	topUp(t, s, a, 10)
+	topUp(t, s, b, 10)
+
+	send := func(user string) error {
+		_, _, err := s.EnqueueAndCharge(ctx, core.SendRequest{UserID: user, To: "+49", Body: "x"})
+		return err
+	}
+	require.NoError(t, send(a))
+	require.NoError(t, send(a))
+
+	var full *core.QueueFullError
+	require.ErrorAs(t, send(a), &full)
+	require.Equal(t, "user", full.Scope)
+	require.Equal(t, 2, full.Depth)
+	require.ErrorIs(t, full, core.ErrQueueFull)
+
+	// With the limit lowered below the backlog, the real depth is reported
+	s.QueueLimits.PerUser = 1
+	require.ErrorAs(t, send(a), &full)
+	require.Equal(t, 1, full.Limit)
+	require.Greater(t, full.Depth, full.Limit)
+	require.Equal(t, 2, full.Depth)
+	s.QueueLimits.PerUser = 2
+
+	// The rejected send wasn't charged
+	bal, err := s.GetBalance(ctx, a)
+	require.NoError(t, err)
+	require.Equal(t, 8, bal)
+
+	require.NoError(t, send(b))
+	require.ErrorAs(t, send(b), &full)
+	require.Equal(t, "global", full.Scope)
+
+	// Draining the queue admits again
+	ids, err := s.ClaimQueuedMessages(ctx, 10)
+	require.NoError(t, err)
+	for _, id := range ids {
+		require.NoError(t, s.MarkSent(ctx, id, "p-"+id))
+	}
+	require.NoError(t, send(b))
+}
+
+func TestPromoCredit_RefundAfterExpiryIsForfeited(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	uid := createUser(t, s, "promo")
+	topUp(t, s, uid, 1)
+	b, err := s.GrantCredit(ctx, core.GrantRequest{UserID: uid, Amount: 2, ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	msgID, _, err := s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+49", Body: "a"})
+	require.NoError(t, err)
+
+	// The bucket that paid expires before the message fails, unswept
+	_, err = s.DB.Pool.Exec(ctx, `UPDATE credit_buckets SET expires_at = now() - interval '1 second' WHERE id = $1`, b.ID)
+	require.NoError(t, err)
+	require.NoError(t, s.MarkFailedPermanentAndRefund(ctx, msgID))
+
+	// The refund goes back where it came from, not into paid balance
+	var remaining int
+	require.NoError(t, s.DB.Pool.QueryRow(ctx, `SELECT remaining FROM credit_buckets WHERE id = $1`, b.ID).Scan(&remaining))
+	require.Equal(t, 2, remaining)
+	var bucket string
+	require.NoError(t, s.DB.Pool.QueryRow(ctx,
+		`SELECT credit_bucket_id::text FROM ledger_entries WHERE user_id = $1 AND kind = 'refund'`, uid).Scan(&bucket))
+	require.Equal(t, b.ID, bucket)
+
+	n, err := s.ExpireCredits(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	var amount, after int
+	require.NoError(t, s.DB.Pool.QueryRow(ctx,
+		`SELECT amount, balance_after FROM ledger_entries WHERE user_id = $1 AND kind = 'promo_expire'`, uid).Scan(&amount, &after))
+	require.Equal(t, -2, amount)
+	require.Equal(t, 1, after)
+
+	bal, err := s.GetBalanceBreakdown(ctx, uid)
+	require.NoError(t, err)
+	require.Equal(t, 1, bal.Paid)
+	require.Zero(t, bal.Promotional)
+}
+
+func TestRateLimits_TakeAllOrNothing(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	user := ratelimit.Bucket{Key: "user:u", Limit: ratelimit.Limit{Rate: 0.01, Burst: 5}}
+	key := ratelimit.Bucket{Key: "key:k", Limit: ratelimit.Limit{Rate: 0.01, Burst: 1}}
+
+	res, err := s.Take(ctx, user, key)
+	require.NoError(t, err)
+	require.True(t, res[0].Allowed && res[1].Allowed)
+	for range 3 {
+		res, err = s.Take(ctx, user, key)
+		require.NoError(t, err)
+		require.False(t, res[1].Allowed)
+		require.Equal(t, 4, res[0].Remaining, "a refused request doesn't spend the user bucket")
+	}
+	res, err = s.Take(ctx, user)
+	require.NoError(t, err)
+	require.True(t, res[0].Allowed)
+	require.Equal(t, 3, res[0].Remaining)
+}
+
+func TestSuspendedUser_MessagesHeldUntilReinstated(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	parent := createUser(t, s, "acme")
+	topUp(t, s, parent, 5)
+	child, err := s.CreateChildAccount(ctx, core.ChildRequest{ParentID: parent, Name: "eu", BillingMode: core.BillingModeParent})
+	require.NoError(t, err)
+	for _, uid := range []string{parent, child.ID} {
+		_, _, err := s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+49", Body: "x"})
+		require.NoError(t, err)
+	}
+
+	yes, no := true, false
+	_, err = s.UpdateUser(ctx, core.UserUpdate{ID: parent, Suspended: &yes})
+	require.NoError(t, err)
+	for _, uid := range []string{parent, child.ID} {
+		_, _, err := s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+49", Body: "y"})
+		require.ErrorIs(t, err, core.ErrUserSuspended)
+	}
+	ids, err := s.ClaimQueuedMessages(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	ids, err = s.ClaimQueuedMessagesLRS(ctx, 10, 10, 10)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	_, err = s.UpdateUser(ctx, core.UserUpdate{ID: parent, Suspended: &no})
+	require.NoError(t, err)
+	ids, err = s.ClaimQueuedMessagesLRS(ctx, 10, 10, 10)
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+
+	// Deleted senders are gone as far as sending goes
+	require.NoError(t, s.DeleteUser(ctx, child.ID))
+	_, _, err = s.EnqueueAndCharge(ctx, core.SendRequest{UserID: child.ID, To: "+49", Body: "z"})
+	require.ErrorIs(t, err, core.ErrUserNotFound)
+}
+
+func TestStats_RollupsFollowMessagesAndRefunds(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	uid := createUser(t, s, "acme")
+	topUp(t, s, uid, 10)
+
+	// Sent two days ago, taking 2s to reach the provider
+	old := time.Now().Add(-48 * time.Hour).UTC()
+	_, err := s.DB.Pool.Exec(ctx, `
+		INSERT INTO messages (user_id, to_msisdn, body, status, requested_at, sent_at)
+		VALUES ($1, '+4915100000000', 'old', 'sent', $2, $2 + interval '2 seconds')`, uid, old)
+	require.NoError(t, err)
+	var ids []string
+	for _, to := range []string{"+4915100000001", "+4915100000002", "+33600000000"} {
+		id, _, err := s.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: to, Body: "x"})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	claimed, err := s.ClaimQueuedMessages(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 3)
+	require.NoError(t, s.MarkSent(ctx, ids[0], "prov-1"))
+	require.NoError(t, s.MarkFailedPermanentAndRefund(ctx, ids[1]))
+
+	st, err := s.GetStats(ctx, core.StatsRequest{
+		UserID: uid, From: time.Now().Add(-72 * time.Hour), To: time.Now(),
+		Granularity: core.GranularityDay, ByCountry: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, st.Buckets, 4)
+	tot := st.Totals
+	require.Equal(t, 4, tot.Messages)
+	require.Equal(t, []int{0, 1, 2, 1}, []int{tot.Queued, tot.Sending, tot.Sent, tot.Failed})
+	require.Equal(t, 2, tot.Spent) // three charges, one refund
+	require.Equal(t, []core.FailureReason{{Reason: "unknown", Count: 1}}, tot.FailureReasons)
+	require.Len(t, tot.Countries, 2)
+	require.Equal(t, "DE", tot.Countries[0].Country)
+	require.Equal(t, 3, tot.Countries[0].Messages)
+	require.Equal(t, "FR", tot.Countries[1].Country)
+	require.Equal(t, 1, tot.Countries[1].Sending)
+
+	y, m, d := old.Date()
+	day := st.Buckets[1]
+	require.Equal(t, time.Date(y, m, d, 0, 0, 0, 0, time.UTC), day.Start.UTC())
+	require.Equal(t, 1, day.Sent)
+	require.Equal(t, int64(2000), *day.AvgLatencyMs)
+	require.Nil(t, st.Buckets[0].AvgLatencyMs)
+	require.Zero(t, st.Buckets[0].Messages)
+
+	_, err = s.GetStats(ctx, core.StatsRequest{
+		UserID: uid, From: time.Now().AddDate(0, -2, 0), To: time.Now(), Granularity: core.GranularityHour,
+	})
+	require.ErrorIs(t, err, core.ErrInvalidPeriod)
+}
+
+func TestBalanceThreshold_RejectsPrivateWebhookURL(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	uid := createUser(t, s, "acme")
+
+	for _, hook := range []string{"http://169.254.169.254/latest/meta-data", "http://127.0.0.1:8080/", "http://localhost/hook"} {
+		_, err := s.CreateBalanceThreshold(ctx, core.ThresholdRequest{UserID: uid, Threshold: 2, WebhookURL: &hook})
+		require.ErrorIs(t, err, core.ErrInvalidThreshold, hook)
+	}
+
+	s.AllowPrivateWebhooks = true
+	hook := "http://127.0.0.1:8080/"
+	_, err := s.CreateBalanceThreshold(ctx, core.ThresholdRequest{UserID: uid, Threshold: 2, WebhookURL: &hook})
+	require.NoError(t, err)
+}
+
+func TestDeleteUser_FailsAndRefundsQueuedMessages(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	s.QueueLimits = core.QueueLimits{Global: 2}
+	a, b := createUser(t, s, "a"), createUser(t, s, "b")
+	topUp(t, s, a, 5)
+	topUp(t, s, b, 5)
+	var queued []string
+	for range 2 {
+		id, _, err := s.EnqueueAndCharge(ctx, core.SendRequest{UserID: a, To: "+49", Body: "x"})
+		require.NoError(t, err)
+		queued = append(queued, id)
+	}
+
+	// A held account's backlog doesn't count against the global limit
+	yes := true
+	_, err := s.UpdateUser(ctx, core.UserUpdate{ID: a, Suspended: &yes})
+	require.NoError(t, err)
+	_, _, err = s.EnqueueAndCharge(ctx, core.SendRequest{UserID: b, To: "+49", Body: "x"})
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteUser(ctx, a))
+	for _, id := range queued {
+		var status, code string
+		require.NoError(t, s.DB.Pool.QueryRow(ctx,
+			`SELECT status::text, error_code FROM messages WHERE id = $1`, id).Scan(&status, &code))
+		require.Equal(t, "failed", status)
+		require.Equal(t, core.ErrorCodeDeleted, code)
+	}
+	var balance, refunds int
+	require.NoError(t, s.DB.Pool.QueryRow(ctx, `SELECT balance FROM users WHERE id = $1`, a).Scan(&balance))
+	require.Equal(t, 5, balance)
+	require.NoError(t, s.DB.Pool.QueryRow(ctx,
+		`SELECT count(*) FROM ledger_entries WHERE user_id = $1 AND kind = 'refund'`, a).Scan(&refunds))
+	require.Equal(t, 2, refunds)
+}
+
+func TestDeleteUser_LeavesNothingToActOn(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	parent := createUser(t, s, "parent")
+	child, err := s.CreateChildAccount(ctx, core.ChildRequest{ParentID: parent, Name: "eu"})
+	require.NoError(t, err)
+	p := auth.Principal{UserID: parent}
+	ok, err := s.CanActFor(ctx, p, child.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.NoError(t, s.DeleteUser(ctx, child.ID))
+	ok, err = s.CanActFor(ctx, p, child.ID)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, _, err = s.CreateAPIKey(ctx, child.ID, "", "k", nil, auth.TenantScopes)
+	require.ErrorIs(t, err, core.ErrUserNotFound)
+	_, err = s.CreateClientCert(ctx, child.ID, "dns:eu.example.com", "c", nil)
+	require.ErrorIs(t, err, core.ErrUserNotFound)
+	_, _, err = s.CreateWebhookEndpoint(ctx, core.WebhookRequest{UserID: child.ID, URL: "https://example.com/hook"})
+	require.ErrorIs(t, err, core.ErrUserNotFound)
+	require.ErrorIs(t, s.CheckUserLive(ctx, child.ID), core.ErrUserNotFound)
+	require.NoError(t, s.CheckUserLive(ctx, parent))
+}
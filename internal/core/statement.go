@@ -0,0 +1,186 @@
+package core
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/Cypherspark/sms-gateway/internal/phone"
+)
+
+var ErrInvalidPeriod = errors.New("invalid_period")
+
+// Statement is a user's account activity for [From, To), built from the ledger.
+// OpeningBalance plus every movement equals ClosingBalance.
+type Statement struct {
+	UserID         string       `json:"user_id"`
+	From           time.Time    `json:"from"`
+	To             time.Time    `json:"to"`
+	OpeningBalance int          `json:"opening_balance"`
+	TopUps         int          `json:"topups"`
+	Charges        int          `json:"charges"` // positive amount debited
+	Refunds        int          `json:"refunds"`
+	TransfersIn    int          `json:"transfers_in"`
+	TransfersOut   int          `json:"transfers_out"` // positive amount moved out
+	PromoGranted   int          `json:"promo_granted"`
+	PromoExpired   int          `json:"promo_expired"` // positive amount forfeited
+	Adjustments    int          `json:"adjustments"`
+	ClosingBalance int          `json:"closing_balance"`
+	ChargeLines    []ChargeLine `json:"charge_lines"`
+}
+
+// ChargeLine groups charges and refunds by destination country and the
+// message's current status.
+type ChargeLine struct {
+	Country     string `json:"country"` // ISO 3166-1 alpha-2, "" if unknown
+	CallingCode string `json:"calling_code"`
+	Status      string `json:"status"`
+	Messages    int    `json:"messages"`
+	Charged     int    `json:"charged"`
+	Refunded    int    `json:"refunded"`
+}
+
+// MonthPeriod returns [first of month, first of next month) in UTC for "YYYY-MM".
+func MonthPeriod(month string) (from, to time.Time, err error) {
+	t, err := time.Parse("2006-01", month)
+	if err != nil {
+		return from, to, ErrInvalidPeriod
+	}
+	return t, t.AddDate(0, 1, 0), nil
+}
+
+func (s *Store) GetStatement(ctx context.Context, userID string, from, to time.Time) (Statement, error) {
+	if !from.Before(to) {
+		return Statement{}, ErrInvalidPeriod
+	}
+	st := Statement{UserID: userID, From: from, To: to, ChargeLines: []ChargeLine{}}
+
+	// One snapshot so the balances and movements agree.
+	err := s.DB.WithSnapshot(ctx, func(q *dbgen.Queries) error {
+		if _, e := q.GetUser(ctx, userID); e != nil {
+			return mapNoRows(e, ErrUserNotFound)
+		}
+		open, e := q.LedgerBalanceAt(ctx, dbgen.LedgerBalanceAtParams{UserID: userID, Ts: toPgTimestamptz(&from)})
+		if e != nil {
+			return e
+		}
+		closing, e := q.LedgerBalanceAt(ctx, dbgen.LedgerBalanceAtParams{UserID: userID, Ts: toPgTimestamptz(&to)})
+		if e != nil {
+			return e
+		}
+		st.OpeningBalance, st.ClosingBalance = int(open), int(closing)
+
+		totals, e := q.LedgerTotalsByKind(ctx, dbgen.LedgerTotalsByKindParams{
+			UserID: userID, FromTs: toPgTimestamptz(&from), ToTs: toPgTimestamptz(&to),
+		})
+		if e != nil {
+			return e
+		}
+		for _, t := range totals {
+			amount := int(t.Amount)
+			switch t.Kind {
+			case LedgerKindTopUp:
+				st.TopUps += amount
+			case LedgerKindCharge:
+				st.Charges -= amount
+			case LedgerKindRefund:
+				st.Refunds += amount
+			case LedgerKindTransferIn:
+				st.TransfersIn += amount
+			case LedgerKindTransferOut:
+				st.TransfersOut -= amount
+			case LedgerKindPromoGrant:
+				st.PromoGranted += amount
+			case LedgerKindPromoExpire:
+				st.PromoExpired -= amount
+			default:
+				st.Adjustments += amount
+			}
+		}
+
+		rows, e := q.LedgerChargesByDestination(ctx, dbgen.LedgerChargesByDestinationParams{
+			UserID: userID, FromTs: toPgTimestamptz(&from), ToTs: toPgTimestamptz(&to),
+		})
+		if e != nil {
+			return e
+		}
+		st.ChargeLines = groupChargeLines(rows)
+		return nil
+	})
+	return st, err
+}
+
+// groupChargeLines folds dial-prefix rows into one line per country and status.
+func groupChargeLines(rows []dbgen.LedgerChargesByDestinationRow) []ChargeLine {
+	type key struct{ code, status string }
+	byKey := map[key]*ChargeLine{}
+	for _, r := range rows {
+		d := phone.Lookup(r.DialPrefix)
+		k := key{d.CallingCode, string(r.Status)}
+		l, ok := byKey[k]
+		if !ok {
+			l = &ChargeLine{Country: d.Country, CallingCode: d.CallingCode, Status: k.status}
+			byKey[k] = l
+		}
+		l.Messages += int(r.Messages)
+		l.Charged += int(r.Charged)
+		l.Refunded += int(r.Refunded)
+	}
+	out := make([]ChargeLine, 0, len(byKey))
+	for _, l := range byKey {
+		out = append(out, *l)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Country != out[j].Country {
+			return out[i].Country < out[j].Country
+		}
+		if out[i].CallingCode != out[j].CallingCode {
+			return out[i].CallingCode < out[j].CallingCode
+		}
+		return out[i].Status < out[j].Status
+	})
+	return out
+}
+
+// WriteCSV writes the statement as one table: summary rows first, then a
+// row per charge line.
+func (st Statement) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	itoa := strconv.Itoa
+	summary := []struct {
+		item   string
+		amount int
+	}{
+		{"opening_balance", st.OpeningBalance},
+		{"topups", st.TopUps},
+		{"charges", st.Charges},
+		{"refunds", st.Refunds},
+		{"transfers_in", st.TransfersIn},
+		{"transfers_out", st.TransfersOut},
+		{"promo_granted", st.PromoGranted},
+		{"promo_expired", st.PromoExpired},
+		{"adjustments", st.Adjustments},
+		{"closing_balance", st.ClosingBalance},
+	}
+	records := [][]string{
+		{"section", "item", "country", "calling_code", "status", "messages", "charged", "refunded", "amount"},
+	}
+	for _, s := range summary {
+		records = append(records, []string{"summary", s.item, "", "", "", "", "", "", itoa(s.amount)})
+	}
+	for _, l := range st.ChargeLines {
+		records = append(records, []string{
+			"charges", "sms", l.Country, l.CallingCode, l.Status,
+			itoa(l.Messages), itoa(l.Charged), itoa(l.Refunded), itoa(l.Charged - l.Refunded),
+		})
+	}
+	if err := cw.WriteAll(records); err != nil {
+		return err
+	}
+	return cw.Error()
+}
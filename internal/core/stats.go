@@ -0,0 +1,271 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/Cypherspark/sms-gateway/internal/phone"
+)
+
+var ErrInvalidGranularity = errors.New("invalid_granularity")
+
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+
+	// MaxStatsBuckets caps a stats period: a month by the hour, two years by
+	// the day.
+	MaxStatsBuckets = 744
+)
+
+// StatsRequest asks for a user's message stats over [From, To), widened to
+// whole buckets in UTC.
+type StatsRequest struct {
+	UserID      string
+	From, To    time.Time
+	Granularity string // GranularityHour or GranularityDay
+	ByCountry   bool
+}
+
+// Stats are a user's message stats for one period, bucketed over time.
+// Messages count towards the bucket they were requested in, under their
+// current status.
+type Stats struct {
+	UserID      string        `json:"user_id"`
+	From        time.Time     `json:"from"`
+	To          time.Time     `json:"to"`
+	Granularity string        `json:"granularity"`
+	Totals      StatsBucket   `json:"totals"` // the whole period; Start is From
+	Buckets     []StatsBucket `json:"buckets"`
+}
+
+// StatsBucket covers one hour or day from Start. Countries is nil unless
+// asked for.
+type StatsBucket struct {
+	Start time.Time `json:"start"`
+	MessageStats
+	Countries []CountryStats `json:"countries"`
+
+	byCode map[string]*CountryStats
+}
+
+// CountryStats are a bucket's messages to one destination country.
+type CountryStats struct {
+	Country     string `json:"country"` // ISO 3166-1 alpha-2, "" if unknown
+	CallingCode string `json:"calling_code"`
+	MessageStats
+}
+
+// MessageStats counts messages by status, with what they cost (charges net of
+// refunds, whoever paid), how long sent ones took from request to provider
+// and why failed ones failed.
+type MessageStats struct {
+	Messages       int             `json:"messages"`
+	Queued         int             `json:"queued"`
+	Sending        int             `json:"sending"`
+	Sent           int             `json:"sent"`
+	Failed         int             `json:"failed"`
+	Spent          int             `json:"spent"`
+	AvgLatencyMs   *int64          `json:"avg_latency_ms"` // nil when nothing was sent
+	FailureReasons []FailureReason `json:"failure_reasons"`
+
+	latencySum int64
+	latencyN   int
+	reasons    map[string]int
+}
+
+// FailureReason is a message error code and how many failed with it;
+// "unknown" when none was recorded.
+type FailureReason struct {
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+func (m *MessageStats) add(r dbgen.MessageStatsRow) {
+	m.Queued += int(r.Queued)
+	m.Sending += int(r.Sending)
+	m.Sent += int(r.Sent)
+	m.Failed += int(r.Failed)
+	m.Spent += int(r.Spent)
+	m.latencySum += r.LatencyMsSum
+	m.latencyN += int(r.LatencyN)
+}
+
+func (m *MessageStats) addFailures(reason string, n int) {
+	if m.reasons == nil {
+		m.reasons = map[string]int{}
+	}
+	m.reasons[reason] += n
+}
+
+func (m *MessageStats) finish() {
+	m.Messages = m.Queued + m.Sending + m.Sent + m.Failed
+	if m.latencyN > 0 {
+		avg := m.latencySum / int64(m.latencyN)
+		m.AvgLatencyMs = &avg
+	}
+	m.FailureReasons = make([]FailureReason, 0, len(m.reasons))
+	for reason, n := range m.reasons {
+		m.FailureReasons = append(m.FailureReasons, FailureReason{Reason: reason, Count: n})
+	}
+	sort.Slice(m.FailureReasons, func(i, j int) bool {
+		a, b := m.FailureReasons[i], m.FailureReasons[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Reason < b.Reason
+	})
+}
+
+// country returns the bucket's line for a destination prefix, or nil when
+// countries weren't asked for.
+func (b *StatsBucket) country(dialPrefix string) *MessageStats {
+	if b.byCode == nil {
+		return nil
+	}
+	d := phone.Lookup(dialPrefix)
+	c, ok := b.byCode[d.CallingCode]
+	if !ok {
+		c = &CountryStats{Country: d.Country, CallingCode: d.CallingCode}
+		b.byCode[d.CallingCode] = c
+	}
+	return &c.MessageStats
+}
+
+func (b *StatsBucket) finish() {
+	b.MessageStats.finish()
+	if b.byCode == nil {
+		return
+	}
+	b.Countries = make([]CountryStats, 0, len(b.byCode))
+	for _, c := range b.byCode {
+		c.finish()
+		b.Countries = append(b.Countries, *c)
+	}
+	sort.Slice(b.Countries, func(i, j int) bool {
+		if b.Countries[i].Country != b.Countries[j].Country {
+			return b.Countries[i].Country < b.Countries[j].Country
+		}
+		return b.Countries[i].CallingCode < b.Countries[j].CallingCode
+	})
+}
+
+// statsBuckets widens [from, to) to whole buckets and returns their starts
+// and where the last one ends.
+func statsBuckets(from, to time.Time, granularity string) (starts []time.Time, end time.Time, err error) {
+	var floor func(time.Time) time.Time
+	var next func(time.Time) time.Time
+	switch granularity {
+	case GranularityHour:
+		floor = func(t time.Time) time.Time { return t.UTC().Truncate(time.Hour) }
+		next = func(t time.Time) time.Time { return t.Add(time.Hour) }
+	case GranularityDay:
+		floor = func(t time.Time) time.Time {
+			y, m, d := t.UTC().Date()
+			return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		}
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	default:
+		return nil, end, ErrInvalidGranularity
+	}
+	if !from.Before(to) {
+		return nil, end, ErrInvalidPeriod
+	}
+	for end = floor(from); end.Before(to); end = next(end) {
+		if len(starts) == MaxStatsBuckets {
+			return nil, end, ErrInvalidPeriod
+		}
+		starts = append(starts, end)
+	}
+	return starts, end, nil
+}
+
+// GetStats reads a user's message stats from the hourly rollups, never from
+// messages themselves.
+func (s *Store) GetStats(ctx context.Context, req StatsRequest) (Stats, error) {
+	starts, to, err := statsBuckets(req.From, req.To, req.Granularity)
+	if err != nil {
+		return Stats{}, err
+	}
+	from := starts[0]
+	st := Stats{
+		UserID:      req.UserID,
+		From:        from,
+		To:          to,
+		Granularity: req.Granularity,
+		Totals:      StatsBucket{Start: from},
+		Buckets:     make([]StatsBucket, len(starts)),
+	}
+	index := make(map[int64]*StatsBucket, len(starts))
+	for i, t := range starts {
+		st.Buckets[i].Start = t
+		index[t.Unix()] = &st.Buckets[i]
+	}
+	if req.ByCountry {
+		st.Totals.byCode = map[string]*CountryStats{}
+		for i := range st.Buckets {
+			st.Buckets[i].byCode = map[string]*CountryStats{}
+		}
+	}
+
+	// One snapshot so counts and failure reasons agree.
+	err = s.DB.WithSnapshot(ctx, func(q *dbgen.Queries) error {
+		if _, e := q.GetUser(ctx, req.UserID); e != nil {
+			return mapNoRows(e, ErrUserNotFound)
+		}
+		rows, e := q.MessageStats(ctx, dbgen.MessageStatsParams{
+			Granularity: req.Granularity,
+			UserID:      req.UserID,
+			FromTs:      toPgTimestamptz(&from),
+			ToTs:        toPgTimestamptz(&to),
+		})
+		if e != nil {
+			return e
+		}
+		for _, r := range rows {
+			b := index[r.Bucket.Time.Unix()]
+			if b == nil {
+				continue
+			}
+			b.add(r)
+			st.Totals.add(r)
+			if c := b.country(r.DialPrefix); c != nil {
+				c.add(r)
+				st.Totals.country(r.DialPrefix).add(r)
+			}
+		}
+		failures, e := q.MessageFailureStats(ctx, dbgen.MessageFailureStatsParams{
+			Granularity: req.Granularity,
+			UserID:      req.UserID,
+			FromTs:      toPgTimestamptz(&from),
+			ToTs:        toPgTimestamptz(&to),
+		})
+		if e != nil {
+			return e
+		}
+		for _, f := range failures {
+			b := index[f.Bucket.Time.Unix()]
+			if b == nil {
+				continue
+			}
+			b.addFailures(f.Reason, int(f.Failed))
+			st.Totals.addFailures(f.Reason, int(f.Failed))
+			if c := b.country(f.DialPrefix); c != nil {
+				c.addFailures(f.Reason, int(f.Failed))
+				st.Totals.country(f.DialPrefix).addFailures(f.Reason, int(f.Failed))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+	st.Totals.finish()
+	for i := range st.Buckets {
+		st.Buckets[i].finish()
+	}
+	return st, nil
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/Cypherspark/sms-gateway/internal/events"
+)
+
+// StatusEventsAfter returns up to limit of the user's logged status changes
+// after afterID, oldest first; messageIDs, when non-nil, narrows them to
+// those messages.
+func (s *Store) StatusEventsAfter(ctx context.Context, userID string, messageIDs []string, afterID int64, limit int) ([]events.StatusEvent, error) {
+	rows, err := s.DB.Queries.ListStatusEventsAfter(ctx, dbgen.ListStatusEventsAfterParams{
+		UserID:     userID,
+		AfterID:    afterID,
+		MessageIds: messageIDs,
+		LimitN:     int32(limit),
+	})
+	if err != nil {
+		return nil, mapNoRows(err, ErrUserNotFound)
+	}
+	out := make([]events.StatusEvent, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, events.StatusEvent{
+			ID:        r.ID,
+			MessageID: r.MessageID,
+			UserID:    r.UserID,
+			Status:    string(r.Status),
+			At:        r.OccurredAt.Time,
+		})
+	}
+	return out, nil
+}
+
+// StatusEventReplayFrom is the id a stream that last saw afterID should
+// replay from: a little before it, since events logged up to lag earlier may
+// have committed after it. The overlap is sent again; clients skip ids they
+// have already seen.
+func (s *Store) StatusEventReplayFrom(ctx context.Context, afterID int64, lag time.Duration) (int64, error) {
+	return s.DB.Queries.StatusEventReplayFrom(ctx, dbgen.StatusEventReplayFromParams{
+		AfterID: afterID,
+		LagMs:   lag.Milliseconds(),
+	})
+}
+
+// OldestStatusEventID is the first status change still in the log, or 0 when
+// it is empty. A stream resuming from before it has missed events.
+func (s *Store) OldestStatusEventID(ctx context.Context) (int64, error) {
+	return s.DB.Queries.OldestStatusEventID(ctx)
+}
+
+// PruneStatusEvents drops logged status changes older than retention.
+func (s *Store) PruneStatusEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	before := time.Now().Add(-retention)
+	return s.DB.Queries.PruneStatusEvents(ctx, toPgTimestamptz(&before))
+}
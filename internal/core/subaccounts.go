@@ -0,0 +1,273 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	BillingModeOwn    = "own"    // pays from its own balance
+	BillingModeParent = "parent" // draws on the parent's balance, up to quota
+
+	LedgerKindTransferIn  = "transfer_in"
+	LedgerKindTransferOut = "transfer_out"
+)
+
+var (
+	ErrQuotaExceeded      = errors.New("quota_exceeded")
+	ErrNestedSubaccount   = errors.New("nested_subaccount")
+	ErrInvalidBillingMode = errors.New("invalid_billing_mode")
+	ErrInvalidQuota       = errors.New("invalid_quota")
+	ErrInvalidTransfer    = errors.New("invalid_transfer")
+)
+
+func toPgTimestamptz(p *time.Time) pgtype.Timestamptz {
+	if p == nil {
+		return pgtype.Timestamptz{Valid: false}
+	}
+	return pgtype.Timestamptz{Time: *p, Valid: true}
+}
+
+func toPgInt4(p *int) pgtype.Int4 {
+	if p == nil {
+		return pgtype.Int4{Valid: false}
+	}
+	return pgtype.Int4{Int32: int32(*p), Valid: true}
+}
+
+// resolvePayer locks the sender's row and returns the account to debit. For a
+// parent-billed child the amount is drawn against its quota first. Deleted
+// senders are reported as missing.
+func resolvePayer(ctx context.Context, q *dbgen.Queries, userID string, amount int) (string, error) {
+	acct, err := q.GetBillingInfoForUpdate(ctx, userID)
+	switch {
+	case err != nil:
+		return "", mapNoRows(err, ErrUserNotFound)
+	case acct.Deleted:
+		return "", ErrUserNotFound
+	case acct.Suspended:
+		return "", ErrUserSuspended
+	}
+	if acct.BillingMode != BillingModeParent {
+		return userID, nil
+	}
+	n, err := q.DrawParentQuota(ctx, dbgen.DrawParentQuotaParams{Amount: int32(amount), ID: userID})
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", ErrQuotaExceeded
+	}
+	return acct.ParentID.String(), nil
+}
+
+// ---- Parent-level management ----
+
+type ChildRequest struct {
+	ParentID    string
+	Name        string
+	BillingMode string // "own" (default) or "parent"
+	Quota       *int   // parent-billed only; nil = uncapped
+}
+
+func validBilling(mode string, quota *int) error {
+	if mode != BillingModeOwn && mode != BillingModeParent {
+		return ErrInvalidBillingMode
+	}
+	if quota != nil && *quota < 0 {
+		return ErrInvalidQuota
+	}
+	return nil
+}
+
+func (s *Store) CreateChildAccount(ctx context.Context, req ChildRequest) (dbgen.User, error) {
+	if req.BillingMode == "" {
+		req.BillingMode = BillingModeOwn
+	}
+	if err := validBilling(req.BillingMode, req.Quota); err != nil {
+		return dbgen.User{}, err
+	}
+	parent, err := s.DB.Queries.GetUser(ctx, req.ParentID)
+	if err != nil {
+		return dbgen.User{}, mapNoRows(err, ErrUserNotFound)
+	}
+	if parent.ParentID.Valid {
+		return dbgen.User{}, ErrNestedSubaccount
+	}
+	var child dbgen.User
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		var e error
+		child, e = q.CreateChildUser(ctx, dbgen.CreateChildUserParams{
+			Name:        req.Name,
+			ParentID:    parent.ID,
+			BillingMode: req.BillingMode,
+			Quota:       toPgInt4(req.Quota),
+		})
+		if e != nil {
+			return e
+		}
+		return record(ctx, q, AuditUserCreate, TargetUser, child.ID, nil, child)
+	})
+	return child, err
+}
+
+func (s *Store) ListChildAccounts(ctx context.Context, parentID string) ([]dbgen.User, error) {
+	items, err := s.DB.Queries.ListChildUsers(ctx, parentID)
+	return items, mapNoRows(err, ErrUserNotFound)
+}
+
+type ChildBillingUpdate struct {
+	ParentID    string
+	ChildID     string
+	BillingMode *string
+	SetQuota    bool // apply Quota, where nil means uncapped
+	Quota       *int
+}
+
+func (s *Store) UpdateChildBilling(ctx context.Context, upd ChildBillingUpdate) (dbgen.User, error) {
+	if upd.BillingMode != nil {
+		if err := validBilling(*upd.BillingMode, upd.Quota); err != nil {
+			return dbgen.User{}, err
+		}
+	} else if upd.Quota != nil && *upd.Quota < 0 {
+		return dbgen.User{}, ErrInvalidQuota
+	}
+	var u dbgen.User
+	err := s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		if _, e := q.LockUserBalance(ctx, upd.ChildID); e != nil {
+			return mapNoRows(e, ErrNotFound)
+		}
+		before, e := q.GetUser(ctx, upd.ChildID)
+		if e != nil {
+			return mapNoRows(e, ErrNotFound)
+		}
+		u, e = q.UpdateChildBilling(ctx, dbgen.UpdateChildBillingParams{
+			BillingMode: toPgText(upd.BillingMode),
+			SetQuota:    upd.SetQuota,
+			Quota:       toPgInt4(upd.Quota),
+			ID:          upd.ChildID,
+			ParentID:    upd.ParentID,
+		})
+		if e != nil {
+			return mapNoRows(e, ErrNotFound)
+		}
+		return record(ctx, q, AuditChildUpdate, TargetUser, u.ID, before, u)
+	})
+	return u, err
+}
+
+type TransferRequest struct {
+	ParentID   string // the family the transfer happens in
+	FromUserID string
+	ToUserID   string
+	Amount     int
+	Note       *string
+}
+
+// TransferCredit moves own balance between a parent and its children (either
+// direction, or child to child), writing a ledger entry on both sides.
+func (s *Store) TransferCredit(ctx context.Context, req TransferRequest) (fromBalance, toBalance int, err error) {
+	if req.Amount <= 0 || req.FromUserID == req.ToUserID {
+		return 0, 0, ErrInvalidTransfer
+	}
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		// 1) Both sides must belong to the parent's family
+		from, e := familyMember(ctx, q, req.ParentID, req.FromUserID)
+		if e != nil {
+			return e
+		}
+		to, e := familyMember(ctx, q, req.ParentID, req.ToUserID)
+		if e != nil {
+			return e
+		}
+
+		// 2) Lock children before the parent (same order as EnqueueAndCharge)
+		locks := []dbgen.User{from, to}
+		sort.Slice(locks, func(i, j int) bool {
+			if locks[i].ParentID.Valid != locks[j].ParentID.Valid {
+				return locks[i].ParentID.Valid
+			}
+			return locks[i].ID < locks[j].ID
+		})
+		for _, u := range locks {
+			if _, e := q.LockUserBalance(ctx, u.ID); e != nil {
+				return e
+			}
+		}
+
+		// 3) Move the credit
+		rows, e := q.DebitIfEnough(ctx, dbgen.DebitIfEnoughParams{Balance: int32(req.Amount), ID: from.ID})
+		if e != nil {
+			return e
+		}
+		if rows == 0 {
+			return ErrInsufficientBalance
+		}
+		if _, e := q.TopUp(ctx, dbgen.TopUpParams{Balance: int32(req.Amount), ID: to.ID}); e != nil {
+			return e
+		}
+
+		// 4) Ledger on both sides
+		out, e := q.InsertLedgerEntry(ctx, dbgen.InsertLedgerEntryParams{
+			Kind:           LedgerKindTransferOut,
+			Amount:         -int32(req.Amount),
+			Note:           toPgText(req.Note),
+			CounterpartyID: toPgUUID(to.ID),
+			UserID:         from.ID,
+		})
+		if e != nil {
+			return e
+		}
+		in, e := q.InsertLedgerEntry(ctx, dbgen.InsertLedgerEntryParams{
+			Kind:           LedgerKindTransferIn,
+			Amount:         int32(req.Amount),
+			Note:           toPgText(req.Note),
+			CounterpartyID: toPgUUID(from.ID),
+			UserID:         to.ID,
+		})
+		if e != nil {
+			return e
+		}
+		fromBalance, toBalance = int(out.BalanceAfter), int(in.BalanceAfter)
+		if e := record(ctx, q, AuditTransfer, TargetUser, from.ID,
+			map[string]int{"balance": fromBalance + req.Amount},
+			map[string]any{"balance": fromBalance, "amount": req.Amount, "to_user_id": to.ID, "to_balance": toBalance},
+		); e != nil {
+			return e
+		}
+
+		// 5) Alerts follow the balances
+		if e := tripThresholds(ctx, q, from.ID); e != nil {
+			return e
+		}
+		return q.RearmBalanceThresholds(ctx, to.ID)
+	})
+	return fromBalance, toBalance, err
+}
+
+func familyMember(ctx context.Context, q *dbgen.Queries, parentID, userID string) (dbgen.User, error) {
+	u, err := q.GetUser(ctx, userID)
+	if err != nil {
+		return u, mapNoRows(err, ErrNotFound)
+	}
+	if u.ID != parentID && u.ParentID.String() != parentID {
+		return u, ErrNotFound
+	}
+	return u, nil
+}
+
+// SubaccountUsage returns one row for the parent followed by one per child,
+// counting messages requested in [from, to).
+func (s *Store) SubaccountUsage(ctx context.Context, parentID string, from, to *time.Time) ([]dbgen.SubaccountUsageRow, error) {
+	items, err := s.DB.Queries.SubaccountUsage(ctx, dbgen.SubaccountUsageParams{
+		FromTs:   toPgTimestamptz(from),
+		ToTs:     toPgTimestamptz(to),
+		ParentID: parentID,
+	})
+	return items, mapNoRows(err, ErrUserNotFound)
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrUserSuspended     = errors.New("user_suspended")
+	ErrSubaccountsRemain = errors.New("subaccounts_remain")
+	ErrInvalidUserStatus = errors.New("invalid_status")
+)
+
+// ErrorCodeDeleted is the error_code of messages failed because their
+// account was deleted before they were sent.
+const ErrorCodeDeleted = "deleted"
+
+// Account states, as filtered on by ListUsers.
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusDeleted   = "deleted"
+)
+
+// UserFilter narrows ListUsers; zero fields don't filter.
+type UserFilter struct {
+	Search string // part of the name (case-insensitive), or the exact id
+	Status string // UserStatus*; empty lists every account that isn't deleted
+	Limit  int
+
+	// After pages on from the last account of the previous page.
+	AfterCreatedAt *time.Time
+	AfterID        string
+}
+
+// ListUsers returns accounts newest first.
+func (s *Store) ListUsers(ctx context.Context, f UserFilter) ([]dbgen.User, error) {
+	switch f.Status {
+	case "", UserStatusActive, UserStatusSuspended, UserStatusDeleted:
+	default:
+		return nil, ErrInvalidUserStatus
+	}
+	p := dbgen.ListUsersParams{
+		Status:   textOrNull(f.Status),
+		CursorTs: toPgTimestamptz(f.AfterCreatedAt),
+		CursorID: toPgUUID(f.AfterID),
+		LimitN:   int32(f.Limit),
+	}
+	if f.Search != "" {
+		p.Search = textOrNull(f.Search)
+		p.NamePattern = textOrNull("%" + likeEscaper.Replace(f.Search) + "%")
+	}
+	return s.DB.Queries.ListUsers(ctx, p)
+}
+
+// likeEscaper makes a search term match literally inside an ILIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// GetUser returns an account, deleted or not.
+func (s *Store) GetUser(ctx context.Context, id string) (dbgen.User, error) {
+	u, err := s.DB.Queries.GetUser(ctx, id)
+	return u, mapNoRows(err, ErrUserNotFound)
+}
+
+// UserUpdate changes an account; nil fields are left alone.
+type UserUpdate struct {
+	ID        string
+	Name      *string
+	Suspended *bool
+}
+
+// UpdateUser renames, suspends or reinstates an account. A suspended account
+// can't enqueue, and its queued messages (and its sub-accounts') wait until
+// it is reinstated. Deleted accounts are reported as missing.
+func (s *Store) UpdateUser(ctx context.Context, upd UserUpdate) (dbgen.User, error) {
+	var u dbgen.User
+	err := s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		if _, e := q.LockUserBalance(ctx, upd.ID); e != nil {
+			return mapNoRows(e, ErrUserNotFound)
+		}
+		before, e := q.GetUser(ctx, upd.ID)
+		if e != nil {
+			return e
+		}
+		var suspended pgtype.Bool
+		if upd.Suspended != nil {
+			suspended = pgtype.Bool{Bool: *upd.Suspended, Valid: true}
+		}
+		u, e = q.UpdateUser(ctx, dbgen.UpdateUserParams{
+			Name:      toPgText(upd.Name),
+			Suspended: suspended,
+			ID:        upd.ID,
+		})
+		if e != nil {
+			return mapNoRows(e, ErrUserNotFound) // deleted
+		}
+		if u.Name != before.Name {
+			e = record(ctx, q, AuditUserUpdate, TargetUser, u.ID,
+				map[string]string{"name": before.Name}, map[string]string{"name": u.Name})
+			if e != nil {
+				return e
+			}
+		}
+		switch {
+		case u.SuspendedAt.Valid && !before.SuspendedAt.Valid:
+			return record(ctx, q, AuditUserSuspend, TargetUser, u.ID, nil, nil)
+		case !u.SuspendedAt.Valid && before.SuspendedAt.Valid:
+			return record(ctx, q, AuditUserReinstate, TargetUser, u.ID, nil, nil)
+		}
+		return nil
+	})
+	return u, err
+}
+
+// CheckUserLive is ErrUserNotFound unless id names an account that hasn't
+// been deleted.
+func (s *Store) CheckUserLive(ctx context.Context, id string) error {
+	if !toPgUUID(id).Valid {
+		return ErrUserNotFound
+	}
+	u, err := s.DB.Queries.GetUser(ctx, id)
+	if err != nil {
+		return mapNoRows(err, ErrUserNotFound)
+	}
+	if u.DeletedAt.Valid {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// DeleteUser soft-deletes an account: its keys and client certificates are
+// revoked, its queued messages are failed and refunded (error_code "deleted")
+// and it drops out of listings, but the row stays so its messages, ledger and
+// audit trail still resolve. A parent with sub-accounts left can't be deleted.
+func (s *Store) DeleteUser(ctx context.Context, id string) error {
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		if _, e := q.LockUserBalance(ctx, id); e != nil {
+			return mapNoRows(e, ErrUserNotFound)
+		}
+		children, e := q.CountLiveChildUsers(ctx, id)
+		if e != nil {
+			return e
+		}
+		if children > 0 {
+			return ErrSubaccountsRemain
+		}
+		u, e := q.SoftDeleteUser(ctx, id)
+		if e != nil {
+			return mapNoRows(e, ErrUserNotFound) // already deleted
+		}
+		if _, e := q.RevokeUserAPIKeys(ctx, id); e != nil {
+			return e
+		}
+		if _, e := q.RevokeUserClientCertificates(ctx, id); e != nil {
+			return e
+		}
+		queued, e := q.LockQueuedMessagesForUser(ctx, id)
+		if e != nil {
+			return e
+		}
+		code := ErrorCodeDeleted
+		for _, m := range queued {
+			if e := failAndRefund(ctx, q, m, &code); e != nil {
+				return e
+			}
+		}
+		return record(ctx, q, AuditUserDelete, TargetUser, id, nil, u)
+	})
+}
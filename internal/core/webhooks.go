@@ -0,0 +1,406 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/auth"
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/Cypherspark/sms-gateway/internal/netguard"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrInvalidURL       = errors.New("invalid_url")
+	ErrInvalidEventType = errors.New("invalid_event_type")
+	ErrWebhookDisabled  = errors.New("webhook_disabled")
+)
+
+// Webhook event types.
+const (
+	EventMessageSent      = "message.sent"
+	EventMessageDelivered = "message.delivered"
+	EventMessageFailed    = "message.failed"
+	EventBalanceLow       = "balance.low"
+)
+
+// WebhookEventTypes are the event types an endpoint can subscribe to; new
+// endpoints get all of them unless narrowed. message.delivered is raised once
+// the provider reports delivery; the dummy provider never does.
+var WebhookEventTypes = []string{EventMessageSent, EventMessageDelivered, EventMessageFailed, EventBalanceLow}
+
+const (
+	AuditWebhookCreate  = "webhook.create"
+	AuditWebhookUpdate  = "webhook.update"
+	AuditWebhookDelete  = "webhook.delete"
+	AuditWebhookReplay  = "webhook.replay"
+	AuditWebhookDisable = "webhook.disable"
+
+	TargetWebhook = "webhook"
+
+	// Delivery states.
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+func validWebhookURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// checkWebhookURL is validWebhookURL plus, unless AllowPrivateWebhooks is
+// set, a check that the host isn't a private or internal address.
+func (s *Store) checkWebhookURL(ctx context.Context, raw string) bool {
+	if !validWebhookURL(raw) {
+		return false
+	}
+	return s.AllowPrivateWebhooks || netguard.CheckURL(ctx, raw) == nil
+}
+
+func validEventTypes(types []string) bool {
+	return len(types) > 0 && subset(types, WebhookEventTypes)
+}
+
+// WebhookEndpoint is an endpoint's public view; the secret is only returned on
+// creation.
+type WebhookEndpoint struct {
+	ID                  string     `json:"id"`
+	UserID              string     `json:"user_id"`
+	URL                 string     `json:"url"`
+	EventTypes          []string   `json:"event_types"`
+	Description         string     `json:"description"`
+	Enabled             bool       `json:"enabled"`
+	DisabledAt          *time.Time `json:"disabled_at"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+func toWebhookEndpoint(e dbgen.WebhookEndpoint) WebhookEndpoint {
+	out := WebhookEndpoint{
+		ID:                  e.ID,
+		UserID:              e.UserID,
+		URL:                 e.Url,
+		EventTypes:          e.EventTypes,
+		Description:         e.Description,
+		Enabled:             !e.DisabledAt.Valid,
+		ConsecutiveFailures: int(e.ConsecutiveFailures),
+		CreatedAt:           e.CreatedAt.Time,
+	}
+	if e.DisabledAt.Valid {
+		out.DisabledAt = &e.DisabledAt.Time
+	}
+	return out
+}
+
+type WebhookRequest struct {
+	UserID      string
+	URL         string
+	EventTypes  []string // nil means all
+	Description string
+}
+
+// CreateWebhookEndpoint registers an endpoint and returns the secret its
+// deliveries are signed with.
+func (s *Store) CreateWebhookEndpoint(ctx context.Context, req WebhookRequest) (WebhookEndpoint, string, error) {
+	if !s.checkWebhookURL(ctx, req.URL) {
+		return WebhookEndpoint{}, "", ErrInvalidURL
+	}
+	if req.EventTypes == nil {
+		req.EventTypes = WebhookEventTypes
+	}
+	if !validEventTypes(req.EventTypes) {
+		return WebhookEndpoint{}, "", ErrInvalidEventType
+	}
+	if !toPgUUID(req.UserID).Valid {
+		return WebhookEndpoint{}, "", ErrUserNotFound
+	}
+	secret, err := auth.NewWebhookSecret()
+	if err != nil {
+		return WebhookEndpoint{}, "", err
+	}
+	var out WebhookEndpoint
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		if _, err := q.LockLiveUser(ctx, req.UserID); err != nil {
+			return mapNoRows(err, ErrUserNotFound)
+		}
+		e, err := q.InsertWebhookEndpoint(ctx, dbgen.InsertWebhookEndpointParams{
+			UserID:      req.UserID,
+			Url:         req.URL,
+			Secret:      []byte(secret),
+			EventTypes:  req.EventTypes,
+			Description: req.Description,
+		})
+		if err != nil {
+			return err
+		}
+		out = toWebhookEndpoint(e)
+		return record(ctx, q, AuditWebhookCreate, TargetWebhook, e.ID, nil, out)
+	})
+	if isForeignKeyViolation(err) {
+		return WebhookEndpoint{}, "", ErrUserNotFound
+	}
+	return out, secret, err
+}
+
+func (s *Store) ListWebhookEndpoints(ctx context.Context, userID string) ([]WebhookEndpoint, error) {
+	items, err := s.DB.Queries.ListWebhookEndpoints(ctx, userID)
+	if err != nil {
+		return nil, mapNoRows(err, ErrUserNotFound)
+	}
+	out := make([]WebhookEndpoint, 0, len(items))
+	for _, e := range items {
+		out = append(out, toWebhookEndpoint(e))
+	}
+	return out, nil
+}
+
+// WebhookUpdate changes the non-nil fields of an endpoint. Enabling a disabled
+// endpoint resets its failure count; deliveries given up on while it was
+// disabled stay failed until replayed.
+type WebhookUpdate struct {
+	URL         *string
+	EventTypes  []string
+	Description *string
+	Enabled     *bool
+}
+
+func (s *Store) UpdateWebhookEndpoint(ctx context.Context, userID, id string, u WebhookUpdate) (WebhookEndpoint, error) {
+	if u.URL != nil && !s.checkWebhookURL(ctx, *u.URL) {
+		return WebhookEndpoint{}, ErrInvalidURL
+	}
+	if u.EventTypes != nil && !validEventTypes(u.EventTypes) {
+		return WebhookEndpoint{}, ErrInvalidEventType
+	}
+	var out WebhookEndpoint
+	err := s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		old, e := q.GetWebhookEndpointForUpdate(ctx, dbgen.GetWebhookEndpointForUpdateParams{ID: id, UserID: userID})
+		if e != nil {
+			return mapNoRows(e, ErrNotFound)
+		}
+		enabled := pgtype.Bool{}
+		if u.Enabled != nil {
+			enabled = pgtype.Bool{Bool: *u.Enabled, Valid: true}
+		}
+		e2, e := q.UpdateWebhookEndpoint(ctx, dbgen.UpdateWebhookEndpointParams{
+			Url:         toPgText(u.URL),
+			EventTypes:  u.EventTypes,
+			Description: toPgText(u.Description),
+			Enabled:     enabled,
+			ID:          id,
+			UserID:      userID,
+		})
+		if e != nil {
+			return e
+		}
+		if u.Enabled != nil && !*u.Enabled {
+			if e := failPendingDeliveries(ctx, q, id); e != nil {
+				return e
+			}
+		}
+		out = toWebhookEndpoint(e2)
+		return record(ctx, q, AuditWebhookUpdate, TargetWebhook, id, toWebhookEndpoint(old), out)
+	})
+	return out, err
+}
+
+func (s *Store) DeleteWebhookEndpoint(ctx context.Context, userID, id string) error {
+	return s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		e, err := q.DeleteWebhookEndpoint(ctx, dbgen.DeleteWebhookEndpointParams{ID: id, UserID: userID})
+		if err != nil {
+			return mapNoRows(err, ErrNotFound)
+		}
+		return record(ctx, q, AuditWebhookDelete, TargetWebhook, e.ID, toWebhookEndpoint(e), nil)
+	})
+}
+
+func failPendingDeliveries(ctx context.Context, q *dbgen.Queries, endpointID string) error {
+	reason := "endpoint_disabled"
+	return q.FailPendingWebhookDeliveries(ctx, dbgen.FailPendingWebhookDeliveriesParams{
+		Reason:     toPgText(&reason),
+		EndpointID: endpointID,
+	})
+}
+
+// ---- Deliveries ----
+
+// WebhookDelivery is one event's delivery (with its retries) to an endpoint.
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	EventID        string     `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status"` // pending | delivered | failed
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at"` // while pending
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	FailedAt       *time.Time `json:"failed_at"`
+	ResponseStatus *int       `json:"response_status"` // of the last attempt
+	LastError      *string    `json:"last_error"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func toWebhookDelivery(d dbgen.ListWebhookDeliveriesRow) WebhookDelivery {
+	out := WebhookDelivery{
+		ID:        d.ID,
+		EventID:   d.EventID,
+		EventType: d.Type,
+		Status:    DeliveryPending,
+		Attempts:  int(d.Attempts),
+		LastError: textPtr(d.LastError),
+		CreatedAt: d.CreatedAt.Time,
+	}
+	switch {
+	case d.DeliveredAt.Valid:
+		out.Status, out.DeliveredAt = DeliveryDelivered, &d.DeliveredAt.Time
+	case d.FailedAt.Valid:
+		out.Status, out.FailedAt = DeliveryFailed, &d.FailedAt.Time
+	default:
+		out.NextAttemptAt = &d.NextAttemptAt.Time
+	}
+	if d.ResponseStatus.Valid {
+		code := int(d.ResponseStatus.Int32)
+		out.ResponseStatus = &code
+	}
+	return out
+}
+
+// ListWebhookDeliveries returns an endpoint's deliveries, newest first, older
+// than beforeID when it is non-zero.
+func (s *Store) ListWebhookDeliveries(ctx context.Context, userID, endpointID string, beforeID int64, limit int) ([]WebhookDelivery, error) {
+	before := pgtype.Int8{Int64: beforeID, Valid: beforeID > 0}
+	rows, err := s.DB.Queries.ListWebhookDeliveries(ctx, dbgen.ListWebhookDeliveriesParams{
+		EndpointID: endpointID,
+		UserID:     userID,
+		BeforeID:   before,
+		LimitN:     int32(limit),
+	})
+	if err != nil {
+		return nil, mapNoRows(err, ErrNotFound)
+	}
+	out := make([]WebhookDelivery, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, toWebhookDelivery(r))
+	}
+	return out, nil
+}
+
+// ReplayWebhookDelivery sends a delivery's event to its endpoint again, as a
+// new delivery. The endpoint must be enabled.
+func (s *Store) ReplayWebhookDelivery(ctx context.Context, userID, endpointID string, deliveryID int64) (WebhookDelivery, error) {
+	var out WebhookDelivery
+	err := s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		e, err := q.GetWebhookEndpointForUpdate(ctx, dbgen.GetWebhookEndpointForUpdateParams{ID: endpointID, UserID: userID})
+		if err != nil {
+			return mapNoRows(err, ErrNotFound)
+		}
+		if e.DisabledAt.Valid {
+			return ErrWebhookDisabled
+		}
+		d, err := q.ReplayWebhookDelivery(ctx, dbgen.ReplayWebhookDeliveryParams{ID: deliveryID, EndpointID: endpointID})
+		if err != nil {
+			return mapNoRows(err, ErrNotFound)
+		}
+		out = toWebhookDelivery(dbgen.ListWebhookDeliveriesRow(d))
+		return record(ctx, q, AuditWebhookReplay, TargetWebhook, endpointID, nil, map[string]any{
+			"replayed_delivery_id": deliveryID, "delivery_id": d.ID, "event_id": d.EventID,
+		})
+	})
+	return out, err
+}
+
+// ---- Outbox (written alongside the change it reports) ----
+
+// messageEvent is the data of message.* events.
+type messageEvent struct {
+	MessageID         string     `json:"message_id"`
+	UserID            string     `json:"user_id"`
+	To                string     `json:"to"`
+	Status            string     `json:"status"`
+	ProviderMessageID *string    `json:"provider_message_id,omitempty"`
+	SentAt            *time.Time `json:"sent_at,omitempty"`
+}
+
+// balanceEvent is the data of balance.low events.
+type balanceEvent struct {
+	UserID    string `json:"user_id"`
+	Threshold int32  `json:"threshold"`
+	Balance   int32  `json:"balance"`
+}
+
+// enqueueWebhookEvent queues an event for the user's subscribed endpoints.
+// Must run in the transaction of the change it reports.
+func enqueueWebhookEvent(ctx context.Context, q *dbgen.Queries, userID, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return q.InsertWebhookEvent(ctx, dbgen.InsertWebhookEventParams{UserID: userID, Type: eventType, Payload: payload})
+}
+
+// ---- Dispatch (worker side) ----
+
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]dbgen.ClaimWebhookDeliveriesRow, error) {
+	return s.DB.Queries.ClaimWebhookDeliveries(ctx, dbgen.ClaimWebhookDeliveriesParams{
+		LimitN:       int32(limit),
+		LeaseSeconds: int32(lease / time.Second),
+	})
+}
+
+func toPgStatus(status int) pgtype.Int4 {
+	return pgtype.Int4{Int32: int32(status), Valid: status != 0}
+}
+
+// MarkWebhookDelivered records a 2xx response; it also ends the endpoint's
+// failure streak.
+func (s *Store) MarkWebhookDelivered(ctx context.Context, id int64, status int) error {
+	return s.DB.Queries.MarkWebhookDelivered(ctx, dbgen.MarkWebhookDeliveredParams{ResponseStatus: toPgStatus(status), ID: id})
+}
+
+// RetryWebhookDelivery schedules another attempt. status is the response
+// status, or 0 when there was no response.
+func (s *Store) RetryWebhookDelivery(ctx context.Context, id int64, retryIn time.Duration, status int, cause error) error {
+	msg := cause.Error()
+	return s.DB.Queries.RetryWebhookDelivery(ctx, dbgen.RetryWebhookDeliveryParams{
+		DelaySeconds:   int32(retryIn / time.Second),
+		ResponseStatus: toPgStatus(status),
+		LastError:      toPgText(&msg),
+		ID:             id,
+	})
+}
+
+func (s *Store) FailWebhookDelivery(ctx context.Context, id int64, status int, cause error) error {
+	msg := cause.Error()
+	return s.DB.Queries.FailWebhookDelivery(ctx, dbgen.FailWebhookDeliveryParams{
+		ResponseStatus: toPgStatus(status),
+		LastError:      toPgText(&msg),
+		ID:             id,
+	})
+}
+
+// RecordWebhookFailure counts a failed attempt against an endpoint. Once
+// disableAfter attempts in a row have failed the endpoint is disabled and its
+// pending deliveries are given up on; it reports whether that happened now.
+func (s *Store) RecordWebhookFailure(ctx context.Context, endpointID string, disableAfter int) (disabled bool, err error) {
+	err = s.DB.WithTx(ctx, func(q *dbgen.Queries) error {
+		row, e := q.RecordWebhookEndpointFailure(ctx, dbgen.RecordWebhookEndpointFailureParams{
+			DisableAfter: int32(disableAfter),
+			ID:           endpointID,
+		})
+		if e != nil {
+			return mapNoRows(e, ErrNotFound)
+		}
+		if disabled = row.Disabled; !disabled {
+			return nil
+		}
+		if e := failPendingDeliveries(ctx, q, endpointID); e != nil {
+			return e
+		}
+		return record(ctx, q, AuditWebhookDisable, TargetWebhook, endpointID, nil, map[string]any{
+			"user_id": row.UserID, "consecutive_failures": disableAfter,
+		})
+	})
+	return disabled, err
+}
@@ -0,0 +1,445 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: api_keys.sql
+
+package dbgen
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const claimRequestNonce = `-- name: ClaimRequestNonce :execrows
+INSERT INTO request_nonces (key_id, nonce, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (key_id, nonce) DO UPDATE
+SET expires_at = EXCLUDED.expires_at
+WHERE request_nonces.expires_at <= now()
+`
+
+type ClaimRequestNonceParams struct {
+	KeyID     string             `json:"key_id"`
+	Nonce     string             `json:"nonce"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+// An expired row with the same nonce is taken over; a live one is a replay.
+func (q *Queries) ClaimRequestNonce(ctx context.Context, arg ClaimRequestNonceParams) (int64, error) {
+	result, err := q.db.Exec(ctx, claimRequestNonce, arg.KeyID, arg.Nonce, arg.ExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getAPIKeyByPrefix = `-- name: GetAPIKeyByPrefix :one
+SELECT id, user_id, name, prefix, secret_hash, created_at, last_used_at, expires_at, revoked_at, replaced_by, kind, scopes, signing_secret, member_id, ip_allowlist FROM api_keys WHERE prefix = $1
+`
+
+func (q *Queries) GetAPIKeyByPrefix(ctx context.Context, prefix string) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, getAPIKeyByPrefix, prefix)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.SecretHash,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Kind,
+		&i.Scopes,
+		&i.SigningSecret,
+		&i.MemberID,
+		&i.IpAllowlist,
+	)
+	return i, err
+}
+
+const getAPIKeyForUpdate = `-- name: GetAPIKeyForUpdate :one
+SELECT id, user_id, name, prefix, secret_hash, created_at, last_used_at, expires_at, revoked_at, replaced_by, kind, scopes, signing_secret, member_id, ip_allowlist FROM api_keys WHERE id = $1 AND user_id = $2::uuid FOR UPDATE
+`
+
+type GetAPIKeyForUpdateParams struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+}
+
+func (q *Queries) GetAPIKeyForUpdate(ctx context.Context, arg GetAPIKeyForUpdateParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, getAPIKeyForUpdate, arg.ID, arg.UserID)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.SecretHash,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Kind,
+		&i.Scopes,
+		&i.SigningSecret,
+		&i.MemberID,
+		&i.IpAllowlist,
+	)
+	return i, err
+}
+
+const getIPAllowlists = `-- name: GetIPAllowlists :one
+SELECT
+  COALESCE((SELECT ip_allowlist FROM users WHERE id = $1::uuid), '{}')::cidr[] AS user_allowlist,
+  COALESCE((SELECT ip_allowlist FROM api_keys WHERE id = $2::uuid), '{}')::cidr[] AS key_allowlist
+`
+
+type GetIPAllowlistsParams struct {
+	UserID pgtype.UUID `json:"user_id"`
+	KeyID  pgtype.UUID `json:"key_id"`
+}
+
+type GetIPAllowlistsRow struct {
+	UserAllowlist []netip.Prefix `json:"user_allowlist"`
+	KeyAllowlist  []netip.Prefix `json:"key_allowlist"`
+}
+
+// Both lists a request has to pass; either id may be NULL.
+func (q *Queries) GetIPAllowlists(ctx context.Context, arg GetIPAllowlistsParams) (GetIPAllowlistsRow, error) {
+	row := q.db.QueryRow(ctx, getIPAllowlists, arg.UserID, arg.KeyID)
+	var i GetIPAllowlistsRow
+	err := row.Scan(&i.UserAllowlist, &i.KeyAllowlist)
+	return i, err
+}
+
+const insertAPIKey = `-- name: InsertAPIKey :one
+INSERT INTO api_keys (user_id, member_id, kind, scopes, name, prefix, secret_hash)
+VALUES ($1, $2, $3, $4::text[], $5, $6, $7)
+RETURNING id, user_id, name, prefix, secret_hash, created_at, last_used_at, expires_at, revoked_at, replaced_by, kind, scopes, signing_secret, member_id, ip_allowlist
+`
+
+type InsertAPIKeyParams struct {
+	UserID     pgtype.UUID `json:"user_id"`
+	MemberID   pgtype.UUID `json:"member_id"`
+	Kind       string      `json:"kind"`
+	Scopes     []string    `json:"scopes"`
+	Name       string      `json:"name"`
+	Prefix     string      `json:"prefix"`
+	SecretHash []byte      `json:"secret_hash"`
+}
+
+func (q *Queries) InsertAPIKey(ctx context.Context, arg InsertAPIKeyParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, insertAPIKey,
+		arg.UserID,
+		arg.MemberID,
+		arg.Kind,
+		arg.Scopes,
+		arg.Name,
+		arg.Prefix,
+		arg.SecretHash,
+	)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.SecretHash,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Kind,
+		&i.Scopes,
+		&i.SigningSecret,
+		&i.MemberID,
+		&i.IpAllowlist,
+	)
+	return i, err
+}
+
+const listAPIKeys = `-- name: ListAPIKeys :many
+SELECT id, user_id, name, prefix, secret_hash, created_at, last_used_at, expires_at, revoked_at, replaced_by, kind, scopes, signing_secret, member_id, ip_allowlist FROM api_keys
+WHERE user_id = $1::uuid
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListAPIKeys(ctx context.Context, userID string) ([]ApiKey, error) {
+	rows, err := q.db.Query(ctx, listAPIKeys, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiKey
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Prefix,
+			&i.SecretHash,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.ReplacedBy,
+			&i.Kind,
+			&i.Scopes,
+			&i.SigningSecret,
+			&i.MemberID,
+			&i.IpAllowlist,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAdminAPIKeys = `-- name: ListAdminAPIKeys :many
+SELECT id, user_id, name, prefix, secret_hash, created_at, last_used_at, expires_at, revoked_at, replaced_by, kind, scopes, signing_secret, member_id, ip_allowlist FROM api_keys
+WHERE kind = 'admin'
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListAdminAPIKeys(ctx context.Context) ([]ApiKey, error) {
+	rows, err := q.db.Query(ctx, listAdminAPIKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiKey
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Prefix,
+			&i.SecretHash,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.ReplacedBy,
+			&i.Kind,
+			&i.Scopes,
+			&i.SigningSecret,
+			&i.MemberID,
+			&i.IpAllowlist,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const purgeRequestNonces = `-- name: PurgeRequestNonces :execrows
+DELETE FROM request_nonces WHERE expires_at <= now()
+`
+
+func (q *Queries) PurgeRequestNonces(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeRequestNonces)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const retireAPIKey = `-- name: RetireAPIKey :exec
+UPDATE api_keys
+SET expires_at = LEAST(COALESCE(expires_at, 'infinity'), now() + $1::int * interval '1 second'),
+    replaced_by = $2::uuid
+WHERE id = $3
+`
+
+type RetireAPIKeyParams struct {
+	GraceSeconds int32  `json:"grace_seconds"`
+	ReplacedBy   string `json:"replaced_by"`
+	ID           string `json:"id"`
+}
+
+// Ends the old key's life after the grace period; never extends an earlier expiry.
+func (q *Queries) RetireAPIKey(ctx context.Context, arg RetireAPIKeyParams) error {
+	_, err := q.db.Exec(ctx, retireAPIKey, arg.GraceSeconds, arg.ReplacedBy, arg.ID)
+	return err
+}
+
+const revokeAPIKey = `-- name: RevokeAPIKey :one
+UPDATE api_keys
+SET revoked_at = now()
+WHERE id = $1 AND user_id = $2::uuid AND revoked_at IS NULL
+RETURNING id, user_id, name, prefix, secret_hash, created_at, last_used_at, expires_at, revoked_at, replaced_by, kind, scopes, signing_secret, member_id, ip_allowlist
+`
+
+type RevokeAPIKeyParams struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+}
+
+func (q *Queries) RevokeAPIKey(ctx context.Context, arg RevokeAPIKeyParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, revokeAPIKey, arg.ID, arg.UserID)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.SecretHash,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Kind,
+		&i.Scopes,
+		&i.SigningSecret,
+		&i.MemberID,
+		&i.IpAllowlist,
+	)
+	return i, err
+}
+
+const revokeAdminAPIKey = `-- name: RevokeAdminAPIKey :one
+UPDATE api_keys
+SET revoked_at = now()
+WHERE id = $1 AND kind = 'admin' AND revoked_at IS NULL
+RETURNING id, user_id, name, prefix, secret_hash, created_at, last_used_at, expires_at, revoked_at, replaced_by, kind, scopes, signing_secret, member_id, ip_allowlist
+`
+
+func (q *Queries) RevokeAdminAPIKey(ctx context.Context, id string) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, revokeAdminAPIKey, id)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.SecretHash,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Kind,
+		&i.Scopes,
+		&i.SigningSecret,
+		&i.MemberID,
+		&i.IpAllowlist,
+	)
+	return i, err
+}
+
+const revokeUserAPIKeys = `-- name: RevokeUserAPIKeys :execrows
+UPDATE api_keys
+SET revoked_at = now()
+WHERE user_id = $1::uuid AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserAPIKeys(ctx context.Context, userID string) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeUserAPIKeys, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setAPIKeyIPAllowlist = `-- name: SetAPIKeyIPAllowlist :one
+UPDATE api_keys
+SET ip_allowlist = $1::cidr[]
+WHERE id = $2 AND user_id = $3::uuid
+RETURNING id, user_id, name, prefix, secret_hash, created_at, last_used_at, expires_at, revoked_at, replaced_by, kind, scopes, signing_secret, member_id, ip_allowlist
+`
+
+type SetAPIKeyIPAllowlistParams struct {
+	IpAllowlist []netip.Prefix `json:"ip_allowlist"`
+	ID          string         `json:"id"`
+	UserID      string         `json:"user_id"`
+}
+
+func (q *Queries) SetAPIKeyIPAllowlist(ctx context.Context, arg SetAPIKeyIPAllowlistParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, setAPIKeyIPAllowlist, arg.IpAllowlist, arg.ID, arg.UserID)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.SecretHash,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Kind,
+		&i.Scopes,
+		&i.SigningSecret,
+		&i.MemberID,
+		&i.IpAllowlist,
+	)
+	return i, err
+}
+
+const setAPIKeySigningSecret = `-- name: SetAPIKeySigningSecret :one
+UPDATE api_keys
+SET signing_secret = $1
+WHERE id = $2 AND user_id = $3::uuid
+  AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+RETURNING id, user_id, name, prefix, secret_hash, created_at, last_used_at, expires_at, revoked_at, replaced_by, kind, scopes, signing_secret, member_id, ip_allowlist
+`
+
+type SetAPIKeySigningSecretParams struct {
+	SigningSecret []byte `json:"signing_secret"`
+	ID            string `json:"id"`
+	UserID        string `json:"user_id"`
+}
+
+func (q *Queries) SetAPIKeySigningSecret(ctx context.Context, arg SetAPIKeySigningSecretParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, setAPIKeySigningSecret, arg.SigningSecret, arg.ID, arg.UserID)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.SecretHash,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Kind,
+		&i.Scopes,
+		&i.SigningSecret,
+		&i.MemberID,
+		&i.IpAllowlist,
+	)
+	return i, err
+}
+
+const touchAPIKey = `-- name: TouchAPIKey :exec
+UPDATE api_keys
+SET last_used_at = now()
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')
+`
+
+// Throttled to one write per key per minute.
+func (q *Queries) TouchAPIKey(ctx context.Context, id string) error {
+	_, err := q.db.Exec(ctx, touchAPIKey, id)
+	return err
+}
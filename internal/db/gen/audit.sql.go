@@ -0,0 +1,117 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: audit.sql
+
+package dbgen
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const insertAuditEvent = `-- name: InsertAuditEvent :exec
+INSERT INTO audit_events (actor_type, actor_key_id, actor_user_id, action, target_type, target_id, request_id, source_ip, before, after)
+VALUES ($1, $2, $3, $4, $5,
+        $6, $7, $8, $9, $10)
+`
+
+type InsertAuditEventParams struct {
+	ActorType   string      `json:"actor_type"`
+	ActorKeyID  pgtype.UUID `json:"actor_key_id"`
+	ActorUserID pgtype.UUID `json:"actor_user_id"`
+	Action      string      `json:"action"`
+	TargetType  string      `json:"target_type"`
+	TargetID    string      `json:"target_id"`
+	RequestID   pgtype.Text `json:"request_id"`
+	SourceIp    pgtype.Text `json:"source_ip"`
+	Before      []byte      `json:"before"`
+	After       []byte      `json:"after"`
+}
+
+func (q *Queries) InsertAuditEvent(ctx context.Context, arg InsertAuditEventParams) error {
+	_, err := q.db.Exec(ctx, insertAuditEvent,
+		arg.ActorType,
+		arg.ActorKeyID,
+		arg.ActorUserID,
+		arg.Action,
+		arg.TargetType,
+		arg.TargetID,
+		arg.RequestID,
+		arg.SourceIp,
+		arg.Before,
+		arg.After,
+	)
+	return err
+}
+
+const listAuditEvents = `-- name: ListAuditEvents :many
+SELECT id, occurred_at, actor_type, actor_key_id, actor_user_id, action, target_type, target_id, request_id, source_ip, before, after FROM audit_events
+WHERE ($1::uuid IS NULL OR actor_user_id = $1)
+  AND ($2::uuid  IS NULL OR actor_key_id  = $2)
+  AND ($3::text        IS NULL OR action        = $3)
+  AND ($4::text   IS NULL OR target_type   = $4)
+  AND ($5::text     IS NULL OR target_id     = $5)
+  AND ($6::timestamptz IS NULL OR occurred_at >= $6)
+  AND ($7::timestamptz   IS NULL OR occurred_at <  $7)
+  AND ($8::bigint    IS NULL OR id < $8)
+ORDER BY id DESC
+LIMIT $9
+`
+
+type ListAuditEventsParams struct {
+	ActorUserID pgtype.UUID        `json:"actor_user_id"`
+	ActorKeyID  pgtype.UUID        `json:"actor_key_id"`
+	Action      pgtype.Text        `json:"action"`
+	TargetType  pgtype.Text        `json:"target_type"`
+	TargetID    pgtype.Text        `json:"target_id"`
+	FromTs      pgtype.Timestamptz `json:"from_ts"`
+	ToTs        pgtype.Timestamptz `json:"to_ts"`
+	BeforeID    pgtype.Int8        `json:"before_id"`
+	LimitN      int32              `json:"limit_n"`
+}
+
+// Newest first; before_id pages backwards.
+func (q *Queries) ListAuditEvents(ctx context.Context, arg ListAuditEventsParams) ([]AuditEvent, error) {
+	rows, err := q.db.Query(ctx, listAuditEvents,
+		arg.ActorUserID,
+		arg.ActorKeyID,
+		arg.Action,
+		arg.TargetType,
+		arg.TargetID,
+		arg.FromTs,
+		arg.ToTs,
+		arg.BeforeID,
+		arg.LimitN,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditEvent
+	for rows.Next() {
+		var i AuditEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.OccurredAt,
+			&i.ActorType,
+			&i.ActorKeyID,
+			&i.ActorUserID,
+			&i.Action,
+			&i.TargetType,
+			&i.TargetID,
+			&i.RequestID,
+			&i.SourceIp,
+			&i.Before,
+			&i.After,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,329 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: balance_alerts.sql
+
+package dbgen
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const claimBalanceNotifications = `-- name: ClaimBalanceNotifications :many
+WITH due AS (
+  SELECT id
+  FROM balance_notifications
+  WHERE delivered_at IS NULL
+    AND failed_at IS NULL
+    AND next_attempt_at <= now()
+  ORDER BY next_attempt_at
+  LIMIT $2
+  FOR UPDATE SKIP LOCKED
+)
+UPDATE balance_notifications n
+SET attempts = n.attempts + 1,
+    next_attempt_at = now() + $1::int * interval '1 second'
+FROM due
+WHERE n.id = due.id
+RETURNING n.id, n.user_id, n.threshold, n.balance, n.channel, n.target, n.attempts, n.created_at
+`
+
+type ClaimBalanceNotificationsParams struct {
+	LeaseSeconds int32 `json:"lease_seconds"`
+	LimitN       int32 `json:"limit_n"`
+}
+
+type ClaimBalanceNotificationsRow struct {
+	ID        int64              `json:"id"`
+	UserID    string             `json:"user_id"`
+	Threshold int32              `json:"threshold"`
+	Balance   int32              `json:"balance"`
+	Channel   string             `json:"channel"`
+	Target    string             `json:"target"`
+	Attempts  int32              `json:"attempts"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+// Pushes next_attempt_at forward as a lease so concurrent workers skip the rows
+// while they are being delivered.
+func (q *Queries) ClaimBalanceNotifications(ctx context.Context, arg ClaimBalanceNotificationsParams) ([]ClaimBalanceNotificationsRow, error) {
+	rows, err := q.db.Query(ctx, claimBalanceNotifications, arg.LeaseSeconds, arg.LimitN)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimBalanceNotificationsRow
+	for rows.Next() {
+		var i ClaimBalanceNotificationsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Threshold,
+			&i.Balance,
+			&i.Channel,
+			&i.Target,
+			&i.Attempts,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const createBalanceThreshold = `-- name: CreateBalanceThreshold :one
+INSERT INTO balance_thresholds (user_id, threshold, webhook_url, notify_msisdn, armed)
+SELECT u.id, $1, $2, $3, sb.balance >= $1
+FROM users u
+CROSS JOIN LATERAL (
+  SELECT u.balance + COALESCE(SUM(b.remaining), 0)::int AS balance
+  FROM credit_buckets b
+  WHERE b.user_id = u.id AND b.expires_at > now()
+) sb
+WHERE u.id = $4
+RETURNING id, user_id, threshold, webhook_url, notify_msisdn, armed, last_fired_at, created_at, updated_at
+`
+
+type CreateBalanceThresholdParams struct {
+	Threshold    int32       `json:"threshold"`
+	WebhookUrl   pgtype.Text `json:"webhook_url"`
+	NotifyMsisdn pgtype.Text `json:"notify_msisdn"`
+	UserID       string      `json:"user_id"`
+}
+
+// A threshold created while the balance is already below it starts disarmed,
+// so it only fires after the next top-up and subsequent drop.
+func (q *Queries) CreateBalanceThreshold(ctx context.Context, arg CreateBalanceThresholdParams) (BalanceThreshold, error) {
+	row := q.db.QueryRow(ctx, createBalanceThreshold,
+		arg.Threshold,
+		arg.WebhookUrl,
+		arg.NotifyMsisdn,
+		arg.UserID,
+	)
+	var i BalanceThreshold
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Threshold,
+		&i.WebhookUrl,
+		&i.NotifyMsisdn,
+		&i.Armed,
+		&i.LastFiredAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const deleteBalanceThreshold = `-- name: DeleteBalanceThreshold :one
+DELETE FROM balance_thresholds
+WHERE id = $1 AND user_id = $2
+RETURNING id, user_id, threshold, webhook_url, notify_msisdn, armed, last_fired_at, created_at, updated_at
+`
+
+type DeleteBalanceThresholdParams struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+}
+
+func (q *Queries) DeleteBalanceThreshold(ctx context.Context, arg DeleteBalanceThresholdParams) (BalanceThreshold, error) {
+	row := q.db.QueryRow(ctx, deleteBalanceThreshold, arg.ID, arg.UserID)
+	var i BalanceThreshold
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Threshold,
+		&i.WebhookUrl,
+		&i.NotifyMsisdn,
+		&i.Armed,
+		&i.LastFiredAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const failBalanceNotification = `-- name: FailBalanceNotification :exec
+UPDATE balance_notifications
+SET failed_at = now(), last_error = $1
+WHERE id = $2
+`
+
+type FailBalanceNotificationParams struct {
+	LastError pgtype.Text `json:"last_error"`
+	ID        int64       `json:"id"`
+}
+
+func (q *Queries) FailBalanceNotification(ctx context.Context, arg FailBalanceNotificationParams) error {
+	_, err := q.db.Exec(ctx, failBalanceNotification, arg.LastError, arg.ID)
+	return err
+}
+
+const insertBalanceNotification = `-- name: InsertBalanceNotification :exec
+INSERT INTO balance_notifications (user_id, threshold_id, threshold, balance, channel, target)
+VALUES ($1, $2, $3, $4, $5, $6)
+`
+
+type InsertBalanceNotificationParams struct {
+	UserID      string      `json:"user_id"`
+	ThresholdID pgtype.UUID `json:"threshold_id"`
+	Threshold   int32       `json:"threshold"`
+	Balance     int32       `json:"balance"`
+	Channel     string      `json:"channel"`
+	Target      string      `json:"target"`
+}
+
+func (q *Queries) InsertBalanceNotification(ctx context.Context, arg InsertBalanceNotificationParams) error {
+	_, err := q.db.Exec(ctx, insertBalanceNotification,
+		arg.UserID,
+		arg.ThresholdID,
+		arg.Threshold,
+		arg.Balance,
+		arg.Channel,
+		arg.Target,
+	)
+	return err
+}
+
+const listBalanceThresholds = `-- name: ListBalanceThresholds :many
+SELECT id, user_id, threshold, webhook_url, notify_msisdn, armed, last_fired_at, created_at, updated_at
+FROM balance_thresholds
+WHERE user_id = $1
+ORDER BY threshold DESC
+`
+
+func (q *Queries) ListBalanceThresholds(ctx context.Context, userID string) ([]BalanceThreshold, error) {
+	rows, err := q.db.Query(ctx, listBalanceThresholds, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []BalanceThreshold
+	for rows.Next() {
+		var i BalanceThreshold
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Threshold,
+			&i.WebhookUrl,
+			&i.NotifyMsisdn,
+			&i.Armed,
+			&i.LastFiredAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markBalanceNotificationDelivered = `-- name: MarkBalanceNotificationDelivered :exec
+UPDATE balance_notifications
+SET delivered_at = now(), last_error = NULL
+WHERE id = $1
+`
+
+func (q *Queries) MarkBalanceNotificationDelivered(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, markBalanceNotificationDelivered, id)
+	return err
+}
+
+const rearmBalanceThresholds = `-- name: RearmBalanceThresholds :exec
+UPDATE balance_thresholds t
+SET armed = true
+FROM users u
+CROSS JOIN LATERAL (
+  SELECT u.balance + COALESCE(SUM(b.remaining), 0)::int AS balance
+  FROM credit_buckets b
+  WHERE b.user_id = u.id AND b.expires_at > now()
+) sb
+WHERE t.user_id = u.id
+  AND u.id = $1
+  AND NOT t.armed
+  AND sb.balance >= t.threshold
+`
+
+func (q *Queries) RearmBalanceThresholds(ctx context.Context, userID string) error {
+	_, err := q.db.Exec(ctx, rearmBalanceThresholds, userID)
+	return err
+}
+
+const retryBalanceNotification = `-- name: RetryBalanceNotification :exec
+UPDATE balance_notifications
+SET next_attempt_at = now() + $1::int * interval '1 second',
+    last_error = $2
+WHERE id = $3
+`
+
+type RetryBalanceNotificationParams struct {
+	DelaySeconds int32       `json:"delay_seconds"`
+	LastError    pgtype.Text `json:"last_error"`
+	ID           int64       `json:"id"`
+}
+
+func (q *Queries) RetryBalanceNotification(ctx context.Context, arg RetryBalanceNotificationParams) error {
+	_, err := q.db.Exec(ctx, retryBalanceNotification, arg.DelaySeconds, arg.LastError, arg.ID)
+	return err
+}
+
+const tripBalanceThresholds = `-- name: TripBalanceThresholds :many
+UPDATE balance_thresholds t
+SET armed = false, last_fired_at = now()
+FROM users u
+CROSS JOIN LATERAL (
+  SELECT u.balance + COALESCE(SUM(b.remaining), 0)::int AS balance
+  FROM credit_buckets b
+  WHERE b.user_id = u.id AND b.expires_at > now()
+) sb
+WHERE t.user_id = u.id
+  AND u.id = $1
+  AND t.armed
+  AND sb.balance < t.threshold
+RETURNING t.id, t.threshold, t.webhook_url, t.notify_msisdn, sb.balance
+`
+
+type TripBalanceThresholdsRow struct {
+	ID           string      `json:"id"`
+	Threshold    int32       `json:"threshold"`
+	WebhookUrl   pgtype.Text `json:"webhook_url"`
+	NotifyMsisdn pgtype.Text `json:"notify_msisdn"`
+	Balance      int32       `json:"balance"`
+}
+
+// Thresholds compare against the spendable balance (paid + unexpired promotional).
+func (q *Queries) TripBalanceThresholds(ctx context.Context, userID string) ([]TripBalanceThresholdsRow, error) {
+	rows, err := q.db.Query(ctx, tripBalanceThresholds, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TripBalanceThresholdsRow
+	for rows.Next() {
+		var i TripBalanceThresholdsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Threshold,
+			&i.WebhookUrl,
+			&i.NotifyMsisdn,
+			&i.Balance,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,168 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: client_certificates.sql
+
+package dbgen
+
+import (
+	"context"
+)
+
+const findClientCertificates = `-- name: FindClientCertificates :many
+SELECT id, user_id, identity, scopes, name, created_at, last_used_at, revoked_at FROM client_certificates
+WHERE identity = ANY($1::text[]) AND revoked_at IS NULL
+`
+
+// Active bindings for any of the identities a certificate presents.
+func (q *Queries) FindClientCertificates(ctx context.Context, identities []string) ([]ClientCertificate, error) {
+	rows, err := q.db.Query(ctx, findClientCertificates, identities)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClientCertificate
+	for rows.Next() {
+		var i ClientCertificate
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Identity,
+			&i.Scopes,
+			&i.Name,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const insertClientCertificate = `-- name: InsertClientCertificate :one
+INSERT INTO client_certificates (user_id, identity, scopes, name)
+VALUES ($1, $2, $3::text[], $4)
+RETURNING id, user_id, identity, scopes, name, created_at, last_used_at, revoked_at
+`
+
+type InsertClientCertificateParams struct {
+	UserID   string   `json:"user_id"`
+	Identity string   `json:"identity"`
+	Scopes   []string `json:"scopes"`
+	Name     string   `json:"name"`
+}
+
+func (q *Queries) InsertClientCertificate(ctx context.Context, arg InsertClientCertificateParams) (ClientCertificate, error) {
+	row := q.db.QueryRow(ctx, insertClientCertificate,
+		arg.UserID,
+		arg.Identity,
+		arg.Scopes,
+		arg.Name,
+	)
+	var i ClientCertificate
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Identity,
+		&i.Scopes,
+		&i.Name,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const listClientCertificates = `-- name: ListClientCertificates :many
+SELECT id, user_id, identity, scopes, name, created_at, last_used_at, revoked_at FROM client_certificates
+WHERE user_id = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListClientCertificates(ctx context.Context, userID string) ([]ClientCertificate, error) {
+	rows, err := q.db.Query(ctx, listClientCertificates, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClientCertificate
+	for rows.Next() {
+		var i ClientCertificate
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Identity,
+			&i.Scopes,
+			&i.Name,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeClientCertificate = `-- name: RevokeClientCertificate :one
+UPDATE client_certificates
+SET revoked_at = now()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+RETURNING id, user_id, identity, scopes, name, created_at, last_used_at, revoked_at
+`
+
+type RevokeClientCertificateParams struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+}
+
+func (q *Queries) RevokeClientCertificate(ctx context.Context, arg RevokeClientCertificateParams) (ClientCertificate, error) {
+	row := q.db.QueryRow(ctx, revokeClientCertificate, arg.ID, arg.UserID)
+	var i ClientCertificate
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Identity,
+		&i.Scopes,
+		&i.Name,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const revokeUserClientCertificates = `-- name: RevokeUserClientCertificates :execrows
+UPDATE client_certificates
+SET revoked_at = now()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserClientCertificates(ctx context.Context, userID string) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeUserClientCertificates, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const touchClientCertificate = `-- name: TouchClientCertificate :exec
+UPDATE client_certificates
+SET last_used_at = now()
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')
+`
+
+// Throttled to one write per binding per minute.
+func (q *Queries) TouchClientCertificate(ctx context.Context, id string) error {
+	_, err := q.db.Exec(ctx, touchClientCertificate, id)
+	return err
+}
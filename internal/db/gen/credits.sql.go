@@ -0,0 +1,195 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: credits.sql
+
+package dbgen
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const debitCreditBucket = `-- name: DebitCreditBucket :one
+UPDATE credit_buckets b
+SET remaining = b.remaining - $1::int
+WHERE b.id = (
+  SELECT c.id
+  FROM credit_buckets c
+  WHERE c.user_id = $2
+    AND c.remaining >= $1::int
+    AND c.expires_at > now()
+  ORDER BY c.expires_at, c.created_at
+  LIMIT 1
+  FOR UPDATE
+)
+RETURNING b.id
+`
+
+type DebitCreditBucketParams struct {
+	Amount int32  `json:"amount"`
+	UserID string `json:"user_id"`
+}
+
+// Spends amount from the earliest-expiring usable bucket; no rows when there is none.
+func (q *Queries) DebitCreditBucket(ctx context.Context, arg DebitCreditBucketParams) (string, error) {
+	row := q.db.QueryRow(ctx, debitCreditBucket, arg.Amount, arg.UserID)
+	var id string
+	err := row.Scan(&id)
+	return id, err
+}
+
+const expireCreditBucket = `-- name: ExpireCreditBucket :one
+WITH due AS (
+  SELECT b.id, b.remaining
+  FROM credit_buckets b
+  WHERE b.id = $1 AND b.remaining > 0 AND b.expires_at <= now()
+  FOR UPDATE
+)
+UPDATE credit_buckets c
+SET remaining = 0, expired_at = now()
+FROM due
+WHERE c.id = due.id
+RETURNING c.user_id, due.remaining::int AS expired_amount
+`
+
+type ExpireCreditBucketRow struct {
+	UserID        string `json:"user_id"`
+	ExpiredAmount int32  `json:"expired_amount"`
+}
+
+// Zeroes one expired bucket; no rows if another worker already swept it.
+func (q *Queries) ExpireCreditBucket(ctx context.Context, id string) (ExpireCreditBucketRow, error) {
+	row := q.db.QueryRow(ctx, expireCreditBucket, id)
+	var i ExpireCreditBucketRow
+	err := row.Scan(&i.UserID, &i.ExpiredAmount)
+	return i, err
+}
+
+const getBalanceBreakdown = `-- name: GetBalanceBreakdown :one
+SELECT u.balance AS paid,
+       COALESCE(SUM(b.remaining), 0)::int AS promotional,
+       MIN(b.expires_at)::timestamptz      AS next_expiry
+FROM users u
+LEFT JOIN credit_buckets b
+  ON b.user_id = u.id AND b.remaining > 0 AND b.expires_at > now()
+WHERE u.id = $1
+GROUP BY u.id
+`
+
+type GetBalanceBreakdownRow struct {
+	Paid        int32              `json:"paid"`
+	Promotional int32              `json:"promotional"`
+	NextExpiry  pgtype.Timestamptz `json:"next_expiry"`
+}
+
+func (q *Queries) GetBalanceBreakdown(ctx context.Context, id string) (GetBalanceBreakdownRow, error) {
+	row := q.db.QueryRow(ctx, getBalanceBreakdown, id)
+	var i GetBalanceBreakdownRow
+	err := row.Scan(&i.Paid, &i.Promotional, &i.NextExpiry)
+	return i, err
+}
+
+const insertCreditBucket = `-- name: InsertCreditBucket :one
+INSERT INTO credit_buckets (user_id, amount, remaining, expires_at, note)
+VALUES ($1, $2, $2, $3, $4)
+RETURNING id, user_id, amount, remaining, expires_at, expired_at, note, created_at
+`
+
+type InsertCreditBucketParams struct {
+	UserID    string             `json:"user_id"`
+	Amount    int32              `json:"amount"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+	Note      pgtype.Text        `json:"note"`
+}
+
+func (q *Queries) InsertCreditBucket(ctx context.Context, arg InsertCreditBucketParams) (CreditBucket, error) {
+	row := q.db.QueryRow(ctx, insertCreditBucket,
+		arg.UserID,
+		arg.Amount,
+		arg.ExpiresAt,
+		arg.Note,
+	)
+	var i CreditBucket
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Amount,
+		&i.Remaining,
+		&i.ExpiresAt,
+		&i.ExpiredAt,
+		&i.Note,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const listActiveCreditBuckets = `-- name: ListActiveCreditBuckets :many
+SELECT id, user_id, amount, remaining, expires_at, expired_at, note, created_at
+FROM credit_buckets
+WHERE user_id = $1 AND remaining > 0 AND expires_at > now()
+ORDER BY expires_at, created_at
+`
+
+func (q *Queries) ListActiveCreditBuckets(ctx context.Context, userID string) ([]CreditBucket, error) {
+	rows, err := q.db.Query(ctx, listActiveCreditBuckets, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CreditBucket
+	for rows.Next() {
+		var i CreditBucket
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Amount,
+			&i.Remaining,
+			&i.ExpiresAt,
+			&i.ExpiredAt,
+			&i.Note,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExpiredCreditBuckets = `-- name: ListExpiredCreditBuckets :many
+SELECT id, user_id
+FROM credit_buckets
+WHERE expires_at <= now() AND remaining > 0
+ORDER BY expires_at
+LIMIT $1
+`
+
+type ListExpiredCreditBucketsRow struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+}
+
+func (q *Queries) ListExpiredCreditBuckets(ctx context.Context, limit int32) ([]ListExpiredCreditBucketsRow, error) {
+	rows, err := q.db.Query(ctx, listExpiredCreditBuckets, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListExpiredCreditBucketsRow
+	for rows.Next() {
+		var i ListExpiredCreditBucketsRow
+		if err := rows.Scan(&i.ID, &i.UserID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
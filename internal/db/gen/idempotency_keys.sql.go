@@ -0,0 +1,108 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: idempotency_keys.sql
+
+package dbgen
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const claimIdempotencyKey = `-- name: ClaimIdempotencyKey :one
+INSERT INTO idempotency_keys AS k (user_id, key, fingerprint, expires_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id, key) DO UPDATE
+  SET fingerprint = EXCLUDED.fingerprint, message_id = NULL,
+      response_status = NULL, response_body = NULL,
+      created_at = now(), expires_at = EXCLUDED.expires_at
+  WHERE k.expires_at <= now()
+RETURNING k.key
+`
+
+type ClaimIdempotencyKeyParams struct {
+	UserID      string             `json:"user_id"`
+	Key         string             `json:"key"`
+	Fingerprint []byte             `json:"fingerprint"`
+	ExpiresAt   pgtype.Timestamptz `json:"expires_at"`
+}
+
+// Claims a key that is unused or expired. No row means a live key exists;
+// a concurrent claim waits for the other transaction to finish first.
+func (q *Queries) ClaimIdempotencyKey(ctx context.Context, arg ClaimIdempotencyKeyParams) (string, error) {
+	row := q.db.QueryRow(ctx, claimIdempotencyKey,
+		arg.UserID,
+		arg.Key,
+		arg.Fingerprint,
+		arg.ExpiresAt,
+	)
+	var key string
+	err := row.Scan(&key)
+	return key, err
+}
+
+const completeIdempotencyKey = `-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET message_id = $1, response_status = $2, response_body = $3
+WHERE user_id = $4 AND key = $5
+`
+
+type CompleteIdempotencyKeyParams struct {
+	MessageID      pgtype.UUID `json:"message_id"`
+	ResponseStatus pgtype.Int4 `json:"response_status"`
+	ResponseBody   []byte      `json:"response_body"`
+	UserID         string      `json:"user_id"`
+	Key            string      `json:"key"`
+}
+
+func (q *Queries) CompleteIdempotencyKey(ctx context.Context, arg CompleteIdempotencyKeyParams) error {
+	_, err := q.db.Exec(ctx, completeIdempotencyKey,
+		arg.MessageID,
+		arg.ResponseStatus,
+		arg.ResponseBody,
+		arg.UserID,
+		arg.Key,
+	)
+	return err
+}
+
+const getIdempotencyKey = `-- name: GetIdempotencyKey :one
+SELECT user_id, key, fingerprint, message_id, response_status, response_body, created_at, expires_at
+FROM idempotency_keys
+WHERE user_id = $1 AND key = $2 AND expires_at > now()
+`
+
+type GetIdempotencyKeyParams struct {
+	UserID string `json:"user_id"`
+	Key    string `json:"key"`
+}
+
+func (q *Queries) GetIdempotencyKey(ctx context.Context, arg GetIdempotencyKeyParams) (IdempotencyKey, error) {
+	row := q.db.QueryRow(ctx, getIdempotencyKey, arg.UserID, arg.Key)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.UserID,
+		&i.Key,
+		&i.Fingerprint,
+		&i.MessageID,
+		&i.ResponseStatus,
+		&i.ResponseBody,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const purgeIdempotencyKeys = `-- name: PurgeIdempotencyKeys :execrows
+DELETE FROM idempotency_keys WHERE expires_at <= now()
+`
+
+func (q *Queries) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeIdempotencyKeys)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,218 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: ledger.sql
+
+package dbgen
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const getLedgerEntryByIdemKey = `-- name: GetLedgerEntryByIdemKey :one
+SELECT id, kind, amount, balance_after, external_ref, created_at
+FROM ledger_entries
+WHERE user_id = $1 AND idempotency_key = $2
+`
+
+type GetLedgerEntryByIdemKeyParams struct {
+	UserID         string      `json:"user_id"`
+	IdempotencyKey pgtype.Text `json:"idempotency_key"`
+}
+
+type GetLedgerEntryByIdemKeyRow struct {
+	ID           int64              `json:"id"`
+	Kind         string             `json:"kind"`
+	Amount       int32              `json:"amount"`
+	BalanceAfter int32              `json:"balance_after"`
+	ExternalRef  pgtype.Text        `json:"external_ref"`
+	CreatedAt    pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) GetLedgerEntryByIdemKey(ctx context.Context, arg GetLedgerEntryByIdemKeyParams) (GetLedgerEntryByIdemKeyRow, error) {
+	row := q.db.QueryRow(ctx, getLedgerEntryByIdemKey, arg.UserID, arg.IdempotencyKey)
+	var i GetLedgerEntryByIdemKeyRow
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Amount,
+		&i.BalanceAfter,
+		&i.ExternalRef,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const insertLedgerEntry = `-- name: InsertLedgerEntry :one
+INSERT INTO ledger_entries (user_id, kind, amount, balance_after, idempotency_key, external_ref, note,
+                            counterparty_id, credit_bucket_id, message_id)
+SELECT u.id, $1, $2,
+       u.balance + COALESCE((SELECT SUM(b.remaining) FROM credit_buckets b WHERE b.user_id = u.id), 0)::int,
+       $3, $4, $5,
+       $6, $7, $8
+FROM users u
+WHERE u.id = $9
+RETURNING id, balance_after, created_at
+`
+
+type InsertLedgerEntryParams struct {
+	Kind           string      `json:"kind"`
+	Amount         int32       `json:"amount"`
+	IdempotencyKey pgtype.Text `json:"idempotency_key"`
+	ExternalRef    pgtype.Text `json:"external_ref"`
+	Note           pgtype.Text `json:"note"`
+	CounterpartyID pgtype.UUID `json:"counterparty_id"`
+	CreditBucketID pgtype.UUID `json:"credit_bucket_id"`
+	MessageID      pgtype.UUID `json:"message_id"`
+	UserID         string      `json:"user_id"`
+}
+
+type InsertLedgerEntryRow struct {
+	ID           int64              `json:"id"`
+	BalanceAfter int32              `json:"balance_after"`
+	CreatedAt    pgtype.Timestamptz `json:"created_at"`
+}
+
+// balance_after is paid balance plus unswept promotional credit, read from the
+// (already updated) rows in the same tx.
+func (q *Queries) InsertLedgerEntry(ctx context.Context, arg InsertLedgerEntryParams) (InsertLedgerEntryRow, error) {
+	row := q.db.QueryRow(ctx, insertLedgerEntry,
+		arg.Kind,
+		arg.Amount,
+		arg.IdempotencyKey,
+		arg.ExternalRef,
+		arg.Note,
+		arg.CounterpartyID,
+		arg.CreditBucketID,
+		arg.MessageID,
+		arg.UserID,
+	)
+	var i InsertLedgerEntryRow
+	err := row.Scan(&i.ID, &i.BalanceAfter, &i.CreatedAt)
+	return i, err
+}
+
+const ledgerBalanceAt = `-- name: LedgerBalanceAt :one
+SELECT COALESCE((
+  SELECT l.balance_after
+  FROM ledger_entries l
+  WHERE l.user_id = $1 AND l.created_at < $2
+  ORDER BY l.id DESC
+  LIMIT 1
+), 0)::int AS balance
+`
+
+type LedgerBalanceAtParams struct {
+	UserID string             `json:"user_id"`
+	Ts     pgtype.Timestamptz `json:"ts"`
+}
+
+// Balance as of ts: balance_after of the last entry before it, 0 if none.
+func (q *Queries) LedgerBalanceAt(ctx context.Context, arg LedgerBalanceAtParams) (int32, error) {
+	row := q.db.QueryRow(ctx, ledgerBalanceAt, arg.UserID, arg.Ts)
+	var balance int32
+	err := row.Scan(&balance)
+	return balance, err
+}
+
+const ledgerChargesByDestination = `-- name: LedgerChargesByDestination :many
+SELECT left(ltrim(m.to_msisdn, '+'), 3)::text AS dial_prefix,
+       m.status,
+       COUNT(*) FILTER (WHERE l.kind = 'charge')::int AS messages,
+       COALESCE(-SUM(l.amount) FILTER (WHERE l.kind = 'charge'), 0)::int AS charged,
+       COALESCE(SUM(l.amount) FILTER (WHERE l.kind = 'refund'), 0)::int AS refunded
+FROM ledger_entries l
+JOIN messages m ON m.id = l.message_id
+WHERE l.user_id = $1
+  AND l.kind IN ('charge', 'refund')
+  AND l.created_at >= $2
+  AND l.created_at <  $3
+GROUP BY 1, 2
+ORDER BY 1, 2
+`
+
+type LedgerChargesByDestinationParams struct {
+	UserID string             `json:"user_id"`
+	FromTs pgtype.Timestamptz `json:"from_ts"`
+	ToTs   pgtype.Timestamptz `json:"to_ts"`
+}
+
+type LedgerChargesByDestinationRow struct {
+	DialPrefix string    `json:"dial_prefix"`
+	Status     MsgStatus `json:"status"`
+	Messages   int32     `json:"messages"`
+	Charged    int32     `json:"charged"`
+	Refunded   int32     `json:"refunded"`
+}
+
+// Charges net of refunds, by destination calling-code prefix (first three digits)
+// and the message's current status.
+func (q *Queries) LedgerChargesByDestination(ctx context.Context, arg LedgerChargesByDestinationParams) ([]LedgerChargesByDestinationRow, error) {
+	rows, err := q.db.Query(ctx, ledgerChargesByDestination, arg.UserID, arg.FromTs, arg.ToTs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LedgerChargesByDestinationRow
+	for rows.Next() {
+		var i LedgerChargesByDestinationRow
+		if err := rows.Scan(
+			&i.DialPrefix,
+			&i.Status,
+			&i.Messages,
+			&i.Charged,
+			&i.Refunded,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ledgerTotalsByKind = `-- name: LedgerTotalsByKind :many
+SELECT kind, COUNT(*)::int AS entries, SUM(amount)::int AS amount
+FROM ledger_entries
+WHERE user_id = $1
+  AND created_at >= $2
+  AND created_at <  $3
+GROUP BY kind
+ORDER BY kind
+`
+
+type LedgerTotalsByKindParams struct {
+	UserID string             `json:"user_id"`
+	FromTs pgtype.Timestamptz `json:"from_ts"`
+	ToTs   pgtype.Timestamptz `json:"to_ts"`
+}
+
+type LedgerTotalsByKindRow struct {
+	Kind    string `json:"kind"`
+	Entries int32  `json:"entries"`
+	Amount  int32  `json:"amount"`
+}
+
+func (q *Queries) LedgerTotalsByKind(ctx context.Context, arg LedgerTotalsByKindParams) ([]LedgerTotalsByKindRow, error) {
+	rows, err := q.db.Query(ctx, ledgerTotalsByKind, arg.UserID, arg.FromTs, arg.ToTs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LedgerTotalsByKindRow
+	for rows.Next() {
+		var i LedgerTotalsByKindRow
+		if err := rows.Scan(&i.Kind, &i.Entries, &i.Amount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,381 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: members.sql
+
+package dbgen
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const countOwners = `-- name: CountOwners :one
+SELECT count(*)::int AS owners FROM memberships
+WHERE account_id = $1 AND role = 'owner'
+`
+
+func (q *Queries) CountOwners(ctx context.Context, accountID string) (int32, error) {
+	row := q.db.QueryRow(ctx, countOwners, accountID)
+	var owners int32
+	err := row.Scan(&owners)
+	return owners, err
+}
+
+const createMember = `-- name: CreateMember :one
+INSERT INTO members (name, email)
+VALUES ($1, $2)
+RETURNING id, name, email, created_at
+`
+
+type CreateMemberParams struct {
+	Name  string      `json:"name"`
+	Email pgtype.Text `json:"email"`
+}
+
+func (q *Queries) CreateMember(ctx context.Context, arg CreateMemberParams) (Member, error) {
+	row := q.db.QueryRow(ctx, createMember, arg.Name, arg.Email)
+	var i Member
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteMembership = `-- name: DeleteMembership :exec
+DELETE FROM memberships
+WHERE account_id = $1 AND member_id = $2
+`
+
+type DeleteMembershipParams struct {
+	AccountID string `json:"account_id"`
+	MemberID  string `json:"member_id"`
+}
+
+func (q *Queries) DeleteMembership(ctx context.Context, arg DeleteMembershipParams) error {
+	_, err := q.db.Exec(ctx, deleteMembership, arg.AccountID, arg.MemberID)
+	return err
+}
+
+const getInvitationByTokenForUpdate = `-- name: GetInvitationByTokenForUpdate :one
+SELECT id, account_id, email, role, token_hash, invited_by, created_at, expires_at, accepted_at, revoked_at FROM invitations WHERE token_hash = $1 FOR UPDATE
+`
+
+func (q *Queries) GetInvitationByTokenForUpdate(ctx context.Context, tokenHash []byte) (Invitation, error) {
+	row := q.db.QueryRow(ctx, getInvitationByTokenForUpdate, tokenHash)
+	var i Invitation
+	err := row.Scan(
+		&i.ID,
+		&i.AccountID,
+		&i.Email,
+		&i.Role,
+		&i.TokenHash,
+		&i.InvitedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getMemberByEmail = `-- name: GetMemberByEmail :one
+SELECT id, name, email, created_at FROM members WHERE email = $1
+`
+
+func (q *Queries) GetMemberByEmail(ctx context.Context, email pgtype.Text) (Member, error) {
+	row := q.db.QueryRow(ctx, getMemberByEmail, email)
+	var i Member
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getMembershipForUpdate = `-- name: GetMembershipForUpdate :one
+SELECT account_id, member_id, role, created_at FROM memberships
+WHERE account_id = $1 AND member_id = $2
+FOR UPDATE
+`
+
+type GetMembershipForUpdateParams struct {
+	AccountID string `json:"account_id"`
+	MemberID  string `json:"member_id"`
+}
+
+func (q *Queries) GetMembershipForUpdate(ctx context.Context, arg GetMembershipForUpdateParams) (Membership, error) {
+	row := q.db.QueryRow(ctx, getMembershipForUpdate, arg.AccountID, arg.MemberID)
+	var i Membership
+	err := row.Scan(
+		&i.AccountID,
+		&i.MemberID,
+		&i.Role,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getMembershipRole = `-- name: GetMembershipRole :one
+SELECT role FROM memberships
+WHERE account_id = $1 AND member_id = $2
+`
+
+type GetMembershipRoleParams struct {
+	AccountID string `json:"account_id"`
+	MemberID  string `json:"member_id"`
+}
+
+func (q *Queries) GetMembershipRole(ctx context.Context, arg GetMembershipRoleParams) (string, error) {
+	row := q.db.QueryRow(ctx, getMembershipRole, arg.AccountID, arg.MemberID)
+	var role string
+	err := row.Scan(&role)
+	return role, err
+}
+
+const insertInvitation = `-- name: InsertInvitation :one
+
+INSERT INTO invitations (account_id, email, role, token_hash, invited_by, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, account_id, email, role, token_hash, invited_by, created_at, expires_at, accepted_at, revoked_at
+`
+
+type InsertInvitationParams struct {
+	AccountID string             `json:"account_id"`
+	Email     string             `json:"email"`
+	Role      string             `json:"role"`
+	TokenHash []byte             `json:"token_hash"`
+	InvitedBy pgtype.UUID        `json:"invited_by"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+// ---- Invitations ----
+func (q *Queries) InsertInvitation(ctx context.Context, arg InsertInvitationParams) (Invitation, error) {
+	row := q.db.QueryRow(ctx, insertInvitation,
+		arg.AccountID,
+		arg.Email,
+		arg.Role,
+		arg.TokenHash,
+		arg.InvitedBy,
+		arg.ExpiresAt,
+	)
+	var i Invitation
+	err := row.Scan(
+		&i.ID,
+		&i.AccountID,
+		&i.Email,
+		&i.Role,
+		&i.TokenHash,
+		&i.InvitedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const insertMembership = `-- name: InsertMembership :one
+INSERT INTO memberships (account_id, member_id, role)
+VALUES ($1, $2, $3)
+RETURNING account_id, member_id, role, created_at
+`
+
+type InsertMembershipParams struct {
+	AccountID string `json:"account_id"`
+	MemberID  string `json:"member_id"`
+	Role      string `json:"role"`
+}
+
+func (q *Queries) InsertMembership(ctx context.Context, arg InsertMembershipParams) (Membership, error) {
+	row := q.db.QueryRow(ctx, insertMembership, arg.AccountID, arg.MemberID, arg.Role)
+	var i Membership
+	err := row.Scan(
+		&i.AccountID,
+		&i.MemberID,
+		&i.Role,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const listMembers = `-- name: ListMembers :many
+SELECT m.id, m.name, m.email, ms.role, ms.created_at AS joined_at
+FROM memberships ms
+JOIN members m ON m.id = ms.member_id
+WHERE ms.account_id = $1
+ORDER BY ms.created_at, m.id
+`
+
+type ListMembersRow struct {
+	ID       string             `json:"id"`
+	Name     string             `json:"name"`
+	Email    pgtype.Text        `json:"email"`
+	Role     string             `json:"role"`
+	JoinedAt pgtype.Timestamptz `json:"joined_at"`
+}
+
+func (q *Queries) ListMembers(ctx context.Context, accountID string) ([]ListMembersRow, error) {
+	rows, err := q.db.Query(ctx, listMembers, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMembersRow
+	for rows.Next() {
+		var i ListMembersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.Role,
+			&i.JoinedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPendingInvitations = `-- name: ListPendingInvitations :many
+SELECT id, account_id, email, role, token_hash, invited_by, created_at, expires_at, accepted_at, revoked_at FROM invitations
+WHERE account_id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+ORDER BY created_at DESC
+`
+
+// Pending invitations: not accepted, revoked or expired.
+func (q *Queries) ListPendingInvitations(ctx context.Context, accountID string) ([]Invitation, error) {
+	rows, err := q.db.Query(ctx, listPendingInvitations, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Invitation
+	for rows.Next() {
+		var i Invitation
+		if err := rows.Scan(
+			&i.ID,
+			&i.AccountID,
+			&i.Email,
+			&i.Role,
+			&i.TokenHash,
+			&i.InvitedBy,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.AcceptedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markInvitationAccepted = `-- name: MarkInvitationAccepted :one
+UPDATE invitations SET accepted_at = now() WHERE id = $1
+RETURNING id, account_id, email, role, token_hash, invited_by, created_at, expires_at, accepted_at, revoked_at
+`
+
+func (q *Queries) MarkInvitationAccepted(ctx context.Context, id string) (Invitation, error) {
+	row := q.db.QueryRow(ctx, markInvitationAccepted, id)
+	var i Invitation
+	err := row.Scan(
+		&i.ID,
+		&i.AccountID,
+		&i.Email,
+		&i.Role,
+		&i.TokenHash,
+		&i.InvitedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const revokeInvitation = `-- name: RevokeInvitation :one
+UPDATE invitations SET revoked_at = now()
+WHERE id = $1 AND account_id = $2
+  AND accepted_at IS NULL AND revoked_at IS NULL
+RETURNING id, account_id, email, role, token_hash, invited_by, created_at, expires_at, accepted_at, revoked_at
+`
+
+type RevokeInvitationParams struct {
+	ID        string `json:"id"`
+	AccountID string `json:"account_id"`
+}
+
+func (q *Queries) RevokeInvitation(ctx context.Context, arg RevokeInvitationParams) (Invitation, error) {
+	row := q.db.QueryRow(ctx, revokeInvitation, arg.ID, arg.AccountID)
+	var i Invitation
+	err := row.Scan(
+		&i.ID,
+		&i.AccountID,
+		&i.Email,
+		&i.Role,
+		&i.TokenHash,
+		&i.InvitedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const revokeMemberKeys = `-- name: RevokeMemberKeys :execrows
+UPDATE api_keys SET revoked_at = now()
+WHERE user_id = $1::uuid AND member_id = $2::uuid AND revoked_at IS NULL
+`
+
+type RevokeMemberKeysParams struct {
+	AccountID string `json:"account_id"`
+	MemberID  string `json:"member_id"`
+}
+
+func (q *Queries) RevokeMemberKeys(ctx context.Context, arg RevokeMemberKeysParams) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeMemberKeys, arg.AccountID, arg.MemberID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateMembershipRole = `-- name: UpdateMembershipRole :one
+UPDATE memberships SET role = $1
+WHERE account_id = $2 AND member_id = $3
+RETURNING account_id, member_id, role, created_at
+`
+
+type UpdateMembershipRoleParams struct {
+	Role      string `json:"role"`
+	AccountID string `json:"account_id"`
+	MemberID  string `json:"member_id"`
+}
+
+func (q *Queries) UpdateMembershipRole(ctx context.Context, arg UpdateMembershipRoleParams) (Membership, error) {
+	row := q.db.QueryRow(ctx, updateMembershipRole, arg.Role, arg.AccountID, arg.MemberID)
+	var i Membership
+	err := row.Scan(
+		&i.AccountID,
+		&i.MemberID,
+		&i.Role,
+		&i.CreatedAt,
+	)
+	return i, err
+}
@@ -0,0 +1,112 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: message_status_events.sql
+
+package dbgen
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const listStatusEventsAfter = `-- name: ListStatusEventsAfter :many
+SELECT id, message_id, user_id, status, occurred_at
+FROM message_status_events
+WHERE user_id = $1
+  AND id > $2
+  AND ($3::uuid[] IS NULL OR message_id = ANY($3::uuid[]))
+ORDER BY id
+LIMIT $4
+`
+
+type ListStatusEventsAfterParams struct {
+	UserID     string   `json:"user_id"`
+	AfterID    int64    `json:"after_id"`
+	MessageIds []string `json:"message_ids"`
+	LimitN     int32    `json:"limit_n"`
+}
+
+// Events after after_id for a user, optionally only for some messages.
+func (q *Queries) ListStatusEventsAfter(ctx context.Context, arg ListStatusEventsAfterParams) ([]MessageStatusEvent, error) {
+	rows, err := q.db.Query(ctx, listStatusEventsAfter,
+		arg.UserID,
+		arg.AfterID,
+		arg.MessageIds,
+		arg.LimitN,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MessageStatusEvent
+	for rows.Next() {
+		var i MessageStatusEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageID,
+			&i.UserID,
+			&i.Status,
+			&i.OccurredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const oldestStatusEventID = `-- name: OldestStatusEventID :one
+SELECT COALESCE(min(id), 0)::bigint FROM message_status_events
+`
+
+// 0 when the log is empty.
+func (q *Queries) OldestStatusEventID(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, oldestStatusEventID)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const pruneStatusEvents = `-- name: PruneStatusEvents :execrows
+DELETE FROM message_status_events
+WHERE occurred_at < $1
+`
+
+func (q *Queries) PruneStatusEvents(ctx context.Context, before pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, pruneStatusEvents, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const statusEventReplayFrom = `-- name: StatusEventReplayFrom :one
+SELECT COALESCE((
+  SELECT min(e.id) - 1
+  FROM message_status_events e, message_status_events a
+  WHERE a.id = $1
+    AND e.id <= a.id
+    AND e.occurred_at >= a.occurred_at - $2::bigint * interval '1 millisecond'
+), $1)::bigint
+`
+
+type StatusEventReplayFromParams struct {
+	AfterID int64 `json:"after_id"`
+	LagMs   int64 `json:"lag_ms"`
+}
+
+// Where to resume a stream that last saw after_id. Ids are taken at insert but
+// rows become visible at commit, so an event logged within lag before after_id
+// may have committed after it; the stream replays from just below the first of
+// them. after_id itself when it is no longer in the log.
+func (q *Queries) StatusEventReplayFrom(ctx context.Context, arg StatusEventReplayFromParams) (int64, error) {
+	row := q.db.QueryRow(ctx, statusEventReplayFrom, arg.AfterID, arg.LagMs)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
@@ -13,12 +13,15 @@ import (
 
 const claimQueued = `-- name: ClaimQueued :many
 WITH picked AS (
-  SELECT id
-  FROM messages
-  WHERE status = 'queued' AND send_after <= now()
-  ORDER BY requested_at
+  SELECT m.id
+  FROM messages m
+  JOIN users u ON u.id = m.user_id
+  LEFT JOIN users p ON p.id = u.parent_id
+  WHERE m.status = 'queued' AND m.send_after <= now()
+    AND u.suspended_at IS NULL AND u.deleted_at IS NULL AND p.suspended_at IS NULL
+  ORDER BY m.requested_at
   LIMIT $1
-  FOR UPDATE SKIP LOCKED
+  FOR UPDATE OF m SKIP LOCKED
 )
 UPDATE messages m
 SET status = 'sending', attempts = attempts + 1
@@ -27,6 +30,8 @@ WHERE m.id = picked.id
 RETURNING m.id
 `
 
+// Suspended and deleted accounts' messages stay queued. A sub-account is
+// held while its parent is suspended.
 func (q *Queries) ClaimQueued(ctx context.Context, limit int32) ([]string, error) {
 	rows, err := q.db.Query(ctx, claimQueued, limit)
 	if err != nil {
@@ -51,7 +56,9 @@ const claimQueuedLRS = `-- name: ClaimQueuedLRS :many
 WITH next_users AS (
   SELECT u.id
   FROM users u
-  WHERE EXISTS (
+  LEFT JOIN users p ON p.id = u.parent_id
+  WHERE u.suspended_at IS NULL AND u.deleted_at IS NULL AND p.suspended_at IS NULL
+    AND EXISTS (
     SELECT 1
     FROM messages m
     WHERE m.user_id = u.id
@@ -99,6 +106,7 @@ type ClaimQueuedLRSParams struct {
 	LimitN     int32 `json:"limit_n"`
 }
 
+// Skips held accounts like ClaimQueued.
 func (q *Queries) ClaimQueuedLRS(ctx context.Context, arg ClaimQueuedLRSParams) ([]string, error) {
 	rows, err := q.db.Query(ctx, claimQueuedLRS, arg.UserSlotsN, arg.PerUserN, arg.LimitN)
 	if err != nil {
@@ -119,6 +127,48 @@ func (q *Queries) ClaimQueuedLRS(ctx context.Context, arg ClaimQueuedLRSParams)
 	return items, nil
 }
 
+const countOutstanding = `-- name: CountOutstanding :one
+SELECT count(*)::int AS depth FROM (
+  SELECT 1
+  FROM messages m
+  JOIN users u ON u.id = m.user_id
+  LEFT JOIN users p ON p.id = u.parent_id
+  WHERE m.status IN ('queued','sending')
+    AND u.suspended_at IS NULL AND u.deleted_at IS NULL AND p.suspended_at IS NULL
+  LIMIT $1::int
+) t
+`
+
+// Messages of held accounts don't count, as ClaimQueued won't send them.
+func (q *Queries) CountOutstanding(ctx context.Context, cap int32) (int32, error) {
+	row := q.db.QueryRow(ctx, countOutstanding, cap)
+	var depth int32
+	err := row.Scan(&depth)
+	return depth, err
+}
+
+const countOutstandingForUser = `-- name: CountOutstandingForUser :one
+SELECT count(*)::int AS depth FROM (
+  SELECT 1 FROM messages
+  WHERE user_id = $1 AND status IN ('queued','sending')
+  LIMIT $2::int
+) t
+`
+
+type CountOutstandingForUserParams struct {
+	UserID string `json:"user_id"`
+	Cap    int32  `json:"cap"`
+}
+
+// Outstanding (queued or sending) messages, counted up to cap so the check
+// stays cheap however deep the queue is; pass MaxInt32 for the full count.
+func (q *Queries) CountOutstandingForUser(ctx context.Context, arg CountOutstandingForUserParams) (int32, error) {
+	row := q.db.QueryRow(ctx, countOutstandingForUser, arg.UserID, arg.Cap)
+	var depth int32
+	err := row.Scan(&depth)
+	return depth, err
+}
+
 const getMessage = `-- name: GetMessage :one
 SELECT id, user_id, to_msisdn, body, status, provider_message_id, error_code,
        requested_at, sent_at, delivered_at, attempts
@@ -159,32 +209,16 @@ func (q *Queries) GetMessage(ctx context.Context, id string) (GetMessageRow, err
 	return i, err
 }
 
-const getMessageByIdemKey = `-- name: GetMessageByIdemKey :one
-SELECT id
-FROM messages
-WHERE user_id = $1 AND idempotency_key = $2
-`
-
-type GetMessageByIdemKeyParams struct {
-	UserID         string      `json:"user_id"`
-	IdempotencyKey pgtype.Text `json:"idempotency_key"`
-}
-
-func (q *Queries) GetMessageByIdemKey(ctx context.Context, arg GetMessageByIdemKeyParams) (string, error) {
-	row := q.db.QueryRow(ctx, getMessageByIdemKey, arg.UserID, arg.IdempotencyKey)
-	var id string
-	err := row.Scan(&id)
-	return id, err
-}
-
 const insertMessage = `-- name: InsertMessage :one
-INSERT INTO messages (user_id, to_msisdn, body, status, idempotency_key)
+INSERT INTO messages (user_id, to_msisdn, body, status, idempotency_key, billed_user_id, credit_bucket_id)
 VALUES (
   $1,
   $2,
   $3,
   'queued',
-  $4     
+  $4,
+  $5::uuid,
+  $6
 )
 RETURNING id
 `
@@ -194,6 +228,8 @@ type InsertMessageParams struct {
 	ToMsisdn       string      `json:"to_msisdn"`
 	Body           string      `json:"body"`
 	IdempotencyKey pgtype.Text `json:"idempotency_key"`
+	BilledUserID   string      `json:"billed_user_id"`
+	CreditBucketID pgtype.UUID `json:"credit_bucket_id"`
 }
 
 func (q *Queries) InsertMessage(ctx context.Context, arg InsertMessageParams) (string, error) {
@@ -202,6 +238,8 @@ func (q *Queries) InsertMessage(ctx context.Context, arg InsertMessageParams) (s
 		arg.ToMsisdn,
 		arg.Body,
 		arg.IdempotencyKey,
+		arg.BilledUserID,
+		arg.CreditBucketID,
 	)
 	var id string
 	err := row.Scan(&id)
@@ -216,7 +254,7 @@ WHERE user_id = $1
   AND ($2::msg_status     IS NULL OR status       = $2::msg_status)
   AND ($3::timestamptz   IS NULL OR requested_at >= $3::timestamptz)
   AND ($4::timestamptz     IS NULL OR requested_at <  $4::timestamptz)
-ORDER BY requested_at DESC
+ORDER BY requested_at DESC, id DESC
 LIMIT  $6
 OFFSET $5
 `
@@ -283,6 +321,165 @@ func (q *Queries) ListMessages(ctx context.Context, arg ListMessagesParams) ([]L
 	return items, nil
 }
 
+const listMessagesAfter = `-- name: ListMessagesAfter :many
+SELECT id, user_id, to_msisdn, body, status, provider_message_id, error_code,
+       requested_at, sent_at, delivered_at, attempts
+FROM messages
+WHERE user_id = $1
+  AND ($2::msg_status     IS NULL OR status       = $2::msg_status)
+  AND ($3::timestamptz   IS NULL OR requested_at >= $3::timestamptz)
+  AND ($4::timestamptz     IS NULL OR requested_at <  $4::timestamptz)
+  AND (requested_at, id) > ($5::timestamptz, $6::uuid)
+ORDER BY requested_at ASC, id ASC
+LIMIT $7
+`
+
+type ListMessagesAfterParams struct {
+	UserID   string             `json:"user_id"`
+	Status   NullMsgStatus      `json:"status"`
+	FromTs   pgtype.Timestamptz `json:"from_ts"`
+	ToTs     pgtype.Timestamptz `json:"to_ts"`
+	CursorTs pgtype.Timestamptz `json:"cursor_ts"`
+	CursorID string             `json:"cursor_id"`
+	LimitN   int32              `json:"limit_n"`
+}
+
+type ListMessagesAfterRow struct {
+	ID                string             `json:"id"`
+	UserID            string             `json:"user_id"`
+	ToMsisdn          string             `json:"to_msisdn"`
+	Body              string             `json:"body"`
+	Status            MsgStatus          `json:"status"`
+	ProviderMessageID pgtype.Text        `json:"provider_message_id"`
+	ErrorCode         pgtype.Text        `json:"error_code"`
+	RequestedAt       pgtype.Timestamptz `json:"requested_at"`
+	SentAt            pgtype.Timestamptz `json:"sent_at"`
+	DeliveredAt       pgtype.Timestamptz `json:"delivered_at"`
+	Attempts          int32              `json:"attempts"`
+}
+
+// Rows strictly newer than the cursor, oldest first (the caller reverses
+// them into page order).
+func (q *Queries) ListMessagesAfter(ctx context.Context, arg ListMessagesAfterParams) ([]ListMessagesAfterRow, error) {
+	rows, err := q.db.Query(ctx, listMessagesAfter,
+		arg.UserID,
+		arg.Status,
+		arg.FromTs,
+		arg.ToTs,
+		arg.CursorTs,
+		arg.CursorID,
+		arg.LimitN,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMessagesAfterRow
+	for rows.Next() {
+		var i ListMessagesAfterRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.ToMsisdn,
+			&i.Body,
+			&i.Status,
+			&i.ProviderMessageID,
+			&i.ErrorCode,
+			&i.RequestedAt,
+			&i.SentAt,
+			&i.DeliveredAt,
+			&i.Attempts,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMessagesBefore = `-- name: ListMessagesBefore :many
+SELECT id, user_id, to_msisdn, body, status, provider_message_id, error_code,
+       requested_at, sent_at, delivered_at, attempts
+FROM messages
+WHERE user_id = $1
+  AND ($2::msg_status     IS NULL OR status       = $2::msg_status)
+  AND ($3::timestamptz   IS NULL OR requested_at >= $3::timestamptz)
+  AND ($4::timestamptz     IS NULL OR requested_at <  $4::timestamptz)
+  AND ($5::timestamptz IS NULL
+       OR (requested_at, id) < ($5::timestamptz, $6::uuid))
+ORDER BY requested_at DESC, id DESC
+LIMIT $7
+`
+
+type ListMessagesBeforeParams struct {
+	UserID   string             `json:"user_id"`
+	Status   NullMsgStatus      `json:"status"`
+	FromTs   pgtype.Timestamptz `json:"from_ts"`
+	ToTs     pgtype.Timestamptz `json:"to_ts"`
+	CursorTs pgtype.Timestamptz `json:"cursor_ts"`
+	CursorID pgtype.UUID        `json:"cursor_id"`
+	LimitN   int32              `json:"limit_n"`
+}
+
+type ListMessagesBeforeRow struct {
+	ID                string             `json:"id"`
+	UserID            string             `json:"user_id"`
+	ToMsisdn          string             `json:"to_msisdn"`
+	Body              string             `json:"body"`
+	Status            MsgStatus          `json:"status"`
+	ProviderMessageID pgtype.Text        `json:"provider_message_id"`
+	ErrorCode         pgtype.Text        `json:"error_code"`
+	RequestedAt       pgtype.Timestamptz `json:"requested_at"`
+	SentAt            pgtype.Timestamptz `json:"sent_at"`
+	DeliveredAt       pgtype.Timestamptz `json:"delivered_at"`
+	Attempts          int32              `json:"attempts"`
+}
+
+// Keyset pages, newest first. Rows strictly older than the cursor, or from
+// the top without one.
+func (q *Queries) ListMessagesBefore(ctx context.Context, arg ListMessagesBeforeParams) ([]ListMessagesBeforeRow, error) {
+	rows, err := q.db.Query(ctx, listMessagesBefore,
+		arg.UserID,
+		arg.Status,
+		arg.FromTs,
+		arg.ToTs,
+		arg.CursorTs,
+		arg.CursorID,
+		arg.LimitN,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMessagesBeforeRow
+	for rows.Next() {
+		var i ListMessagesBeforeRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.ToMsisdn,
+			&i.Body,
+			&i.Status,
+			&i.ProviderMessageID,
+			&i.ErrorCode,
+			&i.RequestedAt,
+			&i.SentAt,
+			&i.DeliveredAt,
+			&i.Attempts,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const loadMessageForSend = `-- name: LoadMessageForSend :one
 SELECT user_id, to_msisdn, body
 FROM messages
@@ -302,39 +499,125 @@ func (q *Queries) LoadMessageForSend(ctx context.Context, id string) (LoadMessag
 	return i, err
 }
 
-const markFailed = `-- name: MarkFailed :exec
+const lockQueuedMessagesForUser = `-- name: LockQueuedMessagesForUser :many
+SELECT id FROM messages
+WHERE user_id = $1 AND status = 'queued'
+FOR UPDATE
+`
+
+// Queued messages of a user, locked so workers skip them until the tx ends.
+func (q *Queries) LockQueuedMessagesForUser(ctx context.Context, userID string) ([]string, error) {
+	rows, err := q.db.Query(ctx, lockQueuedMessagesForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markFailed = `-- name: MarkFailed :one
 UPDATE messages
 SET status='failed'
 WHERE id = $1
+  AND status <> 'failed'
+RETURNING id, user_id, to_msisdn, status
 `
 
-func (q *Queries) MarkFailed(ctx context.Context, id string) error {
-	_, err := q.db.Exec(ctx, markFailed, id)
-	return err
+type MarkFailedRow struct {
+	ID       string    `json:"id"`
+	UserID   string    `json:"user_id"`
+	ToMsisdn string    `json:"to_msisdn"`
+	Status   MsgStatus `json:"status"`
 }
 
-const markFailedAndRefund = `-- name: MarkFailedAndRefund :exec
+// No rows if already failed.
+func (q *Queries) MarkFailed(ctx context.Context, id string) (MarkFailedRow, error) {
+	row := q.db.QueryRow(ctx, markFailed, id)
+	var i MarkFailedRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ToMsisdn,
+		&i.Status,
+	)
+	return i, err
+}
+
+const markFailedAndRefund = `-- name: MarkFailedAndRefund :one
 WITH upd AS (
   UPDATE messages AS m
-  SET status = 'failed'
-  WHERE m.id = $1
+  SET status = 'failed', error_code = COALESCE($1, m.error_code)
+  WHERE m.id = $2
     AND status <> 'failed'
-  RETURNING user_id
+  RETURNING user_id, to_msisdn, COALESCE(billed_user_id, user_id)::uuid AS payer_id, credit_bucket_id
+),
+quota AS (
+  UPDATE users AS c
+  SET quota_used = GREATEST(c.quota_used - 1, 0)
+  FROM upd
+  WHERE c.id = upd.user_id
+    AND upd.payer_id <> upd.user_id
+),
+bucket AS (
+  UPDATE credit_buckets AS b
+  SET remaining = b.remaining + 1
+  FROM upd
+  WHERE b.id = upd.credit_bucket_id
+),
+paid AS (
+  UPDATE users AS u
+  SET balance = balance + 1
+  FROM upd
+  WHERE u.id = upd.payer_id
+    AND upd.credit_bucket_id IS NULL
 )
-UPDATE users AS u
-SET balance = balance + 1
-WHERE u.id = (SELECT user_id FROM upd)
+SELECT user_id, to_msisdn, payer_id, credit_bucket_id FROM upd
 `
 
-func (q *Queries) MarkFailedAndRefund(ctx context.Context, id string) error {
-	_, err := q.db.Exec(ctx, markFailedAndRefund, id)
-	return err
+type MarkFailedAndRefundParams struct {
+	ErrorCode pgtype.Text `json:"error_code"`
+	ID        string      `json:"id"`
+}
+
+type MarkFailedAndRefundRow struct {
+	UserID         string      `json:"user_id"`
+	ToMsisdn       string      `json:"to_msisdn"`
+	PayerID        string      `json:"payer_id"`
+	CreditBucketID pgtype.UUID `json:"credit_bucket_id"`
 }
 
-const markSent = `-- name: MarkSent :exec
+// Credits whoever paid, back into the promotional bucket it came from if any,
+// even one that has expired since (ExpireCredits then forfeits it); a
+// parent-billed child also gets its quota back. error_code is kept when NULL.
+// No rows if already failed.
+func (q *Queries) MarkFailedAndRefund(ctx context.Context, arg MarkFailedAndRefundParams) (MarkFailedAndRefundRow, error) {
+	row := q.db.QueryRow(ctx, markFailedAndRefund, arg.ErrorCode, arg.ID)
+	var i MarkFailedAndRefundRow
+	err := row.Scan(
+		&i.UserID,
+		&i.ToMsisdn,
+		&i.PayerID,
+		&i.CreditBucketID,
+	)
+	return i, err
+}
+
+const markSent = `-- name: MarkSent :one
 UPDATE messages
 SET status = 'sent', provider_message_id = $2, sent_at = now()
 WHERE id = $1
+RETURNING id, user_id, to_msisdn, status, provider_message_id, sent_at
 `
 
 type MarkSentParams struct {
@@ -342,9 +625,27 @@ type MarkSentParams struct {
 	ProviderMessageID pgtype.Text `json:"provider_message_id"`
 }
 
-func (q *Queries) MarkSent(ctx context.Context, arg MarkSentParams) error {
-	_, err := q.db.Exec(ctx, markSent, arg.ID, arg.ProviderMessageID)
-	return err
+type MarkSentRow struct {
+	ID                string             `json:"id"`
+	UserID            string             `json:"user_id"`
+	ToMsisdn          string             `json:"to_msisdn"`
+	Status            MsgStatus          `json:"status"`
+	ProviderMessageID pgtype.Text        `json:"provider_message_id"`
+	SentAt            pgtype.Timestamptz `json:"sent_at"`
+}
+
+func (q *Queries) MarkSent(ctx context.Context, arg MarkSentParams) (MarkSentRow, error) {
+	row := q.db.QueryRow(ctx, markSent, arg.ID, arg.ProviderMessageID)
+	var i MarkSentRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ToMsisdn,
+		&i.Status,
+		&i.ProviderMessageID,
+		&i.SentAt,
+	)
+	return i, err
 }
 
 const requeueWithBackoff = `-- name: RequeueWithBackoff :exec
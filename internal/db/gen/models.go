@@ -7,6 +7,7 @@ package dbgen
 import (
 	"database/sql/driver"
 	"fmt"
+	"net/netip"
 
 	"github.com/jackc/pgx/v5/pgtype"
 )
@@ -55,6 +56,142 @@ func (ns NullMsgStatus) Value() (driver.Value, error) {
 	return string(ns.MsgStatus), nil
 }
 
+type ApiKey struct {
+	ID            string             `json:"id"`
+	UserID        pgtype.UUID        `json:"user_id"`
+	Name          string             `json:"name"`
+	Prefix        string             `json:"prefix"`
+	SecretHash    []byte             `json:"secret_hash"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+	LastUsedAt    pgtype.Timestamptz `json:"last_used_at"`
+	ExpiresAt     pgtype.Timestamptz `json:"expires_at"`
+	RevokedAt     pgtype.Timestamptz `json:"revoked_at"`
+	ReplacedBy    pgtype.UUID        `json:"replaced_by"`
+	Kind          string             `json:"kind"`
+	Scopes        []string           `json:"scopes"`
+	SigningSecret []byte             `json:"signing_secret"`
+	MemberID      pgtype.UUID        `json:"member_id"`
+	IpAllowlist   []netip.Prefix     `json:"ip_allowlist"`
+}
+
+type AuditEvent struct {
+	ID          int64              `json:"id"`
+	OccurredAt  pgtype.Timestamptz `json:"occurred_at"`
+	ActorType   string             `json:"actor_type"`
+	ActorKeyID  pgtype.UUID        `json:"actor_key_id"`
+	ActorUserID pgtype.UUID        `json:"actor_user_id"`
+	Action      string             `json:"action"`
+	TargetType  string             `json:"target_type"`
+	TargetID    string             `json:"target_id"`
+	RequestID   pgtype.Text        `json:"request_id"`
+	SourceIp    pgtype.Text        `json:"source_ip"`
+	Before      []byte             `json:"before"`
+	After       []byte             `json:"after"`
+}
+
+type BalanceNotification struct {
+	ID            int64              `json:"id"`
+	UserID        string             `json:"user_id"`
+	ThresholdID   pgtype.UUID        `json:"threshold_id"`
+	Threshold     int32              `json:"threshold"`
+	Balance       int32              `json:"balance"`
+	Channel       string             `json:"channel"`
+	Target        string             `json:"target"`
+	Attempts      int32              `json:"attempts"`
+	NextAttemptAt pgtype.Timestamptz `json:"next_attempt_at"`
+	DeliveredAt   pgtype.Timestamptz `json:"delivered_at"`
+	FailedAt      pgtype.Timestamptz `json:"failed_at"`
+	LastError     pgtype.Text        `json:"last_error"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+}
+
+type BalanceThreshold struct {
+	ID           string             `json:"id"`
+	UserID       string             `json:"user_id"`
+	Threshold    int32              `json:"threshold"`
+	WebhookUrl   pgtype.Text        `json:"webhook_url"`
+	NotifyMsisdn pgtype.Text        `json:"notify_msisdn"`
+	Armed        bool               `json:"armed"`
+	LastFiredAt  pgtype.Timestamptz `json:"last_fired_at"`
+	CreatedAt    pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt    pgtype.Timestamptz `json:"updated_at"`
+}
+
+type ClientCertificate struct {
+	ID         string             `json:"id"`
+	UserID     string             `json:"user_id"`
+	Identity   string             `json:"identity"`
+	Scopes     []string           `json:"scopes"`
+	Name       string             `json:"name"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	RevokedAt  pgtype.Timestamptz `json:"revoked_at"`
+}
+
+type CreditBucket struct {
+	ID        string             `json:"id"`
+	UserID    string             `json:"user_id"`
+	Amount    int32              `json:"amount"`
+	Remaining int32              `json:"remaining"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+	ExpiredAt pgtype.Timestamptz `json:"expired_at"`
+	Note      pgtype.Text        `json:"note"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type IdempotencyKey struct {
+	UserID         string             `json:"user_id"`
+	Key            string             `json:"key"`
+	Fingerprint    []byte             `json:"fingerprint"`
+	MessageID      pgtype.UUID        `json:"message_id"`
+	ResponseStatus pgtype.Int4        `json:"response_status"`
+	ResponseBody   []byte             `json:"response_body"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+	ExpiresAt      pgtype.Timestamptz `json:"expires_at"`
+}
+
+type Invitation struct {
+	ID         string             `json:"id"`
+	AccountID  string             `json:"account_id"`
+	Email      string             `json:"email"`
+	Role       string             `json:"role"`
+	TokenHash  []byte             `json:"token_hash"`
+	InvitedBy  pgtype.UUID        `json:"invited_by"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+	ExpiresAt  pgtype.Timestamptz `json:"expires_at"`
+	AcceptedAt pgtype.Timestamptz `json:"accepted_at"`
+	RevokedAt  pgtype.Timestamptz `json:"revoked_at"`
+}
+
+type LedgerEntry struct {
+	ID             int64              `json:"id"`
+	UserID         string             `json:"user_id"`
+	Kind           string             `json:"kind"`
+	Amount         int32              `json:"amount"`
+	BalanceAfter   int32              `json:"balance_after"`
+	IdempotencyKey pgtype.Text        `json:"idempotency_key"`
+	ExternalRef    pgtype.Text        `json:"external_ref"`
+	Note           pgtype.Text        `json:"note"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+	CounterpartyID pgtype.UUID        `json:"counterparty_id"`
+	CreditBucketID pgtype.UUID        `json:"credit_bucket_id"`
+	MessageID      pgtype.UUID        `json:"message_id"`
+}
+
+type Member struct {
+	ID        string             `json:"id"`
+	Name      string             `json:"name"`
+	Email     pgtype.Text        `json:"email"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type Membership struct {
+	AccountID string             `json:"account_id"`
+	MemberID  string             `json:"member_id"`
+	Role      string             `json:"role"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
 type Message struct {
 	ID                string             `json:"id"`
 	UserID            string             `json:"user_id"`
@@ -70,6 +207,49 @@ type Message struct {
 	Attempts          int32              `json:"attempts"`
 	IdempotencyKey    pgtype.Text        `json:"idempotency_key"`
 	UpdatedAt         pgtype.Timestamptz `json:"updated_at"`
+	BilledUserID      pgtype.UUID        `json:"billed_user_id"`
+	CreditBucketID    pgtype.UUID        `json:"credit_bucket_id"`
+}
+
+type MessageFailureStat struct {
+	UserID     string             `json:"user_id"`
+	Hour       pgtype.Timestamptz `json:"hour"`
+	DialPrefix string             `json:"dial_prefix"`
+	Reason     string             `json:"reason"`
+	Failed     int32              `json:"failed"`
+}
+
+type MessageStat struct {
+	UserID       string             `json:"user_id"`
+	Hour         pgtype.Timestamptz `json:"hour"`
+	DialPrefix   string             `json:"dial_prefix"`
+	Queued       int32              `json:"queued"`
+	Sending      int32              `json:"sending"`
+	Sent         int32              `json:"sent"`
+	Failed       int32              `json:"failed"`
+	Spent        int32              `json:"spent"`
+	LatencyMsSum int64              `json:"latency_ms_sum"`
+	LatencyN     int32              `json:"latency_n"`
+}
+
+type MessageStatusEvent struct {
+	ID         int64              `json:"id"`
+	MessageID  string             `json:"message_id"`
+	UserID     string             `json:"user_id"`
+	Status     MsgStatus          `json:"status"`
+	OccurredAt pgtype.Timestamptz `json:"occurred_at"`
+}
+
+type RateLimitBucket struct {
+	BucketKey string             `json:"bucket_key"`
+	Tokens    float64            `json:"tokens"`
+	UpdatedAt pgtype.Timestamptz `json:"updated_at"`
+}
+
+type RequestNonce struct {
+	KeyID     string             `json:"key_id"`
+	Nonce     string             `json:"nonce"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
 }
 
 type User struct {
@@ -79,4 +259,46 @@ type User struct {
 	CreatedAt    pgtype.Timestamptz `json:"created_at"`
 	UpdatedAt    pgtype.Timestamptz `json:"updated_at"`
 	LastServedAt pgtype.Timestamptz `json:"last_served_at"`
+	ParentID     pgtype.UUID        `json:"parent_id"`
+	BillingMode  string             `json:"billing_mode"`
+	Quota        pgtype.Int4        `json:"quota"`
+	QuotaUsed    int32              `json:"quota_used"`
+	Plan         string             `json:"plan"`
+	IpAllowlist  []netip.Prefix     `json:"ip_allowlist"`
+	SuspendedAt  pgtype.Timestamptz `json:"suspended_at"`
+	DeletedAt    pgtype.Timestamptz `json:"deleted_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int64              `json:"id"`
+	EventID        string             `json:"event_id"`
+	EndpointID     string             `json:"endpoint_id"`
+	Attempts       int32              `json:"attempts"`
+	NextAttemptAt  pgtype.Timestamptz `json:"next_attempt_at"`
+	DeliveredAt    pgtype.Timestamptz `json:"delivered_at"`
+	FailedAt       pgtype.Timestamptz `json:"failed_at"`
+	ResponseStatus pgtype.Int4        `json:"response_status"`
+	LastError      pgtype.Text        `json:"last_error"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+}
+
+type WebhookEndpoint struct {
+	ID                  string             `json:"id"`
+	UserID              string             `json:"user_id"`
+	Url                 string             `json:"url"`
+	Secret              []byte             `json:"secret"`
+	EventTypes          []string           `json:"event_types"`
+	Description         string             `json:"description"`
+	ConsecutiveFailures int32              `json:"consecutive_failures"`
+	DisabledAt          pgtype.Timestamptz `json:"disabled_at"`
+	CreatedAt           pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt           pgtype.Timestamptz `json:"updated_at"`
+}
+
+type WebhookEvent struct {
+	ID        string             `json:"id"`
+	UserID    string             `json:"user_id"`
+	Type      string             `json:"type"`
+	Payload   []byte             `json:"payload"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
 }
@@ -6,27 +6,212 @@ package dbgen
 
 import (
 	"context"
+	"net/netip"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Querier interface {
+	// Pushes next_attempt_at forward as a lease so concurrent workers skip the rows
+	// while they are being delivered.
+	ClaimBalanceNotifications(ctx context.Context, arg ClaimBalanceNotificationsParams) ([]ClaimBalanceNotificationsRow, error)
+	// Claims a key that is unused or expired. No row means a live key exists;
+	// a concurrent claim waits for the other transaction to finish first.
+	ClaimIdempotencyKey(ctx context.Context, arg ClaimIdempotencyKeyParams) (string, error)
+	// Suspended and deleted accounts' messages stay queued. A sub-account is
+	// held while its parent is suspended.
 	ClaimQueued(ctx context.Context, limit int32) ([]string, error)
+	// Skips held accounts like ClaimQueued.
 	ClaimQueuedLRS(ctx context.Context, arg ClaimQueuedLRSParams) ([]string, error)
+	// An expired row with the same nonce is taken over; a live one is a replay.
+	ClaimRequestNonce(ctx context.Context, arg ClaimRequestNonceParams) (int64, error)
+	// Pushes next_attempt_at forward as a lease, as for balance notifications.
+	// Deliveries to disabled endpoints are not picked up.
+	ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]ClaimWebhookDeliveriesRow, error)
+	CompleteIdempotencyKey(ctx context.Context, arg CompleteIdempotencyKeyParams) error
+	CountLiveChildUsers(ctx context.Context, parentID string) (int32, error)
+	// Messages of held accounts don't count, as ClaimQueued won't send them.
+	CountOutstanding(ctx context.Context, cap int32) (int32, error)
+	// Outstanding (queued or sending) messages, counted up to cap so the check
+	// stays cheap however deep the queue is; pass MaxInt32 for the full count.
+	CountOutstandingForUser(ctx context.Context, arg CountOutstandingForUserParams) (int32, error)
+	CountOwners(ctx context.Context, accountID string) (int32, error)
+	// A threshold created while the balance is already below it starts disarmed,
+	// so it only fires after the next top-up and subsequent drop.
+	CreateBalanceThreshold(ctx context.Context, arg CreateBalanceThresholdParams) (BalanceThreshold, error)
+	CreateChildUser(ctx context.Context, arg CreateChildUserParams) (User, error)
+	CreateMember(ctx context.Context, arg CreateMemberParams) (Member, error)
 	CreateUser(ctx context.Context, name string) (CreateUserRow, error)
+	// Spends amount from the earliest-expiring usable bucket; no rows when there is none.
+	DebitCreditBucket(ctx context.Context, arg DebitCreditBucketParams) (string, error)
 	DebitIfEnough(ctx context.Context, arg DebitIfEnoughParams) (int64, error)
+	DeleteBalanceThreshold(ctx context.Context, arg DeleteBalanceThresholdParams) (BalanceThreshold, error)
+	DeleteMembership(ctx context.Context, arg DeleteMembershipParams) error
+	DeleteWebhookEndpoint(ctx context.Context, arg DeleteWebhookEndpointParams) (WebhookEndpoint, error)
+	// Draws amount against a parent-billed child's quota; 0 rows when it would exceed it.
+	DrawParentQuota(ctx context.Context, arg DrawParentQuotaParams) (int64, error)
+	// Zeroes one expired bucket; no rows if another worker already swept it.
+	ExpireCreditBucket(ctx context.Context, id string) (ExpireCreditBucketRow, error)
+	FailBalanceNotification(ctx context.Context, arg FailBalanceNotificationParams) error
+	// Gives up on an endpoint's pending deliveries; they can be replayed once it
+	// is enabled again.
+	FailPendingWebhookDeliveries(ctx context.Context, arg FailPendingWebhookDeliveriesParams) error
+	FailWebhookDelivery(ctx context.Context, arg FailWebhookDeliveryParams) error
+	// Active bindings for any of the identities a certificate presents.
+	FindClientCertificates(ctx context.Context, identities []string) ([]ClientCertificate, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (ApiKey, error)
+	GetAPIKeyForUpdate(ctx context.Context, arg GetAPIKeyForUpdateParams) (ApiKey, error)
+	// Spendable balance: paid plus unexpired promotional credit.
 	GetBalance(ctx context.Context, id string) (int32, error)
+	GetBalanceBreakdown(ctx context.Context, id string) (GetBalanceBreakdownRow, error)
+	// ---- Sub-accounts ----
+	// Locks the sending user's row; the payer is resolved from billing_mode. A
+	// sub-account counts as suspended while its parent is.
+	GetBillingInfoForUpdate(ctx context.Context, id string) (GetBillingInfoForUpdateRow, error)
+	// Both lists a request has to pass; either id may be NULL.
+	GetIPAllowlists(ctx context.Context, arg GetIPAllowlistsParams) (GetIPAllowlistsRow, error)
+	GetIdempotencyKey(ctx context.Context, arg GetIdempotencyKeyParams) (IdempotencyKey, error)
+	GetInvitationByTokenForUpdate(ctx context.Context, tokenHash []byte) (Invitation, error)
+	GetLedgerEntryByIdemKey(ctx context.Context, arg GetLedgerEntryByIdemKeyParams) (GetLedgerEntryByIdemKeyRow, error)
+	GetMemberByEmail(ctx context.Context, email pgtype.Text) (Member, error)
+	GetMembershipForUpdate(ctx context.Context, arg GetMembershipForUpdateParams) (Membership, error)
+	GetMembershipRole(ctx context.Context, arg GetMembershipRoleParams) (string, error)
 	GetMessage(ctx context.Context, id string) (GetMessageRow, error)
-	GetMessageByIdemKey(ctx context.Context, arg GetMessageByIdemKeyParams) (string, error)
-	GetUser(ctx context.Context, id string) (GetUserRow, error)
+	GetUser(ctx context.Context, id string) (User, error)
+	GetUserIPAllowlist(ctx context.Context, id string) ([]netip.Prefix, error)
+	GetUserPlan(ctx context.Context, id string) (string, error)
+	GetWebhookEndpointForUpdate(ctx context.Context, arg GetWebhookEndpointForUpdateParams) (WebhookEndpoint, error)
+	InsertAPIKey(ctx context.Context, arg InsertAPIKeyParams) (ApiKey, error)
+	InsertAuditEvent(ctx context.Context, arg InsertAuditEventParams) error
+	InsertBalanceNotification(ctx context.Context, arg InsertBalanceNotificationParams) error
+	InsertClientCertificate(ctx context.Context, arg InsertClientCertificateParams) (ClientCertificate, error)
+	InsertCreditBucket(ctx context.Context, arg InsertCreditBucketParams) (CreditBucket, error)
+	// ---- Invitations ----
+	InsertInvitation(ctx context.Context, arg InsertInvitationParams) (Invitation, error)
+	// balance_after is paid balance plus unswept promotional credit, read from the
+	// (already updated) rows in the same tx.
+	InsertLedgerEntry(ctx context.Context, arg InsertLedgerEntryParams) (InsertLedgerEntryRow, error)
+	InsertMembership(ctx context.Context, arg InsertMembershipParams) (Membership, error)
 	InsertMessage(ctx context.Context, arg InsertMessageParams) (string, error)
+	InsertWebhookEndpoint(ctx context.Context, arg InsertWebhookEndpointParams) (WebhookEndpoint, error)
+	// Writes the event and one delivery per enabled endpoint of the user that is
+	// subscribed to its type. Nothing is written when there is none.
+	InsertWebhookEvent(ctx context.Context, arg InsertWebhookEventParams) error
+	// Balance as of ts: balance_after of the last entry before it, 0 if none.
+	LedgerBalanceAt(ctx context.Context, arg LedgerBalanceAtParams) (int32, error)
+	// Charges net of refunds, by destination calling-code prefix (first three digits)
+	// and the message's current status.
+	LedgerChargesByDestination(ctx context.Context, arg LedgerChargesByDestinationParams) ([]LedgerChargesByDestinationRow, error)
+	LedgerTotalsByKind(ctx context.Context, arg LedgerTotalsByKindParams) ([]LedgerTotalsByKindRow, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]ApiKey, error)
+	ListActiveCreditBuckets(ctx context.Context, userID string) ([]CreditBucket, error)
+	ListAdminAPIKeys(ctx context.Context) ([]ApiKey, error)
+	// Newest first; before_id pages backwards.
+	ListAuditEvents(ctx context.Context, arg ListAuditEventsParams) ([]AuditEvent, error)
+	ListBalanceThresholds(ctx context.Context, userID string) ([]BalanceThreshold, error)
+	ListChildUsers(ctx context.Context, parentID string) ([]User, error)
+	ListClientCertificates(ctx context.Context, userID string) ([]ClientCertificate, error)
+	ListExpiredCreditBuckets(ctx context.Context, limit int32) ([]ListExpiredCreditBucketsRow, error)
+	ListMembers(ctx context.Context, accountID string) ([]ListMembersRow, error)
 	ListMessages(ctx context.Context, arg ListMessagesParams) ([]ListMessagesRow, error)
+	// Rows strictly newer than the cursor, oldest first (the caller reverses
+	// them into page order).
+	ListMessagesAfter(ctx context.Context, arg ListMessagesAfterParams) ([]ListMessagesAfterRow, error)
+	// Keyset pages, newest first. Rows strictly older than the cursor, or from
+	// the top without one.
+	ListMessagesBefore(ctx context.Context, arg ListMessagesBeforeParams) ([]ListMessagesBeforeRow, error)
+	// Pending invitations: not accepted, revoked or expired.
+	ListPendingInvitations(ctx context.Context, accountID string) ([]Invitation, error)
+	// Events after after_id for a user, optionally only for some messages.
+	ListStatusEventsAfter(ctx context.Context, arg ListStatusEventsAfterParams) ([]MessageStatusEvent, error)
+	// Newest first, keyset-paged by (created_at, id). A search matches names by
+	// name_pattern (ILIKE) or an exact id. status is active, suspended or deleted;
+	// without it every account that isn't deleted is listed.
+	ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error)
+	// Newest first; before_id pages back from an earlier page's last id.
+	ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]ListWebhookDeliveriesRow, error)
+	ListWebhookEndpoints(ctx context.Context, userID string) ([]WebhookEndpoint, error)
 	LoadMessageForSend(ctx context.Context, id string) (LoadMessageForSendRow, error)
+	// Serializes balance changes for one user; no rows means the user doesn't exist.
+	// No rows for a deleted account. Holds off DeleteUser (which locks FOR
+	// UPDATE) until the tx ends, so anything attached meanwhile is revoked with it.
+	LockLiveUser(ctx context.Context, id string) (string, error)
+	// Queued messages of a user, locked so workers skip them until the tx ends.
+	LockQueuedMessagesForUser(ctx context.Context, userID string) ([]string, error)
 	// Optional: explicit row lock if you need it elsewhere
 	LockUser(ctx context.Context, id string) error
-	MarkFailed(ctx context.Context, id string) error
-	MarkFailedAndRefund(ctx context.Context, id string) error
-	MarkSent(ctx context.Context, arg MarkSentParams) error
+	LockUserBalance(ctx context.Context, id string) (int32, error)
+	MarkBalanceNotificationDelivered(ctx context.Context, id int64) error
+	// No rows if already failed.
+	MarkFailed(ctx context.Context, id string) (MarkFailedRow, error)
+	// Credits whoever paid, back into the promotional bucket it came from if any,
+	// even one that has expired since (ExpireCredits then forfeits it); a
+	// parent-billed child also gets its quota back. error_code is kept when NULL.
+	// No rows if already failed.
+	MarkFailedAndRefund(ctx context.Context, arg MarkFailedAndRefundParams) (MarkFailedAndRefundRow, error)
+	MarkInvitationAccepted(ctx context.Context, id string) (Invitation, error)
+	MarkSent(ctx context.Context, arg MarkSentParams) (MarkSentRow, error)
+	MarkWebhookDelivered(ctx context.Context, arg MarkWebhookDeliveredParams) error
+	MessageFailureStats(ctx context.Context, arg MessageFailureStatsParams) ([]MessageFailureStatsRow, error)
+	// Rollup totals per bucket (hour or day, in UTC) and destination prefix for
+	// buckets starting in [from_ts, to_ts).
+	MessageStats(ctx context.Context, arg MessageStatsParams) ([]MessageStatsRow, error)
+	// 0 when the log is empty.
+	OldestStatusEventID(ctx context.Context) (int64, error)
+	PruneStatusEvents(ctx context.Context, before pgtype.Timestamptz) (int64, error)
+	PurgeIdempotencyKeys(ctx context.Context) (int64, error)
+	// Idle buckets have refilled, so dropping them is lossless.
+	PurgeRateLimitBuckets(ctx context.Context) (int64, error)
+	PurgeRequestNonces(ctx context.Context) (int64, error)
+	RearmBalanceThresholds(ctx context.Context, userID string) error
+	// Counts a failed attempt against the endpoint and disables it once
+	// disable_after attempts in a row have failed. disabled is true only for the
+	// failure that disabled it.
+	RecordWebhookEndpointFailure(ctx context.Context, arg RecordWebhookEndpointFailureParams) (RecordWebhookEndpointFailureRow, error)
+	// Refills a bucket (creating it full) and locks it until the end of the tx;
+	// returns the tokens it now holds.
+	RefillRateLimitBucket(ctx context.Context, arg RefillRateLimitBucketParams) (float64, error)
+	// Queues the event of an earlier delivery to its endpoint again. Same columns
+	// as ListWebhookDeliveries.
+	ReplayWebhookDelivery(ctx context.Context, arg ReplayWebhookDeliveryParams) (ReplayWebhookDeliveryRow, error)
 	RequeueWithBackoff(ctx context.Context, arg RequeueWithBackoffParams) error
-	TopUp(ctx context.Context, arg TopUpParams) error
+	// Ends the old key's life after the grace period; never extends an earlier expiry.
+	RetireAPIKey(ctx context.Context, arg RetireAPIKeyParams) error
+	RetryBalanceNotification(ctx context.Context, arg RetryBalanceNotificationParams) error
+	RetryWebhookDelivery(ctx context.Context, arg RetryWebhookDeliveryParams) error
+	RevokeAPIKey(ctx context.Context, arg RevokeAPIKeyParams) (ApiKey, error)
+	RevokeAdminAPIKey(ctx context.Context, id string) (ApiKey, error)
+	RevokeClientCertificate(ctx context.Context, arg RevokeClientCertificateParams) (ClientCertificate, error)
+	RevokeInvitation(ctx context.Context, arg RevokeInvitationParams) (Invitation, error)
+	RevokeMemberKeys(ctx context.Context, arg RevokeMemberKeysParams) (int64, error)
+	RevokeUserAPIKeys(ctx context.Context, userID string) (int64, error)
+	RevokeUserClientCertificates(ctx context.Context, userID string) (int64, error)
+	SetAPIKeyIPAllowlist(ctx context.Context, arg SetAPIKeyIPAllowlistParams) (ApiKey, error)
+	SetAPIKeySigningSecret(ctx context.Context, arg SetAPIKeySigningSecretParams) (ApiKey, error)
+	SetUserIPAllowlist(ctx context.Context, arg SetUserIPAllowlistParams) ([]netip.Prefix, error)
+	SetUserPlan(ctx context.Context, arg SetUserPlanParams) (string, error)
+	SoftDeleteUser(ctx context.Context, id string) (User, error)
+	SpendRateLimitTokens(ctx context.Context, bucketKeys []string) error
+	// Where to resume a stream that last saw after_id. Ids are taken at insert but
+	// rows become visible at commit, so an event logged within lag before after_id
+	// may have committed after it; the stream replays from just below the first of
+	// them. after_id itself when it is no longer in the log.
+	StatusEventReplayFrom(ctx context.Context, arg StatusEventReplayFromParams) (int64, error)
+	SubaccountUsage(ctx context.Context, arg SubaccountUsageParams) ([]SubaccountUsageRow, error)
+	TopUp(ctx context.Context, arg TopUpParams) (int32, error)
+	// Throttled to one write per key per minute.
+	TouchAPIKey(ctx context.Context, id string) error
+	// Throttled to one write per binding per minute.
+	TouchClientCertificate(ctx context.Context, id string) error
+	// Thresholds compare against the spendable balance (paid + unexpired promotional).
+	TripBalanceThresholds(ctx context.Context, userID string) ([]TripBalanceThresholdsRow, error)
+	UpdateChildBilling(ctx context.Context, arg UpdateChildBillingParams) (User, error)
+	UpdateMembershipRole(ctx context.Context, arg UpdateMembershipRoleParams) (Membership, error)
+	// suspended: NULL leaves it, true suspends (keeping an earlier suspension's
+	// time), false reinstates. Deleted accounts aren't changed.
+	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
+	// Null arguments keep the current value. Enabling clears the failure streak.
+	UpdateWebhookEndpoint(ctx context.Context, arg UpdateWebhookEndpointParams) (WebhookEndpoint, error)
 }
 
 var _ Querier = (*Queries)(nil)
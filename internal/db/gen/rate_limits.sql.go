@@ -0,0 +1,58 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: rate_limits.sql
+
+package dbgen
+
+import (
+	"context"
+)
+
+const purgeRateLimitBuckets = `-- name: PurgeRateLimitBuckets :execrows
+DELETE FROM rate_limit_buckets WHERE updated_at < now() - interval '1 hour'
+`
+
+// Idle buckets have refilled, so dropping them is lossless.
+func (q *Queries) PurgeRateLimitBuckets(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeRateLimitBuckets)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const refillRateLimitBucket = `-- name: RefillRateLimitBucket :one
+INSERT INTO rate_limit_buckets AS b (bucket_key, tokens, updated_at)
+VALUES ($1, $2::float8, now())
+ON CONFLICT (bucket_key) DO UPDATE
+SET tokens = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8),
+    updated_at = now()
+RETURNING b.tokens
+`
+
+type RefillRateLimitBucketParams struct {
+	BucketKey string  `json:"bucket_key"`
+	Burst     float64 `json:"burst"`
+	Rate      float64 `json:"rate"`
+}
+
+// Refills a bucket (creating it full) and locks it until the end of the tx;
+// returns the tokens it now holds.
+func (q *Queries) RefillRateLimitBucket(ctx context.Context, arg RefillRateLimitBucketParams) (float64, error) {
+	row := q.db.QueryRow(ctx, refillRateLimitBucket, arg.BucketKey, arg.Burst, arg.Rate)
+	var tokens float64
+	err := row.Scan(&tokens)
+	return tokens, err
+}
+
+const spendRateLimitTokens = `-- name: SpendRateLimitTokens :exec
+UPDATE rate_limit_buckets
+SET tokens = tokens - 1
+WHERE bucket_key = ANY($1::text[])
+`
+
+func (q *Queries) SpendRateLimitTokens(ctx context.Context, bucketKeys []string) error {
+	_, err := q.db.Exec(ctx, spendRateLimitTokens, bucketKeys)
+	return err
+}
@@ -0,0 +1,144 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: stats.sql
+
+package dbgen
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const messageFailureStats = `-- name: MessageFailureStats :many
+SELECT date_trunc($1::text, hour, 'UTC')::timestamptz AS bucket,
+       dial_prefix,
+       reason,
+       sum(failed)::int AS failed
+FROM message_failure_stats
+WHERE user_id = $2
+  AND hour >= $3
+  AND hour <  $4
+GROUP BY 1, 2, 3
+HAVING sum(failed) > 0
+ORDER BY 1, 2, 3
+`
+
+type MessageFailureStatsParams struct {
+	Granularity string             `json:"granularity"`
+	UserID      string             `json:"user_id"`
+	FromTs      pgtype.Timestamptz `json:"from_ts"`
+	ToTs        pgtype.Timestamptz `json:"to_ts"`
+}
+
+type MessageFailureStatsRow struct {
+	Bucket     pgtype.Timestamptz `json:"bucket"`
+	DialPrefix string             `json:"dial_prefix"`
+	Reason     string             `json:"reason"`
+	Failed     int32              `json:"failed"`
+}
+
+func (q *Queries) MessageFailureStats(ctx context.Context, arg MessageFailureStatsParams) ([]MessageFailureStatsRow, error) {
+	rows, err := q.db.Query(ctx, messageFailureStats,
+		arg.Granularity,
+		arg.UserID,
+		arg.FromTs,
+		arg.ToTs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MessageFailureStatsRow
+	for rows.Next() {
+		var i MessageFailureStatsRow
+		if err := rows.Scan(
+			&i.Bucket,
+			&i.DialPrefix,
+			&i.Reason,
+			&i.Failed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const messageStats = `-- name: MessageStats :many
+SELECT date_trunc($1::text, hour, 'UTC')::timestamptz AS bucket,
+       dial_prefix,
+       sum(queued)::int            AS queued,
+       sum(sending)::int           AS sending,
+       sum(sent)::int              AS sent,
+       sum(failed)::int            AS failed,
+       sum(spent)::int             AS spent,
+       sum(latency_ms_sum)::bigint AS latency_ms_sum,
+       sum(latency_n)::int         AS latency_n
+FROM message_stats
+WHERE user_id = $2
+  AND hour >= $3
+  AND hour <  $4
+GROUP BY 1, 2
+ORDER BY 1, 2
+`
+
+type MessageStatsParams struct {
+	Granularity string             `json:"granularity"`
+	UserID      string             `json:"user_id"`
+	FromTs      pgtype.Timestamptz `json:"from_ts"`
+	ToTs        pgtype.Timestamptz `json:"to_ts"`
+}
+
+type MessageStatsRow struct {
+	Bucket       pgtype.Timestamptz `json:"bucket"`
+	DialPrefix   string             `json:"dial_prefix"`
+	Queued       int32              `json:"queued"`
+	Sending      int32              `json:"sending"`
+	Sent         int32              `json:"sent"`
+	Failed       int32              `json:"failed"`
+	Spent        int32              `json:"spent"`
+	LatencyMsSum int64              `json:"latency_ms_sum"`
+	LatencyN     int32              `json:"latency_n"`
+}
+
+// Rollup totals per bucket (hour or day, in UTC) and destination prefix for
+// buckets starting in [from_ts, to_ts).
+func (q *Queries) MessageStats(ctx context.Context, arg MessageStatsParams) ([]MessageStatsRow, error) {
+	rows, err := q.db.Query(ctx, messageStats,
+		arg.Granularity,
+		arg.UserID,
+		arg.FromTs,
+		arg.ToTs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MessageStatsRow
+	for rows.Next() {
+		var i MessageStatsRow
+		if err := rows.Scan(
+			&i.Bucket,
+			&i.DialPrefix,
+			&i.Queued,
+			&i.Sending,
+			&i.Sent,
+			&i.Failed,
+			&i.Spent,
+			&i.LatencyMsSum,
+			&i.LatencyN,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -7,10 +7,63 @@ package dbgen
 
 import (
 	"context"
+	"net/netip"
 
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const countLiveChildUsers = `-- name: CountLiveChildUsers :one
+SELECT count(*)::int FROM users
+WHERE parent_id = $1::uuid AND deleted_at IS NULL
+`
+
+func (q *Queries) CountLiveChildUsers(ctx context.Context, parentID string) (int32, error) {
+	row := q.db.QueryRow(ctx, countLiveChildUsers, parentID)
+	var column_1 int32
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const createChildUser = `-- name: CreateChildUser :one
+INSERT INTO users (name, parent_id, billing_mode, quota)
+VALUES ($1, $2::uuid, $3, $4)
+RETURNING id, name, balance, created_at, updated_at, last_served_at, parent_id, billing_mode, quota, quota_used, plan, ip_allowlist, suspended_at, deleted_at
+`
+
+type CreateChildUserParams struct {
+	Name        string      `json:"name"`
+	ParentID    string      `json:"parent_id"`
+	BillingMode string      `json:"billing_mode"`
+	Quota       pgtype.Int4 `json:"quota"`
+}
+
+func (q *Queries) CreateChildUser(ctx context.Context, arg CreateChildUserParams) (User, error) {
+	row := q.db.QueryRow(ctx, createChildUser,
+		arg.Name,
+		arg.ParentID,
+		arg.BillingMode,
+		arg.Quota,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Balance,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LastServedAt,
+		&i.ParentID,
+		&i.BillingMode,
+		&i.Quota,
+		&i.QuotaUsed,
+		&i.Plan,
+		&i.IpAllowlist,
+		&i.SuspendedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (name)
 VALUES ($1)
@@ -57,10 +110,38 @@ func (q *Queries) DebitIfEnough(ctx context.Context, arg DebitIfEnoughParams) (i
 	return result.RowsAffected(), nil
 }
 
+const drawParentQuota = `-- name: DrawParentQuota :execrows
+UPDATE users
+SET quota_used = quota_used + $1::int
+WHERE id = $2
+  AND billing_mode = 'parent'
+  AND (quota IS NULL OR quota_used + $1::int <= quota)
+`
+
+type DrawParentQuotaParams struct {
+	Amount int32  `json:"amount"`
+	ID     string `json:"id"`
+}
+
+// Draws amount against a parent-billed child's quota; 0 rows when it would exceed it.
+func (q *Queries) DrawParentQuota(ctx context.Context, arg DrawParentQuotaParams) (int64, error) {
+	result, err := q.db.Exec(ctx, drawParentQuota, arg.Amount, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const getBalance = `-- name: GetBalance :one
-SELECT balance FROM users WHERE id = $1
+SELECT (u.balance + COALESCE((
+         SELECT SUM(b.remaining) FROM credit_buckets b
+         WHERE b.user_id = u.id AND b.expires_at > now()
+       ), 0))::int AS balance
+FROM users u
+WHERE u.id = $1
 `
 
+// Spendable balance: paid plus unexpired promotional credit.
 func (q *Queries) GetBalance(ctx context.Context, id string) (int32, error) {
 	row := q.db.QueryRow(ctx, getBalance, id)
 	var balance int32
@@ -68,33 +149,216 @@ func (q *Queries) GetBalance(ctx context.Context, id string) (int32, error) {
 	return balance, err
 }
 
+const getBillingInfoForUpdate = `-- name: GetBillingInfoForUpdate :one
+
+SELECT u.parent_id, u.billing_mode,
+       (u.deleted_at IS NOT NULL)::bool                                 AS deleted,
+       (u.suspended_at IS NOT NULL OR p.suspended_at IS NOT NULL)::bool AS suspended
+FROM users u
+LEFT JOIN users p ON p.id = u.parent_id
+WHERE u.id = $1
+FOR UPDATE OF u
+`
+
+type GetBillingInfoForUpdateRow struct {
+	ParentID    pgtype.UUID `json:"parent_id"`
+	BillingMode string      `json:"billing_mode"`
+	Deleted     bool        `json:"deleted"`
+	Suspended   bool        `json:"suspended"`
+}
+
+// ---- Sub-accounts ----
+// Locks the sending user's row; the payer is resolved from billing_mode. A
+// sub-account counts as suspended while its parent is.
+func (q *Queries) GetBillingInfoForUpdate(ctx context.Context, id string) (GetBillingInfoForUpdateRow, error) {
+	row := q.db.QueryRow(ctx, getBillingInfoForUpdate, id)
+	var i GetBillingInfoForUpdateRow
+	err := row.Scan(
+		&i.ParentID,
+		&i.BillingMode,
+		&i.Deleted,
+		&i.Suspended,
+	)
+	return i, err
+}
+
 const getUser = `-- name: GetUser :one
-SELECT id, name, balance, created_at, updated_at
+SELECT id, name, balance, created_at, updated_at, last_served_at, parent_id, billing_mode, quota, quota_used, plan, ip_allowlist, suspended_at, deleted_at
 FROM users
 WHERE id = $1
 `
 
-type GetUserRow struct {
-	ID        string             `json:"id"`
-	Name      string             `json:"name"`
-	Balance   int32              `json:"balance"`
-	CreatedAt pgtype.Timestamptz `json:"created_at"`
-	UpdatedAt pgtype.Timestamptz `json:"updated_at"`
-}
-
-func (q *Queries) GetUser(ctx context.Context, id string) (GetUserRow, error) {
+func (q *Queries) GetUser(ctx context.Context, id string) (User, error) {
 	row := q.db.QueryRow(ctx, getUser, id)
-	var i GetUserRow
+	var i User
 	err := row.Scan(
 		&i.ID,
 		&i.Name,
 		&i.Balance,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.LastServedAt,
+		&i.ParentID,
+		&i.BillingMode,
+		&i.Quota,
+		&i.QuotaUsed,
+		&i.Plan,
+		&i.IpAllowlist,
+		&i.SuspendedAt,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
+const getUserIPAllowlist = `-- name: GetUserIPAllowlist :one
+SELECT ip_allowlist FROM users WHERE id = $1
+`
+
+func (q *Queries) GetUserIPAllowlist(ctx context.Context, id string) ([]netip.Prefix, error) {
+	row := q.db.QueryRow(ctx, getUserIPAllowlist, id)
+	var ip_allowlist []netip.Prefix
+	err := row.Scan(&ip_allowlist)
+	return ip_allowlist, err
+}
+
+const getUserPlan = `-- name: GetUserPlan :one
+SELECT plan FROM users WHERE id = $1
+`
+
+func (q *Queries) GetUserPlan(ctx context.Context, id string) (string, error) {
+	row := q.db.QueryRow(ctx, getUserPlan, id)
+	var plan string
+	err := row.Scan(&plan)
+	return plan, err
+}
+
+const listChildUsers = `-- name: ListChildUsers :many
+SELECT id, name, balance, created_at, updated_at, last_served_at, parent_id, billing_mode, quota, quota_used, plan, ip_allowlist, suspended_at, deleted_at
+FROM users
+WHERE parent_id = $1::uuid
+ORDER BY created_at
+`
+
+func (q *Queries) ListChildUsers(ctx context.Context, parentID string) ([]User, error) {
+	rows, err := q.db.Query(ctx, listChildUsers, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Balance,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.LastServedAt,
+			&i.ParentID,
+			&i.BillingMode,
+			&i.Quota,
+			&i.QuotaUsed,
+			&i.Plan,
+			&i.IpAllowlist,
+			&i.SuspendedAt,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsers = `-- name: ListUsers :many
+SELECT id, name, balance, created_at, updated_at, last_served_at, parent_id, billing_mode, quota, quota_used, plan, ip_allowlist, suspended_at, deleted_at
+FROM users
+WHERE ($1::text IS NULL
+       OR name ILIKE $2::text
+       OR id::text = $1::text)
+  AND CASE $3::text
+        WHEN 'active'    THEN deleted_at IS NULL AND suspended_at IS NULL
+        WHEN 'suspended' THEN deleted_at IS NULL AND suspended_at IS NOT NULL
+        WHEN 'deleted'   THEN deleted_at IS NOT NULL
+        ELSE deleted_at IS NULL
+      END
+  AND ($4::timestamptz IS NULL
+       OR (created_at, id) < ($4::timestamptz, $5::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT $6
+`
+
+type ListUsersParams struct {
+	Search      pgtype.Text        `json:"search"`
+	NamePattern pgtype.Text        `json:"name_pattern"`
+	Status      pgtype.Text        `json:"status"`
+	CursorTs    pgtype.Timestamptz `json:"cursor_ts"`
+	CursorID    pgtype.UUID        `json:"cursor_id"`
+	LimitN      int32              `json:"limit_n"`
+}
+
+// Newest first, keyset-paged by (created_at, id). A search matches names by
+// name_pattern (ILIKE) or an exact id. status is active, suspended or deleted;
+// without it every account that isn't deleted is listed.
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error) {
+	rows, err := q.db.Query(ctx, listUsers,
+		arg.Search,
+		arg.NamePattern,
+		arg.Status,
+		arg.CursorTs,
+		arg.CursorID,
+		arg.LimitN,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Balance,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.LastServedAt,
+			&i.ParentID,
+			&i.BillingMode,
+			&i.Quota,
+			&i.QuotaUsed,
+			&i.Plan,
+			&i.IpAllowlist,
+			&i.SuspendedAt,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockLiveUser = `-- name: LockLiveUser :one
+SELECT id FROM users WHERE id = $1 AND deleted_at IS NULL FOR KEY SHARE
+`
+
+// Serializes balance changes for one user; no rows means the user doesn't exist.
+// No rows for a deleted account. Holds off DeleteUser (which locks FOR
+// UPDATE) until the tx ends, so anything attached meanwhile is revoked with it.
+func (q *Queries) LockLiveUser(ctx context.Context, id string) (string, error) {
+	row := q.db.QueryRow(ctx, lockLiveUser, id)
+	err := row.Scan(&id)
+	return id, err
+}
+
 const lockUser = `-- name: LockUser :exec
 SELECT 1 FROM users WHERE id = $1 FOR UPDATE
 `
@@ -105,10 +369,164 @@ func (q *Queries) LockUser(ctx context.Context, id string) error {
 	return err
 }
 
-const topUp = `-- name: TopUp :exec
+const lockUserBalance = `-- name: LockUserBalance :one
+SELECT balance FROM users WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) LockUserBalance(ctx context.Context, id string) (int32, error) {
+	row := q.db.QueryRow(ctx, lockUserBalance, id)
+	var balance int32
+	err := row.Scan(&balance)
+	return balance, err
+}
+
+const setUserIPAllowlist = `-- name: SetUserIPAllowlist :one
+UPDATE users u
+SET ip_allowlist = $1::cidr[], updated_at = now()
+FROM (SELECT o.id, o.ip_allowlist FROM users o WHERE o.id = $2 FOR UPDATE) old
+WHERE u.id = old.id
+RETURNING old.ip_allowlist AS previous_allowlist
+`
+
+type SetUserIPAllowlistParams struct {
+	IpAllowlist []netip.Prefix `json:"ip_allowlist"`
+	ID          string         `json:"id"`
+}
+
+func (q *Queries) SetUserIPAllowlist(ctx context.Context, arg SetUserIPAllowlistParams) ([]netip.Prefix, error) {
+	row := q.db.QueryRow(ctx, setUserIPAllowlist, arg.IpAllowlist, arg.ID)
+	var previous_allowlist []netip.Prefix
+	err := row.Scan(&previous_allowlist)
+	return previous_allowlist, err
+}
+
+const setUserPlan = `-- name: SetUserPlan :one
+UPDATE users u
+SET plan = $1, updated_at = now()
+FROM (SELECT o.id, o.plan FROM users o WHERE o.id = $2 FOR UPDATE) old
+WHERE u.id = old.id
+RETURNING old.plan AS previous_plan
+`
+
+type SetUserPlanParams struct {
+	Plan string `json:"plan"`
+	ID   string `json:"id"`
+}
+
+func (q *Queries) SetUserPlan(ctx context.Context, arg SetUserPlanParams) (string, error) {
+	row := q.db.QueryRow(ctx, setUserPlan, arg.Plan, arg.ID)
+	var previous_plan string
+	err := row.Scan(&previous_plan)
+	return previous_plan, err
+}
+
+const softDeleteUser = `-- name: SoftDeleteUser :one
+UPDATE users
+SET deleted_at = now(), updated_at = now()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, name, balance, created_at, updated_at, last_served_at, parent_id, billing_mode, quota, quota_used, plan, ip_allowlist, suspended_at, deleted_at
+`
+
+func (q *Queries) SoftDeleteUser(ctx context.Context, id string) (User, error) {
+	row := q.db.QueryRow(ctx, softDeleteUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Balance,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LastServedAt,
+		&i.ParentID,
+		&i.BillingMode,
+		&i.Quota,
+		&i.QuotaUsed,
+		&i.Plan,
+		&i.IpAllowlist,
+		&i.SuspendedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const subaccountUsage = `-- name: SubaccountUsage :many
+SELECT u.id, u.name, u.parent_id, u.billing_mode, u.balance, u.quota, u.quota_used,
+       COUNT(m.id)::bigint                                                   AS messages,
+       COUNT(m.id) FILTER (WHERE m.status = 'sent')::bigint                  AS sent,
+       COUNT(m.id) FILTER (WHERE m.status = 'failed')::bigint                AS failed,
+       COUNT(m.id) FILTER (WHERE m.status IN ('queued','sending'))::bigint   AS pending,
+       COUNT(m.id) FILTER (WHERE m.status <> 'failed'
+                             AND m.billed_user_id IS DISTINCT FROM m.user_id
+                             AND m.billed_user_id IS NOT NULL)::bigint       AS billed_to_parent
+FROM users u
+LEFT JOIN messages m
+  ON m.user_id = u.id
+ AND ($1::timestamptz IS NULL OR m.requested_at >= $1::timestamptz)
+ AND ($2::timestamptz   IS NULL OR m.requested_at <  $2::timestamptz)
+WHERE u.id = $3 OR u.parent_id = $3::uuid
+GROUP BY u.id
+ORDER BY u.parent_id NULLS FIRST, u.created_at
+`
+
+type SubaccountUsageParams struct {
+	FromTs   pgtype.Timestamptz `json:"from_ts"`
+	ToTs     pgtype.Timestamptz `json:"to_ts"`
+	ParentID string             `json:"parent_id"`
+}
+
+type SubaccountUsageRow struct {
+	ID             string      `json:"id"`
+	Name           string      `json:"name"`
+	ParentID       pgtype.UUID `json:"parent_id"`
+	BillingMode    string      `json:"billing_mode"`
+	Balance        int32       `json:"balance"`
+	Quota          pgtype.Int4 `json:"quota"`
+	QuotaUsed      int32       `json:"quota_used"`
+	Messages       int64       `json:"messages"`
+	Sent           int64       `json:"sent"`
+	Failed         int64       `json:"failed"`
+	Pending        int64       `json:"pending"`
+	BilledToParent int64       `json:"billed_to_parent"`
+}
+
+func (q *Queries) SubaccountUsage(ctx context.Context, arg SubaccountUsageParams) ([]SubaccountUsageRow, error) {
+	rows, err := q.db.Query(ctx, subaccountUsage, arg.FromTs, arg.ToTs, arg.ParentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SubaccountUsageRow
+	for rows.Next() {
+		var i SubaccountUsageRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.ParentID,
+			&i.BillingMode,
+			&i.Balance,
+			&i.Quota,
+			&i.QuotaUsed,
+			&i.Messages,
+			&i.Sent,
+			&i.Failed,
+			&i.Pending,
+			&i.BilledToParent,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const topUp = `-- name: TopUp :one
 UPDATE users
 SET balance = balance + $1
 WHERE id = $2
+RETURNING balance
 `
 
 type TopUpParams struct {
@@ -116,7 +534,95 @@ type TopUpParams struct {
 	ID      string `json:"id"`
 }
 
-func (q *Queries) TopUp(ctx context.Context, arg TopUpParams) error {
-	_, err := q.db.Exec(ctx, topUp, arg.Balance, arg.ID)
-	return err
+func (q *Queries) TopUp(ctx context.Context, arg TopUpParams) (int32, error) {
+	row := q.db.QueryRow(ctx, topUp, arg.Balance, arg.ID)
+	var balance int32
+	err := row.Scan(&balance)
+	return balance, err
+}
+
+const updateChildBilling = `-- name: UpdateChildBilling :one
+UPDATE users
+SET billing_mode = COALESCE($1, billing_mode),
+    quota        = CASE WHEN $2::bool THEN $3::int ELSE quota END
+WHERE id = $4 AND parent_id = $5::uuid
+RETURNING id, name, balance, created_at, updated_at, last_served_at, parent_id, billing_mode, quota, quota_used, plan, ip_allowlist, suspended_at, deleted_at
+`
+
+type UpdateChildBillingParams struct {
+	BillingMode pgtype.Text `json:"billing_mode"`
+	SetQuota    bool        `json:"set_quota"`
+	Quota       pgtype.Int4 `json:"quota"`
+	ID          string      `json:"id"`
+	ParentID    string      `json:"parent_id"`
+}
+
+func (q *Queries) UpdateChildBilling(ctx context.Context, arg UpdateChildBillingParams) (User, error) {
+	row := q.db.QueryRow(ctx, updateChildBilling,
+		arg.BillingMode,
+		arg.SetQuota,
+		arg.Quota,
+		arg.ID,
+		arg.ParentID,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Balance,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LastServedAt,
+		&i.ParentID,
+		&i.BillingMode,
+		&i.Quota,
+		&i.QuotaUsed,
+		&i.Plan,
+		&i.IpAllowlist,
+		&i.SuspendedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const updateUser = `-- name: UpdateUser :one
+UPDATE users
+SET name         = COALESCE($1, name),
+    suspended_at = CASE
+                     WHEN $2::bool IS NULL THEN suspended_at
+                     WHEN $2::bool THEN COALESCE(suspended_at, now())
+                   END,
+    updated_at   = now()
+WHERE id = $3 AND deleted_at IS NULL
+RETURNING id, name, balance, created_at, updated_at, last_served_at, parent_id, billing_mode, quota, quota_used, plan, ip_allowlist, suspended_at, deleted_at
+`
+
+type UpdateUserParams struct {
+	Name      pgtype.Text `json:"name"`
+	Suspended pgtype.Bool `json:"suspended"`
+	ID        string      `json:"id"`
+}
+
+// suspended: NULL leaves it, true suspends (keeping an earlier suspension's
+// time), false reinstates. Deleted accounts aren't changed.
+func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
+	row := q.db.QueryRow(ctx, updateUser, arg.Name, arg.Suspended, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Balance,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LastServedAt,
+		&i.ParentID,
+		&i.BillingMode,
+		&i.Quota,
+		&i.QuotaUsed,
+		&i.Plan,
+		&i.IpAllowlist,
+		&i.SuspendedAt,
+		&i.DeletedAt,
+	)
+	return i, err
 }
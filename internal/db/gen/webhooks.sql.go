@@ -0,0 +1,537 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: webhooks.sql
+
+package dbgen
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const claimWebhookDeliveries = `-- name: ClaimWebhookDeliveries :many
+WITH due AS (
+  SELECT d.id
+  FROM webhook_deliveries d
+  JOIN webhook_endpoints e ON e.id = d.endpoint_id
+  WHERE d.delivered_at IS NULL
+    AND d.failed_at IS NULL
+    AND d.next_attempt_at <= now()
+    AND e.disabled_at IS NULL
+  ORDER BY d.next_attempt_at
+  LIMIT $1
+  FOR UPDATE OF d SKIP LOCKED
+),
+claimed AS (
+  UPDATE webhook_deliveries d
+  SET attempts = d.attempts + 1,
+      next_attempt_at = now() + $2::int * interval '1 second'
+  FROM due
+  WHERE d.id = due.id
+  RETURNING d.id, d.event_id, d.endpoint_id, d.attempts
+)
+SELECT c.id, c.attempts, c.endpoint_id, e.url, e.secret, c.event_id, ev.type, ev.payload, ev.created_at
+FROM claimed c
+JOIN webhook_endpoints e ON e.id = c.endpoint_id
+JOIN webhook_events ev ON ev.id = c.event_id
+`
+
+type ClaimWebhookDeliveriesParams struct {
+	LimitN       int32 `json:"limit_n"`
+	LeaseSeconds int32 `json:"lease_seconds"`
+}
+
+type ClaimWebhookDeliveriesRow struct {
+	ID         int64              `json:"id"`
+	Attempts   int32              `json:"attempts"`
+	EndpointID string             `json:"endpoint_id"`
+	Url        string             `json:"url"`
+	Secret     []byte             `json:"secret"`
+	EventID    string             `json:"event_id"`
+	Type       string             `json:"type"`
+	Payload    []byte             `json:"payload"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+// Pushes next_attempt_at forward as a lease, as for balance notifications.
+// Deliveries to disabled endpoints are not picked up.
+func (q *Queries) ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]ClaimWebhookDeliveriesRow, error) {
+	rows, err := q.db.Query(ctx, claimWebhookDeliveries, arg.LimitN, arg.LeaseSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimWebhookDeliveriesRow
+	for rows.Next() {
+		var i ClaimWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Attempts,
+			&i.EndpointID,
+			&i.Url,
+			&i.Secret,
+			&i.EventID,
+			&i.Type,
+			&i.Payload,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteWebhookEndpoint = `-- name: DeleteWebhookEndpoint :one
+DELETE FROM webhook_endpoints
+WHERE id = $1 AND user_id = $2
+RETURNING id, user_id, url, secret, event_types, description, consecutive_failures, disabled_at, created_at, updated_at
+`
+
+type DeleteWebhookEndpointParams struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+}
+
+func (q *Queries) DeleteWebhookEndpoint(ctx context.Context, arg DeleteWebhookEndpointParams) (WebhookEndpoint, error) {
+	row := q.db.QueryRow(ctx, deleteWebhookEndpoint, arg.ID, arg.UserID)
+	var i WebhookEndpoint
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Url,
+		&i.Secret,
+		&i.EventTypes,
+		&i.Description,
+		&i.ConsecutiveFailures,
+		&i.DisabledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const failPendingWebhookDeliveries = `-- name: FailPendingWebhookDeliveries :exec
+UPDATE webhook_deliveries
+SET failed_at = now(), last_error = $1
+WHERE endpoint_id = $2 AND delivered_at IS NULL AND failed_at IS NULL
+`
+
+type FailPendingWebhookDeliveriesParams struct {
+	Reason     pgtype.Text `json:"reason"`
+	EndpointID string      `json:"endpoint_id"`
+}
+
+// Gives up on an endpoint's pending deliveries; they can be replayed once it
+// is enabled again.
+func (q *Queries) FailPendingWebhookDeliveries(ctx context.Context, arg FailPendingWebhookDeliveriesParams) error {
+	_, err := q.db.Exec(ctx, failPendingWebhookDeliveries, arg.Reason, arg.EndpointID)
+	return err
+}
+
+const failWebhookDelivery = `-- name: FailWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET failed_at = now(), response_status = $1, last_error = $2
+WHERE id = $3
+`
+
+type FailWebhookDeliveryParams struct {
+	ResponseStatus pgtype.Int4 `json:"response_status"`
+	LastError      pgtype.Text `json:"last_error"`
+	ID             int64       `json:"id"`
+}
+
+func (q *Queries) FailWebhookDelivery(ctx context.Context, arg FailWebhookDeliveryParams) error {
+	_, err := q.db.Exec(ctx, failWebhookDelivery, arg.ResponseStatus, arg.LastError, arg.ID)
+	return err
+}
+
+const getWebhookEndpointForUpdate = `-- name: GetWebhookEndpointForUpdate :one
+SELECT id, user_id, url, secret, event_types, description, consecutive_failures, disabled_at, created_at, updated_at FROM webhook_endpoints
+WHERE id = $1 AND user_id = $2
+FOR UPDATE
+`
+
+type GetWebhookEndpointForUpdateParams struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+}
+
+func (q *Queries) GetWebhookEndpointForUpdate(ctx context.Context, arg GetWebhookEndpointForUpdateParams) (WebhookEndpoint, error) {
+	row := q.db.QueryRow(ctx, getWebhookEndpointForUpdate, arg.ID, arg.UserID)
+	var i WebhookEndpoint
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Url,
+		&i.Secret,
+		&i.EventTypes,
+		&i.Description,
+		&i.ConsecutiveFailures,
+		&i.DisabledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const insertWebhookEndpoint = `-- name: InsertWebhookEndpoint :one
+INSERT INTO webhook_endpoints (user_id, url, secret, event_types, description)
+VALUES ($1, $2, $3, $4::text[], $5)
+RETURNING id, user_id, url, secret, event_types, description, consecutive_failures, disabled_at, created_at, updated_at
+`
+
+type InsertWebhookEndpointParams struct {
+	UserID      string   `json:"user_id"`
+	Url         string   `json:"url"`
+	Secret      []byte   `json:"secret"`
+	EventTypes  []string `json:"event_types"`
+	Description string   `json:"description"`
+}
+
+func (q *Queries) InsertWebhookEndpoint(ctx context.Context, arg InsertWebhookEndpointParams) (WebhookEndpoint, error) {
+	row := q.db.QueryRow(ctx, insertWebhookEndpoint,
+		arg.UserID,
+		arg.Url,
+		arg.Secret,
+		arg.EventTypes,
+		arg.Description,
+	)
+	var i WebhookEndpoint
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Url,
+		&i.Secret,
+		&i.EventTypes,
+		&i.Description,
+		&i.ConsecutiveFailures,
+		&i.DisabledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const insertWebhookEvent = `-- name: InsertWebhookEvent :exec
+WITH subs AS (
+  SELECT e.id FROM webhook_endpoints e
+  WHERE e.user_id = $1::uuid
+    AND e.disabled_at IS NULL
+    AND $2::text = ANY(e.event_types)
+),
+ev AS (
+  INSERT INTO webhook_events (user_id, type, payload)
+  SELECT $1::uuid, $2::text, $3::jsonb
+  WHERE EXISTS (SELECT 1 FROM subs)
+  RETURNING id
+)
+INSERT INTO webhook_deliveries (event_id, endpoint_id)
+SELECT ev.id, subs.id FROM ev CROSS JOIN subs
+`
+
+type InsertWebhookEventParams struct {
+	UserID  string `json:"user_id"`
+	Type    string `json:"type"`
+	Payload []byte `json:"payload"`
+}
+
+// Writes the event and one delivery per enabled endpoint of the user that is
+// subscribed to its type. Nothing is written when there is none.
+func (q *Queries) InsertWebhookEvent(ctx context.Context, arg InsertWebhookEventParams) error {
+	_, err := q.db.Exec(ctx, insertWebhookEvent, arg.UserID, arg.Type, arg.Payload)
+	return err
+}
+
+const listWebhookDeliveries = `-- name: ListWebhookDeliveries :many
+SELECT d.id, d.event_id, ev.type, d.attempts, d.next_attempt_at, d.delivered_at, d.failed_at,
+       d.response_status, d.last_error, d.created_at
+FROM webhook_deliveries d
+JOIN webhook_endpoints e ON e.id = d.endpoint_id
+JOIN webhook_events ev ON ev.id = d.event_id
+WHERE d.endpoint_id = $1 AND e.user_id = $2
+  AND ($3::bigint IS NULL OR d.id < $3::bigint)
+ORDER BY d.id DESC
+LIMIT $4
+`
+
+type ListWebhookDeliveriesParams struct {
+	EndpointID string      `json:"endpoint_id"`
+	UserID     string      `json:"user_id"`
+	BeforeID   pgtype.Int8 `json:"before_id"`
+	LimitN     int32       `json:"limit_n"`
+}
+
+type ListWebhookDeliveriesRow struct {
+	ID             int64              `json:"id"`
+	EventID        string             `json:"event_id"`
+	Type           string             `json:"type"`
+	Attempts       int32              `json:"attempts"`
+	NextAttemptAt  pgtype.Timestamptz `json:"next_attempt_at"`
+	DeliveredAt    pgtype.Timestamptz `json:"delivered_at"`
+	FailedAt       pgtype.Timestamptz `json:"failed_at"`
+	ResponseStatus pgtype.Int4        `json:"response_status"`
+	LastError      pgtype.Text        `json:"last_error"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+}
+
+// Newest first; before_id pages back from an earlier page's last id.
+func (q *Queries) ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]ListWebhookDeliveriesRow, error) {
+	rows, err := q.db.Query(ctx, listWebhookDeliveries,
+		arg.EndpointID,
+		arg.UserID,
+		arg.BeforeID,
+		arg.LimitN,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListWebhookDeliveriesRow
+	for rows.Next() {
+		var i ListWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.EventID,
+			&i.Type,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.DeliveredAt,
+			&i.FailedAt,
+			&i.ResponseStatus,
+			&i.LastError,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookEndpoints = `-- name: ListWebhookEndpoints :many
+SELECT id, user_id, url, secret, event_types, description, consecutive_failures, disabled_at, created_at, updated_at FROM webhook_endpoints
+WHERE user_id = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListWebhookEndpoints(ctx context.Context, userID string) ([]WebhookEndpoint, error) {
+	rows, err := q.db.Query(ctx, listWebhookEndpoints, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookEndpoint
+	for rows.Next() {
+		var i WebhookEndpoint
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Url,
+			&i.Secret,
+			&i.EventTypes,
+			&i.Description,
+			&i.ConsecutiveFailures,
+			&i.DisabledAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markWebhookDelivered = `-- name: MarkWebhookDelivered :exec
+WITH d AS (
+  UPDATE webhook_deliveries w
+  SET delivered_at = now(), response_status = $1, last_error = NULL
+  WHERE w.id = $2
+  RETURNING w.endpoint_id
+)
+UPDATE webhook_endpoints e
+SET consecutive_failures = 0
+FROM d
+WHERE e.id = d.endpoint_id AND e.consecutive_failures <> 0
+`
+
+type MarkWebhookDeliveredParams struct {
+	ResponseStatus pgtype.Int4 `json:"response_status"`
+	ID             int64       `json:"id"`
+}
+
+func (q *Queries) MarkWebhookDelivered(ctx context.Context, arg MarkWebhookDeliveredParams) error {
+	_, err := q.db.Exec(ctx, markWebhookDelivered, arg.ResponseStatus, arg.ID)
+	return err
+}
+
+const recordWebhookEndpointFailure = `-- name: RecordWebhookEndpointFailure :one
+UPDATE webhook_endpoints e
+SET consecutive_failures = e.consecutive_failures + 1,
+    disabled_at = CASE
+                    WHEN e.disabled_at IS NULL AND e.consecutive_failures + 1 >= $1::int THEN now()
+                    ELSE e.disabled_at
+                  END
+FROM (SELECT o.id, o.disabled_at FROM webhook_endpoints o WHERE o.id = $2 FOR UPDATE) old
+WHERE e.id = old.id
+RETURNING e.user_id, (old.disabled_at IS NULL AND e.disabled_at IS NOT NULL)::bool AS disabled
+`
+
+type RecordWebhookEndpointFailureParams struct {
+	DisableAfter int32  `json:"disable_after"`
+	ID           string `json:"id"`
+}
+
+type RecordWebhookEndpointFailureRow struct {
+	UserID   string `json:"user_id"`
+	Disabled bool   `json:"disabled"`
+}
+
+// Counts a failed attempt against the endpoint and disables it once
+// disable_after attempts in a row have failed. disabled is true only for the
+// failure that disabled it.
+func (q *Queries) RecordWebhookEndpointFailure(ctx context.Context, arg RecordWebhookEndpointFailureParams) (RecordWebhookEndpointFailureRow, error) {
+	row := q.db.QueryRow(ctx, recordWebhookEndpointFailure, arg.DisableAfter, arg.ID)
+	var i RecordWebhookEndpointFailureRow
+	err := row.Scan(&i.UserID, &i.Disabled)
+	return i, err
+}
+
+const replayWebhookDelivery = `-- name: ReplayWebhookDelivery :one
+WITH ins AS (
+  INSERT INTO webhook_deliveries (event_id, endpoint_id)
+  SELECT d.event_id, d.endpoint_id
+  FROM webhook_deliveries d
+  WHERE d.id = $1 AND d.endpoint_id = $2
+  RETURNING id, event_id, endpoint_id, attempts, next_attempt_at, delivered_at, failed_at, response_status, last_error, created_at
+)
+SELECT ins.id, ins.event_id, ev.type, ins.attempts, ins.next_attempt_at, ins.delivered_at, ins.failed_at,
+       ins.response_status, ins.last_error, ins.created_at
+FROM ins
+JOIN webhook_events ev ON ev.id = ins.event_id
+`
+
+type ReplayWebhookDeliveryParams struct {
+	ID         int64  `json:"id"`
+	EndpointID string `json:"endpoint_id"`
+}
+
+type ReplayWebhookDeliveryRow struct {
+	ID             int64              `json:"id"`
+	EventID        string             `json:"event_id"`
+	Type           string             `json:"type"`
+	Attempts       int32              `json:"attempts"`
+	NextAttemptAt  pgtype.Timestamptz `json:"next_attempt_at"`
+	DeliveredAt    pgtype.Timestamptz `json:"delivered_at"`
+	FailedAt       pgtype.Timestamptz `json:"failed_at"`
+	ResponseStatus pgtype.Int4        `json:"response_status"`
+	LastError      pgtype.Text        `json:"last_error"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+}
+
+// Queues the event of an earlier delivery to its endpoint again. Same columns
+// as ListWebhookDeliveries.
+func (q *Queries) ReplayWebhookDelivery(ctx context.Context, arg ReplayWebhookDeliveryParams) (ReplayWebhookDeliveryRow, error) {
+	row := q.db.QueryRow(ctx, replayWebhookDelivery, arg.ID, arg.EndpointID)
+	var i ReplayWebhookDeliveryRow
+	err := row.Scan(
+		&i.ID,
+		&i.EventID,
+		&i.Type,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.DeliveredAt,
+		&i.FailedAt,
+		&i.ResponseStatus,
+		&i.LastError,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const retryWebhookDelivery = `-- name: RetryWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET next_attempt_at = now() + $1::int * interval '1 second',
+    response_status = $2,
+    last_error = $3
+WHERE id = $4
+`
+
+type RetryWebhookDeliveryParams struct {
+	DelaySeconds   int32       `json:"delay_seconds"`
+	ResponseStatus pgtype.Int4 `json:"response_status"`
+	LastError      pgtype.Text `json:"last_error"`
+	ID             int64       `json:"id"`
+}
+
+func (q *Queries) RetryWebhookDelivery(ctx context.Context, arg RetryWebhookDeliveryParams) error {
+	_, err := q.db.Exec(ctx, retryWebhookDelivery,
+		arg.DelaySeconds,
+		arg.ResponseStatus,
+		arg.LastError,
+		arg.ID,
+	)
+	return err
+}
+
+const updateWebhookEndpoint = `-- name: UpdateWebhookEndpoint :one
+UPDATE webhook_endpoints
+SET url                  = COALESCE($1, url),
+    event_types          = COALESCE($2::text[], event_types),
+    description          = COALESCE($3, description),
+    disabled_at          = CASE
+                             WHEN $4::bool IS NULL THEN disabled_at
+                             WHEN $4::bool THEN NULL
+                             ELSE COALESCE(disabled_at, now())
+                           END,
+    consecutive_failures = CASE WHEN $4::bool THEN 0 ELSE consecutive_failures END
+WHERE id = $5 AND user_id = $6
+RETURNING id, user_id, url, secret, event_types, description, consecutive_failures, disabled_at, created_at, updated_at
+`
+
+type UpdateWebhookEndpointParams struct {
+	Url         pgtype.Text `json:"url"`
+	EventTypes  []string    `json:"event_types"`
+	Description pgtype.Text `json:"description"`
+	Enabled     pgtype.Bool `json:"enabled"`
+	ID          string      `json:"id"`
+	UserID      string      `json:"user_id"`
+}
+
+// Null arguments keep the current value. Enabling clears the failure streak.
+func (q *Queries) UpdateWebhookEndpoint(ctx context.Context, arg UpdateWebhookEndpointParams) (WebhookEndpoint, error) {
+	row := q.db.QueryRow(ctx, updateWebhookEndpoint,
+		arg.Url,
+		arg.EventTypes,
+		arg.Description,
+		arg.Enabled,
+		arg.ID,
+		arg.UserID,
+	)
+	var i WebhookEndpoint
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Url,
+		&i.Secret,
+		&i.EventTypes,
+		&i.Description,
+		&i.ConsecutiveFailures,
+		&i.DisabledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
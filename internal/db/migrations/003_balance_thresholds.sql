@@ -0,0 +1,40 @@
+-- 003_balance_thresholds.sql — low-balance notifications
+
+-- A threshold fires once when a debit takes the balance below it, then stays
+-- disarmed until the balance is back at or above it.
+CREATE TABLE balance_thresholds (
+  id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  threshold     INTEGER NOT NULL CHECK (threshold > 0),
+  webhook_url   TEXT,
+  notify_msisdn TEXT,
+  armed         BOOLEAN NOT NULL DEFAULT true,
+  last_fired_at TIMESTAMPTZ,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT balance_thresholds_user_id_threshold_key UNIQUE (user_id, threshold),
+  CONSTRAINT balance_thresholds_has_channel CHECK (webhook_url IS NOT NULL OR notify_msisdn IS NOT NULL)
+);
+
+-- Outbox: one row per (fired threshold, channel), drained by the worker.
+CREATE TABLE balance_notifications (
+  id              BIGSERIAL PRIMARY KEY,
+  user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  threshold_id    UUID REFERENCES balance_thresholds(id) ON DELETE SET NULL,
+  threshold       INTEGER NOT NULL,
+  balance         INTEGER NOT NULL,
+  channel         TEXT NOT NULL CHECK (channel IN ('webhook','sms')),
+  target          TEXT NOT NULL,               -- URL or MSISDN, snapshotted at fire time
+  attempts        INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  delivered_at    TIMESTAMPTZ,
+  failed_at       TIMESTAMPTZ,
+  last_error      TEXT,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX balance_notifications_pending_idx
+  ON balance_notifications (next_attempt_at)
+  WHERE delivered_at IS NULL AND failed_at IS NULL;
+
+CREATE TRIGGER balance_thresholds_updated_at BEFORE UPDATE ON balance_thresholds FOR EACH ROW EXECUTE FUNCTION set_updated_at();
@@ -0,0 +1,20 @@
+-- 004_ledger.sql — balance history; top-ups are idempotent per (user, key)
+
+CREATE TABLE ledger_entries (
+  id              BIGSERIAL PRIMARY KEY,
+  user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  kind            TEXT NOT NULL,
+  amount          INTEGER NOT NULL,            -- signed change to the balance
+  balance_after   INTEGER NOT NULL,
+  idempotency_key TEXT,                        -- NULL when unused
+  external_ref    TEXT,                        -- e.g. payment provider transaction id
+  note            TEXT,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT ledger_entries_kind_check CHECK (kind IN ('topup'))
+);
+
+CREATE UNIQUE INDEX ledger_entries_user_id_idempotency_key_idx
+  ON ledger_entries(user_id, idempotency_key)
+  WHERE idempotency_key IS NOT NULL;
+
+CREATE INDEX ledger_entries_user_id_created_at_idx ON ledger_entries(user_id, created_at);
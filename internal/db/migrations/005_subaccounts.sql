@@ -0,0 +1,29 @@
+-- 005_subaccounts.sql — reseller sub-accounts
+--
+-- A child either pays from its own balance ('own') or draws on its parent's
+-- balance ('parent'), capped by quota (NULL = uncapped). quota_used counts
+-- credits drawn from the parent and is given back on refund.
+-- Lock order for balance changes spanning a family: child row first, then parent.
+
+ALTER TABLE users
+  ADD COLUMN parent_id    UUID REFERENCES users(id) ON DELETE RESTRICT,
+  ADD COLUMN billing_mode TEXT NOT NULL DEFAULT 'own',
+  ADD COLUMN quota        INTEGER,
+  ADD COLUMN quota_used   INTEGER NOT NULL DEFAULT 0,
+  ADD CONSTRAINT users_billing_mode_check CHECK (billing_mode IN ('own','parent')),
+  ADD CONSTRAINT users_parent_billing_needs_parent CHECK (billing_mode = 'own' OR parent_id IS NOT NULL),
+  ADD CONSTRAINT users_quota_nonnegative CHECK (quota IS NULL OR quota >= 0),
+  ADD CONSTRAINT users_quota_used_nonnegative CHECK (quota_used >= 0),
+  ADD CONSTRAINT users_not_own_parent CHECK (parent_id <> id);
+
+CREATE INDEX users_parent_id_idx ON users (parent_id) WHERE parent_id IS NOT NULL;
+
+-- Who was debited for the message; NULL on rows created before sub-accounts means user_id.
+ALTER TABLE messages
+  ADD COLUMN billed_user_id UUID REFERENCES users(id) ON DELETE SET NULL;
+
+-- Transfers between a parent and its children are recorded on both sides.
+ALTER TABLE ledger_entries
+  ADD COLUMN counterparty_id UUID REFERENCES users(id) ON DELETE SET NULL,
+  DROP CONSTRAINT ledger_entries_kind_check,
+  ADD CONSTRAINT ledger_entries_kind_check CHECK (kind IN ('topup','transfer_in','transfer_out'));
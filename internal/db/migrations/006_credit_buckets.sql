@@ -0,0 +1,29 @@
+-- 006_credit_buckets.sql — promotional credit with expiry
+--
+-- users.balance stays the paid balance. Promotional grants live in buckets that
+-- are spent before paid credit, earliest-expiring first. The worker zeroes
+-- buckets past expires_at and writes a 'promo_expire' ledger entry.
+
+CREATE TABLE credit_buckets (
+  id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  amount     INTEGER NOT NULL CHECK (amount > 0),      -- as granted
+  remaining  INTEGER NOT NULL CHECK (remaining >= 0),
+  expires_at TIMESTAMPTZ NOT NULL,
+  expired_at TIMESTAMPTZ,                              -- set when swept
+  note       TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX credit_buckets_user_id_expires_at_idx ON credit_buckets (user_id, expires_at) WHERE remaining > 0;
+CREATE INDEX credit_buckets_expires_at_idx         ON credit_buckets (expires_at)          WHERE remaining > 0;
+
+-- Which bucket paid for the message; NULL means paid balance. Refunds go back there.
+ALTER TABLE messages
+  ADD COLUMN credit_bucket_id UUID REFERENCES credit_buckets(id) ON DELETE SET NULL;
+
+ALTER TABLE ledger_entries
+  ADD COLUMN credit_bucket_id UUID REFERENCES credit_buckets(id) ON DELETE SET NULL,
+  DROP CONSTRAINT ledger_entries_kind_check,
+  ADD CONSTRAINT ledger_entries_kind_check
+    CHECK (kind IN ('topup','transfer_in','transfer_out','promo_grant','promo_expire'));
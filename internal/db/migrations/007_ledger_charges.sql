@@ -0,0 +1,32 @@
+-- 007_ledger_charges.sql — charges and refunds in the ledger
+--
+-- Every balance movement now has a ledger entry, so a statement for any period
+-- is opening balance + entries = closing balance. Charges and refunds point at
+-- the message so they can be broken down by destination and status.
+
+ALTER TABLE ledger_entries
+  ADD COLUMN message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+  DROP CONSTRAINT ledger_entries_kind_check,
+  ADD CONSTRAINT ledger_entries_kind_check
+    CHECK (kind IN ('topup','transfer_in','transfer_out','promo_grant','promo_expire',
+                    'charge','refund','adjustment'));
+
+CREATE INDEX ledger_entries_message_id_idx ON ledger_entries (message_id) WHERE message_id IS NOT NULL;
+
+-- Charges before this migration were never recorded. One 'adjustment' per user
+-- brings the ledger in line with the current balance so later statements add up.
+INSERT INTO ledger_entries (user_id, kind, amount, balance_after, note)
+SELECT u.id, 'adjustment', cur.total - COALESCE(last.balance_after, 0), cur.total,
+       'opening reconciliation'
+FROM users u
+CROSS JOIN LATERAL (
+  SELECT u.balance + COALESCE((SELECT SUM(b.remaining) FROM credit_buckets b WHERE b.user_id = u.id), 0)::int AS total
+) cur
+LEFT JOIN LATERAL (
+  SELECT l.balance_after
+  FROM ledger_entries l
+  WHERE l.user_id = u.id
+  ORDER BY l.id DESC
+  LIMIT 1
+) last ON true
+WHERE cur.total <> COALESCE(last.balance_after, 0);
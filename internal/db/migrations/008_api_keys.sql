@@ -0,0 +1,20 @@
+-- 008_api_keys.sql — per-user API keys
+--
+-- Only a SHA-256 of the full token is stored; the prefix is the public,
+-- unique part used to find the row. Rotation gives the old key an expires_at
+-- (grace period) and points it at its replacement.
+CREATE TABLE api_keys (
+  id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id      UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name         TEXT NOT NULL DEFAULT '',
+  prefix       TEXT NOT NULL,
+  secret_hash  BYTEA NOT NULL,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMPTZ,
+  expires_at   TIMESTAMPTZ,
+  revoked_at   TIMESTAMPTZ,
+  replaced_by  UUID REFERENCES api_keys(id) ON DELETE SET NULL,
+  CONSTRAINT api_keys_prefix_key UNIQUE (prefix)
+);
+
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);
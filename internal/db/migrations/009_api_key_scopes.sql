@@ -0,0 +1,13 @@
+-- 009_api_key_scopes.sql — key scopes and admin keys
+--
+-- Tenant keys belong to a user and carry a subset of the tenant scopes.
+-- Admin keys belong to no user, carry admin:* and may act on any account.
+ALTER TABLE api_keys
+  ALTER COLUMN user_id DROP NOT NULL,
+  ADD COLUMN kind   TEXT   NOT NULL DEFAULT 'tenant',
+  ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{}',
+  ADD CONSTRAINT api_keys_kind_check  CHECK (kind IN ('tenant','admin')),
+  ADD CONSTRAINT api_keys_owner_check CHECK ((kind = 'admin') = (user_id IS NULL));
+
+-- Keys issued before scopes existed keep everything a tenant could do.
+UPDATE api_keys SET scopes = '{messages:send,messages:read,balance:read,account:manage}';
@@ -0,0 +1,15 @@
+-- 010_request_signing.sql — HMAC request signing for server-to-server clients
+--
+-- The signing secret is the HMAC key itself, so unlike the bearer token it has
+-- to be stored as issued. It is only set when a client opts in.
+ALTER TABLE api_keys ADD COLUMN signing_secret BYTEA;
+
+-- Nonces seen on signed requests, kept until they can no longer pass the
+-- clock-skew check. Losing them on a crash only reopens that short window.
+CREATE UNLOGGED TABLE request_nonces (
+  key_id     UUID        NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+  nonce      TEXT        NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (key_id, nonce)
+);
+CREATE INDEX request_nonces_expires_idx ON request_nonces (expires_at);
@@ -0,0 +1,13 @@
+-- 011_rate_limits.sql — per-user plans and shared rate-limit buckets
+--
+-- A user's plan picks its API rate limit; the limits themselves are API
+-- configuration, so any name is accepted here.
+ALTER TABLE users ADD COLUMN plan TEXT NOT NULL DEFAULT 'standard';
+
+-- Token buckets shared by API replicas. Tokens are refilled lazily from
+-- updated_at, so a lost or idle row is simply a full bucket.
+CREATE UNLOGGED TABLE rate_limit_buckets (
+  bucket_key TEXT             PRIMARY KEY,
+  tokens     DOUBLE PRECISION NOT NULL,
+  updated_at TIMESTAMPTZ      NOT NULL DEFAULT now()
+);
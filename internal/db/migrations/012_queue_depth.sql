@@ -0,0 +1,3 @@
+-- 012_queue_depth.sql — index for per-user queue-depth admission checks
+CREATE INDEX messages_outstanding_user_idx ON messages (user_id)
+  WHERE status IN ('queued','sending');
@@ -0,0 +1,31 @@
+-- 013_audit_events.sql — append-only audit log of administrative and account actions
+--
+-- Actors and targets are recorded by value, without foreign keys, so events
+-- outlive the users and keys they mention.
+CREATE TABLE audit_events (
+  id            BIGSERIAL   PRIMARY KEY,
+  occurred_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  actor_type    TEXT        NOT NULL,  -- api_key | jwt | cli | system
+  actor_key_id  UUID,
+  actor_user_id UUID,
+  action        TEXT        NOT NULL,  -- e.g. balance.topup, key.revoke
+  target_type   TEXT        NOT NULL,  -- user | api_key | threshold | credit
+  target_id     TEXT        NOT NULL,
+  request_id    TEXT,
+  source_ip     TEXT,
+  before        JSONB,
+  after         JSONB
+);
+CREATE INDEX audit_events_occurred_idx ON audit_events (occurred_at DESC);
+CREATE INDEX audit_events_target_idx   ON audit_events (target_type, target_id, id DESC);
+CREATE INDEX audit_events_actor_idx    ON audit_events (actor_user_id, id DESC);
+
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+  BEFORE UPDATE OR DELETE ON audit_events
+  FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,50 @@
+-- 014_organizations.sql — organizations, members and invitations
+--
+-- A users row is the organization: it owns the balance, messages and ledger.
+-- Members are the people acting for it, each with a role per organization
+-- and their own API keys (api_keys.member_id).
+CREATE TABLE members (
+  id         UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+  name       TEXT        NOT NULL,
+  email      TEXT        UNIQUE,          -- lowercased; NULL for migrated members
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE memberships (
+  account_id UUID        NOT NULL REFERENCES users(id)   ON DELETE CASCADE,
+  member_id  UUID        NOT NULL REFERENCES members(id) ON DELETE CASCADE,
+  role       TEXT        NOT NULL CHECK (role IN ('owner','developer','billing','viewer')),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (account_id, member_id)
+);
+CREATE INDEX memberships_member_idx ON memberships (member_id);
+
+CREATE TABLE invitations (
+  id          UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+  account_id  UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  email       TEXT        NOT NULL,
+  role        TEXT        NOT NULL CHECK (role IN ('owner','developer','billing','viewer')),
+  token_hash  BYTEA       NOT NULL UNIQUE,
+  invited_by  UUID        REFERENCES members(id) ON DELETE SET NULL,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at  TIMESTAMPTZ NOT NULL,
+  accepted_at TIMESTAMPTZ,
+  revoked_at  TIMESTAMPTZ
+);
+CREATE INDEX invitations_account_idx ON invitations (account_id, created_at DESC);
+
+-- Tenant keys act as a member; a key's effective scopes are its own scopes
+-- narrowed by the member's role. Keys without a member are account keys
+-- issued by an admin.
+ALTER TABLE api_keys ADD COLUMN member_id UUID REFERENCES members(id) ON DELETE CASCADE;
+CREATE INDEX api_keys_member_idx ON api_keys (member_id) WHERE member_id IS NOT NULL;
+
+-- Every existing account becomes a single-member organization; the member
+-- reuses the account's id so keys can be mapped over directly.
+INSERT INTO members (id, name, created_at) SELECT id, name, created_at FROM users;
+INSERT INTO memberships (account_id, member_id, role) SELECT id, id, 'owner' FROM users;
+UPDATE api_keys SET member_id = user_id WHERE kind = 'tenant';
+
+-- Member management is a new scope; keys that could manage the account get it.
+UPDATE api_keys SET scopes = array_append(scopes, 'members:manage')
+WHERE kind = 'tenant' AND 'account:manage' = ANY(scopes);
@@ -0,0 +1,19 @@
+-- 015_client_certificates.sql — mutual TLS client certificates
+--
+-- An identity names what a verified client certificate must present:
+-- "subject:<RFC 2253 DN>", "dns:<SAN>", "uri:<SAN>" or "email:<SAN>". The
+-- certificate itself isn't stored; trust comes from the configured client CA,
+-- and the binding only says which account a trusted identity acts for.
+CREATE TABLE client_certificates (
+  id           UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id      UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  identity     TEXT        NOT NULL,
+  scopes       TEXT[]      NOT NULL,
+  name         TEXT        NOT NULL DEFAULT '',
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMPTZ,
+  revoked_at   TIMESTAMPTZ
+);
+-- An identity maps to at most one account at a time.
+CREATE UNIQUE INDEX client_certificates_identity_idx ON client_certificates (identity) WHERE revoked_at IS NULL;
+CREATE INDEX client_certificates_user_idx ON client_certificates (user_id, created_at DESC);
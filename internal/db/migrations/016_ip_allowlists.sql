@@ -0,0 +1,6 @@
+-- 016_ip_allowlists.sql — source IP allowlists per user and per API key
+--
+-- Empty means unrestricted. A request must pass both its user's list and its
+-- key's list.
+ALTER TABLE users    ADD COLUMN ip_allowlist CIDR[] NOT NULL DEFAULT '{}';
+ALTER TABLE api_keys ADD COLUMN ip_allowlist CIDR[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,7 @@
+-- 017_messages_keyset.sql — keyset pagination for GET /messages
+--
+-- Pages are ordered by (requested_at, id) so rows with the same timestamp
+-- still have a stable position. This index serves that order and supersedes
+-- the (user_id, requested_at) one.
+CREATE INDEX messages_user_requested_id_idx ON messages (user_id, requested_at DESC, id DESC);
+DROP INDEX messages_user_id_requested_at_idx;
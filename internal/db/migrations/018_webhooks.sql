@@ -0,0 +1,47 @@
+-- 018_webhooks.sql — signed outbound webhooks
+--
+-- Events are written to an outbox in the same transaction as the change they
+-- describe, fanned out to one delivery per subscribed endpoint, and drained by
+-- the worker.
+CREATE TABLE webhook_endpoints (
+  id                   UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id              UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  url                  TEXT        NOT NULL,
+  secret               BYTEA       NOT NULL,                  -- HMAC key; returned once on creation
+  event_types          TEXT[]      NOT NULL,
+  description          TEXT        NOT NULL DEFAULT '',
+  consecutive_failures INTEGER     NOT NULL DEFAULT 0,        -- failed attempts since the last success
+  disabled_at          TIMESTAMPTZ,                           -- set by the worker after persistent failure
+  created_at           TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at           TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX webhook_endpoints_user_idx ON webhook_endpoints (user_id);
+
+CREATE TABLE webhook_events (
+  id         UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id    UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  type       TEXT        NOT NULL,
+  payload    JSONB       NOT NULL,                            -- the event's "data"
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- One row per (event, endpoint) attempt chain; a replay adds another.
+CREATE TABLE webhook_deliveries (
+  id              BIGSERIAL   PRIMARY KEY,
+  event_id        UUID        NOT NULL REFERENCES webhook_events(id) ON DELETE CASCADE,
+  endpoint_id     UUID        NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+  attempts        INTEGER     NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  delivered_at    TIMESTAMPTZ,
+  failed_at       TIMESTAMPTZ,
+  response_status INTEGER,                                    -- of the last attempt, if it got a response
+  last_error      TEXT,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX webhook_deliveries_pending_idx
+  ON webhook_deliveries (next_attempt_at)
+  WHERE delivered_at IS NULL AND failed_at IS NULL;
+CREATE INDEX webhook_deliveries_endpoint_idx ON webhook_deliveries (endpoint_id, id DESC);
+
+CREATE TRIGGER webhook_endpoints_updated_at BEFORE UPDATE ON webhook_endpoints FOR EACH ROW EXECUTE FUNCTION set_updated_at();
@@ -0,0 +1,32 @@
+-- 019_message_status_events.sql — status change log for live streams
+--
+-- Every message insert and status change is logged here and NOTIFYed on
+-- 'message_status' when its transaction commits. The log is short-lived
+-- (pruned by the API) and only lets a reconnecting stream catch up.
+CREATE TABLE message_status_events (
+  id          BIGSERIAL   PRIMARY KEY,
+  message_id  UUID        NOT NULL,  -- no FK: keeps the hot path cheap, rows are short-lived
+  user_id     UUID        NOT NULL,
+  status      msg_status  NOT NULL,
+  occurred_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX message_status_events_user_idx ON message_status_events (user_id, id);
+CREATE INDEX message_status_events_occurred_at_idx ON message_status_events (occurred_at);
+
+CREATE OR REPLACE FUNCTION log_message_status() RETURNS TRIGGER AS $$
+DECLARE
+  ev message_status_events;
+BEGIN
+  INSERT INTO message_status_events (message_id, user_id, status)
+  VALUES (NEW.id, NEW.user_id, NEW.status)
+  RETURNING * INTO ev;
+  PERFORM pg_notify('message_status', json_build_object(
+    'id', ev.id, 'message_id', ev.message_id, 'user_id', ev.user_id,
+    'status', ev.status, 'at', ev.occurred_at)::text);
+  RETURN NULL;
+END; $$ LANGUAGE plpgsql;
+
+CREATE TRIGGER messages_status_inserted AFTER INSERT ON messages
+  FOR EACH ROW EXECUTE FUNCTION log_message_status();
+CREATE TRIGGER messages_status_changed AFTER UPDATE OF status ON messages
+  FOR EACH ROW WHEN (OLD.status IS DISTINCT FROM NEW.status) EXECUTE FUNCTION log_message_status();
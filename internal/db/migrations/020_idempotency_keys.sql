@@ -0,0 +1,32 @@
+-- 020_idempotency_keys.sql — POST /messages idempotency keys with
+-- fingerprints, stored responses and expiry
+--
+-- A key is claimed before the message is charged. It remembers a hash of the
+-- request it was first used with, so a different request under the same key
+-- is refused instead of answered with the first message, and the response
+-- that was sent, so retries get the same bytes back. After expires_at the key
+-- may be used again.
+CREATE TABLE idempotency_keys (
+  user_id         UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  key             TEXT        NOT NULL,
+  fingerprint     BYTEA       NOT NULL,
+  message_id      UUID        REFERENCES messages(id) ON DELETE SET NULL,
+  response_status INT,                                 -- NULL until answered
+  response_body   BYTEA,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at      TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (user_id, key)
+);
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys(expires_at);
+
+-- Keys used so far carry on for a day; their responses weren't kept.
+INSERT INTO idempotency_keys (user_id, key, fingerprint, message_id, created_at, expires_at)
+SELECT user_id, idempotency_key,
+       sha256(convert_to(to_msisdn, 'UTF8') || '\x00'::bytea || convert_to(body, 'UTF8')),
+       id, requested_at, requested_at + interval '1 day'
+FROM messages
+WHERE idempotency_key IS NOT NULL;
+
+-- The key table now guards uniqueness, and an expired key may name a second
+-- message.
+DROP INDEX messages_user_id_idempotency_key_idx;
@@ -0,0 +1,11 @@
+-- 021_user_lifecycle.sql — suspended and soft-deleted accounts
+--
+-- A suspended account can't enqueue and its queued messages wait until it is
+-- reinstated. A deleted account keeps its row, so its messages, ledger and
+-- audit trail still resolve; it is only hidden from listings.
+ALTER TABLE users
+  ADD COLUMN suspended_at TIMESTAMPTZ,
+  ADD COLUMN deleted_at   TIMESTAMPTZ;
+
+-- GET /users pages newest first.
+CREATE INDEX users_created_at_id_idx ON users (created_at DESC, id DESC);
@@ -0,0 +1,126 @@
+-- 022_message_stats.sql — hourly per-user message rollups for GET /users/{id}/stats
+--
+-- Triggers keep one row per user, hour of requested_at (UTC) and destination
+-- prefix (first three digits, mapped to a country in Go) up to date as
+-- messages are inserted and change status, and as they are charged and
+-- refunded, so stats never scan messages. Status counts follow each message's
+-- current status. Latency is sent_at - requested_at, summed over sends.
+CREATE TABLE message_stats (
+  user_id        UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  hour           TIMESTAMPTZ NOT NULL,
+  dial_prefix    TEXT        NOT NULL,
+  queued         INT         NOT NULL DEFAULT 0,
+  sending        INT         NOT NULL DEFAULT 0,
+  sent           INT         NOT NULL DEFAULT 0,
+  failed         INT         NOT NULL DEFAULT 0,
+  spent          INT         NOT NULL DEFAULT 0,  -- charges net of refunds, whoever paid
+  latency_ms_sum BIGINT      NOT NULL DEFAULT 0,
+  latency_n      INT         NOT NULL DEFAULT 0,
+  PRIMARY KEY (user_id, hour, dial_prefix)
+);
+
+-- Failed messages by error_code ('unknown' when unset).
+CREATE TABLE message_failure_stats (
+  user_id     UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  hour        TIMESTAMPTZ NOT NULL,
+  dial_prefix TEXT        NOT NULL,
+  reason      TEXT        NOT NULL,
+  failed      INT         NOT NULL DEFAULT 0,
+  PRIMARY KEY (user_id, hour, dial_prefix, reason)
+);
+
+CREATE OR REPLACE FUNCTION add_message_stats(
+  m messages, st msg_status, n INT, spent INT, latency_ms BIGINT, latency_n INT
+) RETURNS void AS $$
+BEGIN
+  INSERT INTO message_stats AS s
+    (user_id, hour, dial_prefix, queued, sending, sent, failed, spent, latency_ms_sum, latency_n)
+  VALUES (
+    m.user_id, date_trunc('hour', m.requested_at, 'UTC'), left(ltrim(m.to_msisdn, '+'), 3),
+    CASE WHEN st = 'queued'  THEN n ELSE 0 END,
+    CASE WHEN st = 'sending' THEN n ELSE 0 END,
+    CASE WHEN st = 'sent'    THEN n ELSE 0 END,
+    CASE WHEN st = 'failed'  THEN n ELSE 0 END,
+    spent, latency_ms, latency_n)
+  ON CONFLICT (user_id, hour, dial_prefix) DO UPDATE
+  SET queued         = s.queued         + EXCLUDED.queued,
+      sending        = s.sending        + EXCLUDED.sending,
+      sent           = s.sent           + EXCLUDED.sent,
+      failed         = s.failed         + EXCLUDED.failed,
+      spent          = s.spent          + EXCLUDED.spent,
+      latency_ms_sum = s.latency_ms_sum + EXCLUDED.latency_ms_sum,
+      latency_n      = s.latency_n      + EXCLUDED.latency_n;
+
+  IF st = 'failed' THEN
+    INSERT INTO message_failure_stats AS f (user_id, hour, dial_prefix, reason, failed)
+    VALUES (m.user_id, date_trunc('hour', m.requested_at, 'UTC'), left(ltrim(m.to_msisdn, '+'), 3),
+            COALESCE(m.error_code, 'unknown'), n)
+    ON CONFLICT (user_id, hour, dial_prefix, reason) DO UPDATE
+    SET failed = f.failed + EXCLUDED.failed;
+  END IF;
+END; $$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION roll_up_message_status() RETURNS TRIGGER AS $$
+DECLARE
+  lat_ms BIGINT := 0;
+  lat_n  INT    := 0;
+BEGIN
+  IF TG_OP = 'UPDATE' THEN
+    PERFORM add_message_stats(OLD, OLD.status, -1, 0, 0, 0);
+  END IF;
+  IF NEW.status = 'sent' AND NEW.sent_at IS NOT NULL THEN
+    lat_ms := (extract(epoch FROM NEW.sent_at - NEW.requested_at) * 1000)::bigint;
+    lat_n  := 1;
+  END IF;
+  PERFORM add_message_stats(NEW, NEW.status, 1, 0, lat_ms, lat_n);
+  RETURN NULL;
+END; $$ LANGUAGE plpgsql;
+
+CREATE TRIGGER messages_stats_inserted AFTER INSERT ON messages
+  FOR EACH ROW EXECUTE FUNCTION roll_up_message_status();
+CREATE TRIGGER messages_stats_changed AFTER UPDATE OF status ON messages
+  FOR EACH ROW WHEN (OLD.status IS DISTINCT FROM NEW.status) EXECUTE FUNCTION roll_up_message_status();
+
+CREATE OR REPLACE FUNCTION roll_up_message_spend() RETURNS TRIGGER AS $$
+BEGIN
+  PERFORM add_message_stats(m, NULL, 0, -NEW.amount, 0, 0)
+  FROM messages m
+  WHERE m.id = NEW.message_id;
+  RETURN NULL;
+END; $$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_entries_stats AFTER INSERT ON ledger_entries
+  FOR EACH ROW WHEN (NEW.message_id IS NOT NULL AND NEW.kind IN ('charge', 'refund'))
+  EXECUTE FUNCTION roll_up_message_spend();
+
+-- Messages so far, once.
+INSERT INTO message_stats (user_id, hour, dial_prefix, queued, sending, sent, failed, latency_ms_sum, latency_n)
+SELECT user_id, date_trunc('hour', requested_at, 'UTC'), left(ltrim(to_msisdn, '+'), 3),
+       count(*) FILTER (WHERE status = 'queued'),
+       count(*) FILTER (WHERE status = 'sending'),
+       count(*) FILTER (WHERE status = 'sent'),
+       count(*) FILTER (WHERE status = 'failed'),
+       COALESCE(sum((extract(epoch FROM sent_at - requested_at) * 1000)::bigint)
+                FILTER (WHERE status = 'sent' AND sent_at IS NOT NULL), 0),
+       count(*) FILTER (WHERE status = 'sent' AND sent_at IS NOT NULL)
+FROM messages
+GROUP BY 1, 2, 3;
+
+UPDATE message_stats s
+SET spent = x.spent
+FROM (
+  SELECT m.user_id, date_trunc('hour', m.requested_at, 'UTC') AS hour,
+         left(ltrim(m.to_msisdn, '+'), 3) AS dial_prefix, -sum(l.amount)::int AS spent
+  FROM ledger_entries l
+  JOIN messages m ON m.id = l.message_id
+  WHERE l.kind IN ('charge', 'refund')
+  GROUP BY 1, 2, 3
+) x
+WHERE s.user_id = x.user_id AND s.hour = x.hour AND s.dial_prefix = x.dial_prefix;
+
+INSERT INTO message_failure_stats (user_id, hour, dial_prefix, reason, failed)
+SELECT user_id, date_trunc('hour', requested_at, 'UTC'), left(ltrim(to_msisdn, '+'), 3),
+       COALESCE(error_code, 'unknown'), count(*)
+FROM messages
+WHERE status = 'failed'
+GROUP BY 1, 2, 3, 4;
@@ -0,0 +1,80 @@
+-- name: InsertAPIKey :one
+INSERT INTO api_keys (user_id, member_id, kind, scopes, name, prefix, secret_hash)
+VALUES (sqlc.narg(user_id), sqlc.narg(member_id), sqlc.arg(kind), sqlc.arg(scopes)::text[], sqlc.arg(name), sqlc.arg(prefix), sqlc.arg(secret_hash))
+RETURNING *;
+
+-- name: GetAPIKeyByPrefix :one
+SELECT * FROM api_keys WHERE prefix = $1;
+
+-- name: GetAPIKeyForUpdate :one
+SELECT * FROM api_keys WHERE id = sqlc.arg(id) AND user_id = sqlc.arg(user_id)::uuid FOR UPDATE;
+
+-- name: ListAPIKeys :many
+SELECT * FROM api_keys
+WHERE user_id = sqlc.arg(user_id)::uuid
+ORDER BY created_at DESC;
+
+-- name: ListAdminAPIKeys :many
+SELECT * FROM api_keys
+WHERE kind = 'admin'
+ORDER BY created_at DESC;
+
+-- name: RevokeAPIKey :one
+UPDATE api_keys
+SET revoked_at = now()
+WHERE id = sqlc.arg(id) AND user_id = sqlc.arg(user_id)::uuid AND revoked_at IS NULL
+RETURNING *;
+
+-- name: RevokeAdminAPIKey :one
+UPDATE api_keys
+SET revoked_at = now()
+WHERE id = $1 AND kind = 'admin' AND revoked_at IS NULL
+RETURNING *;
+
+-- name: RevokeUserAPIKeys :execrows
+UPDATE api_keys
+SET revoked_at = now()
+WHERE user_id = sqlc.arg(user_id)::uuid AND revoked_at IS NULL;
+
+-- Ends the old key's life after the grace period; never extends an earlier expiry.
+-- name: RetireAPIKey :exec
+UPDATE api_keys
+SET expires_at = LEAST(COALESCE(expires_at, 'infinity'), now() + sqlc.arg(grace_seconds)::int * interval '1 second'),
+    replaced_by = sqlc.arg(replaced_by)::uuid
+WHERE id = sqlc.arg(id);
+
+-- Throttled to one write per key per minute.
+-- name: TouchAPIKey :exec
+UPDATE api_keys
+SET last_used_at = now()
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute');
+
+-- name: SetAPIKeySigningSecret :one
+UPDATE api_keys
+SET signing_secret = sqlc.arg(signing_secret)
+WHERE id = sqlc.arg(id) AND user_id = sqlc.arg(user_id)::uuid
+  AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+RETURNING *;
+
+-- An expired row with the same nonce is taken over; a live one is a replay.
+-- name: ClaimRequestNonce :execrows
+INSERT INTO request_nonces (key_id, nonce, expires_at)
+VALUES (sqlc.arg(key_id), sqlc.arg(nonce), sqlc.arg(expires_at))
+ON CONFLICT (key_id, nonce) DO UPDATE
+SET expires_at = EXCLUDED.expires_at
+WHERE request_nonces.expires_at <= now();
+
+-- name: PurgeRequestNonces :execrows
+DELETE FROM request_nonces WHERE expires_at <= now();
+
+-- name: SetAPIKeyIPAllowlist :one
+UPDATE api_keys
+SET ip_allowlist = sqlc.arg(ip_allowlist)::cidr[]
+WHERE id = sqlc.arg(id) AND user_id = sqlc.arg(user_id)::uuid
+RETURNING *;
+
+-- Both lists a request has to pass; either id may be NULL.
+-- name: GetIPAllowlists :one
+SELECT
+  COALESCE((SELECT ip_allowlist FROM users WHERE id = sqlc.narg(user_id)::uuid), '{}')::cidr[] AS user_allowlist,
+  COALESCE((SELECT ip_allowlist FROM api_keys WHERE id = sqlc.narg(key_id)::uuid), '{}')::cidr[] AS key_allowlist;
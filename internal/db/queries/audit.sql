@@ -0,0 +1,18 @@
+-- name: InsertAuditEvent :exec
+INSERT INTO audit_events (actor_type, actor_key_id, actor_user_id, action, target_type, target_id, request_id, source_ip, before, after)
+VALUES (sqlc.arg(actor_type), sqlc.narg(actor_key_id), sqlc.narg(actor_user_id), sqlc.arg(action), sqlc.arg(target_type),
+        sqlc.arg(target_id), sqlc.narg(request_id), sqlc.narg(source_ip), sqlc.narg(before), sqlc.narg(after));
+
+-- Newest first; before_id pages backwards.
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg(actor_user_id)::uuid IS NULL OR actor_user_id = sqlc.narg(actor_user_id))
+  AND (sqlc.narg(actor_key_id)::uuid  IS NULL OR actor_key_id  = sqlc.narg(actor_key_id))
+  AND (sqlc.narg(action)::text        IS NULL OR action        = sqlc.narg(action))
+  AND (sqlc.narg(target_type)::text   IS NULL OR target_type   = sqlc.narg(target_type))
+  AND (sqlc.narg(target_id)::text     IS NULL OR target_id     = sqlc.narg(target_id))
+  AND (sqlc.narg(from_ts)::timestamptz IS NULL OR occurred_at >= sqlc.narg(from_ts))
+  AND (sqlc.narg(to_ts)::timestamptz   IS NULL OR occurred_at <  sqlc.narg(to_ts))
+  AND (sqlc.narg(before_id)::bigint    IS NULL OR id < sqlc.narg(before_id))
+ORDER BY id DESC
+LIMIT sqlc.arg(limit_n);
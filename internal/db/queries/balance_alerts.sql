@@ -0,0 +1,94 @@
+-- name: CreateBalanceThreshold :one
+-- A threshold created while the balance is already below it starts disarmed,
+-- so it only fires after the next top-up and subsequent drop.
+INSERT INTO balance_thresholds (user_id, threshold, webhook_url, notify_msisdn, armed)
+SELECT u.id, sqlc.arg(threshold), sqlc.narg(webhook_url), sqlc.narg(notify_msisdn), sb.balance >= sqlc.arg(threshold)
+FROM users u
+CROSS JOIN LATERAL (
+  SELECT u.balance + COALESCE(SUM(b.remaining), 0)::int AS balance
+  FROM credit_buckets b
+  WHERE b.user_id = u.id AND b.expires_at > now()
+) sb
+WHERE u.id = sqlc.arg(user_id)
+RETURNING id, user_id, threshold, webhook_url, notify_msisdn, armed, last_fired_at, created_at, updated_at;
+
+-- name: ListBalanceThresholds :many
+SELECT id, user_id, threshold, webhook_url, notify_msisdn, armed, last_fired_at, created_at, updated_at
+FROM balance_thresholds
+WHERE user_id = $1
+ORDER BY threshold DESC;
+
+-- name: DeleteBalanceThreshold :one
+DELETE FROM balance_thresholds
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: TripBalanceThresholds :many
+-- Thresholds compare against the spendable balance (paid + unexpired promotional).
+UPDATE balance_thresholds t
+SET armed = false, last_fired_at = now()
+FROM users u
+CROSS JOIN LATERAL (
+  SELECT u.balance + COALESCE(SUM(b.remaining), 0)::int AS balance
+  FROM credit_buckets b
+  WHERE b.user_id = u.id AND b.expires_at > now()
+) sb
+WHERE t.user_id = u.id
+  AND u.id = sqlc.arg(user_id)
+  AND t.armed
+  AND sb.balance < t.threshold
+RETURNING t.id, t.threshold, t.webhook_url, t.notify_msisdn, sb.balance;
+
+-- name: RearmBalanceThresholds :exec
+UPDATE balance_thresholds t
+SET armed = true
+FROM users u
+CROSS JOIN LATERAL (
+  SELECT u.balance + COALESCE(SUM(b.remaining), 0)::int AS balance
+  FROM credit_buckets b
+  WHERE b.user_id = u.id AND b.expires_at > now()
+) sb
+WHERE t.user_id = u.id
+  AND u.id = sqlc.arg(user_id)
+  AND NOT t.armed
+  AND sb.balance >= t.threshold;
+
+-- name: InsertBalanceNotification :exec
+INSERT INTO balance_notifications (user_id, threshold_id, threshold, balance, channel, target)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: ClaimBalanceNotifications :many
+-- Pushes next_attempt_at forward as a lease so concurrent workers skip the rows
+-- while they are being delivered.
+WITH due AS (
+  SELECT id
+  FROM balance_notifications
+  WHERE delivered_at IS NULL
+    AND failed_at IS NULL
+    AND next_attempt_at <= now()
+  ORDER BY next_attempt_at
+  LIMIT sqlc.arg(limit_n)
+  FOR UPDATE SKIP LOCKED
+)
+UPDATE balance_notifications n
+SET attempts = n.attempts + 1,
+    next_attempt_at = now() + sqlc.arg(lease_seconds)::int * interval '1 second'
+FROM due
+WHERE n.id = due.id
+RETURNING n.id, n.user_id, n.threshold, n.balance, n.channel, n.target, n.attempts, n.created_at;
+
+-- name: MarkBalanceNotificationDelivered :exec
+UPDATE balance_notifications
+SET delivered_at = now(), last_error = NULL
+WHERE id = $1;
+
+-- name: RetryBalanceNotification :exec
+UPDATE balance_notifications
+SET next_attempt_at = now() + sqlc.arg(delay_seconds)::int * interval '1 second',
+    last_error = sqlc.arg(last_error)
+WHERE id = sqlc.arg(id);
+
+-- name: FailBalanceNotification :exec
+UPDATE balance_notifications
+SET failed_at = now(), last_error = sqlc.arg(last_error)
+WHERE id = sqlc.arg(id);
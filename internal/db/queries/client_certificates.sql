@@ -0,0 +1,31 @@
+-- name: InsertClientCertificate :one
+INSERT INTO client_certificates (user_id, identity, scopes, name)
+VALUES (sqlc.arg(user_id), sqlc.arg(identity), sqlc.arg(scopes)::text[], sqlc.arg(name))
+RETURNING *;
+
+-- name: ListClientCertificates :many
+SELECT * FROM client_certificates
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- name: RevokeClientCertificate :one
+UPDATE client_certificates
+SET revoked_at = now()
+WHERE id = sqlc.arg(id) AND user_id = sqlc.arg(user_id) AND revoked_at IS NULL
+RETURNING *;
+
+-- name: RevokeUserClientCertificates :execrows
+UPDATE client_certificates
+SET revoked_at = now()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- Active bindings for any of the identities a certificate presents.
+-- name: FindClientCertificates :many
+SELECT * FROM client_certificates
+WHERE identity = ANY(sqlc.arg(identities)::text[]) AND revoked_at IS NULL;
+
+-- Throttled to one write per binding per minute.
+-- name: TouchClientCertificate :exec
+UPDATE client_certificates
+SET last_used_at = now()
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute');
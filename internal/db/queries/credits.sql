@@ -0,0 +1,57 @@
+-- name: InsertCreditBucket :one
+INSERT INTO credit_buckets (user_id, amount, remaining, expires_at, note)
+VALUES (sqlc.arg(user_id), sqlc.arg(amount), sqlc.arg(amount), sqlc.arg(expires_at), sqlc.narg(note))
+RETURNING *;
+
+-- name: ListActiveCreditBuckets :many
+SELECT *
+FROM credit_buckets
+WHERE user_id = $1 AND remaining > 0 AND expires_at > now()
+ORDER BY expires_at, created_at;
+
+-- Spends amount from the earliest-expiring usable bucket; no rows when there is none.
+-- name: DebitCreditBucket :one
+UPDATE credit_buckets b
+SET remaining = b.remaining - sqlc.arg(amount)::int
+WHERE b.id = (
+  SELECT c.id
+  FROM credit_buckets c
+  WHERE c.user_id = sqlc.arg(user_id)
+    AND c.remaining >= sqlc.arg(amount)::int
+    AND c.expires_at > now()
+  ORDER BY c.expires_at, c.created_at
+  LIMIT 1
+  FOR UPDATE
+)
+RETURNING b.id;
+
+-- name: GetBalanceBreakdown :one
+SELECT u.balance AS paid,
+       COALESCE(SUM(b.remaining), 0)::int AS promotional,
+       MIN(b.expires_at)::timestamptz      AS next_expiry
+FROM users u
+LEFT JOIN credit_buckets b
+  ON b.user_id = u.id AND b.remaining > 0 AND b.expires_at > now()
+WHERE u.id = $1
+GROUP BY u.id;
+
+-- name: ListExpiredCreditBuckets :many
+SELECT id, user_id
+FROM credit_buckets
+WHERE expires_at <= now() AND remaining > 0
+ORDER BY expires_at
+LIMIT $1;
+
+-- Zeroes one expired bucket; no rows if another worker already swept it.
+-- name: ExpireCreditBucket :one
+WITH due AS (
+  SELECT b.id, b.remaining
+  FROM credit_buckets b
+  WHERE b.id = sqlc.arg(id) AND b.remaining > 0 AND b.expires_at <= now()
+  FOR UPDATE
+)
+UPDATE credit_buckets c
+SET remaining = 0, expired_at = now()
+FROM due
+WHERE c.id = due.id
+RETURNING c.user_id, due.remaining::int AS expired_amount;
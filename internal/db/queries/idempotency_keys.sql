@@ -0,0 +1,24 @@
+-- name: ClaimIdempotencyKey :one
+-- Claims a key that is unused or expired. No row means a live key exists;
+-- a concurrent claim waits for the other transaction to finish first.
+INSERT INTO idempotency_keys AS k (user_id, key, fingerprint, expires_at)
+VALUES (sqlc.arg(user_id), sqlc.arg(key), sqlc.arg(fingerprint), sqlc.arg(expires_at))
+ON CONFLICT (user_id, key) DO UPDATE
+  SET fingerprint = EXCLUDED.fingerprint, message_id = NULL,
+      response_status = NULL, response_body = NULL,
+      created_at = now(), expires_at = EXCLUDED.expires_at
+  WHERE k.expires_at <= now()
+RETURNING k.key;
+
+-- name: GetIdempotencyKey :one
+SELECT user_id, key, fingerprint, message_id, response_status, response_body, created_at, expires_at
+FROM idempotency_keys
+WHERE user_id = sqlc.arg(user_id) AND key = sqlc.arg(key) AND expires_at > now();
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET message_id = sqlc.arg(message_id), response_status = sqlc.narg(response_status), response_body = sqlc.narg(response_body)
+WHERE user_id = sqlc.arg(user_id) AND key = sqlc.arg(key);
+
+-- name: PurgeIdempotencyKeys :execrows
+DELETE FROM idempotency_keys WHERE expires_at <= now();
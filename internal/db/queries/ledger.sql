@@ -0,0 +1,53 @@
+-- name: InsertLedgerEntry :one
+-- balance_after is paid balance plus unswept promotional credit, read from the
+-- (already updated) rows in the same tx.
+INSERT INTO ledger_entries (user_id, kind, amount, balance_after, idempotency_key, external_ref, note,
+                            counterparty_id, credit_bucket_id, message_id)
+SELECT u.id, sqlc.arg(kind), sqlc.arg(amount),
+       u.balance + COALESCE((SELECT SUM(b.remaining) FROM credit_buckets b WHERE b.user_id = u.id), 0)::int,
+       sqlc.narg(idempotency_key), sqlc.narg(external_ref), sqlc.narg(note),
+       sqlc.narg(counterparty_id), sqlc.narg(credit_bucket_id), sqlc.narg(message_id)
+FROM users u
+WHERE u.id = sqlc.arg(user_id)
+RETURNING id, balance_after, created_at;
+
+-- name: GetLedgerEntryByIdemKey :one
+SELECT id, kind, amount, balance_after, external_ref, created_at
+FROM ledger_entries
+WHERE user_id = $1 AND idempotency_key = $2;
+
+-- Balance as of ts: balance_after of the last entry before it, 0 if none.
+-- name: LedgerBalanceAt :one
+SELECT COALESCE((
+  SELECT l.balance_after
+  FROM ledger_entries l
+  WHERE l.user_id = sqlc.arg(user_id) AND l.created_at < sqlc.arg(ts)
+  ORDER BY l.id DESC
+  LIMIT 1
+), 0)::int AS balance;
+
+-- name: LedgerTotalsByKind :many
+SELECT kind, COUNT(*)::int AS entries, SUM(amount)::int AS amount
+FROM ledger_entries
+WHERE user_id = sqlc.arg(user_id)
+  AND created_at >= sqlc.arg(from_ts)
+  AND created_at <  sqlc.arg(to_ts)
+GROUP BY kind
+ORDER BY kind;
+
+-- Charges net of refunds, by destination calling-code prefix (first three digits)
+-- and the message's current status.
+-- name: LedgerChargesByDestination :many
+SELECT left(ltrim(m.to_msisdn, '+'), 3)::text AS dial_prefix,
+       m.status,
+       COUNT(*) FILTER (WHERE l.kind = 'charge')::int AS messages,
+       COALESCE(-SUM(l.amount) FILTER (WHERE l.kind = 'charge'), 0)::int AS charged,
+       COALESCE(SUM(l.amount) FILTER (WHERE l.kind = 'refund'), 0)::int AS refunded
+FROM ledger_entries l
+JOIN messages m ON m.id = l.message_id
+WHERE l.user_id = sqlc.arg(user_id)
+  AND l.kind IN ('charge', 'refund')
+  AND l.created_at >= sqlc.arg(from_ts)
+  AND l.created_at <  sqlc.arg(to_ts)
+GROUP BY 1, 2
+ORDER BY 1, 2;
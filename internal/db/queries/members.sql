@@ -0,0 +1,71 @@
+-- name: CreateMember :one
+INSERT INTO members (name, email)
+VALUES (sqlc.arg(name), sqlc.narg(email))
+RETURNING *;
+
+-- name: GetMemberByEmail :one
+SELECT * FROM members WHERE email = $1;
+
+-- name: InsertMembership :one
+INSERT INTO memberships (account_id, member_id, role)
+VALUES (sqlc.arg(account_id), sqlc.arg(member_id), sqlc.arg(role))
+RETURNING *;
+
+-- name: GetMembershipRole :one
+SELECT role FROM memberships
+WHERE account_id = sqlc.arg(account_id) AND member_id = sqlc.arg(member_id);
+
+-- name: ListMembers :many
+SELECT m.id, m.name, m.email, ms.role, ms.created_at AS joined_at
+FROM memberships ms
+JOIN members m ON m.id = ms.member_id
+WHERE ms.account_id = sqlc.arg(account_id)
+ORDER BY ms.created_at, m.id;
+
+-- name: GetMembershipForUpdate :one
+SELECT * FROM memberships
+WHERE account_id = sqlc.arg(account_id) AND member_id = sqlc.arg(member_id)
+FOR UPDATE;
+
+-- name: UpdateMembershipRole :one
+UPDATE memberships SET role = sqlc.arg(role)
+WHERE account_id = sqlc.arg(account_id) AND member_id = sqlc.arg(member_id)
+RETURNING *;
+
+-- name: DeleteMembership :exec
+DELETE FROM memberships
+WHERE account_id = sqlc.arg(account_id) AND member_id = sqlc.arg(member_id);
+
+-- name: CountOwners :one
+SELECT count(*)::int AS owners FROM memberships
+WHERE account_id = $1 AND role = 'owner';
+
+-- name: RevokeMemberKeys :execrows
+UPDATE api_keys SET revoked_at = now()
+WHERE user_id = sqlc.arg(account_id)::uuid AND member_id = sqlc.arg(member_id)::uuid AND revoked_at IS NULL;
+
+-- ---- Invitations ----
+
+-- name: InsertInvitation :one
+INSERT INTO invitations (account_id, email, role, token_hash, invited_by, expires_at)
+VALUES (sqlc.arg(account_id), sqlc.arg(email), sqlc.arg(role), sqlc.arg(token_hash), sqlc.narg(invited_by), sqlc.arg(expires_at))
+RETURNING *;
+
+-- Pending invitations: not accepted, revoked or expired.
+-- name: ListPendingInvitations :many
+SELECT * FROM invitations
+WHERE account_id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+ORDER BY created_at DESC;
+
+-- name: RevokeInvitation :one
+UPDATE invitations SET revoked_at = now()
+WHERE id = sqlc.arg(id) AND account_id = sqlc.arg(account_id)
+  AND accepted_at IS NULL AND revoked_at IS NULL
+RETURNING *;
+
+-- name: GetInvitationByTokenForUpdate :one
+SELECT * FROM invitations WHERE token_hash = $1 FOR UPDATE;
+
+-- name: MarkInvitationAccepted :one
+UPDATE invitations SET accepted_at = now() WHERE id = $1
+RETURNING *;
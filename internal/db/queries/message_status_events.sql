@@ -0,0 +1,30 @@
+-- Events after after_id for a user, optionally only for some messages.
+-- name: ListStatusEventsAfter :many
+SELECT id, message_id, user_id, status, occurred_at
+FROM message_status_events
+WHERE user_id = sqlc.arg(user_id)
+  AND id > sqlc.arg(after_id)
+  AND (sqlc.narg(message_ids)::uuid[] IS NULL OR message_id = ANY(sqlc.narg(message_ids)::uuid[]))
+ORDER BY id
+LIMIT sqlc.arg(limit_n);
+
+-- Where to resume a stream that last saw after_id. Ids are taken at insert but
+-- rows become visible at commit, so an event logged within lag before after_id
+-- may have committed after it; the stream replays from just below the first of
+-- them. after_id itself when it is no longer in the log.
+-- name: StatusEventReplayFrom :one
+SELECT COALESCE((
+  SELECT min(e.id) - 1
+  FROM message_status_events e, message_status_events a
+  WHERE a.id = sqlc.arg(after_id)
+    AND e.id <= a.id
+    AND e.occurred_at >= a.occurred_at - sqlc.arg(lag_ms)::bigint * interval '1 millisecond'
+), sqlc.arg(after_id))::bigint;
+
+-- 0 when the log is empty.
+-- name: OldestStatusEventID :one
+SELECT COALESCE(min(id), 0)::bigint FROM message_status_events;
+
+-- name: PruneStatusEvents :execrows
+DELETE FROM message_status_events
+WHERE occurred_at < sqlc.arg(before);
@@ -1,27 +1,29 @@
 -- name: InsertMessage :one
-INSERT INTO messages (user_id, to_msisdn, body, status, idempotency_key)
+INSERT INTO messages (user_id, to_msisdn, body, status, idempotency_key, billed_user_id, credit_bucket_id)
 VALUES (
   sqlc.arg(user_id),
   sqlc.arg(to_msisdn),
   sqlc.arg(body),
   'queued',
-  sqlc.narg(idempotency_key)     
+  sqlc.narg(idempotency_key),
+  sqlc.arg(billed_user_id)::uuid,
+  sqlc.narg(credit_bucket_id)
 )
 RETURNING id;
 
--- name: GetMessageByIdemKey :one
-SELECT id
-FROM messages
-WHERE user_id = $1 AND idempotency_key = $2;
-
+-- Suspended and deleted accounts' messages stay queued. A sub-account is
+-- held while its parent is suspended.
 -- name: ClaimQueued :many
 WITH picked AS (
-  SELECT id
-  FROM messages
-  WHERE status = 'queued' AND send_after <= now()
-  ORDER BY requested_at
+  SELECT m.id
+  FROM messages m
+  JOIN users u ON u.id = m.user_id
+  LEFT JOIN users p ON p.id = u.parent_id
+  WHERE m.status = 'queued' AND m.send_after <= now()
+    AND u.suspended_at IS NULL AND u.deleted_at IS NULL AND p.suspended_at IS NULL
+  ORDER BY m.requested_at
   LIMIT $1
-  FOR UPDATE SKIP LOCKED
+  FOR UPDATE OF m SKIP LOCKED
 )
 UPDATE messages m
 SET status = 'sending', attempts = attempts + 1
@@ -34,10 +36,11 @@ SELECT user_id, to_msisdn, body
 FROM messages
 WHERE id = $1;
 
--- name: MarkSent :exec
+-- name: MarkSent :one
 UPDATE messages
 SET status = 'sent', provider_message_id = $2, sent_at = now()
-WHERE id = $1;
+WHERE id = $1
+RETURNING id, user_id, to_msisdn, status, provider_message_id, sent_at;
 
 -- name: RequeueWithBackoff :exec
 UPDATE messages
@@ -45,10 +48,13 @@ SET status = 'queued',
     send_after = now() + (sqlc.arg(seconds) || ' seconds')::interval
 WHERE id = sqlc.arg(id);
 
--- name: MarkFailed :exec
+-- name: MarkFailed :one
+-- No rows if already failed.
 UPDATE messages
 SET status='failed'
-WHERE id = $1;
+WHERE id = $1
+  AND status <> 'failed'
+RETURNING id, user_id, to_msisdn, status;
 
 -- name: ListMessages :many
 SELECT id, user_id, to_msisdn, body, status, provider_message_id, error_code,
@@ -58,22 +64,73 @@ WHERE user_id = sqlc.arg(user_id)
   AND (sqlc.narg(status)::msg_status     IS NULL OR status       = sqlc.narg(status)::msg_status)
   AND (sqlc.narg(from_ts)::timestamptz   IS NULL OR requested_at >= sqlc.narg(from_ts)::timestamptz)
   AND (sqlc.narg(to_ts)::timestamptz     IS NULL OR requested_at <  sqlc.narg(to_ts)::timestamptz)
-ORDER BY requested_at DESC
+ORDER BY requested_at DESC, id DESC
 LIMIT  sqlc.arg(limit_n)
 OFFSET sqlc.arg(offset_n);
 
+-- Keyset pages, newest first. Rows strictly older than the cursor, or from
+-- the top without one.
+-- name: ListMessagesBefore :many
+SELECT id, user_id, to_msisdn, body, status, provider_message_id, error_code,
+       requested_at, sent_at, delivered_at, attempts
+FROM messages
+WHERE user_id = sqlc.arg(user_id)
+  AND (sqlc.narg(status)::msg_status     IS NULL OR status       = sqlc.narg(status)::msg_status)
+  AND (sqlc.narg(from_ts)::timestamptz   IS NULL OR requested_at >= sqlc.narg(from_ts)::timestamptz)
+  AND (sqlc.narg(to_ts)::timestamptz     IS NULL OR requested_at <  sqlc.narg(to_ts)::timestamptz)
+  AND (sqlc.narg(cursor_ts)::timestamptz IS NULL
+       OR (requested_at, id) < (sqlc.narg(cursor_ts)::timestamptz, sqlc.narg(cursor_id)::uuid))
+ORDER BY requested_at DESC, id DESC
+LIMIT sqlc.arg(limit_n);
+
+-- Rows strictly newer than the cursor, oldest first (the caller reverses
+-- them into page order).
+-- name: ListMessagesAfter :many
+SELECT id, user_id, to_msisdn, body, status, provider_message_id, error_code,
+       requested_at, sent_at, delivered_at, attempts
+FROM messages
+WHERE user_id = sqlc.arg(user_id)
+  AND (sqlc.narg(status)::msg_status     IS NULL OR status       = sqlc.narg(status)::msg_status)
+  AND (sqlc.narg(from_ts)::timestamptz   IS NULL OR requested_at >= sqlc.narg(from_ts)::timestamptz)
+  AND (sqlc.narg(to_ts)::timestamptz     IS NULL OR requested_at <  sqlc.narg(to_ts)::timestamptz)
+  AND (requested_at, id) > (sqlc.arg(cursor_ts)::timestamptz, sqlc.arg(cursor_id)::uuid)
+ORDER BY requested_at ASC, id ASC
+LIMIT sqlc.arg(limit_n);
+
 
--- name: MarkFailedAndRefund :exec
+-- name: MarkFailedAndRefund :one
+-- Credits whoever paid, back into the promotional bucket it came from if any,
+-- even one that has expired since (ExpireCredits then forfeits it); a
+-- parent-billed child also gets its quota back. error_code is kept when NULL.
+-- No rows if already failed.
 WITH upd AS (
   UPDATE messages AS m
-  SET status = 'failed'
-  WHERE m.id = $1
+  SET status = 'failed', error_code = COALESCE(sqlc.narg(error_code), m.error_code)
+  WHERE m.id = sqlc.arg(id)
     AND status <> 'failed'
-  RETURNING user_id
+  RETURNING user_id, to_msisdn, COALESCE(billed_user_id, user_id)::uuid AS payer_id, credit_bucket_id
+),
+quota AS (
+  UPDATE users AS c
+  SET quota_used = GREATEST(c.quota_used - 1, 0)
+  FROM upd
+  WHERE c.id = upd.user_id
+    AND upd.payer_id <> upd.user_id
+),
+bucket AS (
+  UPDATE credit_buckets AS b
+  SET remaining = b.remaining + 1
+  FROM upd
+  WHERE b.id = upd.credit_bucket_id
+),
+paid AS (
+  UPDATE users AS u
+  SET balance = balance + 1
+  FROM upd
+  WHERE u.id = upd.payer_id
+    AND upd.credit_bucket_id IS NULL
 )
-UPDATE users AS u
-SET balance = balance + 1
-WHERE u.id = (SELECT user_id FROM upd);
+SELECT user_id, to_msisdn, payer_id, credit_bucket_id FROM upd;
 
 -- name: GetMessage :one
 SELECT id, user_id, to_msisdn, body, status, provider_message_id, error_code,
@@ -81,11 +138,14 @@ SELECT id, user_id, to_msisdn, body, status, provider_message_id, error_code,
 FROM messages
 WHERE id = sqlc.arg(id);
 
+-- Skips held accounts like ClaimQueued.
 -- name: ClaimQueuedLRS :many
 WITH next_users AS (
   SELECT u.id
   FROM users u
-  WHERE EXISTS (
+  LEFT JOIN users p ON p.id = u.parent_id
+  WHERE u.suspended_at IS NULL AND u.deleted_at IS NULL AND p.suspended_at IS NULL
+    AND EXISTS (
     SELECT 1
     FROM messages m
     WHERE m.user_id = u.id
@@ -125,3 +185,30 @@ bump AS (
   RETURNING u.id
 )
 SELECT id FROM upd;
+
+-- Queued messages of a user, locked so workers skip them until the tx ends.
+-- name: LockQueuedMessagesForUser :many
+SELECT id FROM messages
+WHERE user_id = $1 AND status = 'queued'
+FOR UPDATE;
+
+-- Outstanding (queued or sending) messages, counted up to cap so the check
+-- stays cheap however deep the queue is; pass MaxInt32 for the full count.
+-- name: CountOutstandingForUser :one
+SELECT count(*)::int AS depth FROM (
+  SELECT 1 FROM messages
+  WHERE user_id = sqlc.arg(user_id) AND status IN ('queued','sending')
+  LIMIT sqlc.arg(cap)::int
+) t;
+
+-- Messages of held accounts don't count, as ClaimQueued won't send them.
+-- name: CountOutstanding :one
+SELECT count(*)::int AS depth FROM (
+  SELECT 1
+  FROM messages m
+  JOIN users u ON u.id = m.user_id
+  LEFT JOIN users p ON p.id = u.parent_id
+  WHERE m.status IN ('queued','sending')
+    AND u.suspended_at IS NULL AND u.deleted_at IS NULL AND p.suspended_at IS NULL
+  LIMIT sqlc.arg(cap)::int
+) t;
@@ -0,0 +1,18 @@
+-- Refills a bucket (creating it full) and locks it until the end of the tx;
+-- returns the tokens it now holds.
+-- name: RefillRateLimitBucket :one
+INSERT INTO rate_limit_buckets AS b (bucket_key, tokens, updated_at)
+VALUES (sqlc.arg(bucket_key), sqlc.arg(burst)::float8, now())
+ON CONFLICT (bucket_key) DO UPDATE
+SET tokens = LEAST(sqlc.arg(burst)::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * sqlc.arg(rate)::float8),
+    updated_at = now()
+RETURNING b.tokens;
+
+-- name: SpendRateLimitTokens :exec
+UPDATE rate_limit_buckets
+SET tokens = tokens - 1
+WHERE bucket_key = ANY(sqlc.arg(bucket_keys)::text[]);
+
+-- Idle buckets have refilled, so dropping them is lossless.
+-- name: PurgeRateLimitBuckets :execrows
+DELETE FROM rate_limit_buckets WHERE updated_at < now() - interval '1 hour';
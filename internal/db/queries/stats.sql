@@ -0,0 +1,31 @@
+-- Rollup totals per bucket (hour or day, in UTC) and destination prefix for
+-- buckets starting in [from_ts, to_ts).
+-- name: MessageStats :many
+SELECT date_trunc(sqlc.arg(granularity)::text, hour, 'UTC')::timestamptz AS bucket,
+       dial_prefix,
+       sum(queued)::int            AS queued,
+       sum(sending)::int           AS sending,
+       sum(sent)::int              AS sent,
+       sum(failed)::int            AS failed,
+       sum(spent)::int             AS spent,
+       sum(latency_ms_sum)::bigint AS latency_ms_sum,
+       sum(latency_n)::int         AS latency_n
+FROM message_stats
+WHERE user_id = sqlc.arg(user_id)
+  AND hour >= sqlc.arg(from_ts)
+  AND hour <  sqlc.arg(to_ts)
+GROUP BY 1, 2
+ORDER BY 1, 2;
+
+-- name: MessageFailureStats :many
+SELECT date_trunc(sqlc.arg(granularity)::text, hour, 'UTC')::timestamptz AS bucket,
+       dial_prefix,
+       reason,
+       sum(failed)::int AS failed
+FROM message_failure_stats
+WHERE user_id = sqlc.arg(user_id)
+  AND hour >= sqlc.arg(from_ts)
+  AND hour <  sqlc.arg(to_ts)
+GROUP BY 1, 2, 3
+HAVING sum(failed) > 0
+ORDER BY 1, 2, 3;
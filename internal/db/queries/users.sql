@@ -4,23 +4,159 @@ VALUES ($1)
 RETURNING id, name, balance, created_at, updated_at;
 
 -- name: GetUser :one
-SELECT id, name, balance, created_at, updated_at
+SELECT *
 FROM users
 WHERE id = $1;
 
+-- Newest first, keyset-paged by (created_at, id). A search matches names by
+-- name_pattern (ILIKE) or an exact id. status is active, suspended or deleted;
+-- without it every account that isn't deleted is listed.
+-- name: ListUsers :many
+SELECT *
+FROM users
+WHERE (sqlc.narg(search)::text IS NULL
+       OR name ILIKE sqlc.narg(name_pattern)::text
+       OR id::text = sqlc.narg(search)::text)
+  AND CASE sqlc.narg(status)::text
+        WHEN 'active'    THEN deleted_at IS NULL AND suspended_at IS NULL
+        WHEN 'suspended' THEN deleted_at IS NULL AND suspended_at IS NOT NULL
+        WHEN 'deleted'   THEN deleted_at IS NOT NULL
+        ELSE deleted_at IS NULL
+      END
+  AND (sqlc.narg(cursor_ts)::timestamptz IS NULL
+       OR (created_at, id) < (sqlc.narg(cursor_ts)::timestamptz, sqlc.narg(cursor_id)::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(limit_n);
+
+-- suspended: NULL leaves it, true suspends (keeping an earlier suspension's
+-- time), false reinstates. Deleted accounts aren't changed.
+-- name: UpdateUser :one
+UPDATE users
+SET name         = COALESCE(sqlc.narg(name), name),
+    suspended_at = CASE
+                     WHEN sqlc.narg(suspended)::bool IS NULL THEN suspended_at
+                     WHEN sqlc.narg(suspended)::bool THEN COALESCE(suspended_at, now())
+                   END,
+    updated_at   = now()
+WHERE id = sqlc.arg(id) AND deleted_at IS NULL
+RETURNING *;
+
+-- name: SoftDeleteUser :one
+UPDATE users
+SET deleted_at = now(), updated_at = now()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
+
+-- name: CountLiveChildUsers :one
+SELECT count(*)::int FROM users
+WHERE parent_id = sqlc.arg(parent_id)::uuid AND deleted_at IS NULL;
+
+-- Spendable balance: paid plus unexpired promotional credit.
 -- name: GetBalance :one
-SELECT balance FROM users WHERE id = $1;
+SELECT (u.balance + COALESCE((
+         SELECT SUM(b.remaining) FROM credit_buckets b
+         WHERE b.user_id = u.id AND b.expires_at > now()
+       ), 0))::int AS balance
+FROM users u
+WHERE u.id = $1;
 
--- name: TopUp :exec
+-- name: TopUp :one
 UPDATE users
 SET balance = balance + $1
-WHERE id = $2;
+WHERE id = $2
+RETURNING balance;
 
 -- name: DebitIfEnough :execrows
 UPDATE users
 SET balance = balance - $1
 WHERE id = $2 AND balance >= $1;
 
+-- Serializes balance changes for one user; no rows means the user doesn't exist.
+-- No rows for a deleted account. Holds off DeleteUser (which locks FOR
+-- UPDATE) until the tx ends, so anything attached meanwhile is revoked with it.
+-- name: LockLiveUser :one
+SELECT id FROM users WHERE id = $1 AND deleted_at IS NULL FOR KEY SHARE;
+
+-- name: LockUserBalance :one
+SELECT balance FROM users WHERE id = $1 FOR UPDATE;
+
 -- Optional: explicit row lock if you need it elsewhere
 -- name: LockUser :exec
 SELECT 1 FROM users WHERE id = $1 FOR UPDATE;
+
+-- ---- Sub-accounts ----
+
+-- Locks the sending user's row; the payer is resolved from billing_mode. A
+-- sub-account counts as suspended while its parent is.
+-- name: GetBillingInfoForUpdate :one
+SELECT u.parent_id, u.billing_mode,
+       (u.deleted_at IS NOT NULL)::bool                                 AS deleted,
+       (u.suspended_at IS NOT NULL OR p.suspended_at IS NOT NULL)::bool AS suspended
+FROM users u
+LEFT JOIN users p ON p.id = u.parent_id
+WHERE u.id = $1
+FOR UPDATE OF u;
+
+-- Draws amount against a parent-billed child's quota; 0 rows when it would exceed it.
+-- name: DrawParentQuota :execrows
+UPDATE users
+SET quota_used = quota_used + sqlc.arg(amount)::int
+WHERE id = sqlc.arg(id)
+  AND billing_mode = 'parent'
+  AND (quota IS NULL OR quota_used + sqlc.arg(amount)::int <= quota);
+
+-- name: CreateChildUser :one
+INSERT INTO users (name, parent_id, billing_mode, quota)
+VALUES (sqlc.arg(name), sqlc.arg(parent_id)::uuid, sqlc.arg(billing_mode), sqlc.narg(quota))
+RETURNING *;
+
+-- name: ListChildUsers :many
+SELECT *
+FROM users
+WHERE parent_id = sqlc.arg(parent_id)::uuid
+ORDER BY created_at;
+
+-- name: UpdateChildBilling :one
+UPDATE users
+SET billing_mode = COALESCE(sqlc.narg(billing_mode), billing_mode),
+    quota        = CASE WHEN sqlc.arg(set_quota)::bool THEN sqlc.narg(quota)::int ELSE quota END
+WHERE id = sqlc.arg(id) AND parent_id = sqlc.arg(parent_id)::uuid
+RETURNING *;
+
+-- name: SubaccountUsage :many
+SELECT u.id, u.name, u.parent_id, u.billing_mode, u.balance, u.quota, u.quota_used,
+       COUNT(m.id)::bigint                                                   AS messages,
+       COUNT(m.id) FILTER (WHERE m.status = 'sent')::bigint                  AS sent,
+       COUNT(m.id) FILTER (WHERE m.status = 'failed')::bigint                AS failed,
+       COUNT(m.id) FILTER (WHERE m.status IN ('queued','sending'))::bigint   AS pending,
+       COUNT(m.id) FILTER (WHERE m.status <> 'failed'
+                             AND m.billed_user_id IS DISTINCT FROM m.user_id
+                             AND m.billed_user_id IS NOT NULL)::bigint       AS billed_to_parent
+FROM users u
+LEFT JOIN messages m
+  ON m.user_id = u.id
+ AND (sqlc.narg(from_ts)::timestamptz IS NULL OR m.requested_at >= sqlc.narg(from_ts)::timestamptz)
+ AND (sqlc.narg(to_ts)::timestamptz   IS NULL OR m.requested_at <  sqlc.narg(to_ts)::timestamptz)
+WHERE u.id = sqlc.arg(parent_id) OR u.parent_id = sqlc.arg(parent_id)::uuid
+GROUP BY u.id
+ORDER BY u.parent_id NULLS FIRST, u.created_at;
+
+-- name: GetUserPlan :one
+SELECT plan FROM users WHERE id = $1;
+
+-- name: SetUserPlan :one
+UPDATE users u
+SET plan = sqlc.arg(plan), updated_at = now()
+FROM (SELECT o.id, o.plan FROM users o WHERE o.id = sqlc.arg(id) FOR UPDATE) old
+WHERE u.id = old.id
+RETURNING old.plan AS previous_plan;
+
+-- name: SetUserIPAllowlist :one
+UPDATE users u
+SET ip_allowlist = sqlc.arg(ip_allowlist)::cidr[], updated_at = now()
+FROM (SELECT o.id, o.ip_allowlist FROM users o WHERE o.id = sqlc.arg(id) FOR UPDATE) old
+WHERE u.id = old.id
+RETURNING old.ip_allowlist AS previous_allowlist;
+
+-- name: GetUserIPAllowlist :one
+SELECT ip_allowlist FROM users WHERE id = $1;
@@ -0,0 +1,152 @@
+-- name: InsertWebhookEndpoint :one
+INSERT INTO webhook_endpoints (user_id, url, secret, event_types, description)
+VALUES (sqlc.arg(user_id), sqlc.arg(url), sqlc.arg(secret), sqlc.arg(event_types)::text[], sqlc.arg(description))
+RETURNING *;
+
+-- name: ListWebhookEndpoints :many
+SELECT * FROM webhook_endpoints
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- name: GetWebhookEndpointForUpdate :one
+SELECT * FROM webhook_endpoints
+WHERE id = sqlc.arg(id) AND user_id = sqlc.arg(user_id)
+FOR UPDATE;
+
+-- Null arguments keep the current value. Enabling clears the failure streak.
+-- name: UpdateWebhookEndpoint :one
+UPDATE webhook_endpoints
+SET url                  = COALESCE(sqlc.narg(url), url),
+    event_types          = COALESCE(sqlc.narg(event_types)::text[], event_types),
+    description          = COALESCE(sqlc.narg(description), description),
+    disabled_at          = CASE
+                             WHEN sqlc.narg(enabled)::bool IS NULL THEN disabled_at
+                             WHEN sqlc.narg(enabled)::bool THEN NULL
+                             ELSE COALESCE(disabled_at, now())
+                           END,
+    consecutive_failures = CASE WHEN sqlc.narg(enabled)::bool THEN 0 ELSE consecutive_failures END
+WHERE id = sqlc.arg(id) AND user_id = sqlc.arg(user_id)
+RETURNING *;
+
+-- name: DeleteWebhookEndpoint :one
+DELETE FROM webhook_endpoints
+WHERE id = sqlc.arg(id) AND user_id = sqlc.arg(user_id)
+RETURNING *;
+
+-- Writes the event and one delivery per enabled endpoint of the user that is
+-- subscribed to its type. Nothing is written when there is none.
+-- name: InsertWebhookEvent :exec
+WITH subs AS (
+  SELECT e.id FROM webhook_endpoints e
+  WHERE e.user_id = sqlc.arg(user_id)::uuid
+    AND e.disabled_at IS NULL
+    AND sqlc.arg(type)::text = ANY(e.event_types)
+),
+ev AS (
+  INSERT INTO webhook_events (user_id, type, payload)
+  SELECT sqlc.arg(user_id)::uuid, sqlc.arg(type)::text, sqlc.arg(payload)::jsonb
+  WHERE EXISTS (SELECT 1 FROM subs)
+  RETURNING id
+)
+INSERT INTO webhook_deliveries (event_id, endpoint_id)
+SELECT ev.id, subs.id FROM ev CROSS JOIN subs;
+
+-- name: ListWebhookDeliveries :many
+-- Newest first; before_id pages back from an earlier page's last id.
+SELECT d.id, d.event_id, ev.type, d.attempts, d.next_attempt_at, d.delivered_at, d.failed_at,
+       d.response_status, d.last_error, d.created_at
+FROM webhook_deliveries d
+JOIN webhook_endpoints e ON e.id = d.endpoint_id
+JOIN webhook_events ev ON ev.id = d.event_id
+WHERE d.endpoint_id = sqlc.arg(endpoint_id) AND e.user_id = sqlc.arg(user_id)
+  AND (sqlc.narg(before_id)::bigint IS NULL OR d.id < sqlc.narg(before_id)::bigint)
+ORDER BY d.id DESC
+LIMIT sqlc.arg(limit_n);
+
+-- Queues the event of an earlier delivery to its endpoint again. Same columns
+-- as ListWebhookDeliveries.
+-- name: ReplayWebhookDelivery :one
+WITH ins AS (
+  INSERT INTO webhook_deliveries (event_id, endpoint_id)
+  SELECT d.event_id, d.endpoint_id
+  FROM webhook_deliveries d
+  WHERE d.id = sqlc.arg(id) AND d.endpoint_id = sqlc.arg(endpoint_id)
+  RETURNING *
+)
+SELECT ins.id, ins.event_id, ev.type, ins.attempts, ins.next_attempt_at, ins.delivered_at, ins.failed_at,
+       ins.response_status, ins.last_error, ins.created_at
+FROM ins
+JOIN webhook_events ev ON ev.id = ins.event_id;
+
+-- name: ClaimWebhookDeliveries :many
+-- Pushes next_attempt_at forward as a lease, as for balance notifications.
+-- Deliveries to disabled endpoints are not picked up.
+WITH due AS (
+  SELECT d.id
+  FROM webhook_deliveries d
+  JOIN webhook_endpoints e ON e.id = d.endpoint_id
+  WHERE d.delivered_at IS NULL
+    AND d.failed_at IS NULL
+    AND d.next_attempt_at <= now()
+    AND e.disabled_at IS NULL
+  ORDER BY d.next_attempt_at
+  LIMIT sqlc.arg(limit_n)
+  FOR UPDATE OF d SKIP LOCKED
+),
+claimed AS (
+  UPDATE webhook_deliveries d
+  SET attempts = d.attempts + 1,
+      next_attempt_at = now() + sqlc.arg(lease_seconds)::int * interval '1 second'
+  FROM due
+  WHERE d.id = due.id
+  RETURNING d.id, d.event_id, d.endpoint_id, d.attempts
+)
+SELECT c.id, c.attempts, c.endpoint_id, e.url, e.secret, c.event_id, ev.type, ev.payload, ev.created_at
+FROM claimed c
+JOIN webhook_endpoints e ON e.id = c.endpoint_id
+JOIN webhook_events ev ON ev.id = c.event_id;
+
+-- name: MarkWebhookDelivered :exec
+WITH d AS (
+  UPDATE webhook_deliveries w
+  SET delivered_at = now(), response_status = sqlc.arg(response_status), last_error = NULL
+  WHERE w.id = sqlc.arg(id)
+  RETURNING w.endpoint_id
+)
+UPDATE webhook_endpoints e
+SET consecutive_failures = 0
+FROM d
+WHERE e.id = d.endpoint_id AND e.consecutive_failures <> 0;
+
+-- name: RetryWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET next_attempt_at = now() + sqlc.arg(delay_seconds)::int * interval '1 second',
+    response_status = sqlc.narg(response_status),
+    last_error = sqlc.arg(last_error)
+WHERE id = sqlc.arg(id);
+
+-- name: FailWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET failed_at = now(), response_status = sqlc.narg(response_status), last_error = sqlc.arg(last_error)
+WHERE id = sqlc.arg(id);
+
+-- Counts a failed attempt against the endpoint and disables it once
+-- disable_after attempts in a row have failed. disabled is true only for the
+-- failure that disabled it.
+-- name: RecordWebhookEndpointFailure :one
+UPDATE webhook_endpoints e
+SET consecutive_failures = e.consecutive_failures + 1,
+    disabled_at = CASE
+                    WHEN e.disabled_at IS NULL AND e.consecutive_failures + 1 >= sqlc.arg(disable_after)::int THEN now()
+                    ELSE e.disabled_at
+                  END
+FROM (SELECT o.id, o.disabled_at FROM webhook_endpoints o WHERE o.id = sqlc.arg(id) FOR UPDATE) old
+WHERE e.id = old.id
+RETURNING e.user_id, (old.disabled_at IS NULL AND e.disabled_at IS NOT NULL)::bool AS disabled;
+
+-- Gives up on an endpoint's pending deliveries; they can be replayed once it
+-- is enabled again.
+-- name: FailPendingWebhookDeliveries :exec
+UPDATE webhook_deliveries
+SET failed_at = now(), last_error = sqlc.arg(reason)
+WHERE endpoint_id = sqlc.arg(endpoint_id) AND delivered_at IS NULL AND failed_at IS NULL;
@@ -18,7 +18,17 @@ func NewDB(pool *pgxpool.Pool) *DB {
 }
 
 func (db *DB) WithTx(ctx context.Context, fn func(q *dbgen.Queries) error) error {
-	tx, err := db.Pool.BeginTx(ctx, pgx.TxOptions{})
+	return db.withTx(ctx, pgx.TxOptions{}, fn)
+}
+
+// WithSnapshot runs fn in a read-only repeatable-read transaction, so every
+// query sees the same snapshot.
+func (db *DB) WithSnapshot(ctx context.Context, fn func(q *dbgen.Queries) error) error {
+	return db.withTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}, fn)
+}
+
+func (db *DB) withTx(ctx context.Context, opts pgx.TxOptions, fn func(q *dbgen.Queries) error) error {
+	tx, err := db.Pool.BeginTx(ctx, opts)
 	if err != nil {
 		return err
 	}
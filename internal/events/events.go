@@ -0,0 +1,155 @@
+// Package events fans out message status changes to subscribers in this
+// process. Postgres NOTIFYs every change on one channel; a Listener holds a
+// single connection LISTENing on it and hands each change to the matching
+// subscriptions.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Channel is the NOTIFY channel the messages status trigger publishes on.
+const Channel = "message_status"
+
+// StatusEvent is a message status change. ID orders events and is the
+// message_status_events row the change was logged as.
+type StatusEvent struct {
+	ID        int64     `json:"id"`
+	MessageID string    `json:"message_id"`
+	UserID    string    `json:"user_id"`
+	Status    string    `json:"status"`
+	At        time.Time `json:"at"`
+}
+
+// Subscription receives the events its filter matched on C. C is closed when
+// the subscriber falls behind, when the listener loses its connection (events
+// may have been missed; catch up from the event log) and on shutdown.
+type Subscription struct {
+	C <-chan StatusEvent
+
+	c     chan StatusEvent
+	match func(StatusEvent) bool
+	l     *Listener
+}
+
+// Close unsubscribes. It is safe to call more than once.
+func (s *Subscription) Close() { s.l.drop(s) }
+
+// Listener is the process's one LISTEN connection. Run it once; Subscribe
+// may be called before it connects.
+type Listener struct {
+	Pool *pgxpool.Pool
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// Subscribe registers a subscription buffering up to buf events. A nil match
+// takes every event.
+func (l *Listener) Subscribe(buf int, match func(StatusEvent) bool) *Subscription {
+	c := make(chan StatusEvent, buf)
+	s := &Subscription{C: c, c: c, match: match, l: l}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		close(c)
+		return s
+	}
+	if l.subs == nil {
+		l.subs = make(map[*Subscription]struct{})
+	}
+	l.subs[s] = struct{}{}
+	return s
+}
+
+func (l *Listener) drop(s *Subscription) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.subs[s]; ok {
+		delete(l.subs, s)
+		close(s.c)
+	}
+}
+
+// Publish hands ev to every matching subscription. A subscription whose
+// buffer is full is closed rather than blocking the others.
+func (l *Listener) Publish(ev StatusEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for s := range l.subs {
+		if s.match != nil && !s.match(ev) {
+			continue
+		}
+		select {
+		case s.c <- ev:
+		default:
+			delete(l.subs, s)
+			close(s.c)
+		}
+	}
+}
+
+// closeAll ends every subscription; with final set, later ones start closed.
+func (l *Listener) closeAll(final bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for s := range l.subs {
+		delete(l.subs, s)
+		close(s.c)
+	}
+	l.closed = l.closed || final
+}
+
+// Run LISTENs until ctx ends, reconnecting with backoff. Subscriptions are
+// closed whenever the connection drops, and for good when Run returns.
+func (l *Listener) Run(ctx context.Context) error {
+	defer l.closeAll(true)
+	backoff := 200 * time.Millisecond
+	for {
+		err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		l.closeAll(false)
+		log.Printf("status listener: %v; reconnecting in %s", err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 10*time.Second)
+	}
+}
+
+func (l *Listener) listen(ctx context.Context) error {
+	pc, err := l.Pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// A LISTENing connection must not go back to the pool.
+	conn := pc.Hijack()
+	defer func() { _ = conn.Close(context.Background()) }()
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{Channel}.Sanitize()); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var ev StatusEvent
+		if err := json.Unmarshal([]byte(n.Payload), &ev); err != nil {
+			log.Printf("status listener: bad payload %q: %v", n.Payload, err)
+			continue
+		}
+		l.Publish(ev)
+	}
+}
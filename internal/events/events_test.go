@@ -0,0 +1,41 @@
+package events
+
+import "testing"
+
+func TestPublishFiltersAndDropsSlowSubscribers(t *testing.T) {
+	var l Listener
+	mine := l.Subscribe(1, func(ev StatusEvent) bool { return ev.UserID == "u1" })
+	all := l.Subscribe(4, nil)
+	defer all.Close()
+
+	l.Publish(StatusEvent{ID: 1, UserID: "u2"})
+	l.Publish(StatusEvent{ID: 2, UserID: "u1"})
+	if ev := <-mine.C; ev.ID != 2 {
+		t.Fatalf("got event %d, want 2", ev.ID)
+	}
+
+	// A full buffer ends the subscription instead of blocking
+	l.Publish(StatusEvent{ID: 3, UserID: "u1"})
+	l.Publish(StatusEvent{ID: 4, UserID: "u1"})
+	if ev := <-mine.C; ev.ID != 3 {
+		t.Fatalf("got event %d, want 3", ev.ID)
+	}
+	if _, ok := <-mine.C; ok {
+		t.Fatal("slow subscription still open")
+	}
+	mine.Close() // no-op once dropped
+
+	for _, want := range []int64{1, 2, 3, 4} {
+		if ev := <-all.C; ev.ID != want {
+			t.Fatalf("got event %d, want %d", ev.ID, want)
+		}
+	}
+
+	l.closeAll(true)
+	if _, ok := <-all.C; ok {
+		t.Fatal("subscription open after shutdown")
+	}
+	if _, ok := <-l.Subscribe(1, nil).C; ok {
+		t.Fatal("subscription after shutdown not closed")
+	}
+}
@@ -0,0 +1,128 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/auth"
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/go-chi/chi/v5"
+)
+
+// keyWithToken is returned once, on creation and rotation; the token cannot be
+// read back later.
+type keyWithToken struct {
+	core.APIKey
+	Token string `json:"token"`
+}
+
+func (s *Server) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	// scopes: omitted = every scope the caller could grant
+	var in struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	// body is optional
+	if !decodeOptionalBody(w, r, &in) {
+		return
+	}
+	// A member's new key for their own organization belongs to them and is
+	// capped by their role; keys for sub-accounts are account keys. Either
+	// way it can't do more than the caller.
+	p, _ := auth.FromContext(r.Context())
+	var memberID string
+	if p.UserID == id {
+		memberID = p.MemberID
+	}
+	k, token, err := s.Store.CreateAPIKey(r.Context(), id, memberID, in.Name, in.Scopes, p.Grantable())
+	switch {
+	case errors.Is(err, core.ErrInvalidScope):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_scope")
+	case errors.Is(err, core.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+	case errors.Is(err, core.ErrMemberNotFound):
+		writeProblem(w, r, http.StatusForbidden, "forbidden")
+	case err != nil:
+		writeError(w, r, err)
+	default:
+		writeJSON(w, http.StatusCreated, keyWithToken{APIKey: k, Token: token})
+	}
+}
+
+func (s *Server) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	items, err := s.Store.ListAPIKeys(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (s *Server) rotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	keyID := chi.URLParam(r, "key_id")
+	// grace_seconds: how long the old key keeps working (default 0)
+	var in struct {
+		GraceSeconds int `json:"grace_seconds"`
+	}
+	if !decodeOptionalBody(w, r, &in) {
+		return
+	}
+	if in.GraceSeconds < 0 {
+		writeInvalidBody(w, r, FieldError{Field: "grace_seconds", Code: "invalid"})
+		return
+	}
+	p, _ := auth.FromContext(r.Context())
+	k, token, err := s.Store.RotateAPIKey(r.Context(), p, id, keyID, time.Duration(in.GraceSeconds)*time.Second)
+	switch {
+	case errors.Is(err, core.ErrNotFound):
+		writeProblem(w, r, http.StatusNotFound, "not_found")
+	case errors.Is(err, core.ErrKeyInactive):
+		writeProblem(w, r, http.StatusConflict, "key_inactive")
+	case err != nil:
+		writeError(w, r, err)
+	default:
+		writeJSON(w, http.StatusCreated, keyWithToken{APIKey: k, Token: token})
+	}
+}
+
+func (s *Server) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	keyID := chi.URLParam(r, "key_id")
+	p, _ := auth.FromContext(r.Context())
+	err := s.Store.RevokeAPIKey(r.Context(), p, id, keyID)
+	if errors.Is(err, core.ErrNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// enableSigning issues an HMAC signing secret for a key, replacing any earlier
+// one. Like the token, the secret is returned only once; the key's prefix goes
+// in X-Signature-Key-Id.
+func (s *Server) enableSigning(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	keyID := chi.URLParam(r, "key_id")
+	p, _ := auth.FromContext(r.Context())
+	k, secret, err := s.Store.EnableSigning(r.Context(), p, id, keyID)
+	if errors.Is(err, core.ErrNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		core.APIKey
+		SigningSecret string `json:"signing_secret"`
+	}{k, secret})
+}
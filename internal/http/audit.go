@@ -0,0 +1,101 @@
+package httpapi
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/audit"
+	"github.com/Cypherspark/sms-gateway/internal/auth"
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// withAuditActor attributes whatever the request changes to the
+// authenticated caller, its request ID and its address (after realIP).
+func withAuditActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := auth.FromContext(r.Context())
+		a := audit.Actor{
+			Type:      audit.ActorAPIKey,
+			KeyID:     p.KeyID,
+			UserID:    p.UserID,
+			RequestID: middleware.GetReqID(r.Context()),
+			SourceIP:  r.RemoteAddr,
+		}
+		switch {
+		case p.CertID != "":
+			a.Type = audit.ActorClientCert
+		case p.KeyID == "":
+			a.Type = audit.ActorJWT
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			a.SourceIP = host
+		}
+		next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), a)))
+	})
+}
+
+func validUUID(s string) bool {
+	var u pgtype.UUID
+	return u.Scan(s) == nil
+}
+
+// listAuditEvents is the admin view of the audit log, newest first. Page with
+// before=<next_before> from the previous response.
+func (s *Server) listAuditEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := core.AuditFilter{
+		ActorUserID: q.Get("actor_user_id"),
+		ActorKeyID:  q.Get("actor_key_id"),
+		Action:      q.Get("action"),
+		TargetType:  q.Get("target_type"),
+		TargetID:    q.Get("target_id"),
+		Limit:       100,
+	}
+	for _, id := range []string{f.ActorUserID, f.ActorKeyID} {
+		if id != "" && !validUUID(id) {
+			writeProblem(w, r, http.StatusBadRequest, "invalid_actor_id")
+			return
+		}
+	}
+	for name, dst := range map[string]**time.Time{"from": &f.From, "to": &f.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeProblem(w, r, http.StatusBadRequest, "invalid_"+name)
+				return
+			}
+			*dst = &t
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 500 {
+			writeProblem(w, r, http.StatusBadRequest, "invalid_limit")
+			return
+		}
+		f.Limit = n
+	}
+	if v := q.Get("before"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			writeProblem(w, r, http.StatusBadRequest, "invalid_before")
+			return
+		}
+		f.BeforeID = n
+	}
+
+	items, err := s.Store.ListAuditEvents(r.Context(), f)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var next *int64
+	if len(items) == f.Limit {
+		next = &items[len(items)-1].ID
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "next_before": next})
+}
@@ -0,0 +1,196 @@
+package httpapi
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/auth"
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/Cypherspark/sms-gateway/internal/metrics"
+	"github.com/Cypherspark/sms-gateway/signing"
+	"github.com/go-chi/chi/v5"
+)
+
+func writeUnauthenticated(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="sms-gateway"`)
+	writeProblem(w, r, http.StatusUnauthorized, "unauthenticated")
+}
+
+func writeForbidden(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusForbidden, "forbidden")
+}
+
+// maxSignedBody bounds how much of a signed request is buffered to hash it.
+const maxSignedBody = 1 << 20
+
+// authenticate resolves the bearer API key or JWT, the key a request was
+// signed with, or else a verified client certificate, into the request
+// context.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if signing.Signed(r) {
+			s.authenticateSigned(w, r, next)
+			return
+		}
+		token := auth.BearerToken(r)
+		if token == "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			s.authenticateClientCert(w, r, next)
+			return
+		}
+		if token == "" {
+			metrics.AuthFailures.WithLabelValues("missing").Inc()
+			writeUnauthenticated(w, r)
+			return
+		}
+		var p auth.Principal
+		var err error
+		if s.JWT != nil && auth.LooksLikeJWT(token) {
+			p, err = s.JWT.Validate(r.Context(), token)
+			// Keys die with their account, tokens don't: check it still exists.
+			if err == nil && p.UserID != "" {
+				if err = s.Store.CheckUserLive(r.Context(), p.UserID); errors.Is(err, core.ErrUserNotFound) {
+					err = auth.ErrUnauthenticated
+				}
+			}
+		} else {
+			p, err = s.Store.AuthenticateAPIKey(r.Context(), token)
+		}
+		if errors.Is(err, auth.ErrUnauthenticated) {
+			metrics.AuthFailures.WithLabelValues("invalid").Inc()
+			writeUnauthenticated(w, r)
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+	})
+}
+
+// requireScope rejects keys that don't carry scope (admin:* carries all).
+func requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := auth.FromContext(r.Context())
+			if !ok {
+				writeUnauthenticated(w, r)
+				return
+			}
+			if !p.Allows(scope) {
+				metrics.AuthFailures.WithLabelValues("scope").Inc()
+				p := newProblem(http.StatusForbidden, "insufficient_scope")
+				p.Extra = map[string]any{"required_scope": scope}
+				p.write(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireAccount guards /users/{id}/... so a key only reaches its own account
+// and its sub-accounts; admin keys reach every account.
+func (s *Server) requireAccount(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.canActFor(w, r, chi.URLParam(r, "id")) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// canActFor checks the caller against userID and writes the 401/403/500
+// response itself when it returns false.
+func (s *Server) canActFor(w http.ResponseWriter, r *http.Request, userID string) bool {
+	p, ok := auth.FromContext(r.Context())
+	if !ok {
+		writeUnauthenticated(w, r)
+		return false
+	}
+	allowed, err := s.Store.CanActFor(r.Context(), p, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	if !allowed {
+		metrics.AuthFailures.WithLabelValues("forbidden").Inc()
+		writeForbidden(w, r)
+		return false
+	}
+	return true
+}
+
+// authenticateSigned checks an HMAC-signed request: timestamp within
+// SigningMaxSkew, a valid signature from a key with signing enabled, and a
+// nonce not seen before.
+func (s *Server) authenticateSigned(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	params, err := signing.Parse(r)
+	if err != nil {
+		metrics.AuthFailures.WithLabelValues("invalid").Inc()
+		writeUnauthenticated(w, r)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBody))
+	if err != nil {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, "body_too_large")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	p, secret, err := s.Store.SigningKey(r.Context(), params.KeyID)
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		metrics.AuthFailures.WithLabelValues("invalid").Inc()
+		writeUnauthenticated(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	now := time.Now()
+	if err := signing.Verify(r, body, params, secret, now, s.SigningMaxSkew); err != nil {
+		reason := "invalid"
+		if errors.Is(err, signing.ErrSkew) {
+			reason = "skew"
+		}
+		metrics.AuthFailures.WithLabelValues(reason).Inc()
+		writeUnauthenticated(w, r)
+		return
+	}
+	fresh, err := s.SigningNonces.Claim(r.Context(), p.KeyID, params.Nonce, now.Add(2*s.SigningMaxSkew))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !fresh {
+		metrics.AuthFailures.WithLabelValues("replay").Inc()
+		writeUnauthenticated(w, r)
+		return
+	}
+	if err := s.Store.TouchAPIKey(r.Context(), p.KeyID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+}
+
+// authenticateClientCert maps the verified client certificate's subject and
+// SANs to the account bound to them. Verification against the client CA
+// already happened in the TLS handshake.
+func (s *Server) authenticateClientCert(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	p, err := s.Store.AuthenticateClientCert(r.Context(), auth.CertIdentities(r.TLS.VerifiedChains[0][0]))
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		metrics.AuthFailures.WithLabelValues("invalid").Inc()
+		writeUnauthenticated(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+}
@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/go-chi/chi/v5"
+)
+
+// createClientCert binds a client certificate identity to the user. Admin
+// only: identities are global, and whoever binds one decides which account a
+// CA-issued certificate acts for. Tenants may list and revoke their bindings.
+func (s *Server) createClientCert(w http.ResponseWriter, r *http.Request) {
+	// identity: "subject:<DN>", "dns:<SAN>", "uri:<SAN>" or "email:<SAN>"
+	// scopes: omitted = every tenant scope
+	var in struct {
+		Identity string   `json:"identity"`
+		Name     string   `json:"name"`
+		Scopes   []string `json:"scopes"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	c, err := s.Store.CreateClientCert(r.Context(), chi.URLParam(r, "id"), in.Identity, in.Name, in.Scopes)
+	switch {
+	case errors.Is(err, core.ErrInvalidIdentity):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_identity")
+	case errors.Is(err, core.ErrInvalidScope):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_scope")
+	case errors.Is(err, core.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+	case errors.Is(err, core.ErrIdentityTaken):
+		writeProblem(w, r, http.StatusConflict, "identity_taken")
+	case err != nil:
+		writeError(w, r, err)
+	default:
+		writeJSON(w, http.StatusCreated, c)
+	}
+}
+
+func (s *Server) listClientCerts(w http.ResponseWriter, r *http.Request) {
+	items, err := s.Store.ListClientCerts(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (s *Server) revokeClientCert(w http.ResponseWriter, r *http.Request) {
+	err := s.Store.RevokeClientCert(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "cert_id"))
+	switch {
+	case errors.Is(err, core.ErrNotFound):
+		writeProblem(w, r, http.StatusNotFound, "not_found")
+	case err != nil:
+		writeError(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) grantCredit(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	// expires_at wins over expires_in_days; default is 30 days
+	var in struct {
+		Amount        int        `json:"amount"`
+		ExpiresAt     *time.Time `json:"expires_at"`
+		ExpiresInDays *int       `json:"expires_in_days"`
+		Note          *string    `json:"note"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	expires := time.Now().AddDate(0, 0, 30)
+	switch {
+	case in.ExpiresAt != nil:
+		expires = *in.ExpiresAt
+	case in.ExpiresInDays != nil:
+		expires = time.Now().AddDate(0, 0, *in.ExpiresInDays)
+	}
+	b, err := s.Store.GrantCredit(r.Context(), core.GrantRequest{
+		UserID:    id,
+		Amount:    in.Amount,
+		ExpiresAt: expires,
+		Note:      in.Note,
+	})
+	switch {
+	case errors.Is(err, core.ErrInvalidGrant):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_grant")
+	case errors.Is(err, core.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+	case err != nil:
+		writeError(w, r, err)
+	default:
+		writeJSON(w, http.StatusCreated, b)
+	}
+}
+
+func (s *Server) listCredits(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	items, err := s.Store.ListCredits(r.Context(), id)
+	if errors.Is(err, core.ErrUserNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": orEmpty(items)})
+}
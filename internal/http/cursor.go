@@ -0,0 +1,32 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// pageCursor is a keyset position in a list ordered newest first. Clients
+// treat its encoding as opaque.
+type pageCursor struct {
+	At   time.Time `json:"t"`
+	ID   string    `json:"id"`
+	Prev bool      `json:"p,omitempty"` // page towards newer rows
+}
+
+var errBadCursor = errors.New("invalid_cursor")
+
+func (c pageCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.At.IsZero() || !validUUID(c.ID) {
+		return pageCursor{}, errBadCursor
+	}
+	return c, nil
+}
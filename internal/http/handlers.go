@@ -3,13 +3,18 @@ package httpapi
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
+	"net/netip"
 	"strconv"
 	"time"
 
+	"github.com/Cypherspark/sms-gateway/internal/auth"
 	"github.com/Cypherspark/sms-gateway/internal/core"
 	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/Cypherspark/sms-gateway/internal/events"
 	"github.com/Cypherspark/sms-gateway/internal/metrics"
+	"github.com/Cypherspark/sms-gateway/signing"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jackc/pgx/v5"
@@ -18,6 +23,38 @@ import (
 
 type Server struct {
 	Store *core.Store
+
+	// SigningMaxSkew is how far a signed request's timestamp may drift from
+	// the server clock; SigningNonces remembers nonces for twice that long.
+	SigningMaxSkew time.Duration
+	SigningNonces  signing.NonceCache
+
+	// JWT, when set, accepts identity-provider JWTs as bearer tokens; other
+	// bearer tokens are still checked as API keys.
+	JWT *auth.JWTValidator
+
+	// TrustedProxies are the proxies whose X-Forwarded-For / X-Real-IP are
+	// believed when working out a caller's address; with none, the headers
+	// are ignored.
+	TrustedProxies []netip.Prefix
+
+	// Events, when set, feeds GET /messages/stream and ?wait= on
+	// GET /messages/{id}; StreamHeartbeat is how often an idle stream sends a
+	// comment to keep proxies from closing it. MaxWait caps ?wait= and must
+	// stay below the http.Server's WriteTimeout.
+	Events          *events.Listener
+	StreamHeartbeat time.Duration
+	MaxWait         time.Duration
+
+	// Spec is the contract requests are validated against. When
+	// ResponseViolations is set (in tests), responses are checked against it
+	// too and each mismatch is reported there.
+	Spec               *Spec
+	ResponseViolations func(r *http.Request, err error)
+
+	// RateLimits, when set, throttles authenticated requests.
+	RateLimits *RateLimits
+	plans      planCache
 }
 
 func toPgTimestamptz(p *time.Time) pgtype.Timestamptz {
@@ -38,20 +75,89 @@ func toNullMsgStatus(p *string) dbgen.NullMsgStatus {
 }
 
 func NewServer(store *core.Store) *Server {
-	return &Server{Store: store}
+	return &Server{
+		Store:           store,
+		SigningMaxSkew:  5 * time.Minute,
+		SigningNonces:   signing.NewMemoryNonceCache(),
+		StreamHeartbeat: 15 * time.Second,
+		MaxWait:         9 * time.Second,
+		Spec:            mustLoadSpec(),
+	}
 }
 
 func (s *Server) Router() http.Handler {
 	r := chi.NewRouter()
-	r.Use(middleware.RequestID, middleware.RealIP, middleware.Logger, middleware.Recoverer)
-	r.Use(instrument)
+	r.Use(middleware.RequestID, s.realIP, middleware.Logger, middleware.Recoverer)
+	r.Use(instrument, s.checkResponses)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusNotFound, "not_found")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	})
 	s.mountHealth(r)
-	r.Post("/users", s.createUser)
-	r.Post("/users/{id}/topup", s.topUp)
-	r.Get("/users/{id}/balance", s.getBalance)
-	r.Post("/messages", s.postMessage)
-	r.Get("/messages", s.listMessages)
-	r.Get("/messages/{id}", s.getMessage)
+	// Everything below needs an API key (Authorization: Bearer <token>) with
+	// the scope named on the route.
+	admin := requireScope(auth.ScopeAdmin)
+	manage := requireScope(auth.ScopeAccountManage)
+	balanceRead := requireScope(auth.ScopeBalanceRead)
+	members := requireScope(auth.ScopeMembersManage)
+	// The invitation token is the credential here.
+	r.With(s.validateRequest).Post("/invitations/accept", s.acceptInvitation)
+	r.Group(func(r chi.Router) {
+		r.Use(s.authenticate, withAuditActor, s.enforceIPAllowlist, s.rateLimit, s.validateRequest)
+		r.With(admin).Post("/users", s.createUser)
+		r.With(admin).Get("/users", s.listUsers)
+		r.With(admin).Get("/audit-events", s.listAuditEvents)
+		r.Route("/users/{id}", func(r chi.Router) {
+			r.Use(s.requireAccount)
+			r.With(admin).Get("/", s.getUser)
+			r.With(admin).Patch("/", s.updateUser)
+			r.With(admin).Delete("/", s.deleteUser)
+			r.With(admin).Put("/plan", s.setPlan)
+			r.With(admin).Post("/topup", s.topUp)
+			r.With(balanceRead).Get("/balance", s.getBalance)
+			r.With(admin).Post("/credits", s.grantCredit)
+			r.With(balanceRead).Get("/credits", s.listCredits)
+			r.With(manage).Post("/balance/thresholds", s.createThreshold)
+			r.With(balanceRead).Get("/balance/thresholds", s.listThresholds)
+			r.With(manage).Delete("/balance/thresholds/{threshold_id}", s.deleteThreshold)
+			r.With(manage).Post("/children", s.createChild)
+			r.With(manage).Get("/children", s.listChildren)
+			r.With(manage).Patch("/children/{child_id}", s.updateChild)
+			r.With(manage).Post("/transfers", s.transferCredit)
+			r.With(balanceRead).Get("/usage", s.subaccountUsage)
+			r.With(balanceRead).Get("/statement", s.getStatement)
+			r.With(balanceRead).Get("/stats", s.getStats)
+			r.With(manage).Post("/keys", s.createAPIKey)
+			r.With(manage).Get("/keys", s.listAPIKeys)
+			r.With(manage).Post("/keys/{key_id}/rotate", s.rotateAPIKey)
+			r.With(manage).Delete("/keys/{key_id}", s.revokeAPIKey)
+			r.With(manage).Put("/keys/{key_id}/ip-allowlist", s.setAPIKeyIPAllowlist)
+			r.With(manage).Post("/keys/{key_id}/signing-secret", s.enableSigning)
+			r.With(manage).Get("/ip-allowlist", s.getUserIPAllowlist)
+			r.With(manage).Put("/ip-allowlist", s.setUserIPAllowlist)
+			r.With(manage).Post("/webhooks", s.createWebhook)
+			r.With(manage).Get("/webhooks", s.listWebhooks)
+			r.With(manage).Patch("/webhooks/{webhook_id}", s.updateWebhook)
+			r.With(manage).Delete("/webhooks/{webhook_id}", s.deleteWebhook)
+			r.With(manage).Get("/webhooks/{webhook_id}/deliveries", s.listWebhookDeliveries)
+			r.With(manage).Post("/webhooks/{webhook_id}/deliveries/{delivery_id}/replay", s.replayWebhookDelivery)
+			r.With(admin).Post("/client-certs", s.createClientCert)
+			r.With(manage).Get("/client-certs", s.listClientCerts)
+			r.With(manage).Delete("/client-certs/{cert_id}", s.revokeClientCert)
+			r.With(members).Get("/members", s.listMembers)
+			r.With(members).Patch("/members/{member_id}", s.updateMember)
+			r.With(members).Delete("/members/{member_id}", s.removeMember)
+			r.With(members).Post("/invitations", s.createInvitation)
+			r.With(members).Get("/invitations", s.listInvitations)
+			r.With(members).Delete("/invitations/{invitation_id}", s.revokeInvitation)
+		})
+		r.With(requireScope(auth.ScopeMessagesSend)).Post("/messages", s.postMessage)
+		r.With(requireScope(auth.ScopeMessagesRead)).Get("/messages", s.listMessages)
+		r.With(requireScope(auth.ScopeMessagesRead)).Get("/messages/stream", s.streamMessages)
+		r.With(requireScope(auth.ScopeMessagesRead)).Get("/messages/{id}", s.getMessage)
+	})
 	s.mountDocs(r)
 	s.mountMetrics(r)
 
@@ -64,55 +170,150 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// orEmpty keeps an empty list from being sent as null.
+func orEmpty[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
 func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	// owner_*: the organization's first member (defaults to the user's name)
 	var in struct {
-		Name string `json:"name"`
+		Name       string  `json:"name"`
+		OwnerName  string  `json:"owner_name"`
+		OwnerEmail *string `json:"owner_email"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Name == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_body"})
+	if !decodeBody(w, r, &in) {
 		return
 	}
-	id, err := s.Store.CreateUser(r.Context(), in.Name)
-	if err != nil {
-		writeJSON(w, 500, map[string]string{"error": err.Error()})
+	if fe := required("name", in.Name); fe != nil {
+		writeInvalidBody(w, r, fe...)
+		return
+	}
+	id, token, err := s.Store.CreateUserWithKey(r.Context(), in.Name, core.NewMember{Name: in.OwnerName, Email: in.OwnerEmail})
+	switch {
+	case errors.Is(err, core.ErrInvalidEmail):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_email")
+		return
+	case err != nil:
+		writeError(w, r, err)
 		return
 	}
-	writeJSON(w, http.StatusCreated, map[string]string{"id": id, "name": in.Name})
+	writeJSON(w, http.StatusCreated, map[string]string{"id": id, "name": in.Name, "api_key": token})
 }
 
 func (s *Server) topUp(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
+
+	idemp := r.Header.Get("Idempotency-Key")
+	var key *string
+	if idemp != "" {
+		key = &idemp
+	}
+
+	var in struct {
+		Amount      int     `json:"amount"`
+		ExternalRef *string `json:"external_ref"`
+		Note        *string `json:"note"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	if in.Amount <= 0 || in.Amount > math.MaxInt32 { // balances are int4
+		p := newProblem(http.StatusBadRequest, "invalid_amount")
+		p.Errors = []FieldError{{Field: "amount", Code: "invalid"}}
+		p.write(w, r)
+		return
+	}
+	bal, _, err := s.Store.TopUp(r.Context(), core.TopUpRequest{
+		UserID:         id,
+		Amount:         in.Amount,
+		IdempotencyKey: key,
+		ExternalRef:    in.ExternalRef,
+		Note:           in.Note,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, core.ErrUserNotFound):
+			writeProblem(w, r, http.StatusNotFound, "user_not_found")
+		case errors.Is(err, core.ErrIdempotencyKeyReused):
+			writeProblem(w, r, http.StatusUnprocessableEntity, "idempotency_key_reused")
+		default:
+			writeError(w, r, err)
+		}
+		return
+	}
+	writeJSON(w, 200, map[string]any{
+		"user_id": id,
+		"balance": bal,
+	})
+}
+
+func (s *Server) setPlan(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
 	var in struct {
-		Amount int `json:"amount"`
+		Plan string `json:"plan"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	if fe := required("plan", in.Plan); fe != nil {
+		writeInvalidBody(w, r, fe...)
+		return
+	}
+	if s.RateLimits != nil {
+		if _, ok := s.RateLimits.Plans[in.Plan]; !ok {
+			writeProblem(w, r, http.StatusBadRequest, "unknown_plan")
+			return
+		}
 	}
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Amount <= 0 {
-		writeJSON(w, 400, map[string]string{"error": "invalid_amount"})
+	err := s.Store.SetUserPlan(r.Context(), id, in.Plan)
+	if errors.Is(err, core.ErrUserNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
 		return
 	}
-	if err := s.Store.TopUp(r.Context(), core.TopUpRequest{UserID: id, Amount: in.Amount}); err != nil {
-		writeJSON(w, 500, map[string]string{"error": err.Error()})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	writeJSON(w, 200, map[string]any{"ok": true})
+	s.plans.m.Delete(id)
+	writeJSON(w, http.StatusOK, map[string]string{"user_id": id, "plan": in.Plan})
 }
 
 func (s *Server) getBalance(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	bal, err := s.Store.GetBalance(r.Context(), id)
+	bal, err := s.Store.GetBalanceBreakdown(r.Context(), id)
+	if errors.Is(err, core.ErrUserNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+		return
+	}
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "user_not_found"})
+		writeError(w, r, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]any{
-		"user_id": id,
-		"balance": bal,
+		"user_id":     id,
+		"balance":     bal.Balance,
+		"paid":        bal.Paid,
+		"promotional": bal.Promotional,
+		"next_expiry": bal.NextExpiry,
 	})
 }
 
 func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
+	// X-User-ID picks the sender for admin keys (required) and lets a parent
+	// send as one of its sub-accounts; otherwise it is the key's own user.
+	p, _ := auth.FromContext(r.Context())
 	userID := r.Header.Get("X-User-ID")
-	if userID == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing_X-User-ID"})
+	switch {
+	case userID == "" && p.Admin:
+		writeProblem(w, r, http.StatusBadRequest, "missing_X-User-ID")
+		return
+	case userID == "":
+		userID = p.UserID
+	case !s.canActFor(w, r, userID):
 		return
 	}
 
@@ -126,11 +327,24 @@ func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
 		To   string `json:"to"`
 		Body string `json:"body"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.To == "" || in.Body == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_body"})
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	if fe := required("to", in.To, "body", in.Body); fe != nil {
+		writeInvalidBody(w, r, fe...)
 		return
 	}
 
+	// The first answer for a key is stored with it and replayed as is.
+	accepted := func(msgID string) (int, []byte) {
+		body, _ := json.Marshal(map[string]any{
+			"id":      msgID,
+			"user_id": userID,
+			"status":  "queued",
+			"already": false,
+		})
+		return http.StatusAccepted, append(body, '\n')
+	}
 	msgID, already, err := s.Store.EnqueueAndCharge(
 		r.Context(),
 		core.SendRequest{
@@ -138,43 +352,77 @@ func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
 			To:             in.To,
 			Body:           in.Body,
 			IdempotencyKey: key,
+			Response:       accepted,
 		},
 	)
 	if err != nil {
-		if errors.Is(err, core.ErrInsufficientBalance) {
+		var full *core.QueueFullError
+		switch {
+		case errors.Is(err, core.ErrIdempotencyKeyReused):
+			metrics.APIEnqueue.WithLabelValues("idempotency_key_reused").Inc()
+			writeProblem(w, r, http.StatusUnprocessableEntity, "idempotency_key_reused")
+		case errors.Is(err, core.ErrInsufficientBalance):
 			metrics.APIEnqueue.WithLabelValues("insufficient_balance").Inc()
-			writeJSON(w, http.StatusPaymentRequired, map[string]string{
-				"error": "insufficient_balance",
-			})
-			return
+			writeProblem(w, r, http.StatusPaymentRequired, "insufficient_balance")
+		case errors.Is(err, core.ErrQuotaExceeded):
+			metrics.APIEnqueue.WithLabelValues("quota_exceeded").Inc()
+			writeProblem(w, r, http.StatusPaymentRequired, "quota_exceeded")
+		case errors.As(err, &full):
+			metrics.APIEnqueue.WithLabelValues("queue_full").Inc()
+			p := newProblem(http.StatusTooManyRequests, "queue_full")
+			p.Extra = map[string]any{"scope": full.Scope, "depth": full.Depth, "limit": full.Limit}
+			p.write(w, r)
+		case errors.Is(err, core.ErrUserNotFound):
+			metrics.APIEnqueue.WithLabelValues("user_not_found").Inc()
+			writeProblem(w, r, http.StatusNotFound, "user_not_found")
+		case errors.Is(err, core.ErrUserSuspended):
+			metrics.APIEnqueue.WithLabelValues("user_suspended").Inc()
+			writeProblem(w, r, http.StatusForbidden, "user_suspended")
+		default:
+			metrics.APIEnqueue.WithLabelValues("error").Inc()
+			writeError(w, r, err)
 		}
-		metrics.APIEnqueue.WithLabelValues("error").Inc()
-		writeJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
 		return
 	}
 
-	status := http.StatusAccepted
-	if already {
-		metrics.APIEnqueue.WithLabelValues("idempotent").Inc()
-		status = http.StatusOK
-	} else {
+	if !already {
 		metrics.APIEnqueue.WithLabelValues("ok").Inc()
+		status, body := accepted(msgID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+		return
 	}
 
-	writeJSON(w, status, map[string]any{
+	metrics.APIEnqueue.WithLabelValues("idempotent").Inc()
+	w.Header().Set("Idempotent-Replayed", "true")
+	if stored, err := s.Store.IdempotentResponse(r.Context(), userID, idemp); err == nil && stored.Status != 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(stored.Status)
+		_, _ = w.Write(stored.Body)
+		return
+	}
+	// Keys from before responses were stored.
+	writeJSON(w, http.StatusOK, map[string]any{
 		"id":      msgID,
 		"user_id": userID,
 		"status":  "queued",
-		"already": already,
+		"already": true,
 	})
 }
 
 func (s *Server) listMessages(w http.ResponseWriter, r *http.Request) {
+	// Defaults to the caller; a parent may list a sub-account's messages and an
+	// admin must name the user.
+	p, _ := auth.FromContext(r.Context())
 	userID := r.URL.Query().Get("user_id")
-	if userID == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "user_id_required"})
+	switch {
+	case userID == "" && p.Admin:
+		writeProblem(w, r, http.StatusBadRequest, "user_id_required")
+		return
+	case userID == "":
+		userID = p.UserID
+	case !s.canActFor(w, r, userID):
 		return
 	}
 
@@ -202,50 +450,152 @@ func (s *Server) listMessages(w http.ResponseWriter, r *http.Request) {
 			limit = n
 		}
 	}
-	offset := 0
+
+	// Deprecated: offset pages shift when new messages arrive; use cursor.
 	if v := r.URL.Query().Get("offset"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
-			offset = n
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			offset = 0
+		}
+		items, err := s.Store.DB.Queries.ListMessages(r.Context(), dbgen.ListMessagesParams{
+			UserID:  userID,
+			Status:  toNullMsgStatus(statusPtr),
+			FromTs:  toPgTimestamptz(fromPtr),
+			ToTs:    toPgTimestamptz(toPtr),
+			LimitN:  int32(limit),
+			OffsetN: int32(offset),
+		})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Deprecation", "true")
+		var next *string
+		if len(items) == limit {
+			last := items[len(items)-1]
+			c := pageCursor{At: last.RequestedAt.Time, ID: last.ID}.String()
+			next = &c
 		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"items":       orEmpty(items),
+			"limit":       limit,
+			"offset":      offset,
+			"next_cursor": next,
+		})
+		return
 	}
 
-	params := dbgen.ListMessagesParams{
-		UserID:  userID,
-		Status:  toNullMsgStatus(statusPtr),
-		FromTs:  toPgTimestamptz(fromPtr),
-		ToTs:    toPgTimestamptz(toPtr),
-		LimitN:  int32(limit),
-		OffsetN: int32(offset),
+	var cur *pageCursor
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		c, err := parseCursor(v)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "invalid_cursor")
+			return
+		}
+		cur = &c
 	}
 
-	items, err := s.Store.DB.Queries.ListMessages(r.Context(), params)
-
+	// One extra row tells whether there is another page in that direction.
+	backward := cur != nil && cur.Prev
+	var items []dbgen.ListMessagesRow
+	var err error
+	if backward {
+		var rows []dbgen.ListMessagesAfterRow
+		rows, err = s.Store.DB.Queries.ListMessagesAfter(r.Context(), dbgen.ListMessagesAfterParams{
+			UserID:   userID,
+			Status:   toNullMsgStatus(statusPtr),
+			FromTs:   toPgTimestamptz(fromPtr),
+			ToTs:     toPgTimestamptz(toPtr),
+			CursorTs: toPgTimestamptz(&cur.At),
+			CursorID: cur.ID,
+			LimitN:   int32(limit + 1),
+		})
+		for i := len(rows) - 1; i >= 0; i-- {
+			items = append(items, dbgen.ListMessagesRow(rows[i]))
+		}
+	} else {
+		var rows []dbgen.ListMessagesBeforeRow
+		params := dbgen.ListMessagesBeforeParams{
+			UserID: userID,
+			Status: toNullMsgStatus(statusPtr),
+			FromTs: toPgTimestamptz(fromPtr),
+			ToTs:   toPgTimestamptz(toPtr),
+			LimitN: int32(limit + 1),
+		}
+		if cur != nil {
+			params.CursorTs = toPgTimestamptz(&cur.At)
+			_ = params.CursorID.Scan(cur.ID) // checked by parseCursor
+		}
+		rows, err = s.Store.DB.Queries.ListMessagesBefore(r.Context(), params)
+		for _, row := range rows {
+			items = append(items, dbgen.ListMessagesRow(row))
+		}
+	}
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
+	more := len(items) > limit
+	if more && backward {
+		items = items[1:] // the extra row is the newest
+	} else if more {
+		items = items[:limit]
+	}
+
+	var next, prev *string
+	if len(items) > 0 {
+		first, last := items[0], items[len(items)-1]
+		// Older rows remain if the extra row came back going older, and
+		// always after going newer (we came from there).
+		if more || backward {
+			c := pageCursor{At: last.RequestedAt.Time, ID: last.ID}.String()
+			next = &c
+		}
+		// Likewise for newer rows. The first page has none yet.
+		if (backward && more) || (cur != nil && !backward) {
+			c := pageCursor{At: first.RequestedAt.Time, ID: first.ID, Prev: true}.String()
+			prev = &c
+		}
+	}
 	writeJSON(w, http.StatusOK, map[string]any{
-		"items":  items,
-		"limit":  limit,
-		"offset": offset,
+		"items":       orEmpty(items),
+		"limit":       limit,
+		"next_cursor": next,
+		"prev_cursor": prev,
 	})
 }
 
 func (s *Server) getMessage(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "id_required"})
+		writeProblem(w, r, http.StatusBadRequest, "id_required")
+		return
+	}
+	wait, until, ok := parseWait(w, r)
+	if !ok {
 		return
 	}
 
 	msg, err := s.Store.DB.Queries.GetMessage(r.Context(), id)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not_found"})
+			writeProblem(w, r, http.StatusNotFound, "not_found")
 			return
 		}
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, r, err)
+		return
+	}
+	// Someone else's message is reported as missing rather than forbidden.
+	p, _ := auth.FromContext(r.Context())
+	if ok, err := s.Store.CanActFor(r.Context(), p, msg.UserID); err != nil || !ok {
+		writeProblem(w, r, http.StatusNotFound, "not_found")
 		return
 	}
+	if wait > 0 && !until[string(msg.Status)] && s.Events != nil {
+		if msg, err = s.waitForStatus(r.Context(), id, wait, until); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
 	writeJSON(w, http.StatusOK, msg)
 }
@@ -1,76 +1,1210 @@
 package httpapi_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Cypherspark/sms-gateway/internal/auth"
 	"github.com/Cypherspark/sms-gateway/internal/core"
 	dbpkg "github.com/Cypherspark/sms-gateway/internal/db"
+	"github.com/Cypherspark/sms-gateway/internal/events"
 	"github.com/Cypherspark/sms-gateway/internal/http"
+	"github.com/Cypherspark/sms-gateway/internal/ratelimit"
+	"github.com/Cypherspark/sms-gateway/signing"
 	"github.com/stretchr/testify/require"
 )
 
+// startAPI returns a server on a fresh database whose every response must
+// match api/openapi.yaml.
 func startAPI(t *testing.T) *httpapi.Server {
 	database := dbpkg.StartTestPostgres(t)
-	return httpapi.NewServer(&core.Store{DB: database})
+	srv := httpapi.NewServer(&core.Store{DB: database})
+	srv.ResponseViolations = func(r *http.Request, err error) {
+		t.Errorf("%s %s: response doesn't match the spec: %v", r.Method, r.URL, err)
+	}
+	return srv
+}
+
+func adminToken(t *testing.T, srv *httpapi.Server) string {
+	_, token, err := srv.Store.CreateAdminKey(context.Background(), "test")
+	require.NoError(t, err)
+	return token
 }
 
 func TestCreateUserTopUpSend_ListAndBalance(t *testing.T) {
 	srv := startAPI(t)
 	h := srv.Router()
+	admin := "Bearer " + adminToken(t, srv)
 
-	// 1) create user
+	// 1) create user (admin only)
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest("POST", "/users", bytes.NewBufferString(`{"name":"acme"}`))
+	req.Header.Set("Authorization", admin)
 	h.ServeHTTP(w, req)
 	require.Equal(t, http.StatusCreated, w.Code)
 	var user map[string]any
 	_ = json.Unmarshal(w.Body.Bytes(), &user)
 	uid := user["id"].(string)
+	bearer := "Bearer " + user["api_key"].(string)
 
-	// 2) top up
+	// 2) top up (admin only)
 	w = httptest.NewRecorder()
 	req = httptest.NewRequest("POST", "/users/"+uid+"/topup", bytes.NewBufferString(`{"amount":5}`))
+	req.Header.Set("Authorization", bearer)
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/users/"+uid+"/topup", bytes.NewBufferString(`{"amount":5,"external_ref":"psp-1"}`))
+	req.Header.Set("Authorization", admin)
+	req.Header.Set("Idempotency-Key", "topup-1")
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var bal map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &bal)
+	require.EqualValues(t, 5, bal["balance"])
+
+	// Retried top-up must not credit twice
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/users/"+uid+"/topup", bytes.NewBufferString(`{"amount":5,"external_ref":"psp-1"}`))
+	req.Header.Set("Authorization", admin)
+	req.Header.Set("Idempotency-Key", "topup-1")
 	h.ServeHTTP(w, req)
 	require.Equal(t, http.StatusOK, w.Code)
+	_ = json.Unmarshal(w.Body.Bytes(), &bal)
+	require.EqualValues(t, 5, bal["balance"])
+
+	// Same key, different amount or reference → refused, nothing credited
+	for _, body := range []string{`{"amount":50,"external_ref":"psp-1"}`, `{"amount":5,"external_ref":"psp-2"}`} {
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest("POST", "/users/"+uid+"/topup", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", admin)
+		req.Header.Set("Idempotency-Key", "topup-1")
+		h.ServeHTTP(w, req)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code, body)
+		require.Contains(t, w.Body.String(), "idempotency_key_reused")
+	}
+
+	// Amounts that don't fit a balance
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/users/"+uid+"/topup", bytes.NewBufferString(`{"amount":2147483648}`))
+	req.Header.Set("Authorization", admin)
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "invalid_amount")
+
+	// Unknown user
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/users/00000000-0000-0000-0000-000000000000/topup", bytes.NewBufferString(`{"amount":5}`))
+	req.Header.Set("Authorization", admin)
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// Another account is off limits to a tenant key
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/users/00000000-0000-0000-0000-000000000000/balance", nil)
+	req.Header.Set("Authorization", bearer)
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusForbidden, w.Code)
 
 	// 3) send (idempotent)
 	body := bytes.NewBufferString(`{"to":"+49","body":"hello"}`)
 	req = httptest.NewRequest("POST", "/messages", body)
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-User-ID", uid)
+	req.Header.Set("Authorization", bearer)
 	req.Header.Set("Idempotency-Key", "k1")
 	w = httptest.NewRecorder()
 	h.ServeHTTP(w, req)
 	require.Equal(t, http.StatusAccepted, w.Code)
-	var msgResp map[string]string
-	_ = json.Unmarshal(w.Body.Bytes(), &msgResp)
-	firstID := msgResp["id"]
+	first := w.Body.String()
+	require.Empty(t, w.Header().Get("Idempotent-Replayed"))
 
-	// Repeat same request → must be 200 with same id
+	// Repeat same request → the first response, byte for byte
 	body = bytes.NewBufferString(`{"to":"+49","body":"hello"}`)
 	req = httptest.NewRequest("POST", "/messages", body)
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-User-ID", uid)
+	req.Header.Set("Authorization", bearer)
 	req.Header.Set("Idempotency-Key", "k1")
 	w = httptest.NewRecorder()
 	h.ServeHTTP(w, req)
-	require.Equal(t, http.StatusOK, w.Code)
-	_ = json.Unmarshal(w.Body.Bytes(), &msgResp)
-	require.Equal(t, firstID, msgResp["id"])
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Equal(t, first, w.Body.String())
+	require.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+
+	// Same key, different message → refused rather than dropped
+	req = httptest.NewRequest("POST", "/messages", bytes.NewBufferString(`{"to":"+49","body":"goodbye"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", bearer)
+	req.Header.Set("Idempotency-Key", "k1")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	require.Contains(t, w.Body.String(), "idempotency_key_reused")
 
 	// 4) list messages
 	w = httptest.NewRecorder()
 	req = httptest.NewRequest("GET", "/messages?user_id="+uid+"&limit=10", nil)
+	req.Header.Set("Authorization", bearer)
 	h.ServeHTTP(w, req)
 	require.Equal(t, http.StatusOK, w.Code)
 
 	// 5) balance endpoint
 	w = httptest.NewRecorder()
 	req = httptest.NewRequest("GET", "/users/"+uid+"/balance", nil)
+	req.Header.Set("Authorization", bearer)
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAPIKeys_UnauthenticatedRotateRevoke(t *testing.T) {
+	srv := startAPI(t)
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusUnauthorized, do("POST", "/users", "", `{"name":"acme"}`).Code)
+	w := do("POST", "/users", admin, `{"name":"acme"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var user map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &user)
+	uid, first := user["id"], user["api_key"]
+
+	// No key, or a bogus one
+	require.Equal(t, http.StatusUnauthorized, do("GET", "/users/"+uid+"/balance", "", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do("GET", "/users/"+uid+"/balance", "sgw_000000000000_"+strings.Repeat("0", 64), "").Code)
+	require.Equal(t, http.StatusOK, do("GET", "/users/"+uid+"/balance", first, "").Code)
+
+	// Another user's key can't read this account
+	w = do("POST", "/users", admin, `{"name":"other"}`)
+	var other map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &other)
+	require.Equal(t, http.StatusForbidden, do("GET", "/users/"+uid+"/balance", other["api_key"], "").Code)
+
+	// Rotate without grace: the old key stops working, the new one works
+	w = do("GET", "/users/"+uid+"/keys", first, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var keys struct {
+		Items []struct {
+			ID string `json:"id"`
+		} `json:"items"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &keys)
+	require.Len(t, keys.Items, 1)
+
+	w = do("POST", "/users/"+uid+"/keys/"+keys.Items[0].ID+"/rotate", first, "")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var rotated map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &rotated)
+	second := rotated["token"].(string)
+	require.Equal(t, http.StatusUnauthorized, do("GET", "/users/"+uid+"/balance", first, "").Code)
+	require.Equal(t, http.StatusOK, do("GET", "/users/"+uid+"/balance", second, "").Code)
+
+	// Revoke the new key
+	require.Equal(t, http.StatusNoContent, do("DELETE", "/users/"+uid+"/keys/"+rotated["id"].(string), second, "").Code)
+	require.Equal(t, http.StatusUnauthorized, do("GET", "/users/"+uid+"/balance", second, "").Code)
+}
+
+func TestAPIKeys_ScopesEnforcedPerRoute(t *testing.T) {
+	srv := startAPI(t)
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/users", admin, `{"name":"acme"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var user map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &user)
+	uid, full := user["id"], user["api_key"]
+	require.Equal(t, http.StatusOK, do("POST", "/users/"+uid+"/topup", admin, `{"amount":5}`).Code)
+
+	// A tenant can't mint admin keys
+	require.Equal(t, http.StatusBadRequest, do("POST", "/users/"+uid+"/keys", full, `{"scopes":["admin:*"]}`).Code)
+
+	// A send-only key
+	w = do("POST", "/users/"+uid+"/keys", full, `{"name":"sender","scopes":["messages:send"]}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var key map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &key)
+	sender := key["token"].(string)
+
+	require.Equal(t, http.StatusAccepted, do("POST", "/messages", sender, `{"to":"+49","body":"hi"}`).Code)
+	require.Equal(t, http.StatusForbidden, do("GET", "/messages", sender, "").Code)
+	require.Equal(t, http.StatusForbidden, do("GET", "/users/"+uid+"/balance", sender, "").Code)
+
+	// A manage-only key can't mint keys that do more than it does
+	w = do("POST", "/users/"+uid+"/keys", full, `{"name":"ops","scopes":["account:manage"]}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	_ = json.Unmarshal(w.Body.Bytes(), &key)
+	ops := key["token"].(string)
+	require.Equal(t, http.StatusBadRequest, do("POST", "/users/"+uid+"/keys", ops, `{"scopes":["messages:send"]}`).Code)
+	w = do("POST", "/users/"+uid+"/keys", ops, `{"name":"ops-2"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Contains(t, w.Body.String(), `"scopes":["account:manage"]`)
+
+	// Admin acts on behalf of a user via X-User-ID
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/messages", bytes.NewBufferString(`{"to":"+49","body":"hi"}`))
+	req.Header.Set("Authorization", "Bearer "+admin)
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/messages", bytes.NewBufferString(`{"to":"+49","body":"hi"}`))
+	req.Header.Set("Authorization", "Bearer "+admin)
+	req.Header.Set("X-User-ID", uid)
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Equal(t, http.StatusOK, do("GET", "/users/"+uid+"/balance", admin, "").Code)
+}
+
+func TestRequestSigning_VerifiedAndReplayRejected(t *testing.T) {
+	srv := startAPI(t)
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/users", bytes.NewBufferString(`{"name":"acme"}`))
+	req.Header.Set("Authorization", "Bearer "+admin)
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var user map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &user)
+	uid, token := user["id"], user["api_key"]
+
+	keys, err := srv.Store.ListAPIKeys(context.Background(), uid)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/users/"+uid+"/keys/"+keys[0].ID+"/signing-secret", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var enabled map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &enabled)
+	signer := signing.Signer{KeyID: enabled["prefix"].(string), Secret: []byte(enabled["signing_secret"].(string))}
+
+	signed := func() *http.Request {
+		req := httptest.NewRequest("GET", "/users/"+uid+"/balance", nil)
+		require.NoError(t, signer.Sign(req))
+		return req
+	}
+
+	req = signed()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The same signed request again is a replay
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// A tampered path fails verification
+	req = signed()
+	req.URL.Path = "/users/" + uid + "/credits"
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Outside the clock-skew window
+	old := signing.Signer{KeyID: signer.KeyID, Secret: signer.Secret, Now: func() time.Time { return time.Now().Add(-time.Hour) }}
+	req = httptest.NewRequest("GET", "/users/"+uid+"/balance", nil)
+	require.NoError(t, old.Sign(req))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestJWTBearer_FallsBackToAPIKeys(t *testing.T) {
+	srv := startAPI(t)
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	pub, _ := key.PublicKey.Bytes()
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kid": "k1", "kty": "EC", "crv": "P-256", "x": b64(pub[1:33]), "y": b64(pub[33:])},
+	}})
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(file, jwks, 0o600))
+	srv.JWT = &auth.JWTValidator{Keys: &auth.JWKS{File: file}, Issuer: "idp"}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/users", bytes.NewBufferString(`{"name":"acme"}`))
+	req.Header.Set("Authorization", "Bearer "+admin) // API keys still work
 	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var user map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &user)
+	uid := user["id"]
+
+	jwt := func(claims map[string]any) string {
+		hdr, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1"})
+		body, _ := json.Marshal(claims)
+		input := b64(hdr) + "." + b64(body)
+		digest := sha256.Sum256([]byte(input))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		require.NoError(t, err)
+		return input + "." + b64(append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...))
+	}
+	get := func(token string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/users/"+uid+"/balance", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	require.Equal(t, http.StatusOK, get(jwt(map[string]any{"iss": "idp", "sub": uid, "exp": exp, "scope": "balance:read"})))
+	require.Equal(t, http.StatusForbidden, get(jwt(map[string]any{"iss": "idp", "sub": uid, "exp": exp, "scope": "messages:send"})))
+	require.Equal(t, http.StatusUnauthorized, get(jwt(map[string]any{"iss": "other", "sub": uid, "exp": exp, "scope": "balance:read"})))
+
+	// A still-valid token for a deleted account is refused
+	require.NoError(t, srv.Store.DeleteUser(context.Background(), uid))
+	require.Equal(t, http.StatusUnauthorized, get(jwt(map[string]any{"iss": "idp", "sub": uid, "exp": exp, "scope": "balance:read"})))
+}
+
+func TestRateLimit_PerUserPlanWithHeaders(t *testing.T) {
+	srv := startAPI(t)
+	srv.RateLimits = &httpapi.RateLimits{
+		Store:       ratelimit.NewMemory(),
+		Plans:       map[string]ratelimit.Limit{"standard": {Rate: 0.01, Burst: 2}, "premium": {Rate: 100, Burst: 100}},
+		DefaultPlan: "standard",
+	}
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/users", admin, `{"name":"acme"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var user map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &user)
+	uid, token := user["id"], user["api_key"]
+
+	w = do("GET", "/users/"+uid+"/balance", token, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, http.StatusOK, do("GET", "/users/"+uid+"/balance", token, "").Code)
+
+	w = do("POST", "/messages", token, `{"to":"+49","body":"hi"}`)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.NotEmpty(t, w.Header().Get("Retry-After"))
+	require.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	// Upgrading the plan lifts the limit
+	require.Equal(t, http.StatusBadRequest, do("PUT", "/users/"+uid+"/plan", admin, `{"plan":"gold"}`).Code)
+	require.Equal(t, http.StatusOK, do("PUT", "/users/"+uid+"/plan", admin, `{"plan":"premium"}`).Code)
+	time.Sleep(50 * time.Millisecond) // the empty bucket refills at the premium rate
+	require.Equal(t, http.StatusOK, do("GET", "/users/"+uid+"/balance", token, "").Code)
+}
+
+func TestAuditEvents_RecordedAndFilterable(t *testing.T) {
+	srv := startAPI(t)
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = "203.0.113.7:4711"
+		h.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/users", admin, `{"name":"acme"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var user map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &user)
+	uid, token := user["id"], user["api_key"]
+	require.Equal(t, http.StatusOK, do("POST", "/users/"+uid+"/topup", admin, `{"amount":7}`).Code)
+	require.Equal(t, http.StatusCreated, do("POST", "/users/"+uid+"/keys", token, `{"name":"ci"}`).Code)
+
+	// Tenants can't read the audit log
+	require.Equal(t, http.StatusForbidden, do("GET", "/audit-events", token, "").Code)
+
+	w = do("GET", "/audit-events?action=balance.topup&target_id="+uid, admin, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var page struct {
+		Items []core.AuditEvent `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 1)
+	ev := page.Items[0]
+	require.Equal(t, "api_key", ev.ActorType)
+	require.Nil(t, ev.ActorUserID) // admin key
+	require.NotNil(t, ev.RequestID)
+	require.Equal(t, "203.0.113.7", *ev.SourceIP)
+	require.JSONEq(t, `{"balance":0}`, string(ev.Before))
+
+	w = do("GET", "/audit-events?action=key.create&actor_user_id="+uid, admin, "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 1)
+	require.Equal(t, uid, *page.Items[0].ActorUserID)
+
+	// The log is append-only
+	_, err := srv.Store.DB.Pool.Exec(context.Background(), "DELETE FROM audit_events")
+	require.Error(t, err)
+}
+
+func TestOrganizations_InviteRolesAndRemoval(t *testing.T) {
+	srv := startAPI(t)
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/users", admin, `{"name":"acme","owner_name":"Ann","owner_email":"Ann@Acme.test"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var user map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &user)
+	uid, owner := user["id"], user["api_key"]
+
+	w = do("POST", "/users/"+uid+"/invitations", owner, `{"email":"dev@acme.test","role":"developer"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var inv struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &inv))
+
+	w = do("POST", "/invitations/accept", "", `{"token":"`+inv.Token+`","name":"Dev"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var accepted struct {
+		Member core.Member `json:"member"`
+		Key    struct {
+			Token  string   `json:"token"`
+			Scopes []string `json:"scopes"`
+		} `json:"key"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	require.Equal(t, "developer", accepted.Member.Role)
+	dev := accepted.Key.Token
+	// Tokens are single-use
+	require.Equal(t, http.StatusGone, do("POST", "/invitations/accept", "", `{"token":"`+inv.Token+`"}`).Code)
+
+	// A developer can read but not manage the account or its members
+	require.Equal(t, http.StatusOK, do("GET", "/users/"+uid+"/balance", dev, "").Code)
+	require.Equal(t, http.StatusForbidden, do("GET", "/users/"+uid+"/members", dev, "").Code)
+	require.Equal(t, http.StatusForbidden, do("GET", "/users/"+uid+"/keys", dev, "").Code)
+
+	w = do("GET", "/users/"+uid+"/members", owner, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Items []core.Member `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Items, 2)
+	require.Equal(t, "ann@acme.test", *list.Items[0].Email)
+	ownerID := list.Items[0].ID
+
+	// Demoting to viewer narrows the developer's existing key at once
+	require.Equal(t, http.StatusNoContent, do("PATCH", "/users/"+uid+"/members/"+accepted.Member.ID, owner, `{"role":"viewer"}`).Code)
+	require.Equal(t, http.StatusForbidden, do("POST", "/messages", dev, `{"to":"+15551234567","body":"hi"}`).Code)
+
+	// The last owner can't be demoted or removed
+	require.Equal(t, http.StatusConflict, do("PATCH", "/users/"+uid+"/members/"+ownerID, owner, `{"role":"billing"}`).Code)
+	require.Equal(t, http.StatusConflict, do("DELETE", "/users/"+uid+"/members/"+ownerID, owner, "").Code)
+
+	// Removing a member revokes their keys
+	require.Equal(t, http.StatusNoContent, do("DELETE", "/users/"+uid+"/members/"+accepted.Member.ID, owner, "").Code)
+	require.Equal(t, http.StatusUnauthorized, do("GET", "/users/"+uid+"/balance", dev, "").Code)
+}
+
+func TestClientCertificates_MapIdentityToUser(t *testing.T) {
+	srv := startAPI(t)
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	// The TLS handshake has already verified the chain; the handler only sees
+	// the result.
+	cert := func(cn, dns string) *tls.ConnectionState {
+		c := &x509.Certificate{Subject: pkix.Name{CommonName: cn, Organization: []string{"Acme"}}, DNSNames: []string{dns}}
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{c}}}
+	}
+	do := func(method, path, token string, conn *tls.ConnectionState, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.TLS = conn
+		h.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/users", admin, nil, `{"name":"acme"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var user map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &user)
+	uid, token := user["id"], user["api_key"]
+
+	// Only admins bind identities
+	body := `{"identity":"dns:Client.Acme.test","scopes":["balance:read"]}`
+	require.Equal(t, http.StatusForbidden, do("POST", "/users/"+uid+"/client-certs", token, nil, body).Code)
+	w = do("POST", "/users/"+uid+"/client-certs", admin, nil, body)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var bound core.ClientCert
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &bound))
+	require.Equal(t, "dns:client.acme.test", bound.Identity)
+	require.Equal(t, http.StatusConflict, do("POST", "/users/"+uid+"/client-certs", admin, nil, body).Code)
+	require.Equal(t, http.StatusBadRequest, do("POST", "/users/"+uid+"/client-certs", admin, nil, `{"identity":"cn:acme"}`).Code)
+
+	good := cert("acme", "client.acme.test")
+	require.Equal(t, http.StatusOK, do("GET", "/users/"+uid+"/balance", "", good, "").Code)
+	require.Equal(t, http.StatusForbidden, do("GET", "/messages", "", good, "").Code) // scope
+	require.Equal(t, http.StatusUnauthorized, do("GET", "/users/"+uid+"/balance", "", cert("other", "other.test"), "").Code)
+	// A bearer token takes precedence over the certificate
+	require.Equal(t, http.StatusOK, do("GET", "/messages", token, good, "").Code)
+
+	// Subject DNs bind too
+	body = `{"identity":"subject:CN=other,O=Acme"}`
+	require.Equal(t, http.StatusCreated, do("POST", "/users/"+uid+"/client-certs", admin, nil, body).Code)
+	require.Equal(t, http.StatusOK, do("GET", "/messages", "", cert("other", "other.test"), "").Code)
+
+	// The tenant can revoke its own bindings
+	require.Equal(t, http.StatusNoContent, do("DELETE", "/users/"+uid+"/client-certs/"+bound.ID, token, nil, "").Code)
+	require.Equal(t, http.StatusUnauthorized, do("GET", "/users/"+uid+"/balance", "", good, "").Code)
+}
+
+func TestIPAllowlists_TrustedProxiesAndAudit(t *testing.T) {
+	srv := startAPI(t)
+	srv.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	do := func(method, path, token, from, xff, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = from + ":4711"
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		h.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/users", admin, "10.1.1.1", "", `{"name":"acme"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var user map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &user)
+	uid, token := user["id"], user["api_key"]
+	balance := "/users/" + uid + "/balance"
+
+	w = do("PUT", "/users/"+uid+"/ip-allowlist", token, "198.51.100.9", "", `{"cidrs":["198.51.100.0/24","2001:db8::1"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"cidrs":["198.51.100.0/24","2001:db8::1/128"]}`, w.Body.String())
+	require.Equal(t, http.StatusBadRequest, do("PUT", "/users/"+uid+"/ip-allowlist", token, "198.51.100.9", "", `{"cidrs":["nope"]}`).Code)
+
+	require.Equal(t, http.StatusOK, do("GET", balance, token, "198.51.100.9", "", "").Code)
+	// Through the trusted proxy, the forwarded client address counts...
+	require.Equal(t, http.StatusOK, do("GET", balance, token, "10.0.0.2", "198.51.100.9", "").Code)
+	// ...and a client-supplied hop to its left is ignored
+	w = do("GET", balance, token, "10.0.0.2", "198.51.100.9, 203.0.113.5", "")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), `"code":"ip_not_allowed"`)
+	require.Contains(t, w.Body.String(), `"scope":"user"`)
+	// Untrusted peers can't spoof the header at all
+	require.Equal(t, http.StatusForbidden, do("GET", balance, token, "203.0.113.5", "198.51.100.9", "").Code)
+
+	// Key lists narrow further
+	w = do("POST", "/users/"+uid+"/keys", token, "198.51.100.9", "", `{"name":"ci"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var key struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &key))
+	require.Equal(t, http.StatusOK, do("PUT", "/users/"+uid+"/keys/"+key.ID+"/ip-allowlist", token, "198.51.100.9", "", `{"cidrs":["198.51.100.7"]}`).Code)
+	require.Equal(t, http.StatusOK, do("GET", balance, key.Token, "198.51.100.7", "", "").Code)
+	w = do("GET", balance, key.Token, "198.51.100.9", "", "")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), `"code":"ip_not_allowed"`)
+	require.Contains(t, w.Body.String(), `"scope":"key"`)
+
+	// Denials are audited against the list that rejected them
+	w = do("GET", "/audit-events?action=access.ip_denied", admin, "10.1.1.1", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var page struct {
+		Items []core.AuditEvent `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 3)
+	require.Equal(t, "api_key", page.Items[0].TargetType)
+	require.Equal(t, key.ID, page.Items[0].TargetID)
+	require.Equal(t, "198.51.100.9", *page.Items[0].SourceIP)
+	require.Equal(t, "203.0.113.5", *page.Items[1].SourceIP)
+}
+
+func TestListMessages_KeysetCursors(t *testing.T) {
+	srv := startAPI(t)
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	get := func(path string) (int, http.Header, map[string]any) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+admin)
+		h.ServeHTTP(w, req)
+		var out map[string]any
+		_ = json.Unmarshal(w.Body.Bytes(), &out)
+		return w.Code, w.Header(), out
+	}
+	ids := func(page map[string]any) []string {
+		var out []string
+		for _, it := range page["items"].([]any) {
+			out = append(out, it.(map[string]any)["id"].(string))
+		}
+		return out
+	}
+
+	uid, _, err := srv.Store.CreateUserWithKey(context.Background(), "acme", core.NewMember{})
+	require.NoError(t, err)
+	// Seven messages, several sharing a timestamp, so id has to break ties.
+	_, err = srv.Store.DB.Pool.Exec(context.Background(), `
+		INSERT INTO messages (user_id, to_msisdn, body, requested_at)
+		SELECT $1, '+15550000000', 'm' || g, '2026-01-01T00:00:00Z'::timestamptz + (g / 3) * interval '1 minute'
+		FROM generate_series(1, 7) g`, uid)
+	require.NoError(t, err)
+	base := "/messages?user_id=" + uid + "&limit=3"
+
+	code, _, p1 := get(base)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, p1["items"], 3)
+	require.Nil(t, p1["prev_cursor"])
+	code, _, p2 := get(base + "&cursor=" + p1["next_cursor"].(string))
+	require.Equal(t, http.StatusOK, code)
+	code, _, p3 := get(base + "&cursor=" + p2["next_cursor"].(string))
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, p3["items"], 1)
+	require.Nil(t, p3["next_cursor"])
+
+	// Every message exactly once, in order
+	all := append(append(ids(p1), ids(p2)...), ids(p3)...)
+	require.Len(t, all, 7)
+	seen := map[string]bool{}
+	for _, id := range all {
+		require.False(t, seen[id])
+		seen[id] = true
+	}
+
+	// A message arriving mid-scroll doesn't shift later pages
+	_, err = srv.Store.DB.Pool.Exec(context.Background(),
+		`INSERT INTO messages (user_id, to_msisdn, body) VALUES ($1, '+15550000000', 'new')`, uid)
+	require.NoError(t, err)
+	_, _, again := get(base + "&cursor=" + p1["next_cursor"].(string))
+	require.Equal(t, ids(p2), ids(again))
+
+	// Going back from page 2 returns page 1 as it was
+	_, _, back := get(base + "&cursor=" + p2["prev_cursor"].(string))
+	require.Equal(t, ids(p1), ids(back))
+	require.NotNil(t, back["prev_cursor"]) // the new message is newer still
+
+	code, _, _ = get(base + "&cursor=garbage")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	// offset still works for now, flagged as deprecated
+	code, hdr, off := get(base + "&offset=4")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "true", hdr.Get("Deprecation"))
+	require.Len(t, off["items"], 3)
+}
+
+// startListener runs a status listener for srv and waits until it is
+// LISTENing. The returned context ends with the test.
+func startListener(t *testing.T, srv *httpapi.Server) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	listener := &events.Listener{Pool: srv.Store.DB.Pool}
+	go func() { _ = listener.Run(ctx) }()
+	srv.Events = listener
+
+	ready := listener.Subscribe(16, nil)
+	defer ready.Close()
+	require.Eventually(t, func() bool {
+		_, _ = srv.Store.DB.Pool.Exec(ctx, `SELECT pg_notify('message_status', '{}')`)
+		select {
+		case <-ready.C:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+	return ctx
+}
+
+func TestMessageStream_LiveAndResume(t *testing.T) {
+	srv := startAPI(t)
+	ctx := startListener(t, srv)
+	ts := httptest.NewServer(srv.Router())
+	defer ts.Close()
+
+	uid, token, err := srv.Store.CreateUserWithKey(ctx, "acme", core.NewMember{})
+	require.NoError(t, err)
+	otherID, _, err := srv.Store.CreateUserWithKey(ctx, "other", core.NewMember{})
+	require.NoError(t, err)
+	insert := func(user string) string {
+		var id string
+		require.NoError(t, srv.Store.DB.Pool.QueryRow(ctx,
+			`INSERT INTO messages (user_id, to_msisdn, body) VALUES ($1, '+15550000000', 'hi') RETURNING id`, user).Scan(&id))
+		return id
+	}
+	setStatus := func(id, status string) {
+		_, err := srv.Store.DB.Pool.Exec(ctx, `UPDATE messages SET status = $2 WHERE id = $1`, id, status)
+		require.NoError(t, err)
+	}
+
+	type event struct {
+		id, name string
+		data     events.StatusEvent
+	}
+	open := func(query, lastID string) (*http.Response, <-chan event) {
+		req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/messages/stream"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		out := make(chan event, 16)
+		go func() {
+			defer close(out)
+			var ev event
+			sc := bufio.NewScanner(resp.Body)
+			for sc.Scan() {
+				line := sc.Text()
+				switch {
+				case strings.HasPrefix(line, "id: "):
+					ev.id = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "event: "):
+					ev.name = strings.TrimPrefix(line, "event: ")
+				case strings.HasPrefix(line, "data: "):
+					_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.data)
+				case line == "" && ev.name != "":
+					out <- ev
+					ev = event{}
+				}
+			}
+		}()
+		return resp, out
+	}
+	next := func(c <-chan event) event {
+		select {
+		case ev, ok := <-c:
+			require.True(t, ok, "stream ended")
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+			return event{}
+		}
+	}
+
+	resp, stream := open("", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// Only the caller's own messages arrive.
+	insert(otherID)
+	mid := insert(uid)
+	ev := next(stream)
+	require.Equal(t, "status", ev.name)
+	require.Equal(t, mid, ev.data.MessageID)
+	require.Equal(t, "queued", ev.data.Status)
+	setStatus(mid, "sending")
+	setStatus(mid, "sent")
+	require.Equal(t, "sending", next(stream).data.Status)
+	last := next(stream)
+	require.Equal(t, "sent", last.data.Status)
+	resp.Body.Close()
+
+	// Resuming replays only what was missed, filtered to the given ids.
+	missed := insert(uid)
+	insert(uid)
+	resp, stream = open("?ids="+missed, ev.id)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	ev = next(stream)
+	require.Equal(t, missed, ev.data.MessageID)
+	require.Equal(t, "queued", ev.data.Status)
+	setStatus(missed, "failed")
+	require.Equal(t, "failed", next(stream).data.Status)
+	resp.Body.Close()
+
+	// Another user's stream is off limits to a tenant key.
+	resp, _ = open("?user_id="+otherID, "")
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp.Body.Close()
+	resp, _ = open("?ids=nope", "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestGetMessage_WaitsForStatusChange(t *testing.T) {
+	srv := startAPI(t)
+	ctx := startListener(t, srv)
+	srv.MaxWait = 2 * time.Second
+	h := srv.Router()
+	uid, token, err := srv.Store.CreateUserWithKey(ctx, "acme", core.NewMember{})
+	require.NoError(t, err)
+	var id string
+	require.NoError(t, srv.Store.DB.Pool.QueryRow(ctx,
+		`INSERT INTO messages (user_id, to_msisdn, body) VALUES ($1, '+15550000000', 'otp') RETURNING id`, uid).Scan(&id))
+
+	get := func(query string) (int, map[string]any, time.Duration) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/messages/"+id+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		start := time.Now()
+		h.ServeHTTP(w, req)
+		var out map[string]any
+		_ = json.Unmarshal(w.Body.Bytes(), &out)
+		return w.Code, out, time.Since(start)
+	}
+
+	// Intermediate transitions don't end the wait; sent does.
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = srv.Store.DB.Pool.Exec(ctx, `UPDATE messages SET status = 'sending' WHERE id = $1`, id)
+		time.Sleep(200 * time.Millisecond)
+		_, _ = srv.Store.DB.Pool.Exec(ctx, `UPDATE messages SET status = 'sent' WHERE id = $1`, id)
+	}()
+	code, msg, took := get("?wait=30s&until=terminal")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "sent", msg["status"])
+	require.Less(t, took, 2*time.Second)
+
+	// Already there: no wait at all.
+	code, msg, took = get("?wait=30s")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "sent", msg["status"])
+	require.Less(t, took, time.Second)
+
+	// No change: the wait is capped by MaxWait and the current state returned.
+	_, err = srv.Store.DB.Pool.Exec(ctx, `UPDATE messages SET status = 'queued' WHERE id = $1`, id)
+	require.NoError(t, err)
+	code, msg, took = get("?wait=30")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "queued", msg["status"])
+	require.GreaterOrEqual(t, took, 2*time.Second)
+
+	code, _, _ = get("?wait=soon")
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _, _ = get("?wait=1s&until=read")
+	require.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestAPIKeys_MembersOnlyChangeTheirOwn(t *testing.T) {
+	srv := startAPI(t)
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/users", admin, `{"name":"acme"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var user map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &user)
+	uid, owner := user["id"], user["api_key"]
+	keys, err := srv.Store.ListAPIKeys(context.Background(), uid)
+	require.NoError(t, err)
+	ownerKey := keys[0].ID
+
+	w = do("POST", "/users/"+uid+"/invitations", owner, `{"email":"books@acme.test","role":"billing"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var inv map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &inv)
+	w = do("POST", "/invitations/accept", "", `{"token":"`+inv["token"].(string)+`","name":"Books"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var accepted struct {
+		Key struct {
+			ID    string `json:"id"`
+			Token string `json:"token"`
+		} `json:"key"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	billing := accepted.Key.Token
+
+	// A billing member can't take over the owner's key...
+	keyPath := "/users/" + uid + "/keys/" + ownerKey
+	require.Equal(t, http.StatusNotFound, do("POST", keyPath+"/rotate", billing, "").Code)
+	require.Equal(t, http.StatusNotFound, do("POST", keyPath+"/signing-secret", billing, "").Code)
+	require.Equal(t, http.StatusNotFound, do("PUT", keyPath+"/ip-allowlist", billing, `{"cidrs":["203.0.113.0/24"]}`).Code)
+	require.Equal(t, http.StatusNotFound, do("DELETE", keyPath, billing, "").Code)
+	require.Equal(t, http.StatusOK, do("GET", "/users/"+uid+"/balance", owner, "").Code)
+
+	// ...but rotates its own, and the owner manages everyone's
+	w = do("POST", "/users/"+uid+"/keys/"+accepted.Key.ID+"/rotate", billing, "")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var rotated map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &rotated)
+	require.Equal(t, http.StatusNoContent, do("DELETE", "/users/"+uid+"/keys/"+rotated["id"].(string), owner, "").Code)
+}
+
+func TestUsers_SearchSuspendAndSoftDelete(t *testing.T) {
+	srv := startAPI(t)
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(w, req)
+		return w
+	}
+	type page struct {
+		Items []struct {
+			ID          string  `json:"id"`
+			Name        string  `json:"name"`
+			SuspendedAt *string `json:"suspended_at"`
+		} `json:"items"`
+		NextCursor *string `json:"next_cursor"`
+	}
+	list := func(query string) page {
+		w := do("GET", "/users?"+query, admin, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var p page
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+		return p
+	}
+	users := map[string]map[string]string{}
+	for _, name := range []string{"acme", "acme_labs", "globex"} {
+		w := do("POST", "/users", admin, `{"name":"`+name+`"}`)
+		require.Equal(t, http.StatusCreated, w.Code)
+		var u map[string]string
+		_ = json.Unmarshal(w.Body.Bytes(), &u)
+		users[name] = u
+	}
+	acme, labs := users["acme"]["id"], users["acme_labs"]["id"]
+
+	// Newest first, paged; the search term is matched literally ("_" too)
+	p1 := list("limit=2")
+	require.Len(t, p1.Items, 2)
+	require.Equal(t, "globex", p1.Items[0].Name)
+	p2 := list("limit=2&cursor=" + *p1.NextCursor)
+	require.Len(t, p2.Items, 1)
+	require.Equal(t, "acme", p2.Items[0].Name)
+	require.Len(t, list("q=ACME").Items, 2)
+	require.Equal(t, labs, list("q=_").Items[0].ID)
+	require.Len(t, list("q=_").Items, 1)
+	require.Len(t, list("q="+acme).Items, 1)
+	require.Equal(t, http.StatusForbidden, do("GET", "/users", users["acme"]["api_key"], "").Code)
+
+	// Rename and suspend: sending is refused until reinstated
+	w := do("PATCH", "/users/"+acme, admin, `{"name":"acme inc","suspended":true}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Contains(t, w.Body.String(), `"name":"acme inc"`)
+	require.Equal(t, acme, list("status=suspended").Items[0].ID)
+	require.Equal(t, http.StatusOK, do("POST", "/users/"+acme+"/topup", admin, `{"amount":1}`).Code)
+	w = do("POST", "/messages", users["acme"]["api_key"], `{"to":"+15550000000","body":"hi"}`)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), `"code":"user_suspended"`)
+	require.Equal(t, http.StatusOK, do("PATCH", "/users/"+acme, admin, `{"suspended":false}`).Code)
+	require.Equal(t, http.StatusAccepted, do("POST", "/messages", users["acme"]["api_key"], `{"to":"+15550000000","body":"hi"}`).Code)
+	require.Equal(t, http.StatusBadRequest, do("PATCH", "/users/"+acme, admin, `{"name":" "}`).Code)
+
+	// Soft delete: keys stop working, history stays
+	require.Equal(t, http.StatusNoContent, do("DELETE", "/users/"+acme, admin, "").Code)
+	require.Equal(t, http.StatusUnauthorized, do("GET", "/users/"+acme+"/balance", users["acme"]["api_key"], "").Code)
+	w = do("GET", "/users/"+acme, admin, "")
 	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), `"deleted_at":null`)
+	require.Len(t, list("").Items, 2)
+	require.Equal(t, acme, list("status=deleted").Items[0].ID)
+	require.Equal(t, http.StatusOK, do("GET", "/users/"+acme+"/statement", admin, "").Code)
+	require.Equal(t, http.StatusNotFound, do("DELETE", "/users/"+acme, admin, "").Code)
+	require.Equal(t, http.StatusNotFound, do("PATCH", "/users/"+acme, admin, `{"name":"back"}`).Code)
+
+	// A parent goes after its sub-accounts
+	w = do("POST", "/users/"+labs+"/children", admin, `{"name":"labs eu"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var child map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &child)
+	w = do("DELETE", "/users/"+labs, admin, "")
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Contains(t, w.Body.String(), `"code":"subaccounts_remain"`)
+	require.Equal(t, http.StatusNoContent, do("DELETE", "/users/"+child["id"].(string), admin, "").Code)
+	require.Equal(t, http.StatusNoContent, do("DELETE", "/users/"+labs, admin, "").Code)
+
+	events, err := srv.Store.ListAuditEvents(context.Background(), core.AuditFilter{TargetID: acme, Limit: 10})
+	require.NoError(t, err)
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Action)
+	}
+	require.Subset(t, actions, []string{core.AuditUserUpdate, core.AuditUserSuspend, core.AuditUserReinstate, core.AuditUserDelete})
+}
+
+func TestStats_ByCountryAndValidated(t *testing.T) {
+	srv := startAPI(t)
+	h := srv.Router()
+	admin := adminToken(t, srv)
+
+	do := func(path, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(w, req)
+		return w
+	}
+	post := func(path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(w, req)
+		return w
+	}
+	w := post("/users", admin, `{"name":"acme"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var user map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &user)
+	id, key := user["id"], user["api_key"]
+	require.Equal(t, http.StatusOK, post("/users/"+id+"/topup", admin, `{"amount":5}`).Code)
+	for _, to := range []string{"+4915100000001", "+33600000000"} {
+		require.Equal(t, http.StatusAccepted, post("/messages", key, `{"to":"`+to+`","body":"hi"}`).Code)
+	}
+
+	w = do("/users/"+id+"/stats?granularity=hour&breakdown=country", key)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var st struct {
+		Buckets []json.RawMessage `json:"buckets"`
+		Totals  struct {
+			Queued    int `json:"queued"`
+			Spent     int `json:"spent"`
+			Countries []struct {
+				Country string `json:"country"`
+			} `json:"countries"`
+		} `json:"totals"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &st))
+	require.Len(t, st.Buckets, 25) // the last 24 hours, widened to whole hours
+	require.Equal(t, 2, st.Totals.Queued)
+	require.Equal(t, 2, st.Totals.Spent)
+	require.Len(t, st.Totals.Countries, 2)
+	require.Equal(t, "DE", st.Totals.Countries[0].Country)
+
+	for query, code := range map[string]string{
+		"granularity=week":                           "invalid_granularity",
+		"breakdown=operator":                         "invalid_breakdown",
+		"from=yesterday":                             "invalid_period",
+		"granularity=hour&from=2020-01-01T00:00:00Z": "invalid_period",
+	} {
+		w = do("/users/"+id+"/stats?"+query, key)
+		require.Equal(t, http.StatusBadRequest, w.Code, query)
+		require.Contains(t, w.Body.String(), `"code":"`+code+`"`, query)
+	}
+	require.Equal(t, http.StatusNotFound, do("/users/00000000-0000-0000-0000-000000000000/stats", admin).Code)
+}
+
+func TestMessageStream_ResumeCatchesLateCommitsAndResets(t *testing.T) {
+	srv := startAPI(t)
+	ctx := startListener(t, srv)
+	ts := httptest.NewServer(srv.Router())
+	defer ts.Close()
+	uid, token, err := srv.Store.CreateUserWithKey(ctx, "acme", core.NewMember{})
+	require.NoError(t, err)
+
+	// first opens the stream from lastID and returns its first event as
+	// "<event>:<id>".
+	first := func(lastID string) string {
+		rctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(rctx, "GET", ts.URL+"/messages/stream", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Last-Event-ID", lastID)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var id, name string
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case line == "" && name != "":
+				return name + ":" + id
+			}
+		}
+		t.Fatal("no event")
+		return ""
+	}
+
+	// The earlier id commits after the later one the client already saw.
+	tx, err := srv.Store.DB.Pool.Begin(ctx)
+	require.NoError(t, err)
+	_, err = tx.Exec(ctx, `INSERT INTO messages (user_id, to_msisdn, body) VALUES ($1, '+15550000000', 'late')`, uid)
+	require.NoError(t, err)
+	_, err = srv.Store.DB.Pool.Exec(ctx, `INSERT INTO messages (user_id, to_msisdn, body) VALUES ($1, '+15550000000', 'early')`, uid)
+	require.NoError(t, err)
+	var seen int64
+	require.NoError(t, srv.Store.DB.Pool.QueryRow(ctx, `SELECT max(id) FROM message_status_events`).Scan(&seen))
+	require.NoError(t, tx.Commit(ctx))
+	var late int64
+	require.NoError(t, srv.Store.DB.Pool.QueryRow(ctx, `SELECT min(id) FROM message_status_events`).Scan(&late))
+	require.Less(t, late, seen)
+
+	require.Equal(t, fmt.Sprintf("status:%d", late), first(strconv.FormatInt(seen, 10)))
+
+	// An emptied log can't vouch for anything missed.
+	_, err = srv.Store.DB.Pool.Exec(ctx, `DELETE FROM message_status_events`)
+	require.NoError(t, err)
+	require.Equal(t, "reset:", first(strconv.FormatInt(seen, 10)))
 }
@@ -0,0 +1,102 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Cypherspark/sms-gateway/internal/auth"
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/Cypherspark/sms-gateway/internal/metrics"
+	"github.com/go-chi/chi/v5"
+)
+
+// enforceIPAllowlist rejects callers whose address (after realIP) is outside
+// their user's or key's allowlist, and audits the attempt.
+func (s *Server) enforceIPAllowlist(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := auth.FromContext(r.Context())
+		// An unparseable address is the zero Addr, which no allowlist contains.
+		ip, _ := remoteIP(r)
+		scope, err := s.Store.CheckIPAllowlist(r.Context(), p, ip)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if scope != "" {
+			metrics.AuthFailures.WithLabelValues("ip_not_allowed").Inc()
+			if err := s.Store.RecordIPDenied(r.Context(), p, ip, scope, r.Method+" "+r.URL.Path); err != nil {
+				writeError(w, r, err)
+				return
+			}
+			prob := newProblem(http.StatusForbidden, "ip_not_allowed")
+			prob.Extra = map[string]any{"scope": scope}
+			prob.write(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func decodeCIDRs(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var in struct {
+		CIDRs []string `json:"cidrs"`
+	}
+	if !decodeBody(w, r, &in) {
+		return nil, false
+	}
+	if in.CIDRs == nil {
+		writeInvalidBody(w, r, FieldError{Field: "cidrs", Code: "required"})
+		return nil, false
+	}
+	return in.CIDRs, true
+}
+
+func (s *Server) getUserIPAllowlist(w http.ResponseWriter, r *http.Request) {
+	list, err := s.Store.UserIPAllowlist(r.Context(), chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, core.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+	case err != nil:
+		writeError(w, r, err)
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"cidrs": list})
+	}
+}
+
+// setUserIPAllowlist replaces the list; {"cidrs":[]} lifts the restriction.
+func (s *Server) setUserIPAllowlist(w http.ResponseWriter, r *http.Request) {
+	cidrs, ok := decodeCIDRs(w, r)
+	if !ok {
+		return
+	}
+	list, err := s.Store.SetUserIPAllowlist(r.Context(), chi.URLParam(r, "id"), cidrs)
+	switch {
+	case errors.Is(err, core.ErrInvalidCIDR):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_cidr")
+	case errors.Is(err, core.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+	case err != nil:
+		writeError(w, r, err)
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"cidrs": list})
+	}
+}
+
+func (s *Server) setAPIKeyIPAllowlist(w http.ResponseWriter, r *http.Request) {
+	cidrs, ok := decodeCIDRs(w, r)
+	if !ok {
+		return
+	}
+	p, _ := auth.FromContext(r.Context())
+	k, err := s.Store.SetAPIKeyIPAllowlist(r.Context(), p, chi.URLParam(r, "id"), chi.URLParam(r, "key_id"), cidrs)
+	switch {
+	case errors.Is(err, core.ErrInvalidCIDR):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_cidr")
+	case errors.Is(err, core.ErrNotFound):
+		writeProblem(w, r, http.StatusNotFound, "not_found")
+	case err != nil:
+		writeError(w, r, err)
+	default:
+		writeJSON(w, http.StatusOK, k)
+	}
+}
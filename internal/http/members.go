@@ -0,0 +1,151 @@
+package httpapi
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/audit"
+	"github.com/Cypherspark/sms-gateway/internal/auth"
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// writeMemberError maps the member and invitation errors shared by the
+// handlers below.
+func writeMemberError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidRole):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_role")
+	case errors.Is(err, core.ErrInvalidEmail):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_email")
+	case errors.Is(err, core.ErrMemberNotFound):
+		writeProblem(w, r, http.StatusNotFound, "member_not_found")
+	case errors.Is(err, core.ErrNotFound):
+		writeProblem(w, r, http.StatusNotFound, "not_found")
+	case errors.Is(err, core.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+	case errors.Is(err, core.ErrLastOwner):
+		writeProblem(w, r, http.StatusConflict, "last_owner")
+	case errors.Is(err, core.ErrAlreadyMember):
+		writeProblem(w, r, http.StatusConflict, "already_member")
+	case errors.Is(err, core.ErrInvitationInvalid):
+		writeProblem(w, r, http.StatusGone, "invitation_invalid")
+	default:
+		writeError(w, r, err)
+	}
+}
+
+func (s *Server) listMembers(w http.ResponseWriter, r *http.Request) {
+	items, err := s.Store.ListMembers(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeMemberError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (s *Server) updateMember(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Role string `json:"role"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	err := s.Store.UpdateMemberRole(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "member_id"), in.Role)
+	if err != nil {
+		writeMemberError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) removeMember(w http.ResponseWriter, r *http.Request) {
+	if err := s.Store.RemoveMember(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "member_id")); err != nil {
+		writeMemberError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// invitationWithToken is returned once, on creation; the token is what the
+// invitee redeems at POST /invitations/accept.
+type invitationWithToken struct {
+	core.Invitation
+	Token string `json:"token"`
+}
+
+func (s *Server) createInvitation(w http.ResponseWriter, r *http.Request) {
+	// ttl_seconds: default 7 days
+	var in struct {
+		Email      string `json:"email"`
+		Role       string `json:"role"`
+		TTLSeconds int    `json:"ttl_seconds"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	if in.TTLSeconds < 0 {
+		writeInvalidBody(w, r, FieldError{Field: "ttl_seconds", Code: "invalid"})
+		return
+	}
+	p, _ := auth.FromContext(r.Context())
+	inv, token, err := s.Store.CreateInvitation(r.Context(), core.InvitationRequest{
+		AccountID: chi.URLParam(r, "id"),
+		Email:     in.Email,
+		Role:      in.Role,
+		InvitedBy: p.MemberID,
+		TTL:       time.Duration(in.TTLSeconds) * time.Second,
+	})
+	if err != nil {
+		writeMemberError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, invitationWithToken{Invitation: inv, Token: token})
+}
+
+func (s *Server) listInvitations(w http.ResponseWriter, r *http.Request) {
+	items, err := s.Store.ListInvitations(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeMemberError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (s *Server) revokeInvitation(w http.ResponseWriter, r *http.Request) {
+	if err := s.Store.RevokeInvitation(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "invitation_id")); err != nil {
+		writeMemberError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acceptInvitation is unauthenticated: the invitation token is the
+// credential. It returns the new member and their first API key.
+func (s *Server) acceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Token string `json:"token"`
+		Name  string `json:"name"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	if fe := required("token", in.Token); fe != nil {
+		writeInvalidBody(w, r, fe...)
+		return
+	}
+	a := audit.Actor{Type: audit.ActorInvitation, RequestID: middleware.GetReqID(r.Context()), SourceIP: r.RemoteAddr}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		a.SourceIP = host
+	}
+	ctx := audit.WithActor(r.Context(), a)
+	m, k, token, err := s.Store.AcceptInvitation(ctx, in.Token, core.NewMember{Name: in.Name})
+	if err != nil {
+		writeMemberError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"member": m, "key": keyWithToken{APIKey: k, Token: token}})
+}
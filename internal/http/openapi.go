@@ -0,0 +1,243 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Cypherspark/sms-gateway/api"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Spec is api/openapi.yaml prepared for checking requests and responses
+// against it.
+type Spec struct {
+	requests  routers.Router // request bodies reject members the spec doesn't list
+	responses routers.Router
+}
+
+// LoadSpec loads and validates the embedded api/openapi.yaml.
+func LoadSpec() (*Spec, error) {
+	requests, err := loadSpecRouter(true)
+	if err != nil {
+		return nil, err
+	}
+	responses, err := loadSpecRouter(false)
+	if err != nil {
+		return nil, err
+	}
+	return &Spec{requests: requests, responses: responses}, nil
+}
+
+func loadSpecRouter(strictRequests bool) (routers.Router, error) {
+	data, err := api.SpecFS.ReadFile("openapi.yaml")
+	if err != nil {
+		return nil, err
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(data)
+	if err != nil {
+		return nil, fmt.Errorf("openapi.yaml: %w", err)
+	}
+	// Match on paths alone, whatever host the API is reached on.
+	doc.Servers = nil
+	if strictRequests {
+		seen := map[*openapi3.Schema]bool{}
+		for _, item := range doc.Paths.Map() {
+			for _, op := range item.Operations() {
+				if op.RequestBody == nil || op.RequestBody.Value == nil {
+					continue
+				}
+				for _, mt := range op.RequestBody.Value.Content {
+					if mt.Schema != nil {
+						closeSchema(mt.Schema.Value, seen)
+					}
+				}
+			}
+		}
+	}
+	r, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("openapi.yaml: %w", err)
+	}
+	return r, nil
+}
+
+// closeSchema forbids members an object schema doesn't list, unless it says
+// otherwise or is composed (allOf and friends, whose parts each list only
+// some members).
+func closeSchema(s *openapi3.Schema, seen map[*openapi3.Schema]bool) {
+	if s == nil || seen[s] {
+		return
+	}
+	seen[s] = true
+	if len(s.AllOf)+len(s.OneOf)+len(s.AnyOf) > 0 {
+		return
+	}
+	if s.Type.Is(openapi3.TypeObject) && s.AdditionalProperties.Has == nil && s.AdditionalProperties.Schema == nil {
+		no := false
+		s.AdditionalProperties.Has = &no
+	}
+	for _, p := range s.Properties {
+		closeSchema(p.Value, seen)
+	}
+	if s.Items != nil {
+		closeSchema(s.Items.Value, seen)
+	}
+}
+
+func mustLoadSpec() *Spec {
+	s, err := LoadSpec()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+var validationOptions = &openapi3filter.Options{
+	// Authentication is the authenticate middleware's job.
+	AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+	SkipSettingDefaults: true,
+	MultiError:          true,
+}
+
+// validateRequest rejects requests that don't match the spec: unknown or
+// mistyped body members and malformed parameters. Routes the spec doesn't
+// describe pass through.
+func (s *Server) validateRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Spec == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route, params, err := s.Spec.requests.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Bodies have always been read as JSON whatever their Content-Type.
+		if r.Header.Get("Content-Type") == "" && r.ContentLength != 0 && route.Operation.RequestBody != nil {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		err = openapi3filter.ValidateRequest(r.Context(), &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: params,
+			Route:      route,
+			Options:    validationOptions,
+		})
+		if err != nil {
+			writeValidationProblem(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeValidationProblem answers 400 for a request that failed validation,
+// naming the offending body members or parameters.
+func writeValidationProblem(w http.ResponseWriter, r *http.Request, err error) {
+	code := "invalid_parameter"
+	var fields []FieldError
+	var walk func(error)
+	walk = func(err error) {
+		var reqErr *openapi3filter.RequestError
+		var schemaErr *openapi3.SchemaError
+		var multi openapi3.MultiError
+		switch {
+		case errors.As(err, &reqErr) && reqErr.Parameter != nil:
+			fields = append(fields, FieldError{Field: reqErr.Parameter.Name, Code: "invalid"})
+		case reqErr != nil && reqErr.RequestBody != nil:
+			code = "invalid_body"
+			if reqErr.Err != nil {
+				walk(reqErr.Err)
+			}
+		case errors.As(err, &multi):
+			for _, e := range multi {
+				walk(e)
+			}
+		case errors.As(err, &schemaErr):
+			fields = append(fields, schemaFieldError(schemaErr))
+		}
+	}
+	walk(err)
+	p := newProblem(http.StatusBadRequest, code)
+	p.Errors = fields
+	if len(fields) == 0 {
+		p.Detail = err.Error()
+	}
+	p.write(w, r)
+}
+
+// schemaFieldError names the body member a schema error is about.
+func schemaFieldError(e *openapi3.SchemaError) FieldError {
+	path := strings.Join(e.JSONPointer(), ".")
+	// For these the pointer is to the object and the reason names the member.
+	member := func() string {
+		_, name, _ := strings.Cut(e.Reason, `"`)
+		name, _, _ = strings.Cut(name, `"`)
+		return strings.TrimPrefix(path+"."+name, ".")
+	}
+	switch {
+	case strings.HasSuffix(e.Reason, " is unsupported"): // additionalProperties: false
+		return FieldError{Field: member(), Code: "unknown"}
+	case e.SchemaField == "required":
+		return FieldError{Field: member(), Code: "required"}
+	case e.SchemaField == "type":
+		return FieldError{Field: path, Code: "invalid_type"}
+	}
+	return FieldError{Field: path, Code: "invalid"}
+}
+
+// checkResponses reports, to ResponseViolations, every response to a route
+// in the spec that doesn't match it: an undocumented status, content type or
+// body. It is meant for tests; without ResponseViolations it does nothing.
+func (s *Server) checkResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.ResponseViolations == nil || s.Spec == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route, params, err := s.Spec.responses.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Validation reads the request again, so keep what the handler sees.
+		req := r.Clone(context.WithoutCancel(r.Context()))
+		req.Body = http.NoBody
+		var body bytes.Buffer
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(&body)
+		next.ServeHTTP(ww, r)
+
+		if strings.HasPrefix(ww.Header().Get("Content-Type"), "text/event-stream") {
+			return
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		err = openapi3filter.ValidateResponse(req.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: &openapi3filter.RequestValidationInput{
+				Request:    req,
+				PathParams: params,
+				Route:      route,
+				Options:    validationOptions,
+			},
+			Status:  status,
+			Header:  ww.Header(),
+			Body:    io.NopCloser(&body),
+			Options: &openapi3filter.Options{IncludeResponseStatus: true, MultiError: true},
+		})
+		if err != nil {
+			s.ResponseViolations(r, fmt.Errorf("%d %s: %w", status, route.Path, err))
+		}
+	})
+}
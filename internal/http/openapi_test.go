@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSpec(t *testing.T) {
+	_, err := LoadSpec()
+	require.NoError(t, err)
+}
+
+func TestValidateRequest_RejectsUnknownAndMistypedMembers(t *testing.T) {
+	s := &Server{Spec: mustLoadSpec()}
+	reached := false
+	h := middleware.RequestID(s.validateRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusAccepted)
+	})))
+	do := func(method, path, body string) (int, string) {
+		reached = false
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	code, _ := do("POST", "/messages", `{"to":"+15550000000","body":"hi"}`)
+	require.Equal(t, http.StatusAccepted, code)
+	require.True(t, reached)
+
+	code, body := do("POST", "/messages", `{"to":"+15550000000","body":"hi","priority":"high"}`)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.False(t, reached)
+	require.Contains(t, body, `{"field":"priority","code":"unknown"}`)
+
+	code, body = do("POST", "/messages", `{"to":15550000000,"body":"hi"}`)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Contains(t, body, `{"field":"to","code":"invalid_type"}`)
+
+	code, body = do("GET", "/messages?limit=lots", "")
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Contains(t, body, `"invalid_parameter"`)
+
+	// Routes outside the spec aren't checked.
+	code, _ = do("GET", "/healthz", "")
+	require.Equal(t, http.StatusAccepted, code)
+}
+
+func TestCheckResponses_ReportsUndocumentedResponses(t *testing.T) {
+	var violations []string
+	s := &Server{Spec: mustLoadSpec(), ResponseViolations: func(r *http.Request, err error) {
+		violations = append(violations, err.Error())
+	}}
+	respond := func(status int, body string) {
+		h := s.checkResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/users/00000000-0000-0000-0000-000000000000/balance", nil))
+		require.Equal(t, status, w.Code)
+		require.Equal(t, body, w.Body.String())
+	}
+
+	respond(http.StatusOK, `{"user_id":"00000000-0000-0000-0000-000000000000","balance":5,"paid":5,"promotional":0,"next_expiry":null}`)
+	require.Empty(t, violations)
+
+	respond(http.StatusOK, `{"user_id":"00000000-0000-0000-0000-000000000000","balance":"5"}`)
+	require.Len(t, violations, 1)
+
+	respond(http.StatusTeapot, `{}`)
+	require.Len(t, violations, 2)
+	require.Contains(t, violations[1], "418")
+}
@@ -0,0 +1,164 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// problemTypeBase prefixes an error code to form a problem's type URI; each
+// code is described in docs/errors.md.
+const problemTypeBase = "https://github.com/Cypherspark/sms-gateway/blob/main/docs/errors.md#"
+
+// Problem is an RFC 7807 problem details body. Code is the stable,
+// machine-readable error code; it is repeated as "error" for clients written
+// against the earlier {"error": code} bodies. Extra members (such as a queue's
+// depth) are merged into the object.
+type Problem struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+	Code     string
+	Errors   []FieldError
+	Extra    map[string]any
+}
+
+// FieldError points at one invalid member of a request body.
+type FieldError struct {
+	Field string `json:"field"`
+	Code  string `json:"code"`
+}
+
+func newProblem(status int, code string) *Problem {
+	return &Problem{Type: problemTypeBase + code, Title: http.StatusText(status), Status: status, Code: code}
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Extra)+8)
+	maps.Copy(m, p.Extra)
+	m["type"] = p.Type
+	m["title"] = p.Title
+	m["status"] = p.Status
+	m["code"] = p.Code
+	m["error"] = p.Code
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	if len(p.Errors) > 0 {
+		m["errors"] = p.Errors
+	}
+	return json.Marshal(m)
+}
+
+// write sends p as application/problem+json. The instance is the request ID,
+// which also appears in the access log.
+func (p *Problem) write(w http.ResponseWriter, r *http.Request) {
+	if p.Instance == "" {
+		p.Instance = middleware.GetReqID(r.Context())
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// writeProblem answers with status and the stable error code.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code string) {
+	newProblem(status, code).write(w, r)
+}
+
+// writeInvalidBody answers 400 invalid_body, naming the offending fields.
+func writeInvalidBody(w http.ResponseWriter, r *http.Request, fields ...FieldError) {
+	p := newProblem(http.StatusBadRequest, "invalid_body")
+	p.Errors = fields
+	p.write(w, r)
+}
+
+// decodeBody decodes the JSON request body into v. On failure it answers 400
+// invalid_body, pointing at the member of the wrong type when it can, and
+// reports false.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	return decode(w, r, v, false)
+}
+
+// decodeOptionalBody is decodeBody for requests whose body may be empty.
+func decodeOptionalBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	return decode(w, r, v, true)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any, optional bool) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil || optional && errors.Is(err, io.EOF) {
+		return true
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		writeInvalidBody(w, r, FieldError{Field: typeErr.Field, Code: "invalid_type"})
+		return false
+	}
+	p := newProblem(http.StatusBadRequest, "invalid_body")
+	p.Detail = "request body is not valid JSON"
+	p.write(w, r)
+	return false
+}
+
+// required lists, as field errors, the named members that are empty. Pass
+// name/value pairs.
+func required(pairs ...string) []FieldError {
+	var out []FieldError
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			out = append(out, FieldError{Field: pairs[i], Code: "required"})
+		}
+	}
+	return out
+}
+
+// writeError answers for an error no handler recognised. Database errors
+// that stem from the request map to 4xx; anything else is a 500 whose cause
+// is logged rather than shown.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeProblem(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+	var pe *pgconn.PgError
+	if errors.As(err, &pe) {
+		switch pe.Code {
+		case "22P02": // invalid_text_representation, e.g. a malformed UUID
+			writeProblem(w, r, http.StatusBadRequest, "invalid_parameter")
+			return
+		case "22001", "22003", "23502", "23514": // too long, out of range, not null, check
+			p := newProblem(http.StatusUnprocessableEntity, "constraint_violation")
+			p.Detail = pe.ConstraintName
+			p.write(w, r)
+			return
+		case "23503": // foreign_key_violation
+			if strings.Contains(pe.Detail, "still referenced") {
+				writeProblem(w, r, http.StatusConflict, "still_referenced")
+			} else {
+				writeProblem(w, r, http.StatusUnprocessableEntity, "invalid_reference")
+			}
+			return
+		case "23505": // unique_violation
+			writeProblem(w, r, http.StatusConflict, "already_exists")
+			return
+		}
+	}
+	log.Printf("%s %s [%s]: %v", r.Method, r.URL.Path, middleware.GetReqID(r.Context()), err)
+	p := newProblem(http.StatusInternalServerError, "internal_error")
+	p.Detail = "quote the instance when reporting this"
+	p.write(w, r)
+}
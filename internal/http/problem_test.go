@@ -0,0 +1,89 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+func serveProblem(t *testing.T, h http.HandlerFunc, body string) (int, map[string]any) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/things", strings.NewReader(body))
+	middleware.RequestID(h).ServeHTTP(w, req)
+	require.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	var out map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &out))
+	require.EqualValues(t, w.Code, out["status"])
+	require.NotEmpty(t, out["instance"])
+	require.Equal(t, out["code"], out["error"])
+	require.Equal(t, problemTypeBase+out["code"].(string), out["type"])
+	return w.Code, out
+}
+
+func TestWriteError_MapsDatabaseErrorsAndHidesInternals(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{pgx.ErrNoRows, http.StatusNotFound, "not_found"},
+		{fmt.Errorf("load: %w", &pgconn.PgError{Code: "22P02"}), http.StatusBadRequest, "invalid_parameter"},
+		{&pgconn.PgError{Code: "23514", ConstraintName: "users_balance_check"}, http.StatusUnprocessableEntity, "constraint_violation"},
+		{&pgconn.PgError{Code: "23503", Detail: `Key (user_id)=(x) is not present in table "users".`}, http.StatusUnprocessableEntity, "invalid_reference"},
+		{&pgconn.PgError{Code: "23503", Detail: `Key (id)=(x) is still referenced from table "messages".`}, http.StatusConflict, "still_referenced"},
+		{&pgconn.PgError{Code: "23505"}, http.StatusConflict, "already_exists"},
+		{errors.New(`ERROR: relation "secret_table" does not exist`), http.StatusInternalServerError, "internal_error"},
+	} {
+		status, out := serveProblem(t, func(w http.ResponseWriter, r *http.Request) { writeError(w, r, tc.err) }, "")
+		require.Equal(t, tc.status, status, tc.code)
+		require.Equal(t, tc.code, out["code"])
+		require.NotContains(t, fmt.Sprint(out), "secret_table")
+	}
+}
+
+func TestDecodeBody_FieldErrors(t *testing.T) {
+	var in struct {
+		To     string `json:"to"`
+		Amount int    `json:"amount"`
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if !decodeBody(w, r, &in) {
+			return
+		}
+		if fe := required("to", in.To); fe != nil {
+			writeInvalidBody(w, r, fe...)
+		}
+	}
+
+	status, out := serveProblem(t, handler, `{"amount":"ten"}`)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, []any{map[string]any{"field": "amount", "code": "invalid_type"}}, out["errors"])
+
+	_, out = serveProblem(t, handler, `{"amount":10}`)
+	require.Equal(t, []any{map[string]any{"field": "to", "code": "required"}}, out["errors"])
+
+	_, out = serveProblem(t, handler, `{`)
+	require.Equal(t, "invalid_body", out["code"])
+	require.Nil(t, out["errors"])
+}
+
+func TestProblem_ExtraMembers(t *testing.T) {
+	_, out := serveProblem(t, func(w http.ResponseWriter, r *http.Request) {
+		p := newProblem(http.StatusTooManyRequests, "queue_full")
+		p.Extra = map[string]any{"scope": "user", "limit": 10}
+		p.write(w, r)
+	}, "")
+	require.Equal(t, "user", out["scope"])
+	require.EqualValues(t, 10, out["limit"])
+	require.Equal(t, "Too Many Requests", out["title"])
+}
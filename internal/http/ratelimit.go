@@ -0,0 +1,117 @@
+package httpapi
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/auth"
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/Cypherspark/sms-gateway/internal/metrics"
+	"github.com/Cypherspark/sms-gateway/internal/ratelimit"
+)
+
+// RateLimits throttles authenticated requests with a token bucket per user,
+// sized by the user's plan, and optionally one per API key.
+type RateLimits struct {
+	Store       ratelimit.Store
+	Plans       map[string]ratelimit.Limit
+	DefaultPlan string          // for users whose plan has no entry in Plans
+	PerKey      ratelimit.Limit // zero means keys are only limited via their user
+}
+
+// planTTL bounds how long a plan change takes to reach the limiter.
+const planTTL = time.Minute
+
+type cachedPlan struct {
+	plan    string
+	fetched time.Time
+}
+
+// planCache keeps users' plans off the per-request path.
+type planCache struct{ m sync.Map }
+
+func (s *Server) userLimit(r *http.Request, userID string) (ratelimit.Limit, error) {
+	rl := s.RateLimits
+	plan := rl.DefaultPlan
+	if c, ok := s.plans.m.Load(userID); ok && time.Since(c.(cachedPlan).fetched) < planTTL {
+		plan = c.(cachedPlan).plan
+	} else {
+		p, err := s.Store.UserPlan(r.Context(), userID)
+		switch {
+		case err == nil:
+			plan = p
+		case !errors.Is(err, core.ErrUserNotFound):
+			return ratelimit.Limit{}, err
+		}
+		s.plans.m.Store(userID, cachedPlan{plan: plan, fetched: time.Now()})
+	}
+	if l, ok := rl.Plans[plan]; ok {
+		return l, nil
+	}
+	return rl.Plans[rl.DefaultPlan], nil
+}
+
+// rateLimit takes a token from the caller's user bucket and its key's bucket
+// together, and answers 429 when either is empty. The RateLimit-* headers
+// describe whichever bucket is closer to empty.
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.RateLimits == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, _ := auth.FromContext(r.Context())
+		var buckets []ratelimit.Bucket
+		if p.UserID != "" {
+			l, err := s.userLimit(r, p.UserID)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if !l.Unlimited() {
+				buckets = append(buckets, ratelimit.Bucket{Key: "user:" + p.UserID, Limit: l})
+			}
+		}
+		if p.KeyID != "" && !s.RateLimits.PerKey.Unlimited() {
+			buckets = append(buckets, ratelimit.Bucket{Key: "key:" + p.KeyID, Limit: s.RateLimits.PerKey})
+		}
+		if len(buckets) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		results, err := s.RateLimits.Store.Take(r.Context(), buckets...)
+		if err != nil {
+			// Fail open: a limiter outage shouldn't take the API down.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		shown := results[0]
+		for _, res := range results[1:] {
+			if (!res.Allowed && shown.Allowed) || (res.Allowed == shown.Allowed && res.Remaining < shown.Remaining) {
+				shown = res
+			}
+		}
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(shown.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(shown.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(shown.Reset)))
+		if !shown.Allowed {
+			if r.Method == http.MethodPost && r.URL.Path == "/messages" {
+				metrics.APIEnqueue.WithLabelValues("rate_limited").Inc()
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(shown.RetryAfter))))
+			writeProblem(w, r, http.StatusTooManyRequests, "rate_limited")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,60 @@
+package httpapi
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// remoteIP parses r.RemoteAddr, with or without a port.
+func remoteIP(r *http.Request) (netip.Addr, bool) {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip, err := netip.ParseAddr(host)
+	return ip.Unmap(), err == nil
+}
+
+func (s *Server) trustedProxy(ip netip.Addr) bool {
+	return slices.ContainsFunc(s.TrustedProxies, func(p netip.Prefix) bool { return p.Contains(ip) })
+}
+
+// realIP sets r.RemoteAddr to the client address from X-Forwarded-For or
+// X-Real-IP, but only for connections from a trusted proxy. X-Forwarded-For
+// is read right to left, skipping trusted proxies: the first other address is
+// the client, since anything to its left may have been sent by the client
+// itself. With no trusted proxies the headers are ignored.
+func (s *Server) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if peer, ok := remoteIP(r); ok && s.trustedProxy(peer) {
+			if client, ok := s.forwardedFor(r); ok {
+				r.RemoteAddr = client.String()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) forwardedFor(r *http.Request) (netip.Addr, bool) {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Unparseable hop: stop rather than trust what's beyond it.
+			return netip.Addr{}, false
+		}
+		if ip = ip.Unmap(); i == 0 || !s.trustedProxy(ip) {
+			return ip, true
+		}
+	}
+	if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return ip.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
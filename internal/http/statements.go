@@ -0,0 +1,71 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/go-chi/chi/v5"
+)
+
+// statementPeriod reads ?month=YYYY-MM or ?from=&to= (RFC3339); the default is
+// the current calendar month in UTC.
+func statementPeriod(r *http.Request) (from, to time.Time, err error) {
+	qs := r.URL.Query()
+	if m := qs.Get("month"); m != "" {
+		return core.MonthPeriod(m)
+	}
+	if qs.Get("from") == "" && qs.Get("to") == "" {
+		return core.MonthPeriod(time.Now().UTC().Format("2006-01"))
+	}
+	from, err = time.Parse(time.RFC3339, qs.Get("from"))
+	if err != nil {
+		return from, to, core.ErrInvalidPeriod
+	}
+	to, err = time.Parse(time.RFC3339, qs.Get("to"))
+	if err != nil {
+		return from, to, core.ErrInvalidPeriod
+	}
+	return from, to, nil
+}
+
+func writeStatementError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidPeriod):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_period")
+	case errors.Is(err, core.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+	default:
+		writeError(w, r, err)
+	}
+}
+
+func (s *Server) getStatement(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeProblem(w, r, http.StatusBadRequest, "invalid_format")
+		return
+	}
+	from, to, err := statementPeriod(r)
+	if err != nil {
+		writeStatementError(w, r, err)
+		return
+	}
+	st, err := s.Store.GetStatement(r.Context(), id, from, to)
+	if err != nil {
+		writeStatementError(w, r, err)
+		return
+	}
+	if format != "csv" {
+		writeJSON(w, http.StatusOK, st)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="statement-%s-%s.csv"`, id, from.Format("20060102")))
+	w.WriteHeader(http.StatusOK)
+	_ = st.WriteCSV(w)
+}
@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/go-chi/chi/v5"
+)
+
+// statsRequest reads ?from=&to= (RFC3339), ?granularity=hour|day (default
+// day) and ?breakdown=country. Without to the period ends now; without from
+// it covers the last 24 hours, or the last 30 days by the day.
+func statsRequest(r *http.Request) (core.StatsRequest, error) {
+	qs := r.URL.Query()
+	req := core.StatsRequest{
+		UserID:      chi.URLParam(r, "id"),
+		To:          time.Now(),
+		Granularity: core.GranularityDay,
+		ByCountry:   qs.Get("breakdown") == "country",
+	}
+	if v := qs.Get("granularity"); v != "" {
+		req.Granularity = v
+	}
+	if v := qs.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return req, core.ErrInvalidPeriod
+		}
+		req.To = t
+	}
+	if v := qs.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return req, core.ErrInvalidPeriod
+		}
+		req.From = t
+	} else if req.Granularity == core.GranularityHour {
+		req.From = req.To.Add(-24 * time.Hour)
+	} else {
+		req.From = req.To.AddDate(0, 0, -30)
+	}
+	if v := qs.Get("breakdown"); v != "" && v != "country" {
+		return req, errBadBreakdown
+	}
+	return req, nil
+}
+
+var errBadBreakdown = errors.New("invalid_breakdown")
+
+func writeStatsError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidPeriod),
+		errors.Is(err, core.ErrInvalidGranularity),
+		errors.Is(err, errBadBreakdown):
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, core.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+	default:
+		writeError(w, r, err)
+	}
+}
+
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
+	req, err := statsRequest(r)
+	if err != nil {
+		writeStatsError(w, r, err)
+		return
+	}
+	st, err := s.Store.GetStats(r.Context(), req)
+	if err != nil {
+		writeStatsError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
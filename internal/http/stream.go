@@ -0,0 +1,169 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/auth"
+	"github.com/Cypherspark/sms-gateway/internal/events"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	maxStreamIDs     = 100
+	streamBuffer     = 256 // events a slow client may lag behind before it is cut off
+	streamBacklogMax = 500 // events fetched per query when resuming
+
+	// streamResumeLag is how long a status change may take to commit; a
+	// resumed stream replays that far back so none committed late are missed.
+	streamResumeLag = 30 * time.Second
+)
+
+// writeStatusEvent writes ev as an SSE "status" event whose id can be sent
+// back as Last-Event-ID.
+func writeStatusEvent(w io.Writer, ev events.StatusEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: status\ndata: %s\n\n", ev.ID, data)
+	return err
+}
+
+// streamMessages streams the user's message status changes as Server-Sent
+// Events, optionally only for the messages in ids. Reconnecting with
+// Last-Event-ID (or ?last_event_id=) first replays what was missed from the
+// event log, starting a little before it for changes that committed out of id
+// order; if the log no longer reaches back that far a "reset" event tells the
+// client to reload with GET /messages. Comment lines are sent as heartbeats.
+func (s *Server) streamMessages(w http.ResponseWriter, r *http.Request) {
+	if s.Events == nil {
+		writeProblem(w, r, http.StatusServiceUnavailable, "stream_unavailable")
+		return
+	}
+	// Same account rules as listMessages.
+	p, _ := auth.FromContext(r.Context())
+	q := r.URL.Query()
+	userID := q.Get("user_id")
+	switch {
+	case userID == "" && p.Admin:
+		writeProblem(w, r, http.StatusBadRequest, "user_id_required")
+		return
+	case userID == "":
+		userID = p.UserID
+	case !s.canActFor(w, r, userID):
+		return
+	}
+
+	var ids []string
+	var want map[string]bool
+	if v := q.Get("ids"); v != "" {
+		ids = strings.Split(v, ",")
+		want = make(map[string]bool, len(ids))
+		for _, id := range ids {
+			var u pgtype.UUID
+			if len(ids) > maxStreamIDs || u.Scan(id) != nil {
+				writeProblem(w, r, http.StatusBadRequest, "invalid_ids")
+				return
+			}
+			want[id] = true
+		}
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = q.Get("last_event_id")
+	}
+	var after int64
+	if lastID != "" {
+		n, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || n < 0 {
+			writeProblem(w, r, http.StatusBadRequest, "invalid_last_event_id")
+			return
+		}
+		after = n
+	}
+
+	// Subscribe before reading the backlog so nothing falls in between.
+	sub := s.Events.Subscribe(streamBuffer, func(ev events.StatusEvent) bool {
+		return ev.UserID == userID && (want == nil || want[ev.MessageID])
+	})
+	defer sub.Close()
+
+	ctx := r.Context()
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{}) // the stream outlives WriteTimeout
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, "retry: 3000\n\n")
+
+	// Backlog events may also arrive live; those are skipped.
+	replayed := map[int64]bool{}
+	if lastID != "" {
+		oldest, err := s.Store.OldestStatusEventID(ctx)
+		if err != nil {
+			return
+		}
+		if oldest == 0 || after < oldest {
+			_, _ = io.WriteString(w, "event: reset\ndata: {}\n\n")
+		}
+		if after, err = s.Store.StatusEventReplayFrom(ctx, after, streamResumeLag); err != nil {
+			return
+		}
+		for {
+			backlog, err := s.Store.StatusEventsAfter(ctx, userID, ids, after, streamBacklogMax)
+			if err != nil {
+				return
+			}
+			for _, ev := range backlog {
+				if writeStatusEvent(w, ev) != nil {
+					return
+				}
+				replayed[ev.ID] = true
+				after = ev.ID
+			}
+			if len(backlog) < streamBacklogMax {
+				break
+			}
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(s.StreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case ev, ok := <-sub.C:
+			// Closed: the client fell behind, the listener reconnected or the
+			// server is shutting down. The client resumes with Last-Event-ID.
+			if !ok {
+				return
+			}
+			if replayed[ev.ID] {
+				continue
+			}
+			if writeStatusEvent(w, ev) != nil {
+				return
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/go-chi/chi/v5"
+)
+
+func writeSubaccountError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+	case errors.Is(err, core.ErrNotFound):
+		writeProblem(w, r, http.StatusNotFound, "not_found")
+	case errors.Is(err, core.ErrNestedSubaccount),
+		errors.Is(err, core.ErrInvalidBillingMode),
+		errors.Is(err, core.ErrInvalidQuota),
+		errors.Is(err, core.ErrInvalidTransfer):
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, core.ErrInsufficientBalance):
+		writeProblem(w, r, http.StatusPaymentRequired, "insufficient_balance")
+	default:
+		writeError(w, r, err)
+	}
+}
+
+func (s *Server) createChild(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var in struct {
+		Name        string `json:"name"`
+		BillingMode string `json:"billing_mode"`
+		Quota       *int   `json:"quota"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	if fe := required("name", in.Name); fe != nil {
+		writeInvalidBody(w, r, fe...)
+		return
+	}
+	child, err := s.Store.CreateChildAccount(r.Context(), core.ChildRequest{
+		ParentID:    id,
+		Name:        in.Name,
+		BillingMode: in.BillingMode,
+		Quota:       in.Quota,
+	})
+	if err != nil {
+		writeSubaccountError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, child)
+}
+
+func (s *Server) listChildren(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	items, err := s.Store.ListChildAccounts(r.Context(), id)
+	if err != nil {
+		writeSubaccountError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": orEmpty(items)})
+}
+
+func (s *Server) updateChild(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	childID := chi.URLParam(r, "child_id")
+	// quota: absent = unchanged, null = uncapped, number = cap
+	var in struct {
+		BillingMode *string         `json:"billing_mode"`
+		Quota       json.RawMessage `json:"quota"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	upd := core.ChildBillingUpdate{ParentID: id, ChildID: childID, BillingMode: in.BillingMode}
+	if len(in.Quota) > 0 {
+		upd.SetQuota = true
+		if err := json.Unmarshal(in.Quota, &upd.Quota); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "invalid_quota")
+			return
+		}
+	}
+	child, err := s.Store.UpdateChildBilling(r.Context(), upd)
+	if err != nil {
+		writeSubaccountError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, child)
+}
+
+func (s *Server) transferCredit(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var in struct {
+		FromUserID string  `json:"from_user_id"`
+		ToUserID   string  `json:"to_user_id"`
+		Amount     int     `json:"amount"`
+		Note       *string `json:"note"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	if fe := required("from_user_id", in.FromUserID, "to_user_id", in.ToUserID); fe != nil {
+		writeInvalidBody(w, r, fe...)
+		return
+	}
+	fromBal, toBal, err := s.Store.TransferCredit(r.Context(), core.TransferRequest{
+		ParentID:   id,
+		FromUserID: in.FromUserID,
+		ToUserID:   in.ToUserID,
+		Amount:     in.Amount,
+		Note:       in.Note,
+	})
+	if err != nil {
+		writeSubaccountError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"from": map[string]any{"user_id": in.FromUserID, "balance": fromBal},
+		"to":   map[string]any{"user_id": in.ToUserID, "balance": toBal},
+	})
+}
+
+func (s *Server) subaccountUsage(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var fromPtr, toPtr *time.Time
+	if v := r.URL.Query().Get("from"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			fromPtr = &t
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			toPtr = &t
+		}
+	}
+	rows, err := s.Store.SubaccountUsage(r.Context(), id, fromPtr, toPtr)
+	if err != nil {
+		writeSubaccountError(w, r, err)
+		return
+	}
+	if len(rows) == 0 {
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+		return
+	}
+
+	type usage struct {
+		UserID         string `json:"user_id"`
+		Name           string `json:"name"`
+		BillingMode    string `json:"billing_mode"`
+		Balance        int32  `json:"balance"`
+		Quota          *int32 `json:"quota"`
+		QuotaUsed      int32  `json:"quota_used"`
+		Messages       int64  `json:"messages"`
+		Sent           int64  `json:"sent"`
+		Failed         int64  `json:"failed"`
+		Pending        int64  `json:"pending"`
+		Spend          int64  `json:"spend"`
+		BilledToParent int64  `json:"billed_to_parent"`
+	}
+	var total usage
+	total.UserID, total.Name, total.BillingMode = rows[0].ID, rows[0].Name, rows[0].BillingMode
+	accounts := make([]usage, 0, len(rows))
+	for _, row := range rows {
+		u := usage{
+			UserID:         row.ID,
+			Name:           row.Name,
+			BillingMode:    row.BillingMode,
+			Balance:        row.Balance,
+			QuotaUsed:      row.QuotaUsed,
+			Messages:       row.Messages,
+			Sent:           row.Sent,
+			Failed:         row.Failed,
+			Pending:        row.Pending,
+			Spend:          (row.Messages - row.Failed) * core.PricePerSMS,
+			BilledToParent: row.BilledToParent,
+		}
+		if row.Quota.Valid {
+			u.Quota = &row.Quota.Int32
+		}
+		accounts = append(accounts, u)
+
+		total.Balance += u.Balance
+		total.Messages += u.Messages
+		total.Sent += u.Sent
+		total.Failed += u.Failed
+		total.Pending += u.Pending
+		total.Spend += u.Spend
+		total.BilledToParent += u.BilledToParent
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"parent_id": id,
+		"total":     total,
+		"accounts":  accounts,
+	})
+}
@@ -0,0 +1,68 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) createThreshold(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var in struct {
+		Threshold    int     `json:"threshold"`
+		WebhookURL   *string `json:"webhook_url"`
+		NotifyMSISDN *string `json:"notify_msisdn"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	t, err := s.Store.CreateBalanceThreshold(r.Context(), core.ThresholdRequest{
+		UserID:       id,
+		Threshold:    in.Threshold,
+		WebhookURL:   in.WebhookURL,
+		NotifyMSISDN: in.NotifyMSISDN,
+	})
+	switch {
+	case errors.Is(err, core.ErrInvalidThreshold):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_threshold")
+	case errors.Is(err, core.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+	case errors.Is(err, core.ErrThresholdExists):
+		writeProblem(w, r, http.StatusConflict, "threshold_exists")
+	case err != nil:
+		writeError(w, r, err)
+	default:
+		writeJSON(w, http.StatusCreated, t)
+	}
+}
+
+func (s *Server) listThresholds(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	items, err := s.Store.ListBalanceThresholds(r.Context(), id)
+	if errors.Is(err, core.ErrUserNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": orEmpty(items)})
+}
+
+func (s *Server) deleteThreshold(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tid := chi.URLParam(r, "threshold_id")
+	err := s.Store.DeleteBalanceThreshold(r.Context(), id, tid)
+	if errors.Is(err, core.ErrNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
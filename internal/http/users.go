@@ -0,0 +1,111 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/go-chi/chi/v5"
+)
+
+func writeUserError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+	case errors.Is(err, core.ErrSubaccountsRemain):
+		writeProblem(w, r, http.StatusConflict, "subaccounts_remain")
+	case errors.Is(err, core.ErrInvalidUserStatus):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_status")
+	default:
+		writeError(w, r, err)
+	}
+}
+
+// listUsers is the admin view of every account, newest first. Page with
+// cursor=<next_cursor> from the previous response.
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := core.UserFilter{
+		Search: strings.TrimSpace(q.Get("q")),
+		Status: q.Get("status"),
+		Limit:  50,
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 500 {
+			writeProblem(w, r, http.StatusBadRequest, "invalid_limit")
+			return
+		}
+		f.Limit = n
+	}
+	if v := q.Get("cursor"); v != "" {
+		c, err := parseCursor(v)
+		if err != nil || c.Prev {
+			writeProblem(w, r, http.StatusBadRequest, "invalid_cursor")
+			return
+		}
+		f.AfterCreatedAt, f.AfterID = &c.At, c.ID
+	}
+
+	items, err := s.Store.ListUsers(r.Context(), f)
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	var next *string
+	if len(items) == f.Limit {
+		last := items[len(items)-1]
+		c := pageCursor{At: last.CreatedAt.Time, ID: last.ID}.String()
+		next = &c
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items":       orEmpty(items),
+		"limit":       f.Limit,
+		"next_cursor": next,
+	})
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	u, err := s.Store.GetUser(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Name      *string `json:"name"`
+		Suspended *bool   `json:"suspended"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	if in.Name != nil {
+		if fe := required("name", strings.TrimSpace(*in.Name)); fe != nil {
+			writeInvalidBody(w, r, fe...)
+			return
+		}
+	}
+	u, err := s.Store.UpdateUser(r.Context(), core.UserUpdate{
+		ID:        chi.URLParam(r, "id"),
+		Name:      in.Name,
+		Suspended: in.Suspended,
+	})
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	if err := s.Store.DeleteUser(r.Context(), chi.URLParam(r, "id")); err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,73 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/Cypherspark/sms-gateway/internal/events"
+)
+
+// Statuses that end a ?wait= on GET /messages/{id}, by ?until=. A failed
+// message will never be sent, so it ends "sent" waits too. Without delivery
+// receipts, sent and failed are also the only statuses a message can't leave.
+var waitUntil = map[string]map[string]bool{
+	"sent":     {string(dbgen.MsgStatusSent): true, string(dbgen.MsgStatusFailed): true},
+	"terminal": {string(dbgen.MsgStatusSent): true, string(dbgen.MsgStatusFailed): true},
+}
+
+// parseWait reads ?wait= (a duration such as "30s", or whole seconds) and
+// ?until= (default "sent"). It writes a 400 and reports false when either is
+// malformed.
+func parseWait(w http.ResponseWriter, r *http.Request) (time.Duration, map[string]bool, bool) {
+	q := r.URL.Query()
+	v := q.Get("wait")
+	if v == "" {
+		return 0, nil, true
+	}
+	wait, err := time.ParseDuration(v)
+	if err != nil {
+		n, nerr := strconv.Atoi(v)
+		wait, err = time.Duration(n)*time.Second, nerr
+	}
+	if err != nil || wait < 0 {
+		writeProblem(w, r, http.StatusBadRequest, "invalid_wait")
+		return 0, nil, false
+	}
+	name := q.Get("until")
+	if name == "" {
+		name = "sent"
+	}
+	until, ok := waitUntil[name]
+	if !ok {
+		writeProblem(w, r, http.StatusBadRequest, "invalid_until")
+		return 0, nil, false
+	}
+	return wait, until, true
+}
+
+// waitForStatus holds the request until message id reaches one of the until
+// statuses or wait (capped at MaxWait, to answer within WriteTimeout) runs
+// out, then returns the message as it is. It is woken by the shared status
+// listener rather than by polling.
+func (s *Server) waitForStatus(ctx context.Context, id string, wait time.Duration, until map[string]bool) (dbgen.GetMessageRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, min(wait, s.MaxWait))
+	defer cancel()
+	sub := s.Events.Subscribe(4, func(ev events.StatusEvent) bool {
+		return ev.MessageID == id && until[ev.Status]
+	})
+	defer sub.Close()
+
+	// Re-read now that we're subscribed, in case the change already happened.
+	msg, err := s.Store.DB.Queries.GetMessage(ctx, id)
+	if err != nil || until[string(msg.Status)] {
+		return msg, err
+	}
+	select {
+	case <-ctx.Done():
+	case <-sub.C: // an event, or the listener dropped us; either way look again
+	}
+	return s.Store.DB.Queries.GetMessage(context.WithoutCancel(ctx), id)
+}
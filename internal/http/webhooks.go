@@ -0,0 +1,147 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/go-chi/chi/v5"
+)
+
+func writeWebhookError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidURL):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_url")
+	case errors.Is(err, core.ErrInvalidEventType):
+		writeProblem(w, r, http.StatusBadRequest, "invalid_event_type")
+	case errors.Is(err, core.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, "user_not_found")
+	case errors.Is(err, core.ErrNotFound):
+		writeProblem(w, r, http.StatusNotFound, "not_found")
+	case errors.Is(err, core.ErrWebhookDisabled):
+		writeProblem(w, r, http.StatusConflict, "webhook_disabled")
+	default:
+		writeError(w, r, err)
+	}
+}
+
+// createWebhook registers an endpoint. Like a key's signing secret, the
+// endpoint's secret is returned only once.
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	// event_types: omitted = every event type
+	var in struct {
+		URL         string   `json:"url"`
+		EventTypes  []string `json:"event_types"`
+		Description string   `json:"description"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	e, secret, err := s.Store.CreateWebhookEndpoint(r.Context(), core.WebhookRequest{
+		UserID:      chi.URLParam(r, "id"),
+		URL:         in.URL,
+		EventTypes:  in.EventTypes,
+		Description: in.Description,
+	})
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		core.WebhookEndpoint
+		Secret string `json:"secret"`
+	}{e, secret})
+}
+
+func (s *Server) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	items, err := s.Store.ListWebhookEndpoints(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// updateWebhook changes the fields present in the body; "enabled": true
+// re-enables an endpoint the worker disabled.
+func (s *Server) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		URL         *string  `json:"url"`
+		EventTypes  []string `json:"event_types"`
+		Description *string  `json:"description"`
+		Enabled     *bool    `json:"enabled"`
+	}
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	e, err := s.Store.UpdateWebhookEndpoint(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "webhook_id"), core.WebhookUpdate{
+		URL:         in.URL,
+		EventTypes:  in.EventTypes,
+		Description: in.Description,
+		Enabled:     in.Enabled,
+	})
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := s.Store.DeleteWebhookEndpoint(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "webhook_id")); err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listWebhookDeliveries pages newest first; pass next_before as before for
+// the next page.
+func (s *Server) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 500 {
+			writeProblem(w, r, http.StatusBadRequest, "invalid_limit")
+			return
+		}
+		limit = n
+	}
+	var before int64
+	if v := q.Get("before"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			writeProblem(w, r, http.StatusBadRequest, "invalid_before")
+			return
+		}
+		before = n
+	}
+	items, err := s.Store.ListWebhookDeliveries(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "webhook_id"), before, limit)
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	var next *int64
+	if len(items) == limit {
+		next = &items[len(items)-1].ID
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "next_before": next})
+}
+
+// replayWebhookDelivery queues a delivery's event again as a new delivery,
+// with the same event ID so receivers can deduplicate.
+func (s *Server) replayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	deliveryID, err := strconv.ParseInt(chi.URLParam(r, "delivery_id"), 10, 64)
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+	d, err := s.Store.ReplayWebhookDelivery(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "webhook_id"), deliveryID)
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, d)
+}
@@ -23,7 +23,7 @@ var (
 	)
 	APIEnqueue = prometheus.NewCounterVec(
 		prometheus.CounterOpts{Name: "api_enqueue_total", Help: "Enqueue results."},
-		[]string{"result"}, // ok | idempotent | insufficient_balance | error
+		[]string{"result"}, // ok | idempotent | insufficient_balance | quota_exceeded | user_not_found | rate_limited | queue_full | error
 	)
 
 	// Worker
@@ -54,13 +54,36 @@ var (
 	)
 	RetryTotal  = prometheus.NewCounter(prometheus.CounterOpts{Name: "worker_retry_total", Help: "Retries scheduled."})
 	RefundTotal = prometheus.NewCounter(prometheus.CounterOpts{Name: "worker_refund_total", Help: "Refunds after perm fail."})
+
+	// Notifications
+	BalanceNotifications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "worker_balance_notifications_total", Help: "Low-balance notification deliveries."},
+		[]string{"channel", "outcome"}, // webhook | sms ; delivered | retry | failed
+	)
+
+	WebhookDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "worker_webhook_deliveries_total", Help: "Webhook delivery attempts."},
+		[]string{"outcome"}, // delivered | retry | failed
+	)
+	WebhookEndpointsDisabled = prometheus.NewCounter(prometheus.CounterOpts{Name: "worker_webhook_endpoints_disabled_total", Help: "Webhook endpoints disabled after persistent failure."})
+
+	// Auth
+	AuthFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "api_auth_failures_total", Help: "Rejected API requests by reason."},
+		[]string{"reason"}, // missing | invalid | skew | replay | forbidden | scope
+	)
+
+	// Credits
+	CreditBucketsExpired = prometheus.NewCounter(prometheus.CounterOpts{Name: "worker_credit_buckets_expired_total", Help: "Promotional credit buckets expired."})
 )
 
 // Register default + our collectors
 func MustRegister() {
 	prometheus.MustRegister(HTTPRequests, HTTPDuration, APIEnqueue,
 		ClaimTotal, ClaimBatchSize, InFlight,
-		ProviderSendTotal, ProviderSendDuration, RetryTotal, RefundTotal)
+		ProviderSendTotal, ProviderSendDuration, RetryTotal, RefundTotal,
+		BalanceNotifications, WebhookDeliveries, WebhookEndpointsDisabled,
+		CreditBucketsExpired, AuthFailures)
 }
 
 // Export a tiny pgxpool stats exporter
@@ -0,0 +1,110 @@
+// Package netguard keeps tenant-supplied URLs (webhooks, balance alerts) from
+// reaching the gateway's own network: loopback, private, link-local (cloud
+// metadata) and other non-public addresses are refused both when the URL is
+// saved and when the worker dials it, so DNS can't be rebound in between.
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrPrivateDestination = errors.New("netguard: destination is not a public address")
+
+// reserved are non-public ranges netip has no predicate for.
+var reserved = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, can embed any IPv4
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// Public reports whether a is a globally routable unicast address.
+func Public(a netip.Addr) bool {
+	a = a.Unmap()
+	if !a.IsValid() || a.IsUnspecified() || a.IsLoopback() || a.IsPrivate() ||
+		a.IsLinkLocalUnicast() || a.IsMulticast() {
+		return false
+	}
+	for _, p := range reserved {
+		if p.Contains(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckURL refuses a URL whose host is, or resolves to, a non-public address.
+// A name that doesn't resolve right now is let through: the dial-time check
+// still applies when it is used.
+func CheckURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if a, err := netip.ParseAddr(host); err == nil {
+		if !Public(a) {
+			return ErrPrivateDestination
+		}
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateDestination
+	}
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+	for _, a := range addrs {
+		if !Public(a) {
+			return ErrPrivateDestination
+		}
+	}
+	return nil
+}
+
+// control runs after DNS resolution, on the address actually dialed.
+func control(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	a, err := netip.ParseAddr(host)
+	if err != nil || !Public(a) {
+		return ErrPrivateDestination
+	}
+	return nil
+}
+
+// Client returns an HTTP client for tenant URLs. It never follows redirects
+// or goes through a proxy, and unless allowPrivate (development and tests)
+// it only connects to public addresses.
+func Client(timeout time.Duration, allowPrivate bool) *http.Client {
+	d := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		d.Control = control
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = d.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: t,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
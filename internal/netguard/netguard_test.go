@@ -0,0 +1,61 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestPublic(t *testing.T) {
+	for _, s := range []string{"8.8.8.8", "2606:4700::1111", "93.184.216.34"} {
+		if !Public(netip.MustParseAddr(s)) {
+			t.Errorf("%s should be public", s)
+		}
+	}
+	for _, s := range []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"0.0.0.0", "100.64.0.1", "::1", "fe80::1", "fd00:ec2::254", "::ffff:10.0.0.1", "224.0.0.1",
+	} {
+		if Public(netip.MustParseAddr(s)) {
+			t.Errorf("%s should not be public", s)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	ctx := context.Background()
+	for _, raw := range []string{
+		"http://127.0.0.1:8080/hook", "http://169.254.169.254/latest/meta-data",
+		"https://[::1]/", "http://localhost/", "http://api.localhost./",
+	} {
+		if err := CheckURL(ctx, raw); !errors.Is(err, ErrPrivateDestination) {
+			t.Errorf("CheckURL(%q) = %v, want ErrPrivateDestination", raw, err)
+		}
+	}
+	if err := CheckURL(ctx, "https://8.8.8.8/hook"); err != nil {
+		t.Errorf("public address refused: %v", err)
+	}
+}
+
+func TestClientRefusesPrivateAndRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	if _, err := Client(time.Second, false).Post(srv.URL, "application/json", nil); !errors.Is(err, ErrPrivateDestination) {
+		t.Fatalf("dial to %s: err = %v, want ErrPrivateDestination", srv.URL, err)
+	}
+	resp, err := Client(time.Second, true).Post(srv.URL, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("status = %d; the redirect must not be followed", resp.StatusCode)
+	}
+}
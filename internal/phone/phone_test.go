@@ -0,0 +1,23 @@
+package phone
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Destination
+	}{
+		{"+4915112345678", Destination{"49", "DE"}},
+		{"004915112345678", Destination{"49", "DE"}},
+		{"+1 (212) 555-0100", Destination{"1", "US"}},
+		{"+353861234567", Destination{"353", "IE"}},
+		{"+380501234567", Destination{"380", "UA"}},
+		{"+999", Destination{}},
+		{"", Destination{}},
+	}
+	for _, c := range cases {
+		if got := Lookup(c.in); got != c.want {
+			t.Errorf("Lookup(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
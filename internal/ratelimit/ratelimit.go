@@ -0,0 +1,143 @@
+// Package ratelimit implements token-bucket request limits. Buckets live in
+// memory (Memory) or in a store shared by every API replica.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit refills Rate tokens per second up to Burst. The zero Limit means
+// unlimited.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) Unlimited() bool { return l.Rate <= 0 || l.Burst <= 0 }
+
+// ParseLimit reads "rate:burst", e.g. "10:20".
+func ParseLimit(s string) (Limit, error) {
+	rate, burst, ok := strings.Cut(strings.TrimSpace(s), ":")
+	r, err1 := strconv.ParseFloat(rate, 64)
+	b, err2 := strconv.Atoi(burst)
+	if !ok || err1 != nil || err2 != nil || r <= 0 || b <= 0 {
+		return Limit{}, fmt.Errorf("ratelimit: bad limit %q, want rate:burst", s)
+	}
+	return Limit{Rate: r, Burst: b}, nil
+}
+
+// ParsePlans reads "plan=rate:burst,..." e.g. "standard=10:20,premium=100:200".
+func ParsePlans(s string) (map[string]Limit, error) {
+	out := map[string]Limit{}
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(part, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("ratelimit: bad plan %q, want name=rate:burst", part)
+		}
+		l, err := ParseLimit(spec)
+		if err != nil {
+			return nil, err
+		}
+		out[strings.TrimSpace(name)] = l
+	}
+	return out, nil
+}
+
+// Result describes one Take, in the terms of the RateLimit-* headers.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token, when not allowed
+}
+
+// NewResult builds a Result from the tokens left in a bucket after a Take.
+func NewResult(l Limit, tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     l.Burst,
+		Remaining: max(0, int(math.Floor(tokens))),
+		Reset:     time.Duration((float64(l.Burst) - tokens) / l.Rate * float64(time.Second)),
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) / l.Rate * float64(time.Second))
+	}
+	return res
+}
+
+// Bucket names a token bucket and the limit it refills at.
+type Bucket struct {
+	Key   string
+	Limit Limit
+}
+
+// Store takes one token from each of several buckets, all or nothing: when
+// any of them is empty none is spent, so a request refused by one limit
+// doesn't count against the others. Results come back in the buckets' order;
+// each one's Allowed says whether that bucket had a token.
+type Store interface {
+	Take(ctx context.Context, buckets ...Bucket) ([]Result, error)
+}
+
+// Memory is a Store local to one process.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]*bucket{}}
+}
+
+func (m *Memory) Take(_ context.Context, buckets ...Bucket) ([]Result, error) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Idle buckets are full again, so forgetting them changes nothing.
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, b := range m.buckets {
+			if now.Sub(b.updated) > time.Hour {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	found := make([]*bucket, len(buckets))
+	allowed := true
+	for i, want := range buckets {
+		b, ok := m.buckets[want.Key]
+		if !ok {
+			b = &bucket{tokens: float64(want.Limit.Burst), updated: now}
+			m.buckets[want.Key] = b
+		}
+		b.tokens = min(float64(want.Limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*want.Limit.Rate)
+		b.updated = now
+		found[i] = b
+		allowed = allowed && b.tokens >= 1
+	}
+	out := make([]Result, len(buckets))
+	for i, b := range found {
+		if allowed {
+			b.tokens--
+		}
+		out[i] = NewResult(buckets[i].Limit, b.tokens, allowed || b.tokens >= 1)
+	}
+	return out, nil
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryTake(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+	l := Limit{Rate: 1, Burst: 2}
+
+	for i, want := range []int{1, 0} {
+		res, _ := m.Take(ctx, Bucket{"u", l})
+		if !res[0].Allowed || res[0].Remaining != want {
+			t.Fatalf("take %d: %+v, want allowed with %d remaining", i, res[0], want)
+		}
+	}
+	res, _ := m.Take(ctx, Bucket{"u", l})
+	if res[0].Allowed || res[0].RetryAfter <= 0 || res[0].RetryAfter > time.Second {
+		t.Fatalf("over burst: %+v", res[0])
+	}
+	if res, _ := m.Take(ctx, Bucket{"other", l}); !res[0].Allowed {
+		t.Fatal("buckets must be per key")
+	}
+}
+
+func TestMemoryTakeAllOrNothing(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+	user, key := Bucket{"user", Limit{Rate: 0.01, Burst: 5}}, Bucket{"key", Limit{Rate: 0.01, Burst: 1}}
+
+	if res, _ := m.Take(ctx, user, key); !res[0].Allowed || !res[1].Allowed {
+		t.Fatalf("first take: %+v", res)
+	}
+	// The key bucket is empty, so the user bucket isn't spent either
+	for range 3 {
+		res, _ := m.Take(ctx, user, key)
+		if !res[0].Allowed || res[1].Allowed || res[0].Remaining != 4 {
+			t.Fatalf("over the key limit: %+v", res)
+		}
+	}
+	if res, _ := m.Take(ctx, user); !res[0].Allowed || res[0].Remaining != 3 {
+		t.Fatalf("user bucket after refusals: %+v", res[0])
+	}
+}
+
+func TestParsePlans(t *testing.T) {
+	plans, err := ParsePlans("standard=10:20, premium=100.5:200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plans["standard"] != (Limit{10, 20}) || plans["premium"] != (Limit{100.5, 200}) {
+		t.Fatalf("plans = %+v", plans)
+	}
+	for _, bad := range []string{"standard", "standard=10", "=1:1", "x=0:5"} {
+		if _, err := ParsePlans(bad); err == nil {
+			t.Errorf("ParsePlans(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package tlsreload
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue creates a certificate signed by parent (self-signed when nil).
+func issue(t *testing.T, tmpl *x509.Certificate, parent *keyPair) keyPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Minute)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return keyPair{cert, key}
+}
+
+func writePEM(t *testing.T, path string, kp keyPair) {
+	t.Helper()
+	der, _ := x509.MarshalECPrivateKey(kp.key)
+	out := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: kp.cert.Raw})
+	if err := os.WriteFile(path+".crt", out, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloaderMutualTLSAndReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test CA"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	writePEM(t, filepath.Join(dir, "ca"), ca)
+	server := func(cn string) keyPair {
+		return issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: cn}, IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, &ca)
+	}
+	writePEM(t, filepath.Join(dir, "server"), server("server-1"))
+	client := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "acme"}, DNSNames: []string{"client.acme.test"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, &ca)
+
+	r := &Reloader{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.VerifiedChains) > 0 {
+			w.Header().Set("X-Client", req.TLS.VerifiedChains[0][0].Subject.CommonName)
+		}
+	}))
+	ts.TLS = r.Config()
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(withCert bool) (*http.Response, error) {
+		cfg := &tls.Config{RootCAs: roots}
+		if withCert {
+			cfg.Certificates = []tls.Certificate{{Certificate: [][]byte{client.cert.Raw}, PrivateKey: client.key}}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		return c.Get(ts.URL)
+	}
+
+	resp, err := get(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Client"); got != "acme" {
+		t.Fatalf("verified client = %q", got)
+	}
+	if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != "server-1" {
+		t.Fatalf("server cert = %q", cn)
+	}
+	// Client certificates are optional unless required
+	resp, err = get(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// A broken file keeps the old certificate in use
+	if err := os.WriteFile(r.CertFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Load(); err == nil {
+		t.Fatal("Load accepted a broken certificate")
+	}
+	writePEM(t, filepath.Join(dir, "server"), server("server-2"))
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = get(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != "server-2" {
+		t.Fatalf("after reload server cert = %q", cn)
+	}
+
+	r.RequireClientCert = true
+	if resp, err := get(false); err == nil {
+		resp.Body.Close()
+		t.Fatal("request without a client certificate was accepted")
+	}
+}
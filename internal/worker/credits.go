@@ -0,0 +1,37 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	"github.com/Cypherspark/sms-gateway/internal/metrics"
+)
+
+type CreditExpiryOptions struct {
+	BatchSize int           // buckets swept per pass
+	Interval  time.Duration // sleep between passes once caught up
+}
+
+// RunCreditExpiry zeroes promotional buckets past their expiry and records a
+// ledger entry for the credit that was forfeited.
+func RunCreditExpiry(ctx context.Context, store *core.Store, opt CreditExpiryOptions) error {
+	for {
+		n, err := store.ExpireCredits(ctx, opt.BatchSize)
+		if err != nil {
+			log.Printf("credit expiry error: %v", err)
+		}
+		metrics.CreditBucketsExpired.Add(float64(n))
+
+		// A full batch likely means more are due; go again right away.
+		if err == nil && n >= opt.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opt.Interval):
+		}
+	}
+}
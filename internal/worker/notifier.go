@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/Cypherspark/sms-gateway/internal/metrics"
+	"github.com/Cypherspark/sms-gateway/internal/netguard"
+	"github.com/Cypherspark/sms-gateway/internal/provider"
+)
+
+type NotifierOptions struct {
+	BatchSize    int           // notifications claimed per poll
+	PollInterval time.Duration // sleep between polls
+	MaxAttempts  int           // give up after this many failed deliveries
+	Timeout      time.Duration // per-delivery timeout (webhook or SMS)
+	HTTPClient   *http.Client  // nil uses netguard.Client with Timeout
+	AllowPrivate bool          // let the default client dial private addresses (dev only)
+}
+
+// balanceEvent is the webhook payload for a fired low-balance threshold.
+type balanceEvent struct {
+	Type      string    `json:"type"`
+	UserID    string    `json:"user_id"`
+	Threshold int32     `json:"threshold"`
+	Balance   int32     `json:"balance"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RunBalanceNotifier drains the balance notification outbox, POSTing webhooks
+// and sending admin SMS directly through the provider (not billed or queued).
+func RunBalanceNotifier(ctx context.Context, store *core.Store, prov provider.Provider, opt NotifierOptions) error {
+	client := opt.HTTPClient
+	if client == nil {
+		client = netguard.Client(opt.Timeout, opt.AllowPrivate)
+	}
+	// Lease must outlive a delivery so other workers don't pick the row up mid-flight.
+	lease := 2*opt.Timeout + time.Second
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		items, err := store.ClaimBalanceNotifications(ctx, opt.BatchSize, lease)
+		if err != nil {
+			log.Printf("notifier claim error: %v", err)
+		}
+		for _, n := range items {
+			deliverNotification(ctx, store, prov, client, n, opt)
+		}
+		if len(items) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(opt.PollInterval):
+			}
+		}
+	}
+}
+
+func deliverNotification(ctx context.Context, store *core.Store, prov provider.Provider, client *http.Client, n dbgen.ClaimBalanceNotificationsRow, opt NotifierOptions) {
+	cctx, cancel := context.WithTimeout(ctx, opt.Timeout)
+	defer cancel()
+
+	var err error
+	switch n.Channel {
+	case core.NotifyChannelWebhook:
+		err = postBalanceWebhook(cctx, client, n)
+	case core.NotifyChannelSMS:
+		body := fmt.Sprintf("SMS gateway: balance %d is below your alert threshold of %d.", n.Balance, n.Threshold)
+		_, err = prov.Send(cctx, n.Target, body)
+	default:
+		err = fmt.Errorf("unknown channel %q", n.Channel)
+	}
+
+	switch {
+	case err == nil:
+		metrics.BalanceNotifications.WithLabelValues(n.Channel, "delivered").Inc()
+		_ = store.MarkBalanceNotificationDelivered(ctx, n.ID)
+	case int(n.Attempts) >= opt.MaxAttempts:
+		metrics.BalanceNotifications.WithLabelValues(n.Channel, "failed").Inc()
+		log.Printf("balance notification %d failed permanently: %v", n.ID, err)
+		_ = store.FailBalanceNotification(ctx, n.ID, err)
+	default:
+		metrics.BalanceNotifications.WithLabelValues(n.Channel, "retry").Inc()
+		// 30s, 60s, 120s, ... capped at one hour.
+		retryIn := minDur(time.Hour, 30*time.Second<<min(n.Attempts-1, 7))
+		_ = store.RetryBalanceNotification(ctx, n.ID, jitter(retryIn, 0.20), err)
+	}
+}
+
+func postBalanceWebhook(ctx context.Context, client *http.Client, n dbgen.ClaimBalanceNotificationsRow) error {
+	payload, err := json.Marshal(balanceEvent{
+		Type:      "balance.low",
+		UserID:    n.UserID,
+		Threshold: n.Threshold,
+		Balance:   n.Balance,
+		CreatedAt: n.CreatedAt.Time,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Target, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Cypherspark/sms-gateway/internal/core"
+	dbgen "github.com/Cypherspark/sms-gateway/internal/db/gen"
+	"github.com/Cypherspark/sms-gateway/internal/metrics"
+	"github.com/Cypherspark/sms-gateway/internal/netguard"
+	"github.com/Cypherspark/sms-gateway/signing"
+)
+
+type WebhookOptions struct {
+	BatchSize    int           // deliveries claimed per poll
+	PollInterval time.Duration // sleep between polls when idle
+	MaxAttempts  int           // give up on a delivery after this many attempts
+	DisableAfter int           // disable an endpoint after this many failed attempts in a row
+	Timeout      time.Duration // per-attempt timeout
+	HTTPClient   *http.Client  // nil uses netguard.Client with Timeout
+	AllowPrivate bool          // let the default client dial private addresses (dev only)
+}
+
+// webhookEnvelope is the body of every webhook; data depends on the type.
+type webhookEnvelope struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// RunWebhookDispatcher drains the webhook outbox, POSTing each event signed
+// with its endpoint's secret. Failed attempts are retried with exponential
+// backoff; endpoints that keep failing are disabled.
+func RunWebhookDispatcher(ctx context.Context, store *core.Store, opt WebhookOptions) error {
+	client := opt.HTTPClient
+	if client == nil {
+		client = netguard.Client(opt.Timeout, opt.AllowPrivate)
+	}
+	lease := 2*opt.Timeout + time.Second
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		items, err := store.ClaimWebhookDeliveries(ctx, opt.BatchSize, lease)
+		if err != nil {
+			log.Printf("webhook claim error: %v", err)
+		}
+		for _, d := range items {
+			deliverWebhook(ctx, store, client, d, opt)
+		}
+		if len(items) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(opt.PollInterval):
+			}
+		}
+	}
+}
+
+func deliverWebhook(ctx context.Context, store *core.Store, client *http.Client, d dbgen.ClaimWebhookDeliveriesRow, opt WebhookOptions) {
+	cctx, cancel := context.WithTimeout(ctx, opt.Timeout)
+	defer cancel()
+
+	status, err := postWebhook(cctx, client, d, time.Now())
+	if err == nil {
+		metrics.WebhookDeliveries.WithLabelValues("delivered").Inc()
+		_ = store.MarkWebhookDelivered(ctx, d.ID, status)
+		return
+	}
+
+	if int(d.Attempts) >= opt.MaxAttempts {
+		metrics.WebhookDeliveries.WithLabelValues("failed").Inc()
+		log.Printf("webhook delivery %d failed permanently: %v", d.ID, err)
+		_ = store.FailWebhookDelivery(ctx, d.ID, status, err)
+	} else {
+		metrics.WebhookDeliveries.WithLabelValues("retry").Inc()
+		// 30s, 60s, 120s, ... capped at one hour.
+		retryIn := minDur(time.Hour, 30*time.Second<<min(d.Attempts-1, 7))
+		_ = store.RetryWebhookDelivery(ctx, d.ID, jitter(retryIn, 0.20), status, err)
+	}
+	disabled, err := store.RecordWebhookFailure(ctx, d.EndpointID, opt.DisableAfter)
+	if err != nil {
+		log.Printf("webhook endpoint %s: recording failure: %v", d.EndpointID, err)
+	}
+	if disabled {
+		metrics.WebhookEndpointsDisabled.Inc()
+		log.Printf("webhook endpoint %s disabled after %d failed attempts", d.EndpointID, opt.DisableAfter)
+	}
+}
+
+// postWebhook sends one attempt. It returns the response status (0 without a
+// response) and an error unless the endpoint answered 2xx.
+func postWebhook(ctx context.Context, client *http.Client, d dbgen.ClaimWebhookDeliveriesRow, now time.Time) (int, error) {
+	body, err := json.Marshal(webhookEnvelope{
+		ID:        d.EventID,
+		Type:      d.Type,
+		CreatedAt: d.CreatedAt.Time,
+		Data:      d.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sms-gateway-webhooks")
+	req.Header.Set(signing.HeaderWebhookID, d.EventID)
+	req.Header.Set(signing.HeaderWebhookTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(signing.HeaderWebhookSignature, signing.WebhookSignature(d.Secret, now, body))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -2,11 +2,16 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/Cypherspark/sms-gateway/internal/core"
 	database "github.com/Cypherspark/sms-gateway/internal/db"
+	"github.com/Cypherspark/sms-gateway/signing"
 	"github.com/stretchr/testify/require"
 )
 
@@ -16,7 +21,8 @@ func TestWorkerLikeFlow(t *testing.T) {
 	store := &core.Store{DB: db}
 	uid, err := store.CreateUser(context.Background(), "acme")
 	require.NoError(t, err)
-	require.NoError(t, store.TopUp(context.Background(), core.TopUpRequest{UserID: uid, Amount: 1}))
+	_, _, err = store.TopUp(context.Background(), core.TopUpRequest{UserID: uid, Amount: 1})
+	require.NoError(t, err)
 	_, _, _ = store.EnqueueAndCharge(context.Background(), core.SendRequest{UserID: uid, To: "+49", Body: "x"})
 
 	ids, _ := store.ClaimQueuedMessages(context.Background(), 10)
@@ -27,3 +33,104 @@ func TestWorkerLikeFlow(t *testing.T) {
 	// no panics, basic flow covered
 	time.Sleep(20 * time.Millisecond)
 }
+
+func TestWebhookDispatcher_SignsRetriesAndDisables(t *testing.T) {
+	db := database.StartTestPostgres(t)
+	store := &core.Store{DB: db}
+	ctx := context.Background()
+	uid, err := store.CreateUser(ctx, "acme")
+	require.NoError(t, err)
+	_, _, err = store.TopUp(ctx, core.TopUpRequest{UserID: uid, Amount: 5})
+	require.NoError(t, err)
+
+	// Internal destinations are refused unless explicitly allowed
+	_, _, err = store.CreateWebhookEndpoint(ctx, core.WebhookRequest{
+		UserID: uid, URL: "http://169.254.169.254/latest/meta-data", EventTypes: []string{core.EventMessageSent},
+	})
+	require.ErrorIs(t, err, core.ErrInvalidURL)
+	store.AllowPrivateWebhooks = true
+
+	var got []*http.Request
+	var bodies [][]byte
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, bodies = append(got, r), append(bodies, b)
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	ep, secret, err := store.CreateWebhookEndpoint(ctx, core.WebhookRequest{
+		UserID: uid, URL: srv.URL, EventTypes: []string{core.EventMessageSent},
+	})
+	require.NoError(t, err)
+	opt := WebhookOptions{BatchSize: 10, MaxAttempts: 3, DisableAfter: 2, Timeout: 5 * time.Second}
+	drain := func() int {
+		items, err := store.ClaimWebhookDeliveries(ctx, opt.BatchSize, time.Minute)
+		require.NoError(t, err)
+		for _, d := range items {
+			deliverWebhook(ctx, store, srv.Client(), d, opt)
+		}
+		return len(items)
+	}
+
+	// Sent is subscribed, failed isn't
+	sent, _, err := store.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+4915112345678", Body: "a"})
+	require.NoError(t, err)
+	failed, _, err := store.EnqueueAndCharge(ctx, core.SendRequest{UserID: uid, To: "+4915112345678", Body: "b"})
+	require.NoError(t, err)
+	require.NoError(t, store.MarkSent(ctx, sent, "prov-1"))
+	require.NoError(t, store.MarkFailedPermanentAndRefund(ctx, failed))
+	require.Equal(t, 1, drain())
+
+	require.Len(t, got, 1)
+	require.NoError(t, signing.VerifyWebhook(got[0].Header, bodies[0], []byte(secret), time.Now(), time.Minute))
+	var ev struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			MessageID string `json:"message_id"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(bodies[0], &ev))
+	require.Equal(t, core.EventMessageSent, ev.Type)
+	require.Equal(t, sent, ev.Data.MessageID)
+	require.Equal(t, ev.ID, got[0].Header.Get(signing.HeaderWebhookID))
+
+	// Two failed attempts in a row disable the endpoint and give up on
+	// what's pending
+	fail = true
+	deliveries, err := store.ListWebhookDeliveries(ctx, uid, ep.ID, 0, 10)
+	require.NoError(t, err)
+	d, err := store.ReplayWebhookDelivery(ctx, uid, ep.ID, deliveries[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, ev.ID, d.EventID)
+	require.Equal(t, 1, drain())
+	_, err = db.Pool.Exec(ctx, `UPDATE webhook_deliveries SET next_attempt_at = now() WHERE id = $1`, d.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, drain())
+
+	eps, err := store.ListWebhookEndpoints(ctx, uid)
+	require.NoError(t, err)
+	require.False(t, eps[0].Enabled)
+	deliveries, err = store.ListWebhookDeliveries(ctx, uid, ep.ID, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, core.DeliveryFailed, deliveries[0].Status)
+	require.Equal(t, http.StatusServiceUnavailable, *deliveries[0].ResponseStatus)
+	_, err = store.ReplayWebhookDelivery(ctx, uid, ep.ID, d.ID)
+	require.ErrorIs(t, err, core.ErrWebhookDisabled)
+
+	// Re-enabling resets the streak; replays then go through
+	fail = false
+	enabled := true
+	_, err = store.UpdateWebhookEndpoint(ctx, uid, ep.ID, core.WebhookUpdate{Enabled: &enabled})
+	require.NoError(t, err)
+	_, err = store.ReplayWebhookDelivery(ctx, uid, ep.ID, d.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, drain())
+	deliveries, err = store.ListWebhookDeliveries(ctx, uid, ep.ID, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, core.DeliveryDelivered, deliveries[0].Status)
+}
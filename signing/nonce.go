@@ -0,0 +1,46 @@
+package signing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NonceCache remembers nonces until they expire. Claim returns false if the
+// nonce was already claimed for keyID.
+type NonceCache interface {
+	Claim(ctx context.Context, keyID, nonce string, expires time.Time) (bool, error)
+}
+
+// MemoryNonceCache is a NonceCache local to one process. With several API
+// replicas, use a shared cache instead.
+type MemoryNonceCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryNonceCache() *MemoryNonceCache {
+	return &MemoryNonceCache{seen: map[string]time.Time{}}
+}
+
+func (c *MemoryNonceCache) Claim(_ context.Context, keyID, nonce string, expires time.Time) (bool, error) {
+	now := time.Now()
+	k := keyID + "\x00" + nonce
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastSweep) > time.Minute {
+		for key, exp := range c.seen {
+			if exp.Before(now) {
+				delete(c.seen, key)
+			}
+		}
+		c.lastSweep = now
+	}
+	if exp, ok := c.seen[k]; ok && exp.After(now) {
+		return false, nil
+	}
+	c.seen[k] = expires
+	return true, nil
+}
@@ -0,0 +1,94 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Unix(1_700_000_000, 0)
+	signer := Signer{KeyID: "abc123", Secret: secret, Now: func() time.Time { return now }}
+
+	req := httptest.NewRequest("POST", "/messages?b=2&a=1", bytes.NewBufferString(`{"to":"+49","body":"hi"}`))
+	if err := signer.Sign(req); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(req.Body) // Sign must leave the body readable
+
+	p, err := Parse(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.KeyID != "abc123" {
+		t.Fatalf("KeyID = %q", p.KeyID)
+	}
+	if err := Verify(req, body, p, secret, now.Add(30*time.Second), time.Minute); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := Verify(req, body, p, secret, now.Add(2*time.Minute), time.Minute); err != ErrSkew {
+		t.Fatalf("Verify outside skew = %v, want ErrSkew", err)
+	}
+	if err := Verify(req, []byte(`{"to":"+1","body":"hi"}`), p, secret, now, time.Minute); err != ErrMismatch {
+		t.Fatalf("Verify with tampered body = %v, want ErrMismatch", err)
+	}
+	if err := Verify(req, body, p, []byte("other"), now, time.Minute); err != ErrMismatch {
+		t.Fatalf("Verify with wrong secret = %v, want ErrMismatch", err)
+	}
+}
+
+func TestParseMissing(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if Signed(req) {
+		t.Fatal("unsigned request reported as signed")
+	}
+	if _, err := Parse(req); err != ErrMissing {
+		t.Fatalf("Parse = %v, want ErrMissing", err)
+	}
+}
+
+func TestMemoryNonceCache(t *testing.T) {
+	c := NewMemoryNonceCache()
+	ctx := context.Background()
+	exp := time.Now().Add(time.Minute)
+	if ok, _ := c.Claim(ctx, "k", "n1", exp); !ok {
+		t.Fatal("first claim rejected")
+	}
+	if ok, _ := c.Claim(ctx, "k", "n1", exp); ok {
+		t.Fatal("replayed nonce accepted")
+	}
+	if ok, _ := c.Claim(ctx, "other", "n1", exp); !ok {
+		t.Fatal("nonces must be scoped per key")
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	secret := []byte("whsec")
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"type":"message.sent"}`)
+	h := http.Header{}
+	h.Set(HeaderWebhookTimestamp, "1700000000")
+	h.Set(HeaderWebhookSignature, WebhookSignature(secret, now, body))
+
+	if err := VerifyWebhook(h, body, secret, now.Add(time.Minute), 5*time.Minute); err != nil {
+		t.Fatalf("VerifyWebhook: %v", err)
+	}
+	if err := VerifyWebhook(h, body, secret, now.Add(10*time.Minute), 5*time.Minute); err != ErrSkew {
+		t.Fatalf("VerifyWebhook outside skew = %v, want ErrSkew", err)
+	}
+	if err := VerifyWebhook(h, []byte(`{"type":"message.failed"}`), secret, now, 5*time.Minute); err != ErrMismatch {
+		t.Fatalf("VerifyWebhook with tampered body = %v, want ErrMismatch", err)
+	}
+	h.Set(HeaderWebhookSignature, "v2=00")
+	if err := VerifyWebhook(h, body, secret, now, 5*time.Minute); err != ErrMalformed {
+		t.Fatalf("VerifyWebhook with unknown version = %v, want ErrMalformed", err)
+	}
+	if err := VerifyWebhook(http.Header{}, body, secret, now, 5*time.Minute); err != ErrMissing {
+		t.Fatalf("VerifyWebhook without headers = %v, want ErrMissing", err)
+	}
+}